@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: MIT
+// conservationctl: non-root CLI client for conservationd.
+// The same code also runs as a subcommand of the multi-call conservation
+// binary (cmd/conservation).
+
+package main
+
+import "conservationDaemon/internal/app/ctl"
+
+func main() {
+	ctl.Main()
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: MIT
+// conservation: multi-call binary bundling the daemon, the CLI and the tray.
+// Run it as "conservation daemon|ctl|tray [flags]", or through a symlink
+// named conservationd, conservationctl or conservation-tray.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"conservationDaemon/internal/app/ctl"
+	"conservationDaemon/internal/app/daemon"
+	"conservationDaemon/internal/app/tray"
+	"conservationDaemon/internal/version"
+)
+
+// programs maps subcommands and symlink names to their entry points.
+var programs = map[string]func(){
+	"daemon":            daemon.Main,
+	"conservationd":     daemon.Main,
+	"ctl":               ctl.Main,
+	"conservationctl":   ctl.Main,
+	"tray":              tray.Main,
+	"conservation-tray": tray.Main,
+}
+
+func main() {
+	if run, ok := programs[filepath.Base(os.Args[0])]; ok {
+		run()
+		return
+	}
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "-version", "--version":
+		fmt.Println(version.String("conservation"))
+		return
+	}
+	run, ok := programs[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	// Subcommands parse os.Args[1:] as their own flags
+	os.Args = append([]string{os.Args[0] + " " + os.Args[1]}, os.Args[2:]...)
+	run()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s daemon|ctl|tray [flags]\n", filepath.Base(os.Args[0]))
+	os.Exit(2)
+}
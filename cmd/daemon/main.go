@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: MIT
+// conservationd: root daemon that drives the battery charge knob.
+// The same code also runs as a subcommand of the multi-call conservation
+// binary (cmd/conservation).
+
+package main
+
+import "conservationDaemon/internal/app/daemon"
+
+func main() {
+	daemon.Main()
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: MIT
+// conservation-helper: privileged host helper that writes the charge knobs
+// for a conservationd running in a container or Flatpak.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/helper"
+	"conservationDaemon/internal/ipc"
+	"conservationDaemon/internal/version"
+)
+
+func main() {
+	showVersion := flag.Bool("version", false, "print version and exit")
+	sock := flag.String("sock", helper.DefaultSock, "UNIX socket to listen on")
+	group := flag.String("sock-group", "conservationd", "group allowed to use the socket (0660)")
+	usePolkit := flag.Bool("polkit", false, "let any local user's conservationd connect, authorizing each write with polkit ("+helper.PolkitAction+")")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(version.String("conservation-helper"))
+		os.Exit(0)
+	}
+	if c := backend.Container(); c != "" {
+		fmt.Fprintf(os.Stderr, "conservation-helper: running inside %s; it must run on the host\n", c)
+		os.Exit(1)
+	}
+
+	ln, err := ipc.Listen(*sock, *group)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "conservation-helper: %v\n", err)
+		os.Exit(1)
+	}
+	var authorize helper.Authorize
+	if *usePolkit {
+		bus, err := dbus.SystemBus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "conservation-helper: connect system bus: %v\n", err)
+			os.Exit(1)
+		}
+		defer bus.Close()
+		authorize = helper.Polkit(bus)
+		// polkit decides who writes: open the socket to everyone
+		_ = os.Chmod(filepath.Dir(*sock), 0o755)
+		_ = os.Chmod(*sock, 0o666)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	helper.Serve(ctx, ln, backend.WriteDirect, authorize)
+}
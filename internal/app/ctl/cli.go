@@ -0,0 +1,420 @@
+// SPDX-License-Identifier: MIT
+
+// Package ctl is conservationctl, the non-root CLI client for conservationd.
+package ctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/ipc"
+	"conservationDaemon/internal/version"
+)
+
+// Main runs conservationctl with the arguments in os.Args.
+func Main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "prom-textfile":
+			promTextfile(os.Args[2:])
+			return
+		case "prompt":
+			promptSegment(os.Args[2:])
+			return
+		}
+	}
+	showVersion := flag.Bool("version", false, "print version and exit")
+	sock := flag.String("sock", "", "control socket path (default: $CONSERVATIOND_SOCK, $XDG_RUNTIME_DIR/conservationd/conservationd.sock, "+ipc.DefaultSock+")")
+	doSet := flag.Bool("set", false, "set thresholds")
+	max := flag.Float64("max", 80, "target maximum percentage (daemon conservation-threshold..100)")
+	timeFlag := flag.String("time", "", "target time in HH:MM format for scheduled charging (defaults to 'now')")
+	auto := flag.Bool("auto", false, "enable auto mode (display connection based)")
+	status := flag.Bool("status", false, "show current status")
+	perUser := flag.Bool("user", false, "with -set, set your own policy instead of the global one (daemon -multi-user)")
+	chargeCurrent := flag.Int("charge-current", -1, "with -set, cap the charge current in mA where the platform supports it (0 = platform default; with -user, 0 = the global cap)")
+	ifRevision := flag.Uint64("if-revision", 0, "with -set, only change the settings if they are still at this revision (see -status), so a concurrent change isn't undone")
+	showKnobs := flag.Bool("knobs", false, "list extra ideapad knobs (rapid_charge, usb_charging)")
+	setKnobs := map[string]bool{}
+	flag.Func("knob", "set an extra ideapad knob, e.g. usb_charging=on (repeatable)", func(v string) error {
+		name, val, ok := strings.Cut(v, "=")
+		if !ok || (val != "on" && val != "off") {
+			return fmt.Errorf("want name=on|off")
+		}
+		setKnobs[name] = val == "on"
+		return nil
+	})
+	backup := flag.String("backup", "", "write the daemon's full configuration to this file ('-' for stdout)")
+	restore := flag.String("import", "", "restore a configuration written by -backup ('-' for stdin)")
+	resolve := flag.String("external", "", "settle a pending external knob change (daemon -external-change ask): adopt or enforce")
+	summary := flag.Bool("summary", false, "show what the daemon did since it started (charge sessions, toggles, charge range, errors)")
+	clearUser := flag.Bool("clear-user", false, "remove your own policy (daemon -multi-user)")
+	calibrate := flag.String("calibrate", "", "battery calibration: start (force-discharge to -floor, then charge to 100%), abort, schedule (at -at), every (-every) or history")
+	floor := flag.Float64("floor", 0, "with -calibrate start, discharge down to this percentage (default 20, never below the daemon's -safety-floor)")
+	preset := flag.String("preset", "", "apply a threshold preset by name (lifespan, balanced, full) or title; list shows them")
+	storage := flag.String("storage", "", "storage mode for a laptop put away for weeks: on (hold at the daemon's -storage-level) or off")
+	calibrateAt := flag.String("at", "", "with -calibrate schedule: tomorrow, weekend, a weekday, YYYY-MM-DD or \"YYYY-MM-DD HH:MM\"")
+	subscribe := flag.String("subscribe", "", "print the daemon's events as JSON lines as they happen: all, or event names separated by commas (e.g. conservation_changed,config_changed)")
+	capabilities := flag.Bool("capabilities", false, "show the daemon's protocol version, commands and features")
+	reload := flag.Bool("reload", false, "make the daemon re-read its configuration file (like systemctl reload conservationd)")
+	logLevel := flag.String("log-level", "", "daemon log level: debug, info, warn or error; show prints it")
+	logFor := flag.Duration("for", 0, "with -log-level, go back to the previous level after this long, e.g. 30m; with -pause, resume after this long; with -set, go back to the previous thresholds after this long")
+	calibrateEvery := flag.String("every", "", "with -calibrate every: monthly, weekly, a duration (e.g. 1440h) or off")
+	pause := flag.Bool("pause", false, "stop the daemon from touching the hardware (status keeps working) until -resume, or for -for")
+	resume := flag.Bool("resume", false, "end a -pause")
+	full := flag.Bool("full", false, "charge to 100% once, e.g. before a trip; the thresholds come back when full or unplugged")
+	cancelFull := flag.Bool("cancel-full", false, "end a -full charge early")
+	configFlag := flag.String("config", "", "daemon options: show lists every option's effective value; name=value (more may follow as arguments) changes them at runtime, e.g. interval=30s")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(version.String("conservationctl"))
+		os.Exit(0)
+	}
+
+	// Handle time parameter
+	timeValue := *timeFlag
+	if timeValue == "" {
+		timeValue = "now"
+	}
+
+	var req ipc.Req
+	switch {
+	case *doSet:
+		req = ipc.Req{Cmd: ipc.CmdSet, Max: *max, Time: timeValue}
+		req.Auto = auto
+		req.PerUser = *perUser
+		req.IfRevision = *ifRevision
+		if *chargeCurrent >= 0 {
+			req.ChargeCurrentMA = chargeCurrent
+		}
+		if *logFor > 0 {
+			req.For = logFor.String()
+		}
+	case *showKnobs || len(setKnobs) > 0:
+		req = ipc.Req{Cmd: ipc.CmdKnobs, Knobs: setKnobs}
+	case *clearUser:
+		req = ipc.Req{Cmd: ipc.CmdClear, PerUser: true}
+	case *resolve != "":
+		req = ipc.Req{Cmd: ipc.CmdExternal, Resolve: *resolve}
+	case *backup != "":
+		req = ipc.Req{Cmd: ipc.CmdSnapshot}
+	case *restore != "":
+		data, err := readFileOrStdin(*restore)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		var snap config.Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: not a backup document: %v\n", *restore, err)
+			os.Exit(1)
+		}
+		req = ipc.Req{Cmd: ipc.CmdRestore, Snapshot: &snap}
+	case *calibrate != "":
+		req = ipc.Req{Cmd: ipc.CmdCalibrate, Calibrate: *calibrate, Floor: *floor, At: *calibrateAt, Every: *calibrateEvery}
+	case *preset != "":
+		req = ipc.Req{Cmd: ipc.CmdPreset}
+		if *preset != "list" {
+			req.Preset = *preset
+		}
+	case *storage != "":
+		if *storage != "on" && *storage != "off" {
+			fmt.Fprintln(os.Stderr, "-storage must be on or off")
+			os.Exit(2)
+		}
+		on := *storage == "on"
+		req = ipc.Req{Cmd: ipc.CmdStorage, Storage: &on}
+	case *reload:
+		req = ipc.Req{Cmd: ipc.CmdReload}
+	case *pause:
+		req = ipc.Req{Cmd: ipc.CmdPause}
+		if *logFor > 0 {
+			req.For = logFor.String()
+		}
+	case *resume:
+		req = ipc.Req{Cmd: ipc.CmdResume}
+	case *full:
+		req = ipc.Req{Cmd: ipc.CmdFull}
+	case *cancelFull:
+		req = ipc.Req{Cmd: ipc.CmdFull, Cancel: true}
+	case *configFlag != "":
+		req = ipc.Req{Cmd: ipc.CmdConfig}
+		if *configFlag != "show" {
+			req.Settings = map[string]string{}
+			for _, kv := range append([]string{*configFlag}, flag.Args()...) {
+				name, value, ok := strings.Cut(kv, "=")
+				if !ok {
+					fmt.Fprintf(os.Stderr, "-config: %q is not name=value\n", kv)
+					os.Exit(2)
+				}
+				req.Settings[strings.TrimPrefix(name, "-")] = value
+			}
+		}
+	case *capabilities:
+		req = ipc.Req{Cmd: ipc.CmdHello}
+	case *logLevel != "":
+		req = ipc.Req{Cmd: ipc.CmdLogLevel}
+		if *logLevel != "show" {
+			req.Level = *logLevel
+		}
+		if *logFor > 0 {
+			req.For = logFor.String()
+		}
+	case *summary:
+		req = ipc.Req{Cmd: ipc.CmdSummary}
+	case *status:
+		req = ipc.Req{Cmd: ipc.CmdStatus}
+	default:
+		req = ipc.Req{Cmd: ipc.CmdGet}
+	}
+
+	if *subscribe != "" {
+		var types []string
+		if *subscribe != "all" {
+			types = strings.Split(*subscribe, ",")
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err := ipc.Subscribe(ctx, ipc.DiscoverSocket(*sock, ""), types, func(ev json.RawMessage) bool {
+			fmt.Println(string(ev))
+			return true
+		})
+		if err != nil {
+			exitErr(err)
+		}
+		return
+	}
+
+	call := ipc.Call
+	if req.Cmd == ipc.CmdHello {
+		// Hello also answers for daemons from before the hello command
+		call = func(sock string, timeout time.Duration, _ ipc.Req) (*ipc.Resp, error) {
+			return ipc.Hello(sock, timeout)
+		}
+	}
+	resp, err := call(ipc.DiscoverSocket(*sock, ""), 0, req)
+	if err != nil {
+		exitErr(err)
+	}
+	switch req.Cmd {
+	case ipc.CmdSet:
+		autoStr := "false"
+		if resp.Auto {
+			autoStr = "true"
+		}
+		fmt.Printf("max=%.1f time=%s auto=%s\n", resp.Max, resp.Time, autoStr)
+		if resp.ChargeCurrentMA > 0 {
+			fmt.Printf("charge current capped at %d mA\n", resp.ChargeCurrentMA)
+		}
+		if resp.OverrideUntil > 0 {
+			fmt.Println(resp.Msg)
+		}
+	case ipc.CmdStatus, ipc.CmdGet:
+		autoStr := "false"
+		if resp.Auto {
+			autoStr = "true"
+		}
+		fmt.Printf("pct=%.1f state=%s cons=%d max=%.1f time=%s auto=%s\n", resp.Pct, resp.State, resp.Cons, resp.Max, resp.Time, autoStr)
+		if resp.Storage {
+			fmt.Printf("mode=storage level=%.0f\n", resp.StorageLevel)
+		}
+		if resp.Preset != "" {
+			fmt.Printf("preset=%s\n", resp.Preset)
+		}
+		if resp.Policy != "" {
+			fmt.Printf("policy=%s\n", resp.Policy)
+		}
+		if resp.Backend != "" {
+			fmt.Printf("backend=%s\n", resp.Backend)
+		}
+		if resp.Revision > 0 {
+			fmt.Printf("revision=%d\n", resp.Revision)
+		}
+		if resp.Calibration != "" {
+			fmt.Printf("calibration: %s\n", resp.Calibration)
+		}
+		if resp.NextCalibration > 0 {
+			fmt.Printf("next_calibration=%s\n", time.Unix(resp.NextCalibration, 0).Format("2006-01-02 15:04"))
+		}
+		if resp.CalibrationCycles > 0 {
+			fmt.Printf("cycles_since_calibration=%d\n", resp.CalibrationCycles)
+		}
+		if on, ok := resp.Knobs["rapid_charge"]; ok {
+			fmt.Printf("rapid_charge=%t\n", on)
+		}
+		if resp.PausedUntil > 0 {
+			fmt.Printf("paused until %s: run conservationctl -resume to end it\n", time.Unix(resp.PausedUntil, 0).Format("15:04"))
+		} else if resp.Paused {
+			fmt.Println("paused: run conservationctl -resume to end it")
+		}
+		if resp.OverrideUntil > 0 {
+			until := time.Unix(resp.OverrideUntil, 0)
+			fmt.Printf("override: max=%.1f until %s (%s left), then back to max=%.1f\n",
+				resp.OverrideMax, until.Format("15:04"), time.Until(until).Round(time.Minute), resp.Max)
+		}
+		if resp.ChargingFull {
+			fmt.Println("charging to full once: run conservationctl -cancel-full to end it")
+		}
+		if resp.Reason != "" {
+			fmt.Printf("reason: %s\n", resp.Reason)
+		}
+		if resp.HealthMax > 0 {
+			fmt.Printf("health=%.1f%%: max capped at %.1f by -health-adaptive\n", resp.Health, resp.HealthMax)
+		} else if resp.Health > 0 {
+			fmt.Printf("health=%.1f%%\n", resp.Health)
+		}
+		if resp.TempMax > 0 {
+			fmt.Printf("temp=%.1f°C: max capped at %.1f while hot\n", resp.Temp, resp.TempMax)
+		}
+		if resp.External != "" {
+			fmt.Printf("external change to %s pending: run conservationctl -external adopt|enforce\n", resp.External)
+		}
+		for _, b := range resp.Batteries {
+			fmt.Printf("%s: pct=%.0f status=%s", b.Name, b.Pct, b.Status)
+			if b.Threshold > 0 {
+				fmt.Printf(" threshold=%d", b.Threshold)
+			}
+			if b.Behaviour != "" {
+				fmt.Printf(" behaviour=%s", b.Behaviour)
+			}
+			fmt.Println()
+		}
+	case ipc.CmdSummary:
+		s := resp.Summary
+		if s == nil {
+			break
+		}
+		fmt.Printf("since %s\n", time.Unix(s.Since, 0).Format("2006-01-02 15:04"))
+		fmt.Printf("charge_sessions=%d toggles=%d errors=%d\n", s.ChargeSessions, s.Toggles, s.Errors)
+		fmt.Printf("pct_min=%.1f pct_max=%.1f capped=%s\n", s.MinPct, s.MaxPct, time.Duration(s.CappedSeconds)*time.Second)
+	case ipc.CmdClear:
+		fmt.Println("user policy cleared")
+	case ipc.CmdReload, ipc.CmdLogLevel, ipc.CmdPause, ipc.CmdResume, ipc.CmdFull:
+		fmt.Println(resp.Msg)
+	case ipc.CmdConfig:
+		if resp.Msg != "" {
+			fmt.Println(resp.Msg)
+		}
+		names := make([]string, 0, len(resp.Settings))
+		for name := range resp.Settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s=%s\n", name, resp.Settings[name])
+		}
+	case ipc.CmdHello:
+		fmt.Printf("protocol=%d (conservationctl %d)\n", resp.Protocol, ipc.ProtocolVersion)
+		fmt.Printf("commands=%s\n", strings.Join(resp.Commands, ","))
+		fmt.Printf("features=%s\n", strings.Join(resp.Features, ","))
+	case ipc.CmdPreset:
+		if req.Preset != "" {
+			fmt.Printf("preset=%s max=%.1f time=%s auto=%t\n", resp.Preset, resp.Max, resp.Time, resp.Auto)
+			break
+		}
+		for _, p := range resp.Presets {
+			mark := " "
+			if p.Name == resp.Preset {
+				mark = "*"
+			}
+			fmt.Printf("%s %-10s %-14s %.0f/%.0f\n", mark, p.Name, p.Title, p.End, p.Start)
+		}
+	case ipc.CmdStorage:
+		if resp.Storage {
+			fmt.Printf("storage mode on: holding at %.0f%%\n", resp.StorageLevel)
+		} else {
+			fmt.Printf("storage mode off: max=%.1f time=%s auto=%t\n", resp.Max, resp.Time, resp.Auto)
+		}
+	case ipc.CmdCalibrate:
+		for _, c := range resp.Calibrations {
+			fmt.Printf("%s %s", c.Time.Local().Format("2006-01-02 15:04"), c.Result)
+			if c.Health > 0 {
+				fmt.Printf(" health=%.1f%%", c.Health)
+			}
+			if c.Cycles > 0 {
+				fmt.Printf(" cycles=%d", c.Cycles)
+			}
+			fmt.Println()
+		}
+		switch {
+		case resp.Calibration != "":
+			fmt.Printf("calibration: %s\n", resp.Calibration)
+		case resp.NextCalibration > 0:
+			fmt.Printf("next_calibration=%s\n", time.Unix(resp.NextCalibration, 0).Format("2006-01-02 15:04"))
+		case resp.Msg != "":
+			fmt.Println(resp.Msg)
+		case req.Calibrate == "history":
+			fmt.Println("no calibrations yet")
+		}
+	case ipc.CmdSnapshot:
+		data, err := json.MarshalIndent(resp.Snapshot, "", "  ")
+		if err == nil {
+			err = writeFileOrStdout(*backup, append(data, '\n'))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case ipc.CmdExternal:
+		fmt.Printf("max=%.1f time=%s auto=%t\n", resp.Max, resp.Time, resp.Auto)
+	case ipc.CmdRestore:
+		fmt.Printf("restored: max=%.1f time=%s auto=%t\n", resp.Max, resp.Time, resp.Auto)
+	case ipc.CmdKnobs:
+		names := make([]string, 0, len(resp.Knobs))
+		for name := range resp.Knobs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			state := "off"
+			if resp.Knobs[name] {
+				state = "on"
+			}
+			fmt.Printf("%s=%s\n", name, state)
+		}
+	}
+}
+
+// exitErr reports err, with a hint where the daemon's error code suggests
+// one, and exits: 2 for a request the daemon found invalid, like a bad flag,
+// 1 for anything else.
+func exitErr(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	switch code := ipc.ErrorCode(err); {
+	case code == ipc.ErrPermission:
+		fmt.Fprintln(os.Stderr, "hint: join the group named above (sudo usermod -aG <group> $USER), then log in again")
+	case errors.Is(err, os.ErrPermission):
+		fmt.Fprintln(os.Stderr, "hint: the socket is only open to its group; see ls -l on it")
+	case code == ipc.ErrUnsupportedCmd || code == ipc.ErrUnsupportedField:
+		fmt.Fprintln(os.Stderr, "hint: conservationd is older than conservationctl; update it")
+	case code == ipc.ErrInvalid || code == ipc.ErrRange:
+		os.Exit(2)
+	}
+	os.Exit(1)
+}
+
+func readFileOrStdin(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func writeFileOrStdout(path string, data []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: MIT
+
+package ctl
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"conservationDaemon/internal/ipc"
+	"conservationDaemon/internal/metrics"
+)
+
+// promTextfile implements "conservationctl prom-textfile": one status query
+// written in node_exporter textfile-collector format, for cron or a systemd
+// timer.
+func promTextfile(args []string) {
+	fs := flag.NewFlagSet("prom-textfile", flag.ExitOnError)
+	out := fs.String("out", "-", "output file, e.g. /var/lib/node_exporter/textfile/conservationd.prom ('-' for stdout)")
+	sock := fs.String("sock", "", "control socket path")
+	fs.Parse(args)
+
+	resp, err := ipc.Call(ipc.DiscoverSocket(*sock, ""), 0, ipc.Req{Cmd: ipc.CmdStatus})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	s := metrics.Sample{
+		Pct: resp.Pct, Max: resp.Max, State: resp.State, Cons: resp.Cons, Auto: resp.Auto,
+		Health: resp.Health, Temp: resp.Temp, WriteFailures: resp.WriteFailures,
+	}
+	if resp.Updated > 0 {
+		s.Updated = time.Unix(resp.Updated, 0)
+	}
+	if *out == "-" {
+		err = metrics.Write(os.Stdout, s)
+	} else {
+		err = metrics.WriteFile(*out, s)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: MIT
+
+package ctl
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"conservationDaemon/internal/ipc"
+)
+
+// promptTimeout bounds "conservationctl prompt": a prompt must never hang on
+// a stuck daemon. Status is served from the daemon's cached snapshot, so a
+// healthy daemon answers well within it.
+const promptTimeout = 100 * time.Millisecond
+
+// promptSegment implements "conservationctl prompt": a compact status
+// segment such as "🔋78%⛔" for PS1, powerlevel10k or starship. It prints
+// nothing when the daemon is unreachable.
+func promptSegment(args []string) {
+	fs := flag.NewFlagSet("prompt", flag.ExitOnError)
+	shell := fs.String("shell", "", "wrap color escapes for this shell's prompt: bash or zsh (empty: raw escapes)")
+	noColor := fs.Bool("no-color", os.Getenv("NO_COLOR") != "", "print without color escapes")
+	sock := fs.String("sock", "", "control socket path")
+	fs.Parse(args)
+
+	resp, err := ipc.Call(ipc.DiscoverSocket(*sock, ""), promptTimeout, ipc.Req{Cmd: ipc.CmdStatus})
+	if err != nil {
+		return
+	}
+	fmt.Print(segment(resp, *shell, !*noColor))
+}
+
+// segment renders the prompt segment: charge, then ⚡ while charging and ⛔
+// while conservation holds the charge.
+func segment(resp *ipc.Resp, shell string, color bool) string {
+	s := fmt.Sprintf("🔋%.0f%%", resp.Pct)
+	code := ""
+	switch {
+	case resp.Cons > 0:
+		s += "⛔"
+		code = "33" // yellow: capped
+	case resp.State == "charging":
+		s += "⚡"
+		code = "32" // green
+	case resp.Pct < 20:
+		code = "31" // red: low
+	}
+	if !color || code == "" {
+		return s
+	}
+	return esc(shell, "\033["+code+"m") + s + esc(shell, "\033[0m")
+}
+
+// esc marks an escape sequence as zero-width so the shell measures the
+// prompt correctly. bash doesn't decode \[ \] in command substitution
+// output, so readline's own start/end-ignore markers are used.
+func esc(shell, seq string) string {
+	switch shell {
+	case "bash":
+		return "\001" + seq + "\002"
+	case "zsh":
+		return "%{" + seq + "%}"
+	}
+	return seq
+}
@@ -0,0 +1,1296 @@
+// SPDX-License-Identifier: MIT
+// Requires: UPower daemon, ideapad_laptop kernel module.
+// Caveat: Conservation mode is binary and typically targets ~80% when enabled.
+
+// Package daemon is conservationd, the root daemon driving the charge knob.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/calendar"
+	"conservationDaemon/internal/carbon"
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/control"
+	"conservationDaemon/internal/geo"
+	"conservationDaemon/internal/helper"
+	"conservationDaemon/internal/hotkey"
+	"conservationDaemon/internal/ipc"
+	"conservationDaemon/internal/logging"
+	"conservationDaemon/internal/metrics"
+	"conservationDaemon/internal/monitor"
+	"conservationDaemon/internal/platform"
+	"conservationDaemon/internal/quirks"
+	"conservationDaemon/internal/telemetry"
+	"conservationDaemon/internal/version"
+)
+
+// Main runs conservationd with the flags in os.Args.
+func Main() {
+	cfg, flags, err := parseFlags(os.Args[1:])
+	if err != nil {
+		exitErr(err)
+	}
+	if cfg.EventsJSON {
+		logging.EnableEvents(os.Stdout)
+	}
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.SetLevel(level)
+	if err := cfg.Validate(); err != nil {
+		exitErr(err)
+	}
+	if cfg.LogFile != "" {
+		lf, err := logging.OpenFile(cfg.LogFile, int64(cfg.LogMaxSizeMB)<<20, cfg.LogRotateEvery, cfg.LogKeep)
+		if err != nil {
+			exitErr(err)
+		}
+		defer lf.Close()
+		logging.SetOutput(lf)
+	} else if logging.UnderJournal() {
+		if err := logging.UseJournal(); err != nil {
+			logging.Warnf("%v (logging to stdout)", err)
+		}
+	}
+	if cfg.BatteryName == "" {
+		cfg.BatteryName = backend.DefaultBattery()
+	}
+
+	var node backend.Node
+	how := "detected"
+	switch {
+	case cfg.SysfsPath != "":
+		how = "explicit"
+		node, err = backend.Discover(cfg.SysfsPath, cfg.BatteryName)
+	case cfg.Backend != "":
+		how = "forced"
+		node, err = backend.Open(cfg.Backend, cfg.BatteryName)
+	default:
+		node, err = backend.Discover("", cfg.BatteryName)
+		if err != nil && cfg.Modprobe {
+			logging.Logf("no conservation knob found, trying modprobe %s", backend.Module)
+			if merr := backend.LoadModule(); merr != nil {
+				logging.Warnf("%v", merr)
+			} else {
+				node, err = backend.Discover("", cfg.BatteryName)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("%w\n%s", err, backend.Diagnose())
+		}
+	}
+	if err != nil {
+		exitErr(err)
+	}
+	logging.Logf("Using %s %s backend: %s", how, node.Kind, node.Path)
+	logging.Event("started", map[string]any{"version": version.Version, "backend": node.Kind.String(), "knob": node.Path})
+
+	dmi := quirks.ReadDMI()
+	prof := quirks.Detect(dmi)
+	cfg.Quirks = prof.Name
+	logging.Logf("Hardware: %s; quirk profile: %s (rapid_charge_conflict=%t reset_after_suspend=%t rewrite_after_resume=%t)",
+		dmi, prof.Name, prof.RapidChargeConflict, prof.ResetAfterSuspend, prof.RewriteAfterResume)
+
+	helped := !cfg.DryRun && !backend.Writable(node.Path)
+	if helped {
+		useHelper(cfg.HelperSock, node.Path)
+	}
+	if cfg.User != "" && (node.Kind == backend.FrameworkEC || node.Kind == backend.CrosECSustainer) {
+		exitErr(fmt.Errorf("-user: the %s backend runs ectool, which needs root", node.Kind))
+	}
+
+	node.Hold = int(cfg.ConservationThreshold)
+	node.Start = int(cfg.StartThreshold)
+	node.StorageHold = int(cfg.StorageLevel)
+	node.Levels = &backend.Levels{}
+	node.Levels.Set(node.Hold, node.Start)
+	cfg.PercentKnob = node.Capabilities().Percent
+	var knob control.Knob = node
+	if other, ok := backend.Counterpart(node, cfg.BatteryName); ok && how != "explicit" {
+		primary, secondary := backend.Order(node, other, cfg.KnobPrecedence)
+		linked := backend.Linked{Primary: primary, Secondary: secondary}
+		logging.Logf("Both %s and %s present: driving %s, keeping %s off", primary.Kind, secondary.Kind, primary.Path, secondary.Path)
+		if !cfg.DryRun {
+			if err := linked.Neutralize(); err != nil {
+				logging.Warnf("%v", err)
+			}
+		}
+		node, knob = primary, linked
+	}
+	// Every other controllable battery follows the driven one, unless it has
+	// its own -battery-thresholds entry
+	if others := backend.Siblings(node, cfg.BatteryThresholds); len(others) > 0 && how != "explicit" {
+		for _, o := range others {
+			logging.Logf("also driving %s", o.Path)
+		}
+		knob = backend.Mirrored{Knob: knob, Others: others}
+	}
+	// Calibration needs force-discharge: borrow the battery's charge_behaviour
+	canDischarge := node.Capabilities().ForceDischarge
+	if p := backend.FindChargeBehaviourNode(cfg.BatteryName); !canDischarge && p != "" {
+		knob = backend.WithDischarge{Knob: knob, Behaviour: backend.Node{Path: p, Kind: backend.ChargeBehaviour}}
+		canDischarge = true
+	}
+	// Only a percentage threshold can hold below the firmware's fixed level
+	if cfg.LowThresholds && !node.Capabilities().Percent {
+		exitErr(fmt.Errorf("-allow-low-thresholds needs a percentage threshold such as charge_control_end_threshold; %s holds at a fixed level", node.Kind))
+	}
+	// Rapid charge and conservation together are undefined on every ideapad,
+	// not only on the models whose quirk profile is known to misbehave. The
+	// setting is read at each write, as a restore may change it.
+	var st *control.State
+	if node.Kind == backend.ConservationMode {
+		if cfg.RapidChargeConflict == "ignore" && prof.RapidChargeConflict {
+			logging.Warnf("%s ignores conservation mode while rapid charge is on", prof.Name)
+		}
+		knob = quirks.RapidChargePolicy(knob, node.Path, func() string { return st.Config().RapidChargeConflict })
+	}
+
+	// Stop cleanly on SIGTERM so the session summary gets logged. A second
+	// signal kills the daemon if the shutdown hangs.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		exitErr(fmt.Errorf("connect system bus: %w", err))
+	}
+	defer conn.Close()
+
+	if prof.VendorDaemon != "" && node.Kind == backend.ChargeThresholds {
+		// e.g. system76-power restores its own thresholds at boot
+		if owned, err := monitor.NameHasOwner(ctx, conn, prof.VendorDaemon); err != nil {
+			logging.Warnf("%v", err)
+		} else if owned {
+			logging.Warnf("%s is running and also manages the charge thresholds; leave them to conservationd (changes it makes are handled per -external-change)", prof.VendorDaemon)
+		}
+	}
+
+	// Battery source: UPower, or sysfs on systems without it
+	var (
+		battery   control.BatterySource
+		onBattery func(ctx context.Context) (bool, error)
+		bat       *monitor.Battery
+	)
+	if cfg.BatterySource != "sysfs" {
+		bat, err = monitor.NewBattery(ctx, conn)
+		switch {
+		case err == nil:
+			battery, onBattery = bat, bat.OnBattery
+			logging.Logf("Using UPower battery path: %s", bat.Path())
+		case cfg.BatterySource == "upower":
+			exitErr(err)
+		default:
+			logging.Warnf("upower: %v (falling back to sysfs)", err)
+		}
+	}
+	if battery == nil {
+		sb := monitor.SysfsBattery{Name: cfg.BatteryName}
+		if _, _, err := sb.Read(ctx); err != nil {
+			exitErr(fmt.Errorf("sysfs battery %s: %w", cfg.BatteryName, err))
+		}
+		battery, onBattery = sb, sb.OnBattery
+		logging.Logf("Using sysfs battery: %s", cfg.BatteryName)
+	}
+
+	// Load persisted state (overrides CLI defaults for auto/max)
+	if cfg.StatePath != "" {
+		if err := config.LoadState(cfg.StatePath, &cfg); err != nil {
+			logging.Warnf("load state: %v (using defaults)", err)
+		} else {
+			logging.Logf("loaded persisted state: auto=%t max=%.1f", cfg.Auto, cfg.MaxPercent)
+			if cfg.Preset != "" {
+				logging.Logf("threshold preset %s: threshold=%.0f start=%.0f", cfg.Preset, cfg.ConservationThreshold, cfg.StartThreshold)
+			}
+			if cfg.TargetTime != nil {
+				logging.Logf("resuming schedule: %.1f%% by %s", cfg.MaxPercent, cfg.TargetTime.Format("2006-01-02 15:04"))
+			}
+		}
+	}
+	canStore := node.Capabilities().Storage
+	if cfg.Storage && !canStore {
+		logging.Warnf("storage mode needs a percentage threshold backend; %s has none, ignoring it", node.Kind)
+		cfg.Storage = false
+	}
+	if (cfg.CalibrateEvery > 0 || cfg.CalibrateAt != nil) && !canDischarge {
+		logging.Warnf("calibration scheduled but %s has no force-discharge; not calibrating", node.Path)
+		cfg.CalibrateEvery, cfg.CalibrateAt = 0, nil
+	}
+	cfg.ScheduleNextCalibration(time.Now())
+	if cfg.CalibrateAt != nil {
+		logging.Logf("next battery calibration: %s", cfg.CalibrateAt.Format("2006-01-02 15:04"))
+	}
+
+	// Shared state for control-plane
+	st = control.NewState(cfg)
+	st.SetBackend(node.Kind.String())
+	if cfg.BackendFallback && how == "detected" {
+		knob = withFallback(knob, node, cfg, st)
+	}
+	ctrl := &control.Controller{
+		State:   st,
+		Battery: battery,
+		Knob:    knob,
+		KnobID:  node.Path,
+		Display: monitor.ExternalDisplayConnected,
+	}
+	if cfg.PercentKnob {
+		ctrl.Levels = node.Levels
+		if node.HasLevel() {
+			ctrl.Level = node.Level
+		}
+	}
+	if node.HasRefresh() {
+		ctrl.Refresh = node.Refresh
+	}
+	if cur, ok := backend.FindCurrentNode(cfg.BatteryName); ok {
+		ctrl.Current = cur
+		logging.Logf("Charge current limit available: %s (default %d mA)", cur.Path, cur.Default)
+	} else if cfg.ChargeCurrentMA > 0 {
+		logging.Warnf("charge current limit requested but %s exposes no writable constant_charge_current_max", cfg.BatteryName)
+	}
+	// Wired whether or not enabled, so a reload can turn them on
+	sb := monitor.SysfsBattery{Name: cfg.BatteryName}
+	ctrl.Health = func() (float64, error) {
+		health, _, err := sb.Health()
+		return health, err
+	}
+	ctrl.Gauge = sb.Health
+	ctrl.Temperature = sb.Temp
+	if len(cfg.BatteryThresholds) > 0 || cfg.ChargeFirst != "" {
+		ctrl.Batteries = backend.Batteries{Thresholds: cfg.BatteryThresholds, ChargeFirst: cfg.ChargeFirst}
+	}
+	if len(cfg.PlatformProfiles) > 0 {
+		if prof, err := platform.Find(); err != nil {
+			logging.Warnf("%v", err)
+		} else if err := prof.Check(cfg.PlatformProfiles); err != nil {
+			exitErr(err)
+		} else {
+			ctrl.Platform = prof
+		}
+	}
+	if cfg.MultiUser {
+		ctrl.Sessions = func(ctx context.Context) ([]monitor.Session, error) {
+			return monitor.ActiveSessions(ctx, conn)
+		}
+	}
+	if len(cfg.Places) > 0 {
+		if tr, err := geo.Track(ctx, conn); err != nil {
+			logging.Warnf("geoclue: %v (location profiles disabled)", err)
+		} else {
+			ctrl.Location = tr.Location
+			logging.Logf("location profiles: %d place(s), away target %.1f%%", len(cfg.Places), cfg.AwayMax)
+		}
+	}
+	if cfg.Calendar != "" {
+		trips := &calendar.Trips{Source: cfg.Calendar, Tag: cfg.CalendarTag}
+		go refreshCalendar(ctx, trips, cfg.CalendarRefresh)
+		ctrl.Trip = func(now time.Time) (calendar.Event, bool) {
+			return trips.Next(now, cfg.CalendarLookahead)
+		}
+	}
+	if cfg.Carbon != "" {
+		provider, err := carbon.New(cfg.Carbon, cfg.CarbonRegion, cfg.CarbonToken)
+		if err != nil {
+			exitErr(err)
+		}
+		fc := &carbon.Forecaster{Provider: provider}
+		go refreshCarbon(ctx, fc, cfg.CarbonRefresh)
+		ctrl.LowCarbon = fc.Low
+	}
+	if cfg.TariffCommand != "" {
+		hook := &control.TariffHook{Command: cfg.TariffCommand}
+		go refreshTariff(ctx, hook, cfg.TariffRefresh)
+		ctrl.Tariff = hook.Windows
+	}
+	var events []<-chan struct{}
+	if bat != nil {
+		if ch, err := bat.Watch(ctx); err != nil {
+			logging.Warnf("watch upower: %v", err)
+		} else {
+			events = append(events, ch)
+		}
+	}
+	// Kernel uevents make AC plug/unplug act immediately, UPower or not
+	if ch, err := monitor.WatchUevents(ctx); err != nil {
+		logging.Warnf("watch uevents: %v", err)
+	} else {
+		events = append(events, ch)
+	}
+	if len(events) == 0 {
+		logging.Logf("no battery event source: polling only")
+	} else {
+		ctrl.OnBattery = onBattery
+	}
+	if cfg.Hotkey != "" {
+		if ch, err := watchHotkey(ctx, st, cfg.Hotkey, uint16(cfg.HotkeyCode)); err != nil {
+			logging.Warnf("hotkey: %v", err)
+		} else {
+			events = append(events, ch)
+		}
+	}
+	rl := &reloader{flags: flags, st: st, canStore: canStore, canDischarge: canDischarge, canPercent: node.Capabilities().Percent, dryStart: cfg.DryRun, applied: make(chan struct{}, 1)}
+	events = append(events, rl.applied)
+	rewrite := prof.RewriteAfterResume && node.Kind == backend.ChargeThresholds
+	if prof.ResetAfterSuspend || rewrite {
+		// The node forgets its value across suspend: re-apply on resume
+		if ch, err := monitor.WatchResume(ctx, conn); err != nil {
+			logging.Warnf("watch resume: %v", err)
+		} else {
+			if rewrite && !cfg.DryRun {
+				ch = quirks.Rewrite(ctx, knob, ch)
+			}
+			events = append(events, ch)
+		}
+	}
+	if len(events) > 0 {
+		ctrl.Events = monitor.Merge(ctx, events...)
+	}
+
+	if cfg.Once {
+		ctrl.Step(ctx)
+		return
+	}
+
+	go watchReload(ctx, rl)
+	if cfg.Telemetry != "" {
+		startTelemetry(ctx, st, cfg, dmi, node.Kind)
+	}
+	if cfg.PromTextfile != "" {
+		go metrics.Run(ctx, cfg.PromTextfile, cfg.PromInterval, func() metrics.Sample {
+			s := st.Status()
+			return metrics.Sample{
+				Pct: s.Pct, Max: s.Config.MaxPercent, State: s.BatteryState.String(), Cons: s.Cons, Auto: s.Config.Auto,
+				Health: s.Health, Temp: s.Temp, WriteFailures: s.WriteFailures, Updated: s.Updated,
+			}
+		}, logging.Logf)
+	}
+
+	// Start control socket and D-Bus API
+	srv := &ipc.Server{State: st, MaxConns: cfg.MaxConns, RateLimit: cfg.RateLimit, Extras: backend.FindExtras(), Batteries: backend.ListBatteries,
+		CanCalibrate: canDischarge, CanStore: canStore, Reload: rl.reload, Configure: rl.configure, AdminGroup: cfg.AdminGroup}
+	if ipc.Abstract(cfg.SockPath) {
+		srv.Group = cfg.SockGroup
+	}
+	if cfg.DBus {
+		go func() {
+			if err := srv.ServeBus(ctx, conn); err != nil {
+				logging.Warnf("%v (D-Bus API disabled)", err)
+			}
+		}()
+	}
+	var serving sync.WaitGroup
+	if cfg.SockPath != "" {
+		var ln net.Listener
+		ln, err = ipc.Listen(cfg.SockPath, cfg.SockGroup)
+		if err != nil {
+			exitErr(err)
+		}
+		serving.Add(1)
+		go func() {
+			defer serving.Done()
+			srv.Serve(ctx, ln)
+		}()
+	}
+	if cfg.StatusSockPath != "" {
+		ln, err := ipc.ListenStatus(cfg.StatusSockPath)
+		if err != nil {
+			exitErr(err)
+		}
+		ro := *srv
+		ro.ReadOnly, ro.Group = true, ""
+		serving.Add(1)
+		go func() {
+			defer serving.Done()
+			ro.Serve(ctx, ln)
+		}()
+	}
+	if cfg.HTTPAddr != "" {
+		if err := serveHTTP(ctx, &serving, srv, cfg); err != nil {
+			exitErr(err)
+		}
+	}
+
+	if cfg.User != "" {
+		if err := dropPrivileges(cfg, !helped && !cfg.DryRun); err != nil {
+			exitErr(err)
+		}
+	}
+
+	ctrl.Run(ctx, cfg.PollInterval)
+
+	// The step in progress has finished or given up with ctx: stop
+	// answering, remove the socket and flush the state
+	logging.Logf("shutting down")
+	serving.Wait()
+	ctrl.Exit()
+	if cur := st.Config(); cur.StatePath != "" {
+		if err := config.SaveState(cur.StatePath, cur); err != nil {
+			logging.Errorf("save state: %v", err)
+		}
+	}
+
+	sum := st.Summary()
+	logging.Logf("%s", sum)
+	logging.Event("summary", map[string]any{
+		"since": sum.Since.Format(time.RFC3339), "charge_sessions": sum.ChargeSessions, "toggles": sum.Toggles,
+		"min_pct": sum.MinPct, "max_pct": sum.MaxPct, "capped_seconds": int64(sum.Capped.Seconds()), "errors": sum.Errors,
+	})
+}
+
+// parseFlags parses args, then the configuration file, into a Config. The
+// returned FlagSet holds the values both gave, for a later reload to compare.
+func parseFlags(args []string) (config.Config, *flag.FlagSet, error) {
+	flags := flag.NewFlagSet("conservationd", flag.ExitOnError)
+	showVersion := flags.Bool("version", false, "print version and exit")
+	max := flags.Float64("max", 80, "target maximum percentage to start capping (conservation-threshold..100)")
+	conservationThreshold := flags.Float64("conservation-threshold", 80, "battery percentage at which conservation mode activates (default varies by laptop model)")
+	interval := flags.Duration("interval", 45*time.Second, "poll interval")
+	dry := flags.Bool("dry-run", false, "do not write sysfs, only log actions")
+	once := flags.Bool("once", false, "perform a single control step and exit")
+	auto := flags.Bool("auto", false, "enable/disable conservation mode based on external monitor connection status")
+	startThreshold := flags.Float64("start-threshold", 0, "with charge_control thresholds (ThinkPad and others), resume charging below this percentage while conserving (0 = 5 below -conservation-threshold)")
+	lowThresholds := flags.Bool("allow-low-thresholds", false, fmt.Sprintf("allow -conservation-threshold (and -max) down to %d%%; needs a backend with real percentage thresholds (charge_control_end_threshold)", config.LowThresholdFloor))
+	storage := flags.Bool("storage", false, "storage mode: hold at -storage-level for a laptop put away for weeks, whatever -max, -auto or a schedule say")
+	storageLevel := flags.Float64("storage-level", 55, "charge level storage mode holds at (below -conservation-threshold); needs a percentage threshold backend")
+	safetyFloor := flags.Float64("safety-floor", 15, "always allow charging below this battery percentage, overriding every mode and schedule")
+	externalPolicy := flags.String("external-change", "enforce", "when another tool (e.g. KDE PowerDevil) changes the knob: enforce (revert it), adopt (make it the new setting) or ask (pause until conservationctl -external decides)")
+	onExit := flags.String("on-exit", "keep", "conservation when the daemon stops: keep (as last set), on or off (charging resumes)")
+	followExternal := flags.Bool("follow-external", false, "shorthand for -external-change adopt")
+	chargeCurrent := flags.Int("charge-current", 0, "cap the charge current in mA where the platform supports it (0 = platform default)")
+	places := flags.String("places", "", "opt-in location profiles: \"lat,lon[,radius_km];...\" places considered home (GeoClue, city accuracy)")
+	awayMax := flags.Float64("away-max", 100, "target maximum percentage away from every -places entry")
+	awayCurrent := flags.Int("away-charge-current", 0, "cap the charge current in mA away from every -places entry (0 = -charge-current)")
+	calSrc := flags.String("calendar", "", "iCalendar (.ics) path or http(s) URL to scan for trips")
+	calTag := flags.String("calendar-tag", "[travel]", "case-insensitive marker in event summaries that arms trip mode")
+	calLookahead := flags.Duration("calendar-lookahead", 12*time.Hour, "arm trip mode for events starting within this window")
+	calRefresh := flags.Duration("calendar-refresh", 15*time.Minute, "how often to reload the calendar")
+	tripMax := flags.Float64("trip-max", 100, "target maximum percentage before a trip")
+	platformProfiles := flags.String("platform-profile", "", "opt-in platform_profile per mode, e.g. \"conserve=low-power,charge=balanced,trip=performance\" (modes: conserve, charge, trip, away)")
+	batThresholds := flags.String("battery-thresholds", "", "per-battery end thresholds on multi-battery machines, e.g. \"BAT0=80,BAT1=60\"")
+	chargeFirst := flags.String("charge-first", "", "battery to charge before the others (needs charge_behaviour on the others), e.g. BAT1")
+	healthAdaptive := flags.Bool("health-adaptive", false, "lower the target as the battery ages (see -health-below, -health-max)")
+	healthBelow := flags.Float64("health-below", 85, "with -health-adaptive, cap the target once full capacity drops below this percentage of design capacity")
+	healthMax := flags.Float64("health-max", 78, "with -health-adaptive, the capped target percentage")
+	offPeak := flags.String("offpeak", "", "cheap-rate windows for charging above the threshold, e.g. \"23:00-07:00,13:00-15:00\"")
+	schedule := flags.String("schedule", "", "recurring target changes, e.g. \"mon-fri 06:30 full, mon-fri 18:00 80\" (see README)")
+	chargeWindow := flags.String("charge-window", "", "opt-in: charge only inside these windows and hold the battery outside them, e.g. \"23:00-07:00\"")
+	tariff := flags.String("tariff", "", "JSON tariff file with cheap-rate windows (see README)")
+	tariffCmd := flags.String("tariff-command", "", "script printing a JSON tariff document with more cheap-rate windows, run with sh -c")
+	tariffRefresh := flags.Duration("tariff-refresh", time.Hour, "how often to run -tariff-command")
+	carbonProvider := flags.String("carbon", "", "carbon-aware charging: forecast provider, uk (carbonintensity.org.uk) or electricitymaps")
+	carbonRegion := flags.String("carbon-region", "", "UK region id (1..17, national if empty) or Electricity Maps zone (e.g. DE)")
+	carbonToken := flags.String("carbon-token", "", "Electricity Maps API token (or $CONSERVATIOND_CARBON_TOKEN)")
+	carbonDeadline := flags.String("carbon-deadline", "07:00", "with -carbon, time of day by which the battery must be charged")
+	carbonRefresh := flags.Duration("carbon-refresh", time.Hour, "how often to fetch the carbon forecast")
+	tempLimit := flags.Float64("temp-limit", 0, "cap the target while the battery stays above this temperature in °C (0 = off)")
+	tempSustain := flags.Duration("temp-sustain", 10*time.Minute, "how long the battery must stay above -temp-limit before the cap applies")
+	tempMax := flags.Float64("temp-max", 80, "target maximum percentage while the battery is hot")
+	telemetryURL := flags.String("telemetry", "", "opt-in: upload anonymous battery health reports (model, capacity, cycle count, thresholds) to this URL")
+	telemetryInterval := flags.Duration("telemetry-interval", 7*24*time.Hour, "how often to send a -telemetry report")
+	promTextfile := flags.String("prom-textfile", "", "write metrics for node_exporter's textfile collector to this file, e.g. /var/lib/node_exporter/textfile/conservationd.prom")
+	promInterval := flags.Duration("prom-interval", time.Minute, "how often to rewrite the -prom-textfile file")
+	hotkeyDev := flags.String("hotkey", "", "toggle conservation with a hardware key on this input device (name or /dev/input path, e.g. \""+hotkey.DefaultDevice+"\")")
+	hotkeyCode := flags.Int("hotkey-code", hotkey.KeyBattery, "key code for -hotkey (default KEY_BATTERY)")
+	batterySource := flags.String("battery-source", "auto", "battery readings: upower, sysfs, or auto (UPower, falling back to sysfs)")
+	helperSock := flags.String("helper", helper.DefaultSock, "host helper socket, used when the knob is read-only here (containers, Flatpak)")
+	rapidConflict := flags.String("rapid-charge-conflict", "disable", "ideapad rapid_charge on when conservation turns on: disable (turn rapid charge off), refuse (keep conservation off) or ignore")
+	backendFallback := flags.Bool("backend-fallback", true, "when the detected backend keeps failing (node gone, write errors), switch to the next detected one")
+	modprobe := flags.Bool("modprobe", true, "try loading ideapad_laptop when no conservation knob is found")
+	precedence := flags.String("precedence", "charge_thresholds", "knob to drive when both charge_thresholds and conservation_mode exist; the other is kept off")
+	multiUser := flags.Bool("multi-user", false, "let each user set their own policy; the active seat0 session's policy wins")
+	logLevel := flags.String("log-level", "info", "log messages at this level and above: debug, info, warn or error")
+	logFile := flags.String("log-file", "", "write log lines to this file, rotating it, instead of stdout or the journal")
+	logMaxSize := flags.Int("log-max-size", 10, "rotate -log-file once it would grow past this many MiB (0 = never)")
+	logRotateEvery := flags.Duration("log-rotate-every", 0, "also rotate -log-file every period, e.g. 24h for midnight UTC (0 = never)")
+	logKeep := flags.Int("log-keep", 5, "rotated -log-file files to keep (file.1 newest)")
+	eventsJSON := flags.Bool("events-json", false, "emit one JSON event per line on stdout for every decision and state change (logs move to stderr)")
+	lowPower := flags.Bool("low-power", false, "stop periodic polling while on battery with conservation settled; react to UPower events only")
+	backendName := flags.String("backend", "", "force a backend (see -list-backends); auto-detect if empty")
+	listBackends := flags.Bool("list-backends", false, "list compiled-in backends with their detection results and exit")
+	sysfs := flags.String("sysfs", "", "explicit conservation_mode path; auto-discover if empty")
+	battery := flags.String("battery", "", "battery to drive, e.g. BAT0, BAT1 or CMB0 (default: the first battery with a charge-control knob)")
+	sock := flags.String("sock", ipc.DefaultSock, "UNIX control socket path, or @name for an abstract socket ('' to disable)")
+	adminGroup := flags.String("admin-group", "", "only root and this group may change settings over the socket; everyone in -sock-group can still read ('' = everyone in -sock-group may change them)")
+	dbusAPI := flags.Bool("dbus", true, "serve the org.conservationd.Manager D-Bus API on the system bus")
+	statusSock := flags.String("status-sock", "", "read-only status socket anyone may connect to, e.g. /run/conservationd/status.sock ('' to disable)")
+	httpAddr := flags.String("http", "", "serve a JSON HTTP API on this address, e.g. 127.0.0.1:8765 ('' to disable)")
+	httpToken := flags.String("http-token-file", "", "file holding the bearer token that allows changes over -http (without it, the API is read-only)")
+	sockGroup := flags.String("sock-group", "conservationd", "group name to own the socket (0660)")
+	maxConns := flags.Int("max-conns", ipc.DefaultMaxConns, "maximum concurrent control socket connections")
+	rateLimit := flags.Float64("rate-limit", ipc.DefaultRateLimit, "socket requests per second each client (by uid) may make, with bursts of twice that; faster clients are slowed down (0 = no limit)")
+	calibrateEvery := flags.String("calibrate-every", "off", "schedule a battery calibration this often: monthly, weekly, a duration (e.g. 1440h) or off; needs force-discharge (charge_behaviour)")
+	runAs := flags.String("user", "", "after startup, drop root and run as this user, keeping the sysfs files it writes open (e.g. conservationd)")
+	configPath := flags.String("config", config.DefaultFile, "configuration file of flag = value lines, e.g. max = 90; flags on the command line win")
+	statePath := flags.String("state", "/var/lib/conservationd/state.json", "path to persist runtime state ('' to disable)")
+	flags.Parse(args)
+
+	if *showVersion {
+		fmt.Println(version.String("conservationd"))
+		os.Exit(0)
+	}
+	if err := applyFile(flags, *configPath); err != nil {
+		return config.Config{}, nil, err
+	}
+	if _, err := logging.ParseLevel(*logLevel); err != nil {
+		return config.Config{}, nil, err
+	}
+	if *adminGroup != "" {
+		if _, err := user.LookupGroup(*adminGroup); err != nil {
+			return config.Config{}, nil, fmt.Errorf("-admin-group: %w", err)
+		}
+	}
+	if *listBackends {
+		printBackends(*battery)
+		os.Exit(0)
+	}
+	placeList, err := config.ParsePlaces(*places)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+	profiles, err := config.ParsePlatformProfiles(*platformProfiles)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+	thresholds, err := backend.ParseBatteryThresholds(*batThresholds)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+	windows, err := config.ParseWindows(*offPeak)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+	chargeWindows, err := config.ParseWindows(*chargeWindow)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+	rules, err := config.ParseRules(*schedule)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+	if *telemetryURL != "" && *telemetryInterval <= 0 {
+		return config.Config{}, nil, fmt.Errorf("telemetry-interval must be positive, got %v", *telemetryInterval)
+	}
+	if *carbonProvider != "" && *carbonRefresh <= 0 {
+		return config.Config{}, nil, fmt.Errorf("carbon-refresh must be positive, got %v", *carbonRefresh)
+	}
+	if *calSrc != "" && *calRefresh <= 0 {
+		return config.Config{}, nil, fmt.Errorf("calendar-refresh must be positive, got %v", *calRefresh)
+	}
+	if *tariffCmd != "" && *tariffRefresh <= 0 {
+		return config.Config{}, nil, fmt.Errorf("tariff-refresh must be positive, got %v", *tariffRefresh)
+	}
+	if *rateLimit < 0 {
+		return config.Config{}, nil, fmt.Errorf("rate-limit must be >= 0, got %v", *rateLimit)
+	}
+	if *interval <= 0 {
+		return config.Config{}, nil, fmt.Errorf("interval must be positive, got %v", *interval)
+	}
+	every, err := control.ParseCalibrationInterval(*calibrateEvery)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+	var deadline time.Duration
+	if dt, err := time.Parse("15:04", *carbonDeadline); err != nil {
+		return config.Config{}, nil, fmt.Errorf("carbon-deadline must be in HH:MM format, got %s", *carbonDeadline)
+	} else {
+		deadline = time.Duration(dt.Hour())*time.Hour + time.Duration(dt.Minute())*time.Minute
+	}
+	if *carbonToken == "" {
+		*carbonToken = os.Getenv("CONSERVATIOND_CARBON_TOKEN")
+	}
+	if *tariff != "" {
+		tw, err := config.LoadTariff(*tariff)
+		if err != nil {
+			return config.Config{}, nil, err
+		}
+		windows = append(windows, tw...)
+	}
+	if *followExternal {
+		*externalPolicy = "adopt"
+	}
+	cfg := config.Config{
+		MaxPercent:            *max,
+		ConservationThreshold: *conservationThreshold,
+		LowThresholds:         *lowThresholds,
+		StartThreshold:        *startThreshold,
+		SafetyFloor:           *safetyFloor,
+		PollInterval:          *interval,
+		DryRun:                *dry,
+		Once:                  *once,
+		Auto:                  *auto,
+		Storage:               *storage,
+		StorageLevel:          *storageLevel,
+		LowPower:              *lowPower,
+		EventsJSON:            *eventsJSON,
+		LogLevel:              *logLevel,
+		LogFile:               *logFile,
+		LogMaxSizeMB:          *logMaxSize,
+		LogRotateEvery:        *logRotateEvery,
+		LogKeep:               *logKeep,
+		MultiUser:             *multiUser,
+		ExternalPolicy:        *externalPolicy,
+		OnExit:                *onExit,
+		ChargeCurrentMA:       *chargeCurrent,
+		Backend:               *backendName,
+		KnobPrecedence:        *precedence,
+		Modprobe:              *modprobe,
+		BackendFallback:       *backendFallback,
+		RapidChargeConflict:   *rapidConflict,
+		HelperSock:            *helperSock,
+		BatterySource:         *batterySource,
+		Hotkey:                *hotkeyDev,
+		HotkeyCode:            *hotkeyCode,
+		PlatformProfiles:      profiles,
+		BatteryThresholds:     thresholds,
+		ChargeFirst:           *chargeFirst,
+		SysfsPath:             *sysfs,
+		BatteryName:           *battery,
+		SockPath:              *sock,
+		SockGroup:             *sockGroup,
+		StatusSockPath:        *statusSock,
+		AdminGroup:            *adminGroup,
+		HTTPAddr:              *httpAddr,
+		HTTPTokenFile:         *httpToken,
+		DBus:                  *dbusAPI,
+		MaxConns:              *maxConns,
+		RateLimit:             *rateLimit,
+		StatePath:             *statePath,
+		User:                  *runAs,
+		OffPeak:               windows,
+		ChargeWindow:          chargeWindows,
+		TariffCommand:         *tariffCmd,
+		TariffRefresh:         *tariffRefresh,
+		Schedule:              rules,
+		Carbon:                *carbonProvider,
+		CarbonRegion:          *carbonRegion,
+		CarbonToken:           *carbonToken,
+		CarbonDeadline:        deadline,
+		CarbonRefresh:         *carbonRefresh,
+		Places:                placeList,
+		AwayMax:               *awayMax,
+		AwayChargeCurrentMA:   *awayCurrent,
+		Calendar:              *calSrc,
+		CalendarTag:           *calTag,
+		CalendarLookahead:     *calLookahead,
+		CalendarRefresh:       *calRefresh,
+		TripMax:               *tripMax,
+		HealthAdaptive:        *healthAdaptive,
+		HealthBelow:           *healthBelow,
+		HealthMax:             *healthMax,
+		TempLimit:             *tempLimit,
+		TempSustain:           *tempSustain,
+		TempMax:               *tempMax,
+		Telemetry:             *telemetryURL,
+		TelemetryInterval:     *telemetryInterval,
+		PromTextfile:          *promTextfile,
+		PromInterval:          *promInterval,
+		CalibrateEvery:        every,
+	}
+	cfg.Configured = cfg.Settings()
+	return cfg, flags, nil
+}
+
+// reloadable maps every flag a reload applies at once to the Config fields
+// it sets. The other flags only take effect on restart.
+var reloadable = map[string]func(cfg *config.Config, next config.Config){
+	"max":                    func(cfg *config.Config, next config.Config) { cfg.MaxPercent = next.MaxPercent },
+	"auto":                   func(cfg *config.Config, next config.Config) { cfg.Auto = next.Auto },
+	"conservation-threshold": func(cfg *config.Config, next config.Config) { cfg.ConservationThreshold = next.ConservationThreshold },
+	"start-threshold":        func(cfg *config.Config, next config.Config) { cfg.StartThreshold = next.StartThreshold },
+	"allow-low-thresholds":   func(cfg *config.Config, next config.Config) { cfg.LowThresholds = next.LowThresholds },
+	"safety-floor":           func(cfg *config.Config, next config.Config) { cfg.SafetyFloor = next.SafetyFloor },
+	"storage":                func(cfg *config.Config, next config.Config) { cfg.Storage = next.Storage },
+	"external-change":        func(cfg *config.Config, next config.Config) { cfg.ExternalPolicy = next.ExternalPolicy },
+	"follow-external":        func(cfg *config.Config, next config.Config) { cfg.ExternalPolicy = next.ExternalPolicy },
+	"on-exit":                func(cfg *config.Config, next config.Config) { cfg.OnExit = next.OnExit },
+	"charge-current":         func(cfg *config.Config, next config.Config) { cfg.ChargeCurrentMA = next.ChargeCurrentMA },
+	"offpeak":                func(cfg *config.Config, next config.Config) { cfg.OffPeak = next.OffPeak },
+	"tariff":                 func(cfg *config.Config, next config.Config) { cfg.OffPeak = next.OffPeak },
+	"charge-window":          func(cfg *config.Config, next config.Config) { cfg.ChargeWindow = next.ChargeWindow },
+	"schedule":               func(cfg *config.Config, next config.Config) { cfg.Schedule = next.Schedule },
+	"away-max":               func(cfg *config.Config, next config.Config) { cfg.AwayMax = next.AwayMax },
+	"away-charge-current":    func(cfg *config.Config, next config.Config) { cfg.AwayChargeCurrentMA = next.AwayChargeCurrentMA },
+	"trip-max":               func(cfg *config.Config, next config.Config) { cfg.TripMax = next.TripMax },
+	"health-adaptive":        func(cfg *config.Config, next config.Config) { cfg.HealthAdaptive = next.HealthAdaptive },
+	"health-below":           func(cfg *config.Config, next config.Config) { cfg.HealthBelow = next.HealthBelow },
+	"health-max":             func(cfg *config.Config, next config.Config) { cfg.HealthMax = next.HealthMax },
+	"temp-limit":             func(cfg *config.Config, next config.Config) { cfg.TempLimit = next.TempLimit },
+	"temp-sustain":           func(cfg *config.Config, next config.Config) { cfg.TempSustain = next.TempSustain },
+	"temp-max":               func(cfg *config.Config, next config.Config) { cfg.TempMax = next.TempMax },
+	"low-power":              func(cfg *config.Config, next config.Config) { cfg.LowPower = next.LowPower },
+	"calibrate-every":        func(cfg *config.Config, next config.Config) { cfg.CalibrateEvery = next.CalibrateEvery },
+	"log-level":              func(cfg *config.Config, next config.Config) { cfg.LogLevel = next.LogLevel },
+	"interval":               func(cfg *config.Config, next config.Config) { cfg.PollInterval = next.PollInterval },
+	"dry-run":                func(cfg *config.Config, next config.Config) { cfg.DryRun = next.DryRun },
+}
+
+// effective formats the runtime value of the reloadable options that other
+// commands (-set, presets, storage mode, ...) change too, for the config
+// command. The others report the value they were loaded with.
+var effective = map[string]func(cfg config.Config) string{
+	"max":                    func(cfg config.Config) string { return fmt.Sprint(cfg.MaxPercent) },
+	"auto":                   func(cfg config.Config) string { return strconv.FormatBool(cfg.Auto) },
+	"conservation-threshold": func(cfg config.Config) string { return fmt.Sprint(cfg.ConservationThreshold) },
+	"start-threshold":        func(cfg config.Config) string { return fmt.Sprint(cfg.StartThreshold) },
+	"allow-low-thresholds":   func(cfg config.Config) string { return strconv.FormatBool(cfg.LowThresholds) },
+	"safety-floor":           func(cfg config.Config) string { return fmt.Sprint(cfg.SafetyFloor) },
+	"storage":                func(cfg config.Config) string { return strconv.FormatBool(cfg.Storage) },
+	"external-change":        func(cfg config.Config) string { return cfg.ExternalPolicy },
+	"charge-current":         func(cfg config.Config) string { return strconv.Itoa(cfg.ChargeCurrentMA) },
+	"calibrate-every":        func(cfg config.Config) string { return calibrationInterval(cfg.CalibrateEvery) },
+	"log-level":              func(config.Config) string { cur, _, _ := logging.Level(); return strings.ToLower(cur.String()) },
+	"interval":               func(cfg config.Config) string { return cfg.PollInterval.String() },
+	"dry-run":                func(cfg config.Config) string { return strconv.FormatBool(cfg.DryRun) },
+}
+
+// hiddenSettings are the flags the config command leaves out: actions
+// rather than options, and secrets.
+var hiddenSettings = map[string]bool{"version": true, "list-backends": true, "once": true, "carbon-token": true}
+
+func calibrationInterval(d time.Duration) string {
+	if d == 0 {
+		return "off"
+	}
+	return d.String()
+}
+
+// reloader re-reads the command line and the configuration file on SIGHUP
+// or conservationctl -reload. Only the options that changed since the last
+// load are applied, so runtime changes (conservationctl -max, a preset) to
+// options the file leaves alone survive a reload.
+type reloader struct {
+	mu           sync.Mutex
+	flags        *flag.FlagSet // values of the last load
+	st           *control.State
+	canStore     bool
+	canDischarge bool
+	canPercent   bool          // the knob holds at a percentage, as -allow-low-thresholds needs
+	dryStart     bool          // started with -dry-run: nothing was set up to write the knob
+	applied      chan struct{} // fires after each reload so the change applies at once
+}
+
+// reload applies the reloadable options that changed and describes what it
+// did. Either every changed option is applied or, if the result is invalid,
+// none is.
+func (r *reloader) reload() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next, flags, err := parseFlags(os.Args[1:])
+	if err != nil {
+		return "", err
+	}
+	var apply, restart []string
+	for _, name := range changedFlags(r.flags, flags) {
+		if _, ok := reloadable[name]; ok {
+			apply = append(apply, name)
+		} else {
+			restart = append(restart, name)
+		}
+	}
+	cfg, err := r.apply(apply, next)
+	if err != nil {
+		return "", err
+	}
+	r.flags = flags
+	logging.Event("config_reloaded", map[string]any{"applied": apply, "restart": restart, "max": cfg.MaxPercent, "auto": cfg.Auto})
+	msg := "reloaded, nothing changed"
+	if len(apply) > 0 {
+		msg = "reloaded " + strings.Join(apply, ", ")
+	}
+	if len(restart) > 0 {
+		msg += "; restart to apply " + strings.Join(restart, ", ")
+	}
+	return msg, nil
+}
+
+// apply sets the reloadable options names to their value in next and
+// triggers a control step. Either all of them are applied or, if the
+// result is invalid, none is.
+func (r *reloader) apply(names []string, next config.Config) (config.Config, error) {
+	cfg, err := r.st.Update(func(cfg *config.Config) error {
+		c := *cfg
+		for _, name := range names {
+			reloadable[name](&c, next)
+		}
+		c.Configured = next.Configured
+		if c.Storage && !r.canStore {
+			return errors.New("storage mode needs a percentage threshold backend")
+		}
+		if c.LowThresholds && !r.canPercent {
+			return errors.New("allow-low-thresholds needs a percentage threshold such as charge_control_end_threshold")
+		}
+		if c.CalibrateEvery != cfg.CalibrateEvery && !r.canDischarge {
+			return errors.New("calibration needs a knob with force-discharge (charge_behaviour)")
+		}
+		if !c.DryRun && r.dryStart {
+			return errors.New("dry-run was on at startup, so nothing is set up to write the knob; restart to turn it off")
+		}
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if c.CalibrateEvery != cfg.CalibrateEvery {
+			c.CalibrateAt = nil
+			c.ScheduleNextCalibration(time.Now())
+		}
+		if c.StatePath != "" {
+			if err := config.SaveState(c.StatePath, c); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		*cfg = c
+		return nil
+	})
+	if err != nil {
+		return cfg, err
+	}
+	if slices.Contains(names, "log-level") {
+		level, _ := logging.ParseLevel(cfg.LogLevel)
+		logging.SetLevel(level)
+	}
+	select {
+	case r.applied <- struct{}{}:
+	default:
+	}
+	return cfg, nil
+}
+
+// configure sets the options in set, by flag name and in flag syntax, as
+// if they were given last on the command line, and returns every option's
+// effective value. Options that only take effect on restart are refused:
+// they belong in the configuration file. Like other runtime changes, the
+// new values survive reloads that leave these options alone.
+func (r *reloader) configure(set map[string]string) (map[string]string, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(set) == 0 {
+		return r.settings(), "", nil
+	}
+	// Check the values on a scratch parse first: a bad one would make
+	// parseFlags exit
+	_, scratch, err := parseFlags(os.Args[1:])
+	if err != nil {
+		return nil, "", err
+	}
+	names := slices.Sorted(maps.Keys(set))
+	args := slices.Clone(os.Args[1:])
+	for _, name := range names {
+		if r.flags.Lookup(name) == nil || hiddenSettings[name] {
+			return nil, "", fmt.Errorf("unknown option %q", name)
+		}
+		if _, ok := reloadable[name]; !ok {
+			return nil, "", fmt.Errorf("%s only takes effect on restart: set it in %s", name, r.flags.Lookup("config").Value)
+		}
+		if err := scratch.Set(name, set[name]); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", name, err)
+		}
+		args = append(args, "-"+name+"="+set[name])
+	}
+	next, _, err := parseFlags(args)
+	if err != nil {
+		return nil, "", err
+	}
+	// Changes made here are runtime ones, for the state file to keep
+	next.Configured = r.st.Config().Configured
+	cfg, err := r.apply(names, next)
+	if err != nil {
+		return nil, "", err
+	}
+	logging.Event("config_changed", map[string]any{"options": names, "max": cfg.MaxPercent, "auto": cfg.Auto})
+	return r.settings(), "set " + strings.Join(names, ", "), nil
+}
+
+// settings returns every option by flag name: its runtime value if it can
+// change at runtime, the value it was loaded with otherwise.
+func (r *reloader) settings() map[string]string {
+	cfg := r.st.Config()
+	out := map[string]string{}
+	r.flags.VisitAll(func(f *flag.Flag) {
+		if hiddenSettings[f.Name] {
+			return
+		}
+		if get, ok := effective[f.Name]; ok {
+			out[f.Name] = get(cfg)
+		} else {
+			out[f.Name] = f.Value.String()
+		}
+	})
+	return out
+}
+
+// watchReload reloads the configuration on every SIGHUP until ctx is
+// cancelled.
+func watchReload(ctx context.Context, r *reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if msg, err := r.reload(); err != nil {
+				logging.Errorf("reload: %v (keeping the current configuration)", err)
+			} else {
+				logging.Logf("%s", msg)
+			}
+		}
+	}
+}
+
+// changedFlags returns the names of the flags whose value differs between
+// two parses, sorted.
+func changedFlags(prev, next *flag.FlagSet) []string {
+	var names []string
+	next.VisitAll(func(f *flag.Flag) {
+		if old := prev.Lookup(f.Name); old == nil || old.Value.String() != f.Value.String() {
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+// withFallback chains knob with the other backends detected on this machine,
+// in priority order, so a failing knob hands over to the next one.
+func withFallback(knob control.Knob, node backend.Node, cfg config.Config, st *control.State) control.Knob {
+	chain := []backend.Named{{Name: node.Kind.String(), Knob: knob}}
+	for _, d := range backend.DetectAll(cfg.BatteryName) {
+		if d.Err != nil || d.Kind == node.Kind {
+			continue
+		}
+		n := backend.Node{Path: d.Path, Kind: d.Kind, Hold: node.Hold, Start: node.Start, StorageHold: node.StorageHold, Levels: node.Levels}
+		chain = append(chain, backend.Named{Name: d.Kind.String(), Knob: n})
+	}
+	if len(chain) == 1 {
+		return knob
+	}
+	return &backend.Fallback{
+		Knobs: chain,
+		OnSwitch: func(to backend.Named, err error) {
+			logging.Warnf("backend failover: %v; now driving %s", err, to.Name)
+			logging.Event("backend_failover", map[string]any{"backend": to.Name, "error": err.Error()})
+			st.SetBackend(to.Name)
+		},
+	}
+}
+
+// dropPrivileges switches to cfg.User and its groups. With keepOpen, every
+// sysfs file the daemon may write is opened first, as root. The control
+// socket and state directories are handed over so the socket can be
+// removed and the state saved later.
+func dropPrivileges(cfg config.Config, keepOpen bool) error {
+	u, err := user.Lookup(cfg.User)
+	if err != nil {
+		return fmt.Errorf("-user: %w", err)
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	var groups []int
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.Atoi(id); err == nil {
+				groups = append(groups, g)
+			}
+		}
+	}
+	if keepOpen {
+		paths := backend.WritablePaths(cfg.BatteryName)
+		if prof, err := platform.Find(); err == nil {
+			paths = append(paths, prof.Path)
+		}
+		if err := backend.KeepOpen(paths); err != nil {
+			return err
+		}
+	}
+	// Group ownership stays: it's what lets -sock-group members connect.
+	// Shared directories such as /tmp are left alone.
+	for _, p := range []string{cfg.SockPath, cfg.StatePath} {
+		if p == "" || ipc.Abstract(p) {
+			continue
+		}
+		dir := filepath.Dir(p)
+		if fi, err := os.Stat(dir); err == nil && fi.Mode()&os.ModeSticky == 0 {
+			if err := os.Chown(dir, uid, -1); err != nil {
+				return fmt.Errorf("-user: %w", err)
+			}
+		}
+		if err := os.Chown(p, uid, -1); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("-user: %w", err)
+		}
+	}
+	// The log file, but not its directory: that may well be /var/log.
+	if cfg.LogFile != "" {
+		if err := os.Chown(cfg.LogFile, uid, -1); err != nil {
+			return fmt.Errorf("-user: %w", err)
+		}
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d: %w", uid, err)
+	}
+	logging.Logf("dropped root: running as %s (uid %d, gid %d)", cfg.User, uid, gid)
+	return nil
+}
+
+// useHelper routes sysfs writes through the host helper when the knob is
+// read-only here, typically because sysfs is mounted read-only inside a
+// container or Flatpak.
+func useHelper(sock, knob string) {
+	c := helper.Client{Sock: sock}
+	if err := c.Ping(); err == nil {
+		backend.SetWriter(c.Write)
+		logging.Logf("%s is read-only here: writing through the host helper at %s", knob, sock)
+		return
+	}
+	if ct := backend.Container(); ct != "" {
+		exitErr(fmt.Errorf("%s is read-only inside this %s container and no host helper answers at %s.\n"+
+			"Start conservation-helper on the host (systemctl enable --now conservation-helper) and share its socket with the container", knob, ct, sock))
+	}
+	logging.Warnf("no write access to %s (not running as root?) and no host helper at %s", knob, sock)
+}
+
+// watchHotkey toggles conservation on every press of the hardware key. The
+// returned channel fires after each toggle so the change applies at once.
+// device is an input device name, or a /dev/input path.
+func watchHotkey(ctx context.Context, st *control.State, device string, code uint16) (<-chan struct{}, error) {
+	path := device
+	if !strings.HasPrefix(device, "/") {
+		var err error
+		if path, err = hotkey.Find(device); err != nil {
+			return nil, err
+		}
+	}
+	keys, err := hotkey.Listen(ctx, path, code)
+	if err != nil {
+		return nil, err
+	}
+	logging.Logf("listening for key %d on %s", code, path)
+	out := make(chan struct{}, 1)
+	go func() {
+		for range keys {
+			cfg := control.Toggle(st)
+			logging.Logf("hotkey pressed: max=%.1f", cfg.MaxPercent)
+			logging.Event("config_changed", map[string]any{"max": cfg.MaxPercent, "source": "hotkey"})
+			select {
+			case out <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return out, nil
+}
+
+// startTelemetry sends a health report every cfg.TelemetryInterval. The
+// install ID lives next to the state file so the capacity trend survives
+// restarts, and so does the time of the last report, so restarts don't
+// postpone the next one.
+func startTelemetry(ctx context.Context, st *control.State, cfg config.Config, dmi quirks.DMI, kind backend.Kind) {
+	var idPath string
+	if cfg.StatePath != "" {
+		idPath = filepath.Join(filepath.Dir(cfg.StatePath), "telemetry-id")
+	}
+	id, err := telemetry.LoadID(idPath)
+	if err != nil {
+		logging.Warnf("telemetry: %v (disabled)", err)
+		return
+	}
+	logging.Logf("telemetry enabled: anonymous reports to %s every %s", cfg.Telemetry, cfg.TelemetryInterval)
+	build := func() (telemetry.Report, error) {
+		health, cycles, err := monitor.SysfsBattery{Name: cfg.BatteryName}.Health()
+		if err != nil {
+			return telemetry.Report{}, err
+		}
+		cur := st.Config()
+		return telemetry.Report{
+			ID:         id,
+			Version:    version.Version,
+			Model:      dmi.Version,
+			Backend:    kind.String(),
+			HealthPct:  health,
+			CycleCount: cycles,
+			Max:        cur.MaxPercent,
+			Threshold:  cur.ConservationThreshold,
+			Auto:       cur.Auto,
+		}, nil
+	}
+	// Record when telemetry was enabled, so the first report is due one
+	// interval later however often the daemon restarts
+	last := time.Now()
+	if cfg.TelemetrySent != nil {
+		last = *cfg.TelemetrySent
+	} else {
+		recordTelemetry(st, last)
+	}
+	go telemetry.Run(ctx, cfg.Telemetry, cfg.TelemetryInterval, last, build, func(at time.Time) { recordTelemetry(st, at) }, logging.Logf)
+}
+
+// recordTelemetry persists the time of the last telemetry report.
+func recordTelemetry(st *control.State, at time.Time) {
+	st.Update(func(cfg *config.Config) error {
+		cfg.TelemetrySent = &at
+		if cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// refreshCarbon fetches the carbon forecast every interval until ctx is
+// cancelled.
+func refreshCarbon(ctx context.Context, fc *carbon.Forecaster, interval time.Duration) {
+	for {
+		if err := fc.Refresh(ctx); err != nil {
+			logging.Warnf("carbon forecast: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// refreshTariff runs the tariff command every interval until ctx is
+// cancelled.
+func refreshTariff(ctx context.Context, hook *control.TariffHook, interval time.Duration) {
+	for {
+		if err := hook.Refresh(ctx); err != nil {
+			logging.Warnf("tariff command: %v", err)
+		} else {
+			logging.Debugf("tariff command: %d window(s)", len(hook.Windows()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// refreshCalendar reloads trips every interval until ctx is cancelled.
+func refreshCalendar(ctx context.Context, trips *calendar.Trips, interval time.Duration) {
+	for {
+		if err := trips.Refresh(ctx); err != nil {
+			logging.Warnf("calendar: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// printBackends prints every compiled-in backend and whether it was detected.
+// The first available one is what auto-detection would pick.
+func printBackends(battery string) {
+	picked := false
+	for _, d := range backend.DetectAll(battery) {
+		switch {
+		case d.Err != nil:
+			fmt.Printf("%-18s unavailable  %v\n", d.Kind, d.Err)
+		case !picked:
+			picked = true
+			fmt.Printf("%-18s available    %s (auto)\n", d.Kind, d.Path)
+		default:
+			fmt.Printf("%-18s available    %s\n", d.Kind, d.Path)
+		}
+	}
+}
+
+// applyFile sets every flag named in the configuration file at path that
+// was not given on the command line. A missing file is fine unless -config
+// asked for it.
+func applyFile(flags *flag.FlagSet, path string) error {
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	settings, err := config.ParseFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit["config"] {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		if s.Key == "config" || flags.Lookup(s.Key) == nil {
+			return fmt.Errorf("%s:%d: unknown option %s", path, s.Line, s.Key)
+		}
+		if explicit[s.Key] {
+			continue
+		}
+		if err := flags.Set(s.Key, s.Value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, s.Line, s.Key, err)
+		}
+	}
+	return nil
+}
+
+func exitErr(err error) {
+	fmt.Fprintf(os.Stderr, "conservationd: %v\n", err)
+	os.Exit(1)
+}
+
+// serveHTTP starts the -http API, which shuts down with ctx. The token is
+// read now, while the daemon can still read a root-only file.
+func serveHTTP(ctx context.Context, serving *sync.WaitGroup, srv *ipc.Server, cfg config.Config) error {
+	var token string
+	if cfg.HTTPTokenFile != "" {
+		b, err := os.ReadFile(cfg.HTTPTokenFile)
+		if err != nil {
+			return fmt.Errorf("http token: %w", err)
+		}
+		if token = strings.TrimSpace(string(b)); token == "" {
+			return fmt.Errorf("http token: %s is empty", cfg.HTTPTokenFile)
+		}
+	}
+	ln, err := net.Listen("tcp", cfg.HTTPAddr)
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	hs := &http.Server{Handler: srv.HTTPHandler(token), ReadHeaderTimeout: 10 * time.Second}
+	serving.Add(1)
+	go func() {
+		defer serving.Done()
+		if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Errorf("http: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		hs.Shutdown(sctx)
+	}()
+	logging.Logf("http API on %s", ln.Addr())
+	return nil
+}
@@ -0,0 +1,75 @@
+package tray
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// instanceAddr returns the abstract socket name used to detect another tray
+// running in the same session. Abstract sockets vanish with their owner, so
+// a crashed tray never leaves a stale lock behind.
+func instanceAddr() string {
+	session := os.Getenv("XDG_SESSION_ID")
+	if session == "" {
+		session = os.Getenv("WAYLAND_DISPLAY") + os.Getenv("DISPLAY")
+	}
+	return fmt.Sprintf("@conservation-tray-%d-%s", os.Getuid(), session)
+}
+
+// acquireInstance claims the single-instance socket. If another tray already
+// owns it, that tray is asked to flash its icon and ok is false.
+func acquireInstance() (ln net.Listener, ok bool) {
+	addr := instanceAddr()
+	ln, err := net.Listen("unix", addr)
+	if err == nil {
+		return ln, true
+	}
+	c, err := net.DialTimeout("unix", addr, time.Second)
+	if err != nil {
+		// Name taken but nobody answers: run anyway rather than not at all.
+		fmt.Fprintf(os.Stderr, "instance check: %v\n", err)
+		return nil, true
+	}
+	defer c.Close()
+	_, _ = fmt.Fprintln(c, "flash")
+	return nil, false
+}
+
+// serveInstance answers later launches of the tray by flashing the icon.
+func serveInstance(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		c.Close()
+		if strings.TrimSpace(line) == "flash" {
+			flashIcon()
+		}
+	}
+}
+
+// flashIcon blinks the tray icon so the user can spot the running instance,
+// then lets the poller restore the real state.
+func flashIcon() {
+	on := generateIcon(true, true, false)
+	off := generateIcon(false, false, false)
+	for i := 0; i < 4; i++ {
+		systray.SetIcon(on)
+		time.Sleep(250 * time.Millisecond)
+		systray.SetIcon(off)
+		time.Sleep(250 * time.Millisecond)
+	}
+	select {
+	case refreshCh <- struct{}{}:
+	default:
+	}
+}
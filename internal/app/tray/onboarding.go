@@ -0,0 +1,207 @@
+package tray
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/ncruces/zenity"
+
+	"conservationDaemon/internal/ipc"
+)
+
+// setupReport describes what is missing for the tray to talk to the daemon.
+type setupReport struct {
+	Installed   bool // conservationd binary found in PATH
+	Running     bool // systemd reports the service active
+	SocketFound bool // control socket exists on disk
+	GroupExists bool // socket group exists on the system
+	InGroup     bool // user is listed as a member of the socket group
+	GroupActive bool // the running session already carries the group
+	Reachable   bool // a status request succeeded
+}
+
+func (r setupReport) ok() bool {
+	return r.Reachable
+}
+
+// problems returns a human-readable list of detected issues.
+func (r setupReport) problems() []string {
+	var out []string
+	if !r.Installed {
+		out = append(out, "conservationd is not installed")
+	} else if !r.Running {
+		out = append(out, "the conservationd service is not running")
+	} else if !r.SocketFound {
+		out = append(out, fmt.Sprintf("control socket %s does not exist", socket()))
+	}
+	switch {
+	case !r.GroupExists:
+		out = append(out, fmt.Sprintf("group %q does not exist", sockGroup))
+	case !r.InGroup:
+		out = append(out, fmt.Sprintf("you are not a member of the %q group", sockGroup))
+	case !r.GroupActive:
+		out = append(out, fmt.Sprintf("membership in %q is not active yet (log out and back in)", sockGroup))
+	}
+	if len(out) == 0 && !r.Reachable {
+		out = append(out, "the daemon did not answer a status request")
+	}
+	return out
+}
+
+// fixable reports whether a privileged setup run can resolve the issues.
+func (r setupReport) fixable() bool {
+	return r.Installed && (!r.Running || !r.GroupExists || !r.InGroup)
+}
+
+func diagnoseSetup() setupReport {
+	var r setupReport
+	if sandboxed {
+		_, err := doIPC(ipc.Req{Cmd: ipc.CmdStatus})
+		r.Reachable = err == nil
+		return r
+	}
+	if _, err := exec.LookPath("conservationd"); err == nil {
+		r.Installed = true
+	}
+	if err := exec.Command("systemctl", "is-active", "--quiet", "conservationd").Run(); err == nil {
+		r.Running = true
+	}
+	r.SocketFound = ipc.SocketExists(socket())
+	if g, err := user.LookupGroup(sockGroup); err == nil {
+		r.GroupExists = true
+		if u, err := user.Current(); err == nil {
+			if ids, err := u.GroupIds(); err == nil {
+				for _, id := range ids {
+					if id == g.Gid {
+						r.InGroup = true
+						break
+					}
+				}
+			}
+		}
+		if gid, err := strconv.Atoi(g.Gid); err == nil {
+			if gids, err := os.Getgroups(); err == nil {
+				for _, id := range gids {
+					if id == gid {
+						r.GroupActive = true
+						break
+					}
+				}
+			}
+			if os.Getegid() == gid {
+				r.GroupActive = true
+			}
+		}
+	}
+	if _, err := doIPC(ipc.Req{Cmd: ipc.CmdStatus}); err == nil {
+		r.Reachable = true
+	}
+	return r
+}
+
+// runPrivilegedSetup creates the socket group, adds the current user to it
+// and enables the daemon service through pkexec.
+func runPrivilegedSetup() error {
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("pkexec"); err != nil {
+		return errors.New("pkexec not found; run the setup commands manually")
+	}
+	// The group and user go in as arguments ($1, $2), never parsed as shell
+	script := strings.Join([]string{
+		"set -e",
+		`groupadd -f "$1"`,
+		`usermod -a -G "$1" "$2"`,
+		"systemctl enable --now conservationd",
+	}, "\n")
+	out, err := exec.Command("pkexec", "sh", "-c", script, "sh", sockGroup, u.Username).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("setup failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runOnboarding checks the daemon setup and, if something is wrong, explains
+// the problem and offers to fix it. It returns once the user has dismissed
+// the dialogs. When quiet is set, nothing is shown if the setup is fine.
+func runOnboarding(quiet bool) {
+	r := diagnoseSetup()
+	if r.ok() {
+		if !quiet {
+			zenity.Info("conservationd is running and reachable.", zenity.Title("Conservation Setup"))
+		}
+		markOnboarded()
+		return
+	}
+
+	if sandboxed {
+		// No host tools in the sandbox: explain what to do on the host
+		zenity.Warning("The tray cannot reach conservationd at "+socket()+".\n\n"+
+			"Install the conservation-daemon package on the host, then share its socket with the tray:\n\n"+
+			"flatpak override --user --filesystem=/run/conservationd "+os.Getenv("FLATPAK_ID"),
+			zenity.Title("Conservation Setup"))
+		return
+	}
+
+	msg := "The tray cannot talk to conservationd:\n\n• " + strings.Join(r.problems(), "\n• ")
+
+	if !r.Installed {
+		zenity.Warning(msg+"\n\nInstall the conservation-daemon package for your distribution, then restart the tray.",
+			zenity.Title("Conservation Setup"))
+		return
+	}
+	if !r.fixable() {
+		zenity.Warning(msg, zenity.Title("Conservation Setup"))
+		return
+	}
+
+	err := zenity.Question(msg+"\n\nFix this now? You will be asked for an administrator password.",
+		zenity.Title("Conservation Setup"),
+		zenity.OKLabel("Fix"),
+		zenity.CancelLabel("Not now"),
+		zenity.QuestionIcon,
+	)
+	if err != nil {
+		return
+	}
+	if err := runPrivilegedSetup(); err != nil {
+		zenity.Error(err.Error(), zenity.Title("Conservation Setup"))
+		return
+	}
+
+	r = diagnoseSetup()
+	switch {
+	case r.ok():
+		zenity.Info("Setup complete. conservationd is reachable.", zenity.Title("Conservation Setup"))
+		markOnboarded()
+	case r.InGroup && !r.GroupActive:
+		zenity.Info("Setup complete. Log out and back in so the new group membership takes effect.",
+			zenity.Title("Conservation Setup"))
+		markOnboarded()
+	default:
+		zenity.Warning("Setup finished but the daemon is still unreachable:\n\n• "+strings.Join(r.problems(), "\n• "),
+			zenity.Title("Conservation Setup"))
+	}
+	select {
+	case refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+func markOnboarded() {
+	p := loadPrefs()
+	if p.Onboarded {
+		return
+	}
+	p.Onboarded = true
+	if err := savePrefs(p); err != nil {
+		fmt.Fprintf(os.Stderr, "save prefs: %v\n", err)
+	}
+}
@@ -0,0 +1,242 @@
+package tray
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Inside a Flatpak the host system bus and host tools (systemctl, pkexec)
+// are off limits: the tray talks to the daemon socket and reaches the
+// desktop through xdg-desktop-portal only.
+const (
+	portalDest = "org.freedesktop.portal.Desktop"
+	portalPath = dbus.ObjectPath("/org/freedesktop/portal/desktop")
+)
+
+// inFlatpak reports whether the tray runs inside a Flatpak sandbox.
+func inFlatpak() bool {
+	_, err := os.Stat("/.flatpak-info")
+	return err == nil
+}
+
+func portalObject() (*dbus.Conn, dbus.BusObject, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, conn.Object(portalDest, portalPath), nil
+}
+
+// notify shows a desktop notification through the Notification portal.
+func notify(id, title, body string) {
+	_, obj, err := portalObject()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notify: %v\n", err)
+		return
+	}
+	n := map[string]dbus.Variant{
+		"title": dbus.MakeVariant(title),
+		"body":  dbus.MakeVariant(body),
+	}
+	if err := obj.Call("org.freedesktop.portal.Notification.AddNotification", 0, id, n).Err; err != nil {
+		fmt.Fprintf(os.Stderr, "notify: %v\n", err)
+	}
+}
+
+// darkScheme is set when the desktop prefers a dark color scheme.
+var darkScheme atomic.Bool
+
+// watchColorScheme tracks org.freedesktop.appearance color-scheme from the
+// Settings portal (0 = no preference, 1 = dark, 2 = light).
+func watchColorScheme() {
+	conn, obj, err := portalObject()
+	if err != nil {
+		return
+	}
+	var v dbus.Variant
+	if err := obj.Call("org.freedesktop.portal.Settings.ReadOne", 0, "org.freedesktop.appearance", "color-scheme").Store(&v); err == nil {
+		darkScheme.Store(schemeIsDark(v))
+	}
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(portalPath),
+		dbus.WithMatchInterface("org.freedesktop.portal.Settings"),
+		dbus.WithMatchMember("SettingChanged"),
+	); err != nil {
+		return
+	}
+	sigs := make(chan *dbus.Signal, 8)
+	conn.Signal(sigs)
+	for sig := range sigs {
+		if sig.Name != "org.freedesktop.portal.Settings.SettingChanged" || len(sig.Body) != 3 {
+			continue
+		}
+		if ns, _ := sig.Body[0].(string); ns != "org.freedesktop.appearance" {
+			continue
+		}
+		if key, _ := sig.Body[1].(string); key != "color-scheme" {
+			continue
+		}
+		if v, ok := sig.Body[2].(dbus.Variant); ok {
+			darkScheme.Store(schemeIsDark(v))
+			select {
+			case refreshCh <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func schemeIsDark(v dbus.Variant) bool {
+	// Some portal versions wrap the value in a second variant
+	if inner, ok := v.Value().(dbus.Variant); ok {
+		v = inner
+	}
+	scheme, _ := v.Value().(uint32)
+	return scheme == 1
+}
+
+// portalRequest runs a portal method that answers through a Request object
+// and returns the response results. call receives the handle_token to pass
+// in the method options.
+func portalRequest(conn *dbus.Conn, call func(token string) error) (map[string]dbus.Variant, error) {
+	token := fmt.Sprintf("conservation%d", rand.Uint32())
+	sender := strings.ReplaceAll(strings.TrimPrefix(conn.Names()[0], ":"), ".", "_")
+	path := dbus.ObjectPath("/org/freedesktop/portal/desktop/request/" + sender + "/" + token)
+
+	// Subscribe before calling so the response can't be missed
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface("org.freedesktop.portal.Request"),
+		dbus.WithMatchMember("Response"),
+	}
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return nil, err
+	}
+	defer conn.RemoveMatchSignal(match...)
+	sigs := make(chan *dbus.Signal, 1)
+	conn.Signal(sigs)
+	defer conn.RemoveSignal(sigs)
+
+	if err := call(token); err != nil {
+		return nil, err
+	}
+	timeout := time.After(2 * time.Minute) // the user may be looking at a dialog
+	for {
+		select {
+		case sig := <-sigs:
+			if sig.Path != path || len(sig.Body) != 2 {
+				continue
+			}
+			if code, _ := sig.Body[0].(uint32); code != 0 {
+				return nil, errors.New("request denied or cancelled")
+			}
+			results, _ := sig.Body[1].(map[string]dbus.Variant)
+			return results, nil
+		case <-timeout:
+			return nil, errors.New("portal did not answer")
+		}
+	}
+}
+
+// requestAutostart asks the Background portal to start the tray at login,
+// or to stop doing so.
+func requestAutostart(enable bool) error {
+	conn, obj, err := portalObject()
+	if err != nil {
+		return err
+	}
+	results, err := portalRequest(conn, func(token string) error {
+		opts := map[string]dbus.Variant{
+			"handle_token": dbus.MakeVariant(token),
+			"reason":       dbus.MakeVariant("Show battery conservation status at login"),
+			"autostart":    dbus.MakeVariant(enable),
+			"commandline":  dbus.MakeVariant([]string{"conservation-tray", "-hidden", "-start-delay", "15s"}),
+		}
+		return obj.Call("org.freedesktop.portal.Background.RequestBackground", 0, "", opts).Err
+	})
+	if err != nil {
+		return err
+	}
+	if granted, _ := results["autostart"].Value().(bool); granted != enable {
+		return errors.New("autostart change not granted")
+	}
+	return nil
+}
+
+// toggleShortcut is the id of the global shortcut that toggles conservation.
+const toggleShortcut = "toggle-conservation"
+
+// bindShortcuts registers the tray's global shortcuts through the
+// GlobalShortcuts portal. The returned channel receives the id of each
+// activated shortcut.
+func bindShortcuts() (<-chan string, error) {
+	conn, obj, err := portalObject()
+	if err != nil {
+		return nil, err
+	}
+	results, err := portalRequest(conn, func(token string) error {
+		opts := map[string]dbus.Variant{
+			"handle_token":         dbus.MakeVariant(token),
+			"session_handle_token": dbus.MakeVariant(token),
+		}
+		return obj.Call("org.freedesktop.portal.GlobalShortcuts.CreateSession", 0, opts).Err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create shortcut session: %w", err)
+	}
+	handle, _ := results["session_handle"].Value().(string)
+	if handle == "" {
+		return nil, errors.New("create shortcut session: no session handle")
+	}
+	session := dbus.ObjectPath(handle)
+
+	type shortcut struct {
+		ID   string
+		Opts map[string]dbus.Variant
+	}
+	shortcuts := []shortcut{{toggleShortcut, map[string]dbus.Variant{
+		"description":       dbus.MakeVariant("Toggle battery conservation"),
+		"preferred_trigger": dbus.MakeVariant("CTRL+ALT+B"),
+	}}}
+	if _, err := portalRequest(conn, func(token string) error {
+		opts := map[string]dbus.Variant{"handle_token": dbus.MakeVariant(token)}
+		return obj.Call("org.freedesktop.portal.GlobalShortcuts.BindShortcuts", 0, session, shortcuts, "", opts).Err
+	}); err != nil {
+		return nil, fmt.Errorf("bind shortcuts: %w", err)
+	}
+
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(portalPath),
+		dbus.WithMatchInterface("org.freedesktop.portal.GlobalShortcuts"),
+		dbus.WithMatchMember("Activated"),
+	); err != nil {
+		return nil, err
+	}
+	sigs := make(chan *dbus.Signal, 8)
+	conn.Signal(sigs)
+	out := make(chan string, 1)
+	go func() {
+		for sig := range sigs {
+			if sig.Name != "org.freedesktop.portal.GlobalShortcuts.Activated" || len(sig.Body) < 2 {
+				continue
+			}
+			if s, _ := sig.Body[0].(dbus.ObjectPath); s != session {
+				continue
+			}
+			if id, ok := sig.Body[1].(string); ok {
+				select {
+				case out <- id:
+				default:
+				}
+			}
+		}
+	}()
+	return out, nil
+}
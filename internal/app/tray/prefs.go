@@ -0,0 +1,55 @@
+package tray
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// trayPrefs is the per-user tray state persisted under the XDG config dir.
+type trayPrefs struct {
+	Onboarded bool   `json:"onboarded"`
+	Socket    string `json:"socket,omitempty"`    // user-picked socket path; empty means auto-discover
+	Autostart bool   `json:"autostart,omitempty"` // Background portal autostart granted (Flatpak)
+}
+
+func prefsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "conservation-tray", "prefs.json"), nil
+}
+
+func loadPrefs() trayPrefs {
+	var p trayPrefs
+	path, err := prefsPath()
+	if err != nil {
+		return p
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p
+	}
+	_ = json.Unmarshal(data, &p)
+	return p
+}
+
+func savePrefs(p trayPrefs) error {
+	path, err := prefsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
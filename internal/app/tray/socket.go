@@ -0,0 +1,57 @@
+package tray
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ncruces/zenity"
+
+	"conservationDaemon/internal/ipc"
+)
+
+// pickSocket lets the user override the socket path from the tray. An empty
+// entry restores automatic discovery.
+func pickSocket(explicit string) {
+	prefs := loadPrefs()
+	path, err := zenity.Entry("Daemon socket path (leave empty for automatic discovery):",
+		zenity.Title("Daemon Socket"),
+		zenity.EntryText(prefs.Socket),
+		zenity.ExtraButton("Browse..."))
+	if errors.Is(err, zenity.ErrExtraButton) {
+		path, err = zenity.SelectFile(
+			zenity.Title("Select Daemon Socket"),
+			zenity.Filename(filepath.Dir(socket())+"/"),
+			zenity.ShowHidden())
+	}
+	if err != nil {
+		return
+	}
+	path = strings.TrimSpace(path)
+	if path != "" {
+		if !ipc.SocketExists(path) {
+			msg := fmt.Sprintf("%s is not a UNIX socket.", path)
+			if ipc.Abstract(path) {
+				msg = fmt.Sprintf("Nothing listens on the abstract socket %s.", path)
+			}
+			zenity.Error(msg, zenity.Title("Error"))
+			return
+		}
+	}
+
+	prefs.Socket = path
+	if err := savePrefs(prefs); err != nil {
+		fmt.Fprintf(os.Stderr, "save prefs: %v\n", err)
+	}
+	setSocket(ipc.DiscoverSocket(explicit, prefs.Socket))
+	if explicit != "" {
+		zenity.Info(fmt.Sprintf("The -sock flag is set, so %s stays in use until the tray is restarted without it.", explicit),
+			zenity.Title("Daemon Socket"))
+	}
+	select {
+	case refreshCh <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,594 @@
+// Package tray is conservation-tray, the status icon for conservationd.
+package tray
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/systray"
+	"github.com/godbus/dbus/v5"
+	"github.com/ncruces/zenity"
+
+	"conservationDaemon/internal/ipc"
+)
+
+var sockFlag string
+var sockGroup string
+var pollInterval time.Duration
+var slowInterval time.Duration
+var startDelay time.Duration
+var startHidden bool
+var currentState ipc.Resp
+var sandboxed bool // running as a Flatpak: no host system bus
+var refreshCh = make(chan struct{}, 1)
+
+// sockPath holds the daemon socket in use. The preferences change it from
+// the menu while the poll loop reads it.
+var sockPath atomic.Value // string
+
+func socket() string {
+	p, _ := sockPath.Load().(string)
+	return p
+}
+
+func setSocket(p string) { sockPath.Store(p) }
+
+// generateIcon creates a battery-shaped icon with color reflecting state.
+// Gray = unplugged/idle, Green = charging, Blue = conservation enabled.
+func generateIcon(plugged bool, charging bool, consEnabled bool) []byte {
+	rect := image.Rect(0, 0, 64, 64)
+	img := image.NewRGBA(rect)
+
+	c := color.RGBA{80, 80, 80, 255} // Gray: unplugged or idle
+	idle := color.RGBA{200, 200, 200, 255}
+	if darkScheme.Load() {
+		// Keep both grays visible on a dark panel
+		c, idle = color.RGBA{150, 150, 150, 255}, color.RGBA{230, 230, 230, 255}
+	}
+	if plugged && consEnabled {
+		c = color.RGBA{0, 150, 255, 255} // Blue: conservation on
+	} else if plugged && charging {
+		c = color.RGBA{0, 200, 80, 255} // Green: charging
+	} else if plugged {
+		c = idle // Light gray: plugged but idle
+	}
+
+	// Battery body
+	for y := 16; y < 48; y++ {
+		for x := 10; x < 54; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	// Battery tip (positive terminal)
+	for y := 24; y < 40; y++ {
+		for x := 54; x < 58; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// daemonConn is kept open between polls, and replaced when the socket
+// changes in the preferences.
+var (
+	daemonMu   sync.Mutex
+	daemonConn *ipc.Conn
+)
+
+func doIPC(req ipc.Req) (*ipc.Resp, error) {
+	daemonMu.Lock()
+	sock := socket()
+	if daemonConn == nil || daemonConn.Sock != sock {
+		if daemonConn != nil {
+			daemonConn.Close()
+		}
+		daemonConn = &ipc.Conn{Sock: sock}
+	}
+	c := daemonConn
+	daemonMu.Unlock()
+	return c.Call(req)
+}
+
+// checkProtocol warns when the daemon is older than the tray: settings the
+// tray sends that the daemon doesn't know would otherwise be dropped without
+// a word.
+func checkProtocol() {
+	hello, err := ipc.Hello(socket(), 5*time.Second)
+	if err != nil || hello.Protocol >= ipc.ProtocolVersion {
+		return
+	}
+	notify("protocol", "Battery conservation",
+		fmt.Sprintf("conservationd speaks protocol %d, this tray %d: some settings may not apply until conservationd is updated", hello.Protocol, ipc.ProtocolVersion))
+}
+
+// isACPluggedIn asks UPower whether the system runs on AC power. A sandboxed
+// tray has no system bus and goes by the daemon's battery state instead.
+func isACPluggedIn() bool {
+	if sandboxed {
+		return currentState.State != "" && currentState.State != "discharging"
+	}
+	obj, err := busObject("org.freedesktop.UPower", "/org/freedesktop/UPower")
+	if err != nil {
+		return false
+	}
+	variant, err := obj.GetProperty("org.freedesktop.UPower.OnBattery")
+	if err != nil {
+		return false
+	}
+	onBattery, ok := variant.Value().(bool)
+	if !ok {
+		return false
+	}
+	return !onBattery
+}
+
+// busObject returns a proxy on the shared system bus connection. The
+// connection is kept open across polls instead of redialled each time.
+func busObject(dest, path string) (dbus.BusObject, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Object(dest, dbus.ObjectPath(path)), nil
+}
+
+// isSessionIdle reports whether logind considers the caller's session idle.
+// A sandboxed tray can't ask logind and never considers itself idle.
+func isSessionIdle() bool {
+	if sandboxed {
+		return false
+	}
+	obj, err := busObject("org.freedesktop.login1", "/org/freedesktop/login1/session/auto")
+	if err != nil {
+		return false
+	}
+	variant, err := obj.GetProperty("org.freedesktop.login1.Session.IdleHint")
+	if err != nil {
+		return false
+	}
+	idle, ok := variant.Value().(bool)
+	return ok && idle
+}
+
+// pollDelay returns how long to wait before the next status poll. The tray
+// backs off while on battery or idle so the applet itself doesn't cost power.
+func pollDelay(pluggedIn bool) time.Duration {
+	if slowInterval > pollInterval && (!pluggedIn || isSessionIdle()) {
+		return slowInterval
+	}
+	return pollInterval
+}
+
+// waitForSocket blocks until the daemon socket exists or the delay elapses,
+// so autostarted trays don't race a daemon that is still coming up.
+func waitForSocket(delay time.Duration) {
+	deadline := time.Now().Add(delay)
+	for time.Now().Before(deadline) {
+		if ipc.SocketExists(socket()) {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Main runs the tray icon with the flags in os.Args.
+func Main() {
+	flag.StringVar(&sockFlag, "sock", "", "daemon socket path (default: $CONSERVATIOND_SOCK, preferences, $XDG_RUNTIME_DIR, "+ipc.DefaultSock+")")
+	flag.StringVar(&sockGroup, "sock-group", "conservationd", "group that owns the daemon socket")
+	flag.DurationVar(&pollInterval, "interval", 3*time.Second, "status poll interval while on AC power")
+	flag.DurationVar(&slowInterval, "idle-interval", 30*time.Second, "status poll interval while on battery or when the session is idle")
+	flag.DurationVar(&startDelay, "start-delay", 0, "wait up to this long for the daemon socket before the first poll")
+	flag.BoolVar(&startHidden, "hidden", false, "don't show connecting/unreachable status until the daemon has answered once")
+	flag.Parse()
+
+	setSocket(ipc.DiscoverSocket(sockFlag, loadPrefs().Socket))
+	sandboxed = inFlatpak()
+
+	ln, ok := acquireInstance()
+	if !ok {
+		fmt.Fprintln(os.Stderr, "conservation-tray is already running in this session")
+		os.Exit(0)
+	}
+	if ln != nil {
+		defer ln.Close()
+		go serveInstance(ln)
+	}
+
+	systray.Run(onReady, onExit)
+}
+
+func onExit() {}
+
+func onReady() {
+	icon := generateIcon(false, false, false)
+	systray.SetIcon(icon)
+	systray.SetTitle("Conservation")
+	systray.SetTooltip("Battery Conservation Daemon")
+
+	mStatus := systray.AddMenuItem("Status: connecting...", "Current daemon status")
+	mStatus.Disable()
+	if startHidden {
+		mStatus.Hide()
+	}
+
+	systray.AddSeparator()
+	mSetup := systray.AddMenuItem("Fix Setup...", "Diagnose why the daemon is unreachable")
+	mSetup.Hide()
+	mConfigure := systray.AddMenuItem("Configure Conservation", "Set Max % and Target Time")
+	mToggleAuto := systray.AddMenuItemCheckbox("Auto Mode (Enable on external display)", "Toggle display-based auto mode", false)
+	// Filled from the daemon's list once it answers
+	mPresets := systray.AddMenuItem("Presets", "Threshold presets")
+	var presets []presetItem
+	mStorage := systray.AddMenuItemCheckbox("Storage Mode (Unused for weeks)", "Hold the battery at the storage level", false)
+	mFull := systray.AddMenuItemCheckbox("Charge to Full Once", "Charge to 100%, then go back to the thresholds", false)
+	systray.AddSeparator()
+	mPrefs := systray.AddMenuItem("Preferences", "Tray preferences")
+	mSocket := mPrefs.AddSubMenuItem("Daemon Socket...", "Choose a non-standard daemon socket")
+	// Host installs autostart through the systemd user unit; a Flatpak
+	// asks the Background portal
+	mAutostart := mPrefs.AddSubMenuItemCheckbox("Start at Login", "Start the tray when you log in", loadPrefs().Autostart)
+	if !sandboxed {
+		mAutostart.Hide()
+	}
+	systray.AddSeparator()
+	mQuit := systray.AddMenuItem("Quit Tray", "Exit tray applet")
+
+	go watchColorScheme()
+	shortcuts, err := bindShortcuts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "global shortcuts unavailable: %v\n", err)
+	}
+
+	// Polling goroutine: updates icon, status text, and auto checkbox
+	go func() {
+		waitForSocket(startDelay)
+
+		// First launch: make sure the daemon is installed, running and reachable
+		if !loadPrefs().Onboarded {
+			go runOnboarding(true)
+		}
+
+		connected := false
+		for {
+			pluggedIn := isACPluggedIn()
+
+			resp, err := doIPC(ipc.Req{Cmd: ipc.CmdStatus})
+			if err != nil {
+				if connected && currentState.Ok {
+					currentState.Ok = false
+					notify("unreachable", "Battery conservation", "conservationd stopped answering")
+				}
+				// With -hidden, stay quiet until the daemon has answered once
+				if !startHidden || connected {
+					mStatus.SetTitle("Status: daemon unreachable")
+					systray.SetTooltip("Conservation: daemon unreachable")
+					systray.SetIcon(generateIcon(false, false, false))
+					mStatus.Show()
+					mSetup.Show()
+				}
+			} else {
+				if !connected {
+					checkProtocol()
+				}
+				connected = true
+				currentState = *resp
+				if sandboxed {
+					pluggedIn = isACPluggedIn()
+				}
+				mStatus.Show()
+				mSetup.Hide()
+
+				systray.SetIcon(generateIcon(pluggedIn, resp.State == "charging", resp.Cons > 0))
+
+				consStr := "OFF"
+				if resp.Cons > 0 {
+					consStr = "ON"
+				}
+				statusStr := fmt.Sprintf("%.0f%% | Max: %.0f%% | Time: %s | Cons: %s",
+					resp.Pct, resp.Max, resp.Time, consStr)
+				if resp.Storage {
+					statusStr = fmt.Sprintf("%.0f%% | Storage: %.0f%%", resp.Pct, resp.StorageLevel)
+				}
+				mStatus.SetTitle(statusStr)
+				tooltip := fmt.Sprintf("Battery: %.0f%% — Conservation %s", resp.Pct, consStr)
+				if resp.Reason != "" {
+					tooltip += "\n" + resp.Reason
+				}
+				systray.SetTooltip(tooltip)
+
+				if resp.Auto {
+					mToggleAuto.Check()
+				} else {
+					mToggleAuto.Uncheck()
+				}
+				if resp.Storage {
+					mStorage.Check()
+				} else {
+					mStorage.Uncheck()
+				}
+				if resp.ChargingFull {
+					mFull.Check()
+				} else {
+					mFull.Uncheck()
+				}
+				if presets == nil {
+					presets = addPresets(mPresets)
+				}
+				for _, p := range presets {
+					if p.name == resp.Preset {
+						p.item.Check()
+					} else {
+						p.item.Uncheck()
+					}
+				}
+			}
+
+			select {
+			case <-time.After(pollDelay(pluggedIn)):
+			case <-refreshCh:
+			}
+		}
+	}()
+
+	// Event handler goroutine
+	go func() {
+		for {
+			select {
+			case <-mSetup.ClickedCh:
+				runOnboarding(false)
+			case <-mConfigure.ClickedCh:
+				configureClicked()
+			case <-mToggleAuto.ClickedCh:
+				toggleAutoMode()
+			case <-mStorage.ClickedCh:
+				toggleStorageMode()
+			case <-mFull.ClickedCh:
+				toggleFullCharge()
+			case <-mSocket.ClickedCh:
+				pickSocket(sockFlag)
+			case <-mAutostart.ClickedCh:
+				toggleAutostart(mAutostart)
+			case id := <-shortcuts:
+				if id == toggleShortcut {
+					toggleConservation()
+				}
+			case <-mQuit.ClickedCh:
+				systray.Quit()
+				os.Exit(0)
+			}
+		}
+	}()
+}
+
+// lowestTarget returns the lowest charge target the daemon accepts: its
+// conservation threshold, which a preset such as "Max lifespan" (60%) or
+// -allow-low-thresholds may have put below 80. Daemons without "config" are
+// asked for their presets instead: the active one's end, else the lowest.
+func lowestTarget() float64 {
+	if resp, err := doIPC(ipc.Req{Cmd: ipc.CmdConfig}); err == nil {
+		if v, err := strconv.ParseFloat(resp.Settings["conservation-threshold"], 64); err == nil {
+			return v
+		}
+	}
+	resp, err := doIPC(ipc.Req{Cmd: ipc.CmdPreset})
+	if err != nil || len(resp.Presets) == 0 {
+		return 80
+	}
+	low := 100.0
+	for _, p := range resp.Presets {
+		if p.Name == currentState.Preset {
+			return p.End
+		}
+		low = min(low, p.End)
+	}
+	return low
+}
+
+func configureClicked() {
+	fmt.Fprintf(os.Stderr, "configure clicked: cons=%d max=%.1f\n", currentState.Cons, currentState.Max)
+	low := lowestTarget()
+	if currentState.Cons > 0 {
+		// Conservation is ON - let user set a charge target (disable conservation temporarily)
+		maxStr, err := zenity.Entry(fmt.Sprintf("Enter target maximum battery percentage (%.0f-100):", low),
+			zenity.Title("Configure Conservation"),
+			zenity.EntryText("100"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "zenity entry (max) error: %v\n", err)
+			return
+		}
+
+		maxFloat, err := strconv.ParseFloat(maxStr, 64)
+		if err != nil || maxFloat < low || maxFloat > 100 {
+			zenity.Error(fmt.Sprintf("Invalid percentage. Must be between %.0f and 100.", low),
+				zenity.Title("Error"))
+			return
+		}
+
+		timeStr, err := zenity.Entry("Enter target time (HH:MM format, or 'now'):",
+			zenity.Title("Configure Schedule"),
+			zenity.EntryText("now"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "zenity entry (time) error: %v\n", err)
+			return
+		}
+
+		if _, err := doIPC(ipc.Req{Cmd: ipc.CmdSet, Max: maxFloat, Time: timeStr, IfRevision: currentState.Revision}); err != nil {
+			showIPCError("configure", "Configure Conservation", err)
+			return
+		}
+		select {
+		case refreshCh <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	// Conservation is OFF - offer to reset back to default (re-enable conservation at the threshold)
+	err := zenity.Question(
+		fmt.Sprintf("Conservation mode is currently disabled.\nRe-enable it? (Max: %.0f%%, immediate)", low),
+		zenity.Title("Enable Conservation Mode"),
+		zenity.QuestionIcon,
+	)
+	if err == nil {
+		if _, err := doIPC(ipc.Req{Cmd: ipc.CmdSet, Max: low, Time: "now", IfRevision: currentState.Revision}); err != nil {
+			showIPCError("configure", "Enable Conservation Mode", err)
+			return
+		}
+		select {
+		case refreshCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// showIPCError tells the user the daemon refused a request, by its error
+// code: a dialog for a permission problem or a bad value, which the user has
+// to act on, and a notification for anything else.
+func showIPCError(id, title string, err error) {
+	switch ipc.ErrorCode(err) {
+	case ipc.ErrPermission:
+		zenity.Error(err.Error()+"\n\nAsk an administrator to add you to that group, then log in again.",
+			zenity.Title("Permission Denied"))
+	case ipc.ErrInvalid, ipc.ErrRange:
+		zenity.Warning(err.Error(), zenity.Title(title))
+	case ipc.ErrConflict:
+		notify(id, title, "The settings were just changed elsewhere. Check them and try again.")
+		select {
+		case refreshCh <- struct{}{}:
+		default:
+		}
+	default:
+		notify(id, title, err.Error())
+	}
+}
+
+// toggleConservation flips between conserving (80%) and a full charge, for
+// the global shortcut.
+func toggleConservation() {
+	max := 80.0
+	if currentState.Cons > 0 {
+		max = 100
+	}
+	if _, err := doIPC(ipc.Req{Cmd: ipc.CmdSet, Max: max, Time: "now", IfRevision: currentState.Revision}); err != nil {
+		showIPCError("toggle", "Battery conservation", err)
+		return
+	}
+	if max == 100 {
+		notify("toggle", "Battery conservation off", "Charging to 100%")
+	} else {
+		notify("toggle", "Battery conservation on", "Holding the charge at 80%")
+	}
+	select {
+	case refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+// toggleAutostart asks the Background portal to flip autostart and records
+// the outcome in the preferences.
+func toggleAutostart(item *systray.MenuItem) {
+	enable := !item.Checked()
+	if err := requestAutostart(enable); err != nil {
+		fmt.Fprintf(os.Stderr, "autostart: %v\n", err)
+		return
+	}
+	if enable {
+		item.Check()
+	} else {
+		item.Uncheck()
+	}
+	p := loadPrefs()
+	p.Autostart = enable
+	if err := savePrefs(p); err != nil {
+		fmt.Fprintf(os.Stderr, "save prefs: %v\n", err)
+	}
+}
+
+func toggleAutoMode() {
+	newAuto := !currentState.Auto
+	// Sends the max and time last read back: refused if they changed since
+	req := ipc.Req{Cmd: ipc.CmdSet, Max: currentState.Max, Time: currentState.Time, Auto: &newAuto, IfRevision: currentState.Revision}
+	if _, err := doIPC(req); err != nil {
+		showIPCError("auto", "Auto mode", err)
+	}
+	select {
+	case refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+// toggleStorageMode turns storage mode on or off; the daemon keeps the
+// regular settings for when it is turned off again.
+func toggleStorageMode() {
+	on := !currentState.Storage
+	if _, err := doIPC(ipc.Req{Cmd: ipc.CmdStorage, Storage: &on}); err != nil {
+		showIPCError("storage", "Battery storage mode", err)
+		return
+	}
+	select {
+	case refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+// toggleFullCharge starts a one-shot charge to 100%, or cancels the one
+// running.
+func toggleFullCharge() {
+	req := ipc.Req{Cmd: ipc.CmdFull, Cancel: currentState.ChargingFull}
+	if _, err := doIPC(req); err != nil {
+		showIPCError("full", "Charge to full", err)
+		return
+	}
+	select {
+	case refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+// presetItem is a threshold preset in the Presets submenu.
+type presetItem struct {
+	name string
+	item *systray.MenuItem
+}
+
+// addPresets lists the daemon's threshold presets under menu. Clicking one
+// applies it.
+func addPresets(menu *systray.MenuItem) []presetItem {
+	resp, err := doIPC(ipc.Req{Cmd: ipc.CmdPreset})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list presets: %v\n", err)
+		return nil
+	}
+	items := make([]presetItem, 0, len(resp.Presets))
+	for _, p := range resp.Presets {
+		item := menu.AddSubMenuItemCheckbox(fmt.Sprintf("%s (%.0f%%/%.0f%%)", p.Title, p.End, p.Start),
+			fmt.Sprintf("Stop charging at %.0f%%, resume below %.0f%%", p.End, p.Start), false)
+		items = append(items, presetItem{name: p.Name, item: item})
+		go func(name, title string) {
+			for range item.ClickedCh {
+				if _, err := doIPC(ipc.Req{Cmd: ipc.CmdPreset, Preset: name}); err != nil {
+					showIPCError("preset", "Battery conservation", err)
+					continue
+				}
+				notify("preset", "Battery conservation", title+" preset applied")
+				select {
+				case refreshCh <- struct{}{}:
+				default:
+				}
+			}
+		}(p.Name, p.Title)
+	}
+	return items
+}
@@ -0,0 +1,383 @@
+// SPDX-License-Identifier: MIT
+
+// Package backend discovers and drives the sysfs knob that toggles battery
+// conservation mode.
+//
+// Each kind of knob is a Backend in its own file, added to the registry with
+// Register from an init function; Discover tries them in priority order.
+package backend
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Sysfs locations, overridable in tests.
+var (
+	powerSupplyDir = "/sys/class/power_supply"
+	ideapadDir     = "/sys/bus/platform/drivers/ideapad_acpi"
+	smapiDir       = "/sys/devices/platform/smapi" // legacy ThinkPad tp_smapi
+)
+
+// Kind identifies the sysfs interface behind a Node.
+type Kind int
+
+const (
+	ConservationMode Kind = iota // vendor-specific ideapad_acpi conservation_mode
+	ChargeTypes                  // standard power_supply charge_types
+	ChargeBehaviour              // standard power_supply charge_behaviour
+	ChargeThresholds             // standard power_supply charge_control_*_threshold
+	FrameworkEC                  // Framework EC charge limit, through ectool
+	MSIBatteryMode               // msi-ec battery_mode presets
+	CrosECSustainer              // ChromeOS EC battery sustainer, through ectool
+)
+
+// ForceDischarge is the knob value that drains the battery even on AC.
+// Only backends with Capabilities.ForceDischarge accept it.
+const ForceDischarge = 2
+
+// Storage is the knob value that holds at Node.StorageHold, a level for
+// laptops put away for weeks. Only backends with Capabilities.Storage
+// accept it.
+const Storage = 3
+
+// String returns the name of the backend registered for k.
+func (k Kind) String() string {
+	if b := lookup(k); b != nil {
+		return b.Name()
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// Node is a discovered conservation control file.
+type Node struct {
+	Path  string
+	Kind  Kind
+	Hold  int // percentage backends: end threshold while conserving (default DefaultHold)
+	Start int // backends with a start threshold: start while conserving (default Hold-DefaultStartGap)
+
+	StorageHold int // storage backends: end threshold in storage mode (default DefaultStorageHold)
+
+	Levels *Levels // runtime Hold and Start, shared by every copy; nil for the fixed ones
+}
+
+// Levels are conserving levels that change at runtime, e.g. with a
+// threshold preset. Once set they take precedence over Node.Hold and
+// Node.Start.
+type Levels struct {
+	mu          sync.Mutex
+	hold, start int
+	inhibiting  bool // charge_behaviour was last set to conserve
+}
+
+// Set changes the levels and reports whether they changed.
+func (l *Levels) Set(hold, start int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.hold == hold && l.start == start {
+		return false
+	}
+	l.hold, l.start = hold, start
+	return true
+}
+
+func (l *Levels) get() (hold, start int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.hold, l.start
+}
+
+func (l *Levels) setConserving(on bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inhibiting = on
+}
+
+func (l *Levels) conserving() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inhibiting
+}
+
+// Capabilities describes what a backend can do beyond on/off.
+type Capabilities struct {
+	Percent        bool // holds at Node.Hold rather than a fixed firmware level
+	Start          bool // also resumes charging at Node.Start
+	ForceDischarge bool // accepts ForceDischarge
+	Storage        bool // accepts Storage
+}
+
+// Backend drives one kind of conservation knob. Read and Write use 1 for
+// conserving and 0 for charging normally.
+type Backend interface {
+	Kind() Kind
+	Name() string // as accepted by -backend
+	// Detect returns the knob for battery, or why it is unavailable.
+	Detect(battery string) (string, error)
+	Capabilities() Capabilities
+	Read(n Node) (int, error)
+	Write(n Node, v int) error
+	// ValueString describes v for log messages.
+	ValueString(n Node, v int) string
+}
+
+type registered struct {
+	priority int
+	b        Backend
+}
+
+// registry holds the compiled-in backends in auto-detection priority order.
+var registry []registered
+
+// Register adds b to the backends tried by Discover; lower priorities are
+// tried first.
+func Register(priority int, b Backend) {
+	i, _ := slices.BinarySearchFunc(registry, priority, func(r registered, p int) int { return r.priority - p })
+	for i < len(registry) && registry[i].priority == priority {
+		i++
+	}
+	registry = slices.Insert(registry, i, registered{priority, b})
+}
+
+func lookup(k Kind) Backend {
+	for _, r := range registry {
+		if r.b.Kind() == k {
+			return r.b
+		}
+	}
+	return nil
+}
+
+// Detection is the probe result of one compiled-in backend.
+type Detection struct {
+	Kind Kind
+	Path string // node path when detected
+	Err  error  // why the backend is unavailable
+}
+
+// DetectAll probes every compiled-in backend, in priority order.
+func DetectAll(battery string) []Detection {
+	out := make([]Detection, 0, len(registry))
+	for _, r := range registry {
+		p, err := r.b.Detect(battery)
+		out = append(out, Detection{Kind: r.b.Kind(), Path: p, Err: err})
+	}
+	return out
+}
+
+// Open returns the node of the backend called name, failing if that backend
+// is unknown or not available on this machine.
+func Open(name, battery string) (Node, error) {
+	var names []string
+	for _, r := range registry {
+		if r.b.Name() != name {
+			names = append(names, r.b.Name())
+			continue
+		}
+		p, err := r.b.Detect(battery)
+		if err != nil {
+			return Node{}, fmt.Errorf("backend %s unavailable: %w", name, err)
+		}
+		return Node{Path: p, Kind: r.b.Kind()}, nil
+	}
+	return Node{}, fmt.Errorf("unknown backend %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// Discover picks the backend to use: an explicit conservation_mode path, or
+// else the first registered backend detected on this machine. The built-in
+// priorities are 1) charge_types (standard API)  2) charge_control
+// thresholds (standard API)  3) conservation_mode (vendor-specific)
+// 4) Framework EC through ectool  5) ChromeOS EC battery sustainer through
+// ectool  6) msi-ec battery_mode presets  7) charge_behaviour (inhibits
+// charging outright, so only used when nothing gentler exists).
+func Discover(sysfsPath, battery string) (Node, error) {
+	if sysfsPath != "" {
+		return Node{Path: sysfsPath, Kind: ConservationMode}, nil
+	}
+	var vendorErr error
+	for _, r := range registry {
+		p, err := r.b.Detect(battery)
+		if err == nil {
+			return Node{Path: p, Kind: r.b.Kind()}, nil
+		}
+		if r.b.Kind() == ConservationMode {
+			vendorErr = err
+		}
+	}
+	// The vendor fallback's error is the most actionable one
+	return Node{}, vendorErr
+}
+
+func (n Node) backend() (Backend, error) {
+	if b := lookup(n.Kind); b != nil {
+		return b, nil
+	}
+	return nil, fmt.Errorf("no backend for %s", n.Kind)
+}
+
+// Capabilities returns what n's backend supports.
+func (n Node) Capabilities() Capabilities {
+	if b := lookup(n.Kind); b != nil {
+		return b.Capabilities()
+	}
+	return Capabilities{}
+}
+
+// ValueString returns a human-readable representation of the conservation
+// value for log messages, e.g. "Long_Life"/"Standard" for charge_types.
+func (n Node) ValueString(v int) string {
+	if b := lookup(n.Kind); b != nil {
+		return b.ValueString(n, v)
+	}
+	return fmt.Sprint(v)
+}
+
+// Read returns 1 if conservation is active, 0 otherwise. Backends with
+// Capabilities.ForceDischarge or Capabilities.Storage may also report
+// ForceDischarge or Storage.
+func (n Node) Read() (int, error) {
+	b, err := n.backend()
+	if err != nil {
+		return 0, err
+	}
+	return b.Read(n)
+}
+
+// leveler is implemented by backends whose hold level other tools may
+// change, such as desktop battery settings writing the end threshold.
+type leveler interface {
+	Level(n Node) (int, error)
+}
+
+// HasLevel reports whether Level can read n's hold level.
+func (n Node) HasLevel() bool {
+	_, ok := lookup(n.Kind).(leveler)
+	return ok
+}
+
+// Level returns the end threshold n holds at, 100 if it doesn't limit
+// charging.
+func (n Node) Level() (int, error) {
+	b, err := n.backend()
+	if err != nil {
+		return 0, err
+	}
+	l, ok := b.(leveler)
+	if !ok {
+		return 0, fmt.Errorf("%s has no level to read", n.Kind)
+	}
+	return l.Level(n)
+}
+
+// refresher is implemented by backends that hold in software and must
+// re-check the battery level every step while conserving.
+type refresher interface {
+	Refresh(n Node) error
+}
+
+// HasRefresh reports whether n needs Refresh called while conserving.
+func (n Node) HasRefresh() bool {
+	_, ok := lookup(n.Kind).(refresher)
+	return ok
+}
+
+// Refresh re-applies a software hold for the battery's current level. Read
+// never writes, so this is the only place such a hold follows the level.
+func (n Node) Refresh() error {
+	b, err := n.backend()
+	if err != nil {
+		return err
+	}
+	r, ok := b.(refresher)
+	if !ok {
+		return fmt.Errorf("%s has nothing to refresh", n.Kind)
+	}
+	return r.Refresh(n)
+}
+
+// Write sets conservation mode on (v=1) or off (v=0), or ForceDischarge or
+// Storage where supported.
+func (n Node) Write(v int) error {
+	b, err := n.backend()
+	if err != nil {
+		return err
+	}
+	caps := b.Capabilities()
+	if v != 0 && v != 1 && !(v == ForceDischarge && caps.ForceDischarge) && !(v == Storage && caps.Storage) {
+		return fmt.Errorf("invalid conservation value %d", v)
+	}
+	return b.Write(n, v)
+}
+
+// offersChargeType reports whether the charge_types node at path lists mode.
+func offersChargeType(path, mode string) bool {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	for _, m := range strings.Fields(string(b)) {
+		if strings.Trim(m, "[]") == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadChargeType reads /sys/class/power_supply/<bat>/charge_types and returns
+// the currently active mode (the one in [brackets]), e.g. "Long_Life".
+// charge_behaviour uses the same format.
+func ReadChargeType(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(string(b))
+	// Format: "Fast Standard [Long_Life]" — active mode is in brackets.
+	start := strings.Index(s, "[")
+	end := strings.Index(s, "]")
+	if start < 0 || end <= start {
+		return "", fmt.Errorf("cannot parse charge_types: %q", s)
+	}
+	return s[start+1 : end], nil
+}
+
+// WriteChargeType writes a mode string (e.g. "Long_Life", "Standard") to the
+// charge_types sysfs file.
+func WriteChargeType(path string, mode string) error {
+	return writeFile(path, mode)
+}
+
+// writer performs this package's sysfs writes. SetWriter replaces it, e.g.
+// with the host helper when the daemon has no write access to sysfs.
+var writer = WriteDirect
+
+// SetWriter routes every sysfs write of this package through w.
+func SetWriter(w func(path, value string) error) {
+	writer = w
+}
+
+func writeFile(path, value string) error {
+	return writer(path, value)
+}
+
+// WriteSysfs writes value to the sysfs file at path through the current
+// writer, for knobs managed outside this package.
+func WriteSysfs(path, value string) error {
+	return writeFile(path, value)
+}
+
+// WriteDirect writes value, newline-terminated, to the sysfs file at path.
+func WriteDirect(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte(value + "\n")); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
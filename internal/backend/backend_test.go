@@ -0,0 +1,905 @@
+package backend
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func writeNode(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	oldPS, oldIdeapad, oldSmapi, oldEC, oldMSI, oldVendor := powerSupplyDir, ideapadDir, smapiDir, crosECDev, msiECDir, dmiVendorPath
+	powerSupplyDir = filepath.Join(root, "class/power_supply")
+	ideapadDir = filepath.Join(root, "bus/platform/drivers/ideapad_acpi")
+	smapiDir = filepath.Join(root, "devices/platform/smapi")
+	crosECDev = filepath.Join(root, "dev/cros_ec")
+	msiECDir = filepath.Join(root, "devices/platform/msi-ec")
+	dmiVendorPath = filepath.Join(root, "class/dmi/id/sys_vendor")
+	t.Cleanup(func() {
+		powerSupplyDir, ideapadDir, smapiDir, crosECDev, msiECDir, dmiVendorPath = oldPS, oldIdeapad, oldSmapi, oldEC, oldMSI, oldVendor
+	})
+	return root
+}
+
+func TestConservationModeReadWrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "conservation_mode")
+	writeNode(t, p, "0\n")
+	n := Node{Path: p, Kind: ConservationMode}
+
+	if v, err := n.Read(); err != nil || v != 0 {
+		t.Fatalf("Read = %d, %v", v, err)
+	}
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := n.Read(); err != nil || v != 1 {
+		t.Fatalf("Read after write = %d, %v", v, err)
+	}
+	if err := n.Write(2); err == nil {
+		t.Error("expected error for invalid value")
+	}
+}
+
+func TestChargeTypesReadWrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "charge_types")
+	writeNode(t, p, "Fast [Standard] Long_Life\n")
+	n := Node{Path: p, Kind: ChargeTypes}
+
+	if v, err := n.Read(); err != nil || v != 0 {
+		t.Fatalf("Read = %d, %v", v, err)
+	}
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(p)
+	if strings.TrimSpace(string(b)) != "Long_Life" {
+		t.Errorf("wrote %q", b)
+	}
+	if n.ValueString(0) != "Standard" || n.ValueString(1) != "Long_Life" {
+		t.Error("unexpected ValueString")
+	}
+}
+
+func TestChargeBehaviourReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "charge_behaviour")
+	capacity := filepath.Join(dir, "capacity")
+	writeNode(t, p, "[auto] inhibit-charge force-discharge\n")
+	writeNode(t, capacity, "50\n")
+	n := Node{Path: p, Kind: ChargeBehaviour, Hold: 80, Start: 75, Levels: &Levels{}}
+	// mode returns the active mode, and puts the brackets sysfs shows
+	// around it back after a write
+	mode := func() string {
+		m, err := ReadChargeType(p)
+		if err != nil {
+			b, _ := os.ReadFile(p)
+			m = strings.TrimSpace(string(b))
+		}
+		writeNode(t, p, strings.Replace("auto inhibit-charge force-discharge", m, "["+m+"]", 1))
+		return m
+	}
+
+	if v, err := n.Read(); err != nil || v != 0 {
+		t.Fatalf("Read = %d, %v", v, err)
+	}
+	// Below the hold level, conserving still charges up to it
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if m := mode(); m != "auto" {
+		t.Errorf("below the hold level: %s", m)
+	}
+	if v, err := n.Read(); err != nil || v != 1 {
+		t.Errorf("Read while charging to the hold level = %d, %v", v, err)
+	}
+	// Read only reports; Refresh follows the level
+	writeNode(t, capacity, "80\n")
+	if v, err := n.Read(); err != nil || v != 1 {
+		t.Fatalf("Read = %d, %v", v, err)
+	}
+	if m := mode(); m != "auto" {
+		t.Errorf("Read wrote %s", m)
+	}
+	if err := n.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if m := mode(); m != "inhibit-charge" {
+		t.Errorf("at the hold level: %s", m)
+	}
+	// Held down to the start level, then charging again
+	writeNode(t, capacity, "76\n")
+	if err := n.Refresh(); err != nil || mode() != "inhibit-charge" {
+		t.Errorf("not held above the start level: %v", err)
+	}
+	writeNode(t, capacity, "74\n")
+	if err := n.Refresh(); err != nil || mode() != "auto" {
+		t.Errorf("not charging below the start level: %v", err)
+	}
+	if v, _ := n.Read(); v != 1 {
+		t.Error("charging to the hold level not read as conserving")
+	}
+	// Off is plain auto, whatever the level
+	writeNode(t, capacity, "90\n")
+	if err := n.Write(0); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := n.Read(); v != 0 || mode() != "auto" {
+		t.Error("off isn't auto")
+	}
+	// Inhibited by someone else: conserving
+	writeNode(t, p, "auto [inhibit-charge] force-discharge\n")
+	if v, _ := n.Read(); v != 1 {
+		t.Error("inhibit-charge not read as conserving")
+	}
+	// Conservation state is per node, not per path
+	if v, _ := (Node{Path: p, Kind: ChargeBehaviour, Levels: &Levels{}}).Read(); v != 1 {
+		t.Error("another node's inhibit-charge not read as conserving")
+	}
+	writeNode(t, p, "[auto] inhibit-charge force-discharge\n")
+	n.Levels.setConserving(true)
+	if v, _ := (Node{Path: p, Kind: ChargeBehaviour, Levels: &Levels{}}).Read(); v != 0 {
+		t.Error("conserving leaked to another node")
+	}
+	writeNode(t, p, "auto inhibit-charge [force-discharge]\n")
+	if v, err := n.Read(); err != nil || v != ForceDischarge {
+		t.Errorf("Read force-discharge = %d, %v", v, err)
+	}
+	if err := (Node{Path: p, Kind: ChargeTypes}).Write(ForceDischarge); err == nil {
+		t.Error("charge_types accepted force-discharge")
+	}
+}
+
+func TestChargeBehaviourExplicit(t *testing.T) {
+	root := fakeSysfs(t)
+	writeNode(t, filepath.Join(root, "bus/platform/drivers/ideapad_acpi/VPC2004:00/conservation_mode"), "0\n")
+	beh := filepath.Join(root, "class/power_supply/BAT0/charge_behaviour")
+	writeNode(t, beh, "[auto] inhibit-charge force-discharge\n")
+	writeNode(t, filepath.Join(root, "class/power_supply/BAT0/capacity"), "72\n")
+
+	// Auto-detection prefers the firmware level; -backend picks inhibition
+	if n, err := Discover("", "BAT0"); err != nil || n.Kind != ConservationMode {
+		t.Fatalf("Discover = %+v, %v", n, err)
+	}
+	n, err := Open("charge_behaviour", "BAT0")
+	if err != nil || n.Kind != ChargeBehaviour || n.Path != beh {
+		t.Fatalf("Open = %+v, %v", n, err)
+	}
+	if !n.Capabilities().Percent {
+		t.Error("charge_behaviour can't hold at a level")
+	}
+	n.Hold = 70
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(beh); strings.TrimSpace(string(b)) != "inhibit-charge" {
+		t.Errorf("above a 70%% hold: %q", b)
+	}
+}
+
+func TestReadChargeTypeMalformed(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "charge_types")
+	writeNode(t, p, "Fast Standard\n")
+	if _, err := ReadChargeType(p); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	root := fakeSysfs(t)
+
+	if _, err := Discover("", "BAT0"); err == nil {
+		t.Fatal("expected error with no nodes present")
+	}
+
+	cons := filepath.Join(root, "bus/platform/drivers/ideapad_acpi/VPC2004:00/conservation_mode")
+	writeNode(t, cons, "0\n")
+	n, err := Discover("", "BAT0")
+	if err != nil || n.Kind != ConservationMode || n.Path != cons {
+		t.Fatalf("Discover = %+v, %v", n, err)
+	}
+
+	ct := filepath.Join(root, "class/power_supply/BAT0/charge_types")
+	writeNode(t, ct, "[Standard] Long_Life\n")
+	n, err = Discover("", "BAT0")
+	if err != nil || n.Kind != ChargeTypes || n.Path != ct {
+		t.Fatalf("Discover with charge_types = %+v, %v", n, err)
+	}
+
+	n, err = Discover("/explicit", "BAT0")
+	if err != nil || n.Path != "/explicit" || n.Kind != ConservationMode {
+		t.Fatalf("explicit Discover = %+v, %v", n, err)
+	}
+}
+
+func TestDetectAllAndOpen(t *testing.T) {
+	root := fakeSysfs(t)
+	cons := filepath.Join(root, "bus/platform/drivers/ideapad_acpi/VPC2004:00/conservation_mode")
+	writeNode(t, cons, "0\n")
+
+	ds := DetectAll("BAT0")
+	if len(ds) != 7 || ds[0].Kind != ChargeTypes || ds[0].Err == nil || ds[2].Path != cons {
+		t.Fatalf("DetectAll = %+v", ds)
+	}
+
+	if n, err := Open("conservation_mode", "BAT0"); err != nil || n.Path != cons {
+		t.Errorf("Open(conservation_mode) = %+v, %v", n, err)
+	}
+	if _, err := Open("charge_types", "BAT0"); err == nil {
+		t.Error("Open of an undetected backend succeeded")
+	}
+	if _, err := Open("bogus", "BAT0"); err == nil {
+		t.Error("Open of an unknown backend succeeded")
+	}
+}
+
+func TestCurrentNode(t *testing.T) {
+	root := fakeSysfs(t)
+	if _, ok := FindCurrentNode("BAT0"); ok {
+		t.Fatal("found current node on empty sysfs")
+	}
+
+	p := filepath.Join(root, "class/power_supply/BAT0/constant_charge_current_max")
+	writeNode(t, p, "3000000\n")
+	n, ok := FindCurrentNode("BAT0")
+	if !ok || n.Default != 3000 {
+		t.Fatalf("FindCurrentNode = %+v, %t", n, ok)
+	}
+	if err := n.WriteMA(1500); err != nil {
+		t.Fatal(err)
+	}
+	if ma, err := n.ReadMA(); err != nil || ma != 1500 {
+		t.Errorf("ReadMA after write = %d, %v", ma, err)
+	}
+	if err := n.WriteMA(0); err == nil {
+		t.Error("expected error for zero current")
+	}
+
+	// A cap left at startup isn't the default when the design value is known
+	writeNode(t, p+"_design", "3000000\n")
+	if n, ok := FindCurrentNode("BAT0"); !ok || n.Default != 3000 {
+		t.Errorf("FindCurrentNode with a design value = %+v, %t", n, ok)
+	}
+}
+
+func TestThresholdsAndLinked(t *testing.T) {
+	root := fakeSysfs(t)
+	cons := filepath.Join(root, "bus/platform/drivers/ideapad_acpi/VPC2004:00/conservation_mode")
+	end := filepath.Join(root, "class/power_supply/BAT0/charge_control_end_threshold")
+	start := filepath.Join(root, "class/power_supply/BAT0/charge_control_start_threshold")
+	writeNode(t, cons, "1\n")
+	writeNode(t, end, "100\n")
+	writeNode(t, start, "90\n")
+
+	n, err := Discover("", "BAT0")
+	if err != nil || n.Kind != ChargeThresholds {
+		t.Fatalf("Discover = %+v, %v", n, err)
+	}
+	n.Hold = 80
+	other, ok := Counterpart(n, "BAT0")
+	if !ok || other.Path != cons {
+		t.Fatalf("Counterpart = %+v, %t", other, ok)
+	}
+
+	primary, secondary := Order(n, other, "charge_thresholds")
+	l := Linked{Primary: primary, Secondary: secondary}
+	if err := l.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := readInt(end); v != 80 {
+		t.Errorf("end threshold = %d, want 80", v)
+	}
+	if v, _ := readInt(start); v != 75 {
+		t.Errorf("start threshold = %d, want 75", v)
+	}
+	if v, _ := other.Read(); v != 0 {
+		t.Error("secondary conservation_mode left on")
+	}
+	if v, err := l.Read(); err != nil || v != 1 {
+		t.Errorf("Read = %d, %v", v, err)
+	}
+
+	if p, _ := Order(n, other, "conservation_mode"); p.Kind != ConservationMode {
+		t.Error("precedence not honoured")
+	}
+}
+
+func TestThresholdsStartStop(t *testing.T) {
+	root := fakeSysfs(t)
+	stop := filepath.Join(root, "devices/platform/smapi/BAT0/stop_charge_thresh")
+	start := filepath.Join(root, "devices/platform/smapi/BAT0/start_charge_thresh")
+	writeNode(t, stop, "0\n")
+	writeNode(t, start, "0\n")
+
+	n, err := Discover("", "BAT0")
+	if err != nil || n.Kind != ChargeThresholds || n.Path != stop {
+		t.Fatalf("Discover = %+v, %v", n, err)
+	}
+	if v, _ := n.Read(); v != 0 {
+		t.Error("tp_smapi default read as conserving")
+	}
+	if l, err := n.Level(); err != nil || l != 100 || !n.HasLevel() {
+		t.Errorf("tp_smapi default level = %d, %v", l, err)
+	}
+	n.Hold, n.Start = 80, 60
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	// Another tool moves the level: still conserving, at a new level
+	writeNode(t, stop, "60\n")
+	if v, _ := n.Read(); v != 1 {
+		t.Errorf("read at 60 = %d", v)
+	}
+	if l, err := n.Level(); err != nil || l != 60 {
+		t.Errorf("level = %d, %v, want 60", l, err)
+	}
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := readInt(start); s != 60 {
+		t.Errorf("start = %d, want 60", s)
+	}
+	if e, _ := readInt(stop); e != 80 {
+		t.Errorf("stop = %d, want 80", e)
+	}
+	// Off charges right away: start just below a full end threshold
+	if err := n.Write(0); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := readInt(start); s != 99 {
+		t.Errorf("start after off = %d, want 99", s)
+	}
+	if e, _ := readInt(stop); e != 100 {
+		t.Errorf("stop after off = %d, want 100", e)
+	}
+}
+
+func TestThresholdsStorage(t *testing.T) {
+	root := fakeSysfs(t)
+	end := filepath.Join(root, "class/power_supply/BAT0/charge_control_end_threshold")
+	start := filepath.Join(root, "class/power_supply/BAT0/charge_control_start_threshold")
+	writeNode(t, end, "100\n")
+	writeNode(t, start, "0\n")
+
+	n := Node{Path: end, Kind: ChargeThresholds, Hold: 80, StorageHold: 55}
+	if err := n.Write(Storage); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := readInt(end); e != 55 {
+		t.Errorf("end = %d, want 55", e)
+	}
+	if s, _ := readInt(start); s != 50 {
+		t.Errorf("start = %d, want 50", s)
+	}
+	if v, _ := n.Read(); v != Storage {
+		t.Errorf("Read = %d, want Storage", v)
+	}
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := n.Read(); v != 1 {
+		t.Errorf("Read after conserving = %d, want 1", v)
+	}
+
+	cm := Node{Path: filepath.Join(root, "conservation_mode"), Kind: ConservationMode}
+	if err := cm.Write(Storage); err == nil {
+		t.Error("conservation_mode accepted Storage")
+	}
+}
+
+func TestThresholdsLevels(t *testing.T) {
+	root := fakeSysfs(t)
+	end := filepath.Join(root, "class/power_supply/BAT0/charge_control_end_threshold")
+	start := filepath.Join(root, "class/power_supply/BAT0/charge_control_start_threshold")
+	writeNode(t, end, "100\n")
+	writeNode(t, start, "0\n")
+
+	n := Node{Path: end, Kind: ChargeThresholds, Hold: 80, Levels: &Levels{}}
+	if !n.Levels.Set(60, 55) || n.Levels.Set(60, 55) {
+		t.Error("Set did not report the change once")
+	}
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := readInt(end); e != 60 {
+		t.Errorf("end = %d, want 60", e)
+	}
+	if s, _ := readInt(start); s != 55 {
+		t.Errorf("start = %d, want 55", s)
+	}
+
+	// Holding at 100 reads as conserving, unlike off
+	n.Levels.Set(100, 95)
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := n.Read(); v != 1 {
+		t.Errorf("Read at 100/95 = %d, want 1", v)
+	}
+	if err := n.Write(0); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := n.Read(); v != 0 {
+		t.Errorf("Read after off = %d, want 0", v)
+	}
+}
+
+func TestExtras(t *testing.T) {
+	root := fakeSysfs(t)
+	dir := filepath.Join(root, "bus/platform/drivers/ideapad_acpi/VPC2004:00")
+	if len(FindExtras()) != 0 {
+		t.Fatal("extras found without conservation_mode")
+	}
+	writeNode(t, filepath.Join(dir, "conservation_mode"), "0\n")
+	writeNode(t, filepath.Join(dir, "usb_charging"), "0\n")
+
+	extras := FindExtras()
+	p, ok := extras["usb_charging"]
+	if len(extras) != 1 || !ok {
+		t.Fatalf("FindExtras = %v", extras)
+	}
+	if err := WriteFlag(p, true); err != nil {
+		t.Fatal(err)
+	}
+	if on, err := ReadFlag(p); err != nil || !on {
+		t.Errorf("ReadFlag = %t, %v", on, err)
+	}
+}
+
+func TestKeepOpen(t *testing.T) {
+	root := fakeSysfs(t)
+	bat := filepath.Join(root, "class/power_supply/BAT0")
+	writeNode(t, filepath.Join(bat, "type"), "Battery\n")
+	writeNode(t, filepath.Join(bat, "charge_control_end_threshold"), "80\n")
+	writeNode(t, filepath.Join(bat, "charge_control_start_threshold"), "75\n")
+	old := writer
+	t.Cleanup(func() { writer = old })
+
+	paths := WritablePaths("BAT0")
+	end := filepath.Join(bat, "charge_control_end_threshold")
+	if !slices.Contains(paths, end) || !slices.Contains(paths, filepath.Join(bat, "charge_control_start_threshold")) {
+		t.Fatalf("WritablePaths = %v", paths)
+	}
+	if err := KeepOpen(paths); err != nil {
+		t.Fatal(err)
+	}
+	// Writes go through the descriptor opened before, not the path
+	moved := filepath.Join(root, "end.moved")
+	if err := os.Rename(end, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(end, "60"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(moved); string(b) != "60\n" {
+		t.Errorf("kept file = %q, want 60", b)
+	}
+	// Other paths still go to the previous writer
+	other := filepath.Join(bat, "charge_behaviour")
+	writeNode(t, other, "auto\n")
+	if err := writeFile(other, "inhibit-charge"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(other); string(b) != "inhibit-charge\n" {
+		t.Errorf("other file = %q", b)
+	}
+}
+
+func TestBatteriesApply(t *testing.T) {
+	root := fakeSysfs(t)
+	bat := func(name, attr, v string) {
+		writeNode(t, filepath.Join(root, "class/power_supply", name, attr), v+"\n")
+	}
+	for _, name := range []string{"BAT0", "BAT1"} {
+		bat(name, "type", "Battery")
+		bat(name, "status", "Charging")
+		bat(name, "charge_control_end_threshold", "100")
+		bat(name, "charge_behaviour", "[auto] inhibit-charge force-discharge")
+	}
+	bat("BAT0", "capacity", "40")
+	bat("BAT1", "capacity", "30")
+	bat("AC", "type", "Mains")
+
+	b := Batteries{Thresholds: map[string]int{"BAT0": 80, "BAT1": 60}, ChargeFirst: "BAT1"}
+	if err := b.Apply(false); err != nil {
+		t.Fatal(err)
+	}
+	bats := ListBatteries()
+	if len(bats) != 2 || bats[0].EndThreshold != 80 || bats[1].EndThreshold != 60 {
+		t.Fatalf("thresholds not applied: %+v", bats)
+	}
+	// sysfs would show the new mode in brackets; the fake file holds it bare
+	behaviour := filepath.Join(root, "class/power_supply/BAT0/charge_behaviour")
+	if b, _ := os.ReadFile(behaviour); strings.TrimSpace(string(b)) != "inhibit-charge" {
+		t.Errorf("BAT0 charge_behaviour = %q while BAT1 charges first", b)
+	}
+
+	bat("BAT0", "charge_behaviour", "auto [inhibit-charge] force-discharge")
+	bat("BAT1", "capacity", "60")
+	if err := b.Apply(false); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(behaviour); strings.TrimSpace(string(b)) != "auto" {
+		t.Errorf("BAT0 charge_behaviour = %q after BAT1 reached its threshold", b)
+	}
+
+	// Reset lifts an inhibit Apply left
+	bat("BAT0", "charge_behaviour", "auto [inhibit-charge] force-discharge")
+	if err := b.Reset(false); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(behaviour); strings.TrimSpace(string(b)) != "auto" {
+		t.Errorf("BAT0 charge_behaviour = %q after Reset", b)
+	}
+
+	if _, err := ParseBatteryThresholds("BAT0=80,BAT1"); err == nil {
+		t.Error("malformed thresholds accepted")
+	}
+}
+
+func TestThresholdsDellCustom(t *testing.T) {
+	root := fakeSysfs(t)
+	bat := filepath.Join(root, "class/power_supply/BAT0")
+	ct := filepath.Join(bat, "charge_types")
+	end := filepath.Join(bat, "charge_control_end_threshold")
+	start := filepath.Join(bat, "charge_control_start_threshold")
+	writeNode(t, ct, "[Standard] Adaptive Custom Fast Trickle\n")
+	writeNode(t, end, "90\n")
+	writeNode(t, start, "50\n")
+
+	// charge_types without Long_Life is left to the thresholds backend
+	n, err := Discover("", "BAT0")
+	if err != nil || n.Kind != ChargeThresholds || n.Path != end {
+		t.Fatalf("Discover = %+v, %v", n, err)
+	}
+	if v, _ := n.Read(); v != 0 {
+		t.Error("Standard mode read as conserving")
+	}
+
+	n.Hold = 80
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(ct); strings.TrimSpace(string(b)) != "Custom" {
+		t.Errorf("mode after on = %q, want Custom", b)
+	}
+	if e, _ := readInt(end); e != 80 {
+		t.Errorf("end = %d, want 80", e)
+	}
+	if s, _ := readInt(start); s != 75 {
+		t.Errorf("start = %d, want 75", s)
+	}
+	writeNode(t, ct, "Standard Adaptive [Custom] Fast Trickle\n")
+	if v, _ := n.Read(); v != 1 {
+		t.Error("Custom mode not read as conserving")
+	}
+
+	if err := n.Write(0); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(ct); strings.TrimSpace(string(b)) != "Standard" {
+		t.Errorf("mode after off = %q, want Standard", b)
+	}
+	if e, _ := readInt(end); e != 80 {
+		t.Errorf("off touched the thresholds: end = %d", e)
+	}
+}
+
+// fakeEctool installs script as ectool for the test.
+func fakeEctool(t *testing.T, root, script string) {
+	t.Helper()
+	ectool := filepath.Join(root, "ectool")
+	writeNode(t, ectool, "#!/bin/sh\n"+script)
+	if err := os.Chmod(ectool, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := ectoolPath
+	ectoolPath = ectool
+	t.Cleanup(func() { ectoolPath = old })
+}
+
+func TestFrameworkEC(t *testing.T) {
+	root := fakeSysfs(t)
+	limit := filepath.Join(root, "limit")
+	writeNode(t, limit, "100\n")
+	// "fwchargelimit [N]" stores or prints the limit
+	fakeEctool(t, root, "if [ -n \"$2\" ]; then echo \"$2\" > "+limit+"; else cat "+limit+"; fi\n")
+	writeNode(t, dmiVendorPath, "Framework\n")
+
+	if p := FindFrameworkEC(); p != "" {
+		t.Errorf("found %s without an EC device", p)
+	}
+	writeNode(t, crosECDev, "")
+	if p := FindCrosEC(); p != "" {
+		t.Errorf("Framework EC offered as a sustainer: %s", p)
+	}
+	n, err := Discover("", "BAT1")
+	if err != nil || n.Kind != FrameworkEC || n.Path != crosECDev {
+		t.Fatalf("Discover = %+v, %v", n, err)
+	}
+	if v, err := n.Read(); err != nil || v != 0 {
+		t.Fatalf("Read = %d, %v", v, err)
+	}
+	n.Hold = 70
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := readInt(limit); l != 70 {
+		t.Errorf("limit = %d, want 70", l)
+	}
+	if v, _ := n.Read(); v != 1 {
+		t.Error("limit 70 not read as conserving")
+	}
+	if err := n.Write(0); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := readInt(limit); l != 100 {
+		t.Errorf("limit after off = %d, want 100", l)
+	}
+}
+
+func TestCrosECSustainer(t *testing.T) {
+	root := fakeSysfs(t)
+	args := filepath.Join(root, "args")
+	writeNode(t, args, "normal\n")
+	// "chargecontrol [normal [lower upper]]" stores or reports the setting
+	fakeEctool(t, root, `if [ -n "$2" ]; then shift; echo "$@" > `+args+`; exit; fi
+set -- $(cat `+args+`)
+if [ -n "$2" ]; then echo "Battery sustainer = on ($2% ~ $3%)"; else echo "Battery sustainer = off (-1% ~ -1%)"; fi
+`)
+	writeNode(t, dmiVendorPath, "Google\n")
+	writeNode(t, crosECDev, "")
+
+	n, err := Discover("", "BAT0")
+	if err != nil || n.Kind != CrosECSustainer {
+		t.Fatalf("Discover = %+v, %v", n, err)
+	}
+	if v, err := n.Read(); err != nil || v != 0 {
+		t.Fatalf("Read = %d, %v", v, err)
+	}
+	n.Hold, n.Start = 80, 60
+	if err := n.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(args); strings.TrimSpace(string(b)) != "normal 60 80" {
+		t.Errorf("ectool chargecontrol %s", b)
+	}
+	if v, _ := n.Read(); v != 1 {
+		t.Error("sustainer on not read as conserving")
+	}
+	if err := n.Write(0); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(args); strings.TrimSpace(string(b)) != "normal" {
+		t.Errorf("off ran ectool chargecontrol %s", b)
+	}
+}
+
+func TestMSIBatteryMode(t *testing.T) {
+	root := fakeSysfs(t)
+	p := filepath.Join(root, "devices/platform/msi-ec/battery_mode")
+	writeNode(t, p, "max\n")
+	n, err := Discover("", "BAT1")
+	if err != nil || n.Kind != MSIBatteryMode || n.Path != p {
+		t.Fatalf("Discover = %+v, %v", n, err)
+	}
+	if v, err := n.Read(); err != nil || v != 0 {
+		t.Fatalf("Read = %d, %v", v, err)
+	}
+	for _, tc := range []struct {
+		hold int
+		want string
+	}{{60, "best"}, {80, "balanced"}, {90, "balanced"}} {
+		n.Hold = tc.hold
+		if err := n.Write(1); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := os.ReadFile(p); strings.TrimSpace(string(b)) != tc.want {
+			t.Errorf("hold %d wrote %q, want %s", tc.hold, b, tc.want)
+		}
+		// What conserving writes reads back as conserving
+		if v, err := n.Read(); err != nil || v != 1 {
+			t.Errorf("hold %d: Read after conserving = %d, %v", tc.hold, v, err)
+		}
+	}
+	writeNode(t, p, "best [balanced] max\n")
+	if v, _ := n.Read(); v != 1 {
+		t.Error("balanced not read as conserving")
+	}
+	if err := n.Write(0); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(p); strings.TrimSpace(string(b)) != "max" {
+		t.Errorf("off wrote %q, want max", b)
+	}
+}
+
+type fakeBackend struct{ chargeTypes }
+
+func (fakeBackend) Kind() Kind   { return Kind(99) }
+func (fakeBackend) Name() string { return "fake" }
+
+func (fakeBackend) Detect(string) (string, error) { return "/fake", nil }
+
+func TestRegister(t *testing.T) {
+	root := fakeSysfs(t)
+	old := registry
+	registry = slices.Clone(registry)
+	t.Cleanup(func() { registry = old })
+
+	// Registered after conservation_mode but before charge_behaviour
+	Register(30, fakeBackend{})
+	names := []string{}
+	for _, d := range DetectAll("BAT0") {
+		names = append(names, d.Kind.String())
+	}
+	if i := slices.Index(names, "fake"); i != 3 || names[2] != "conservation_mode" || names[len(names)-1] != "charge_behaviour" {
+		t.Fatalf("registry order = %v", names)
+	}
+	n, err := Discover("", "BAT0")
+	if err != nil || n.Kind != Kind(99) || n.Path != "/fake" {
+		t.Fatalf("Discover = %+v, %v", n, err)
+	}
+
+	writeNode(t, filepath.Join(root, "class/power_supply/BAT0/charge_types"), "[Standard] Long_Life\n")
+	if n, _ := Discover("", "BAT0"); n.Kind != ChargeTypes {
+		t.Errorf("higher priority backend not preferred: %+v", n)
+	}
+	if c := (Node{Kind: ChargeThresholds}).Capabilities(); !c.Percent || !c.Start || c.ForceDischarge {
+		t.Errorf("charge_thresholds capabilities = %+v", c)
+	}
+}
+
+func TestSiblingsMirrored(t *testing.T) {
+	root := fakeSysfs(t)
+	ps := filepath.Join(root, "class/power_supply")
+	for _, bat := range []string{"BAT0", "BAT1", "BAT2"} {
+		writeNode(t, filepath.Join(ps, bat, "type"), "Battery\n")
+		writeNode(t, filepath.Join(ps, bat, "charge_control_end_threshold"), "100\n")
+	}
+	writeNode(t, filepath.Join(ps, "hidpp_battery_0/type"), "Battery\n")
+	writeNode(t, filepath.Join(ps, "hidpp_battery_0/scope"), "Device\n")
+	writeNode(t, filepath.Join(ps, "hidpp_battery_0/charge_control_end_threshold"), "100\n")
+
+	if bats := ListBatteries(); len(bats) != 3 {
+		t.Errorf("ListBatteries = %+v, want the peripheral skipped", bats)
+	}
+	n := Node{Path: filepath.Join(ps, "BAT0/charge_control_end_threshold"), Kind: ChargeThresholds, Hold: 80}
+	others := Siblings(n, map[string]int{"BAT2": 60})
+	if len(others) != 1 || others[0].Path != filepath.Join(ps, "BAT1/charge_control_end_threshold") || others[0].Hold != 80 {
+		t.Fatalf("Siblings = %+v", others)
+	}
+	if err := (Mirrored{Knob: n, Others: others}).Write(1); err != nil {
+		t.Fatal(err)
+	}
+	for bat, want := range map[string]int{"BAT0": 80, "BAT1": 80, "BAT2": 100} {
+		if v, _ := readInt(filepath.Join(ps, bat, "charge_control_end_threshold")); v != want {
+			t.Errorf("%s end = %d, want %d", bat, v, want)
+		}
+	}
+	if s := Siblings(Node{Path: "/explicit/conservation_mode"}, nil); s != nil {
+		t.Errorf("Siblings of a non power_supply node = %+v", s)
+	}
+}
+
+func TestDefaultBattery(t *testing.T) {
+	root := fakeSysfs(t)
+	ps := filepath.Join(root, "class/power_supply")
+	if got := DefaultBattery(); got != "BAT0" {
+		t.Errorf("no batteries: %s", got)
+	}
+	writeNode(t, filepath.Join(ps, "AC/type"), "Mains\n")
+	writeNode(t, filepath.Join(ps, "BAT0/type"), "Battery\n")
+	writeNode(t, filepath.Join(ps, "hidpp_battery_0/type"), "Battery\n")
+	writeNode(t, filepath.Join(ps, "hidpp_battery_0/scope"), "Device\n")
+	writeNode(t, filepath.Join(ps, "hidpp_battery_0/charge_types"), "Standard\n")
+	writeNode(t, filepath.Join(ps, "CMB0/type"), "Battery\n")
+	writeNode(t, filepath.Join(ps, "CMB0/charge_control_end_threshold"), "100\n")
+	if got := DefaultBattery(); got != "CMB0" {
+		t.Errorf("DefaultBattery = %s, want CMB0", got)
+	}
+}
+
+type flakyKnob struct {
+	val int
+	err error
+}
+
+func (k *flakyKnob) Read() (int, error)       { return k.val, k.err }
+func (k *flakyKnob) ValueString(v int) string { return "v" }
+
+func (k *flakyKnob) Write(v int) error {
+	if k.err != nil {
+		return k.err
+	}
+	k.val = v
+	return nil
+}
+
+func TestFallback(t *testing.T) {
+	a, b, c := &flakyKnob{}, &flakyKnob{}, &flakyKnob{}
+	var switched []string
+	f := &Fallback{
+		Knobs:    []Named{{"a", a}, {"b", b}, {"c", c}},
+		OnSwitch: func(to Named, err error) { switched = append(switched, to.Name) },
+	}
+
+	// Write errors fail over only once they persist
+	a.err = syscall.EIO
+	for i := 1; i < FailoverAfter; i++ {
+		if err := f.Write(1); err == nil {
+			t.Fatalf("write %d succeeded on a failing knob", i)
+		}
+	}
+	if f.Active().Name != "a" {
+		t.Fatal("failed over too early")
+	}
+	if err := f.Write(1); err != nil || b.val != 1 || f.Active().Name != "b" {
+		t.Fatalf("after %d failures: %v, active %s", FailoverAfter, err, f.Active().Name)
+	}
+
+	// A vanished node fails over at once
+	b.err = fs.ErrNotExist
+	if v, err := f.Read(); err != nil || v != 0 || f.Active().Name != "c" {
+		t.Fatalf("Read = %d, %v, active %s", v, err, f.Active().Name)
+	}
+
+	// The last knob keeps reporting its errors
+	c.err = fs.ErrNotExist
+	if _, err := f.Read(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Read on the last knob = %v", err)
+	}
+	if !slices.Equal(switched, []string{"b", "c"}) {
+		t.Errorf("switched = %v", switched)
+	}
+}
+
+func TestWithDischarge(t *testing.T) {
+	root := fakeSysfs(t)
+	bat := filepath.Join(root, "class/power_supply/BAT0")
+	end := filepath.Join(bat, "charge_control_end_threshold")
+	beh := filepath.Join(bat, "charge_behaviour")
+	writeNode(t, end, "80\n")
+	writeNode(t, beh, "[auto] inhibit-charge force-discharge\n")
+	w := WithDischarge{
+		Knob:      Node{Path: end, Kind: ChargeThresholds, Hold: 80},
+		Behaviour: Node{Path: beh, Kind: ChargeBehaviour},
+	}
+	if err := w.Write(ForceDischarge); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := readInt(end); e != 100 {
+		t.Errorf("end = %d, want the cap lifted", e)
+	}
+	if b, _ := os.ReadFile(beh); strings.TrimSpace(string(b)) != "force-discharge" {
+		t.Errorf("charge_behaviour = %q", b)
+	}
+	writeNode(t, beh, "auto inhibit-charge [force-discharge]\n")
+	if v, _ := w.Read(); v != ForceDischarge {
+		t.Errorf("Read = %d, want ForceDischarge", v)
+	}
+	if err := w.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(beh); strings.TrimSpace(string(b)) != "auto" {
+		t.Errorf("charge_behaviour after conserving = %q", b)
+	}
+	if e, _ := readInt(end); e != 80 {
+		t.Errorf("end = %d, want 80", e)
+	}
+}
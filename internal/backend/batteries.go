@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"conservationDaemon/internal/logging"
+)
+
+// BatteryInfo describes one battery for multi-battery status output.
+type BatteryInfo struct {
+	Name         string
+	Pct          float64
+	Status       string // kernel status, e.g. "Charging"
+	EndThreshold int    // 0 if the battery has no charge_control_end_threshold
+	Behaviour    string // active charge_behaviour, "" if unsupported
+}
+
+// ListBatteries returns every system battery under power_supply, sorted by
+// name. Peripheral batteries (scope "Device", e.g. a wireless mouse) are
+// skipped. It is re-read on every call, so hot-swapped batteries come and go.
+func ListBatteries() []BatteryInfo {
+	dirs, _ := os.ReadDir(powerSupplyDir)
+	var out []BatteryInfo
+	for _, d := range dirs {
+		dir := filepath.Join(powerSupplyDir, d.Name())
+		if t, _ := os.ReadFile(filepath.Join(dir, "type")); strings.TrimSpace(string(t)) != "Battery" {
+			continue
+		}
+		if s, _ := os.ReadFile(filepath.Join(dir, "scope")); strings.TrimSpace(string(s)) == "Device" {
+			continue
+		}
+		b := BatteryInfo{Name: d.Name()}
+		if v, err := readInt(filepath.Join(dir, "capacity")); err == nil {
+			b.Pct = float64(v)
+		}
+		if s, err := os.ReadFile(filepath.Join(dir, "status")); err == nil {
+			b.Status = strings.TrimSpace(string(s))
+		}
+		if v, err := readInt(filepath.Join(dir, "charge_control_end_threshold")); err == nil {
+			b.EndThreshold = v
+		}
+		if m, err := ReadChargeType(filepath.Join(dir, "charge_behaviour")); err == nil {
+			b.Behaviour = m
+		}
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// DefaultBattery picks the battery to drive when none is configured: the
+// first system battery exposing a charge-control attribute, else the first
+// system battery, else BAT0. Not every laptop names its battery BAT0: BAT1,
+// CMB0 and BATT are common too.
+func DefaultBattery() string {
+	var first string
+	for _, b := range ListBatteries() {
+		dir := filepath.Join(powerSupplyDir, b.Name)
+		for _, attr := range []string{"charge_control_end_threshold", "charge_types", "charge_behaviour"} {
+			if _, err := os.Stat(filepath.Join(dir, attr)); err == nil {
+				return b.Name
+			}
+		}
+		if first == "" {
+			first = b.Name
+		}
+	}
+	if first == "" {
+		return "BAT0"
+	}
+	return first
+}
+
+// Siblings returns the same knob as n on the other system batteries, for
+// power_supply backends (charge thresholds, charge_types). Batteries listed in
+// skip are left out.
+func Siblings(n Node, skip map[string]int) []Node {
+	dir := filepath.Dir(n.Path)
+	if filepath.Dir(dir) != powerSupplyDir || (n.Kind != ChargeThresholds && n.Kind != ChargeTypes) {
+		return nil
+	}
+	var out []Node
+	for _, b := range ListBatteries() {
+		if _, ok := skip[b.Name]; ok || b.Name == filepath.Base(dir) {
+			continue
+		}
+		o := n
+		o.Path = filepath.Join(powerSupplyDir, b.Name, filepath.Base(n.Path))
+		if _, err := os.Stat(o.Path); err == nil {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// Mirrored drives Knob and repeats every write on Others, so conservation
+// covers every controllable battery and not just the one driven.
+type Mirrored struct {
+	Knob   Knob
+	Others []Node
+}
+
+func (m Mirrored) Read() (int, error)       { return m.Knob.Read() }
+func (m Mirrored) ValueString(v int) string { return m.Knob.ValueString(v) }
+
+// Write writes v to Knob, then to Others; failures on the others are logged
+// but don't fail the write, as a removed battery is no reason to stop.
+func (m Mirrored) Write(v int) error {
+	if err := m.Knob.Write(v); err != nil {
+		return err
+	}
+	for _, o := range m.Others {
+		if cur, err := o.Read(); err == nil && cur == v {
+			continue
+		}
+		if err := o.Write(v); err != nil {
+			logging.Warnf("%s: %v", o.Path, err)
+		}
+	}
+	return nil
+}
+
+// Batteries manages the batteries of dual-battery machines (ThinkPads):
+// distinct end thresholds per battery, and which one charges first.
+type Batteries struct {
+	Thresholds  map[string]int // battery name -> end threshold
+	ChargeFirst string         // battery charged before the others; "" for firmware order
+}
+
+// Apply writes the per-battery thresholds, then inhibits charging of the
+// other batteries until ChargeFirst reaches its threshold (or is full).
+func (b Batteries) Apply(dryRun bool) error {
+	var firstErr error
+	set := func(path, value string) {
+		if dryRun {
+			logging.Logf("[dry-run] would write %s to %s", value, path)
+			return
+		}
+		if err := writeFile(path, value); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	bats := ListBatteries()
+	for _, bat := range bats {
+		want, ok := b.Thresholds[bat.Name]
+		if !ok || bat.EndThreshold == 0 || bat.EndThreshold == want {
+			continue
+		}
+		start := filepath.Join(powerSupplyDir, bat.Name, "charge_control_start_threshold")
+		if s, err := readInt(start); err == nil && s >= want {
+			set(start, strconv.Itoa(want-5))
+		}
+		set(filepath.Join(powerSupplyDir, bat.Name, "charge_control_end_threshold"), strconv.Itoa(want))
+		logging.Logf("%s end threshold set to %d", bat.Name, want)
+	}
+
+	if b.ChargeFirst == "" {
+		return firstErr
+	}
+	var first *BatteryInfo
+	for i := range bats {
+		if bats[i].Name == b.ChargeFirst {
+			first = &bats[i]
+		}
+	}
+	if first == nil {
+		return fmt.Errorf("charge-first battery %s not found", b.ChargeFirst)
+	}
+	limit := 100
+	if t, ok := b.Thresholds[first.Name]; ok {
+		limit = t
+	} else if first.EndThreshold > 0 {
+		limit = first.EndThreshold
+	}
+	mode := "auto"
+	if first.Pct < float64(limit) && first.Status != "Full" {
+		mode = "inhibit-charge"
+	}
+	for _, bat := range bats {
+		if bat.Name == first.Name || bat.Behaviour == "" || bat.Behaviour == mode {
+			continue
+		}
+		set(filepath.Join(powerSupplyDir, bat.Name, "charge_behaviour"), mode)
+		logging.Logf("%s charge_behaviour set to %s (%s charges first)", bat.Name, mode, first.Name)
+	}
+	return firstErr
+}
+
+// Reset lets the batteries Apply held back charge again, so the charge
+// order doesn't outlive the daemon.
+func (b Batteries) Reset(dryRun bool) error {
+	if b.ChargeFirst == "" {
+		return nil
+	}
+	var firstErr error
+	for _, bat := range ListBatteries() {
+		if bat.Name == b.ChargeFirst || bat.Behaviour != "inhibit-charge" {
+			continue
+		}
+		path := filepath.Join(powerSupplyDir, bat.Name, "charge_behaviour")
+		if dryRun {
+			logging.Logf("[dry-run] would write auto to %s", path)
+			continue
+		}
+		if err := writeFile(path, "auto"); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logging.Logf("%s charge_behaviour set to auto", bat.Name)
+	}
+	return firstErr
+}
+
+// ParseBatteryThresholds parses "BAT0=80,BAT1=60".
+func ParseBatteryThresholds(s string) (map[string]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	m := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		t, err := strconv.Atoi(v)
+		if !ok || err != nil || t < 10 || t > 100 {
+			return nil, fmt.Errorf("battery threshold %q: want NAME=10..100", pair)
+		}
+		m[name] = t
+	}
+	return m, nil
+}
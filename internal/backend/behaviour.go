@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// charge_behaviour has no level of its own, so it is only used when nothing
+// with a firmware threshold exists.
+func init() { Register(90, chargeBehaviour{}) }
+
+// chargeBehaviour is the standard power_supply charge_behaviour attribute.
+// Knob values index behaviourModes, so it also supports ForceDischarge.
+//
+// Conserving holds at Node.Hold in software: charging is inhibited at or
+// above it and allowed again below the start level. The battery's level
+// moves without any write, so Refresh re-checks it every control step while
+// conserving; Read only reports.
+type chargeBehaviour struct{}
+
+// behaviourModes maps knob values to charge_behaviour modes.
+var behaviourModes = []string{"auto", "inhibit-charge", "force-discharge"}
+
+func (chargeBehaviour) Kind() Kind   { return ChargeBehaviour }
+func (chargeBehaviour) Name() string { return "charge_behaviour" }
+
+func (chargeBehaviour) Capabilities() Capabilities {
+	return Capabilities{Percent: true, Start: true, ForceDischarge: true}
+}
+
+func (chargeBehaviour) Detect(battery string) (string, error) {
+	if p := FindChargeBehaviourNode(battery); p != "" {
+		return p, nil
+	}
+	return "", fmt.Errorf("%s not found", filepath.Join(powerSupplyDir, battery, "charge_behaviour"))
+}
+
+func (chargeBehaviour) Read(n Node) (int, error) {
+	mode, err := ReadChargeType(n.Path)
+	if err != nil {
+		return 0, err
+	}
+	switch mode {
+	case "force-discharge":
+		return ForceDischarge, nil
+	case "inhibit-charge":
+		// Conserving, whoever wrote it
+		return 1, nil
+	}
+	// "auto" after a conserving write is charging up to the hold level
+	if n.Levels != nil && n.Levels.conserving() {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (chargeBehaviour) Write(n Node, v int) error {
+	if n.Levels != nil {
+		n.Levels.setConserving(v == 1)
+	}
+	if v != 1 {
+		return WriteChargeType(n.Path, behaviourModes[v])
+	}
+	mode, err := ReadChargeType(n.Path)
+	if err != nil {
+		mode = ""
+	}
+	return n.holdBehaviour(mode)
+}
+
+func (chargeBehaviour) Refresh(n Node) error {
+	if n.Levels != nil {
+		n.Levels.setConserving(true)
+	}
+	mode, err := ReadChargeType(n.Path)
+	if err != nil {
+		return err
+	}
+	return n.holdBehaviour(mode)
+}
+
+// holdBehaviour inhibits charging once the battery reaches the hold level,
+// and allows it again below the start level, for a node in mode.
+func (n Node) holdBehaviour(mode string) error {
+	pct, err := readInt(filepath.Join(filepath.Dir(n.Path), "capacity"))
+	if err != nil {
+		return fmt.Errorf("charge_behaviour: battery level: %w", err)
+	}
+	want := mode
+	switch {
+	case pct >= n.hold():
+		want = "inhibit-charge"
+	case pct < n.start() || mode != "inhibit-charge":
+		want = "auto"
+	}
+	if want == mode {
+		return nil
+	}
+	return WriteChargeType(n.Path, want)
+}
+
+func (chargeBehaviour) ValueString(n Node, v int) string {
+	if v == 1 {
+		return fmt.Sprintf("inhibit-charge at %d%% (auto below %d%%)", n.hold(), n.start())
+	}
+	if v >= 0 && v < len(behaviourModes) {
+		return behaviourModes[v]
+	}
+	return strconv.Itoa(v)
+}
+
+// FindChargeBehaviourNode returns the path of
+// /sys/class/power_supply/<battery>/charge_behaviour, or "" if absent.
+func FindChargeBehaviourNode(battery string) string {
+	p := filepath.Join(powerSupplyDir, battery, "charge_behaviour")
+	if st, err := os.Stat(p); err == nil && !st.IsDir() {
+		return p
+	}
+	return ""
+}
+
+// WithDischarge adds force-discharge to a knob without it, through the
+// charge_behaviour of the same battery (ThinkPads: thresholds plus
+// charge_behaviour).
+type WithDischarge struct {
+	Knob      Knob
+	Behaviour Node
+}
+
+func (w WithDischarge) Read() (int, error) {
+	if v, err := w.Behaviour.Read(); err == nil && v == ForceDischarge {
+		return v, nil
+	}
+	return w.Knob.Read()
+}
+
+func (w WithDischarge) Write(v int) error {
+	if v == ForceDischarge {
+		// Lift the cap first so the discharge isn't mistaken for conserving
+		if err := w.Knob.Write(0); err != nil {
+			return err
+		}
+		return w.Behaviour.Write(ForceDischarge)
+	}
+	if cur, err := w.Behaviour.Read(); err != nil || cur != 0 {
+		if err := w.Behaviour.Write(0); err != nil {
+			return err
+		}
+	}
+	return w.Knob.Write(v)
+}
+
+func (w WithDischarge) ValueString(v int) string {
+	if v == ForceDischarge {
+		return w.Behaviour.ValueString(v)
+	}
+	return w.Knob.ValueString(v)
+}
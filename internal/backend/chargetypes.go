@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+func init() { Register(10, chargeTypes{}) }
+
+// chargeTypes is the standard power_supply charge_types attribute, switched
+// between Long_Life and Standard.
+type chargeTypes struct{}
+
+func (chargeTypes) Kind() Kind                 { return ChargeTypes }
+func (chargeTypes) Name() string               { return "charge_types" }
+func (chargeTypes) Capabilities() Capabilities { return Capabilities{} }
+
+func (chargeTypes) Detect(battery string) (string, error) {
+	if p := FindChargeTypesNode(battery); p != "" {
+		return p, nil
+	}
+	return "", fmt.Errorf("%s not found or without Long_Life", filepath.Join(powerSupplyDir, battery, "charge_types"))
+}
+
+func (chargeTypes) Read(n Node) (int, error) {
+	mode, err := ReadChargeType(n.Path)
+	if err != nil {
+		return 0, err
+	}
+	if mode == "Long_Life" {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (c chargeTypes) Write(n Node, v int) error { return WriteChargeType(n.Path, c.ValueString(n, v)) }
+
+func (chargeTypes) ValueString(_ Node, v int) string {
+	if v == 1 {
+		return "Long_Life"
+	}
+	return "Standard"
+}
+
+// FindChargeTypesNode checks if /sys/class/power_supply/<battery>/charge_types
+// exists and offers Long_Life. Returns the path if available, or "" if not.
+// Dell's charge_types (Standard, Adaptive, Custom...) has no Long_Life: there
+// the thresholds backend drives it through Custom instead.
+func FindChargeTypesNode(battery string) string {
+	p := filepath.Join(powerSupplyDir, battery, "charge_types")
+	if offersChargeType(p, "Long_Life") {
+		return p
+	}
+	return ""
+}
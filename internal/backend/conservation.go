@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() { Register(30, conservationMode{}) }
+
+// conservationMode is ideapad_acpi's vendor-specific conservation_mode, a
+// 0/1 attribute holding the battery at a fixed firmware level.
+type conservationMode struct{}
+
+func (conservationMode) Kind() Kind                 { return ConservationMode }
+func (conservationMode) Name() string               { return "conservation_mode" }
+func (conservationMode) Capabilities() Capabilities { return Capabilities{} }
+
+func (conservationMode) Detect(string) (string, error) { return FindConservationNode() }
+
+func (conservationMode) Read(n Node) (int, error) {
+	b, err := os.ReadFile(n.Path)
+	if err != nil {
+		return 0, err
+	}
+	if strings.TrimSpace(string(b)) == "1" {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (conservationMode) Write(n Node, v int) error { return writeFile(n.Path, strconv.Itoa(v)) }
+
+func (conservationMode) ValueString(_ Node, v int) string { return strconv.Itoa(v) }
+
+// FindConservationNode returns the conservation_mode attribute under
+// ideapad_acpi, preferring the shortest path when several devices match.
+func FindConservationNode() (string, error) {
+	candidates := []string{
+		filepath.Join(ideapadDir, "VPC2004:00", "conservation_mode"),
+	}
+	if matches, _ := filepath.Glob(filepath.Join(ideapadDir, "VPC????:??", "conservation_mode")); len(matches) > 0 {
+		candidates = append(candidates, matches...)
+	}
+	filepath.WalkDir(ideapadDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && filepath.Base(path) == "conservation_mode" {
+			candidates = append(candidates, path)
+		}
+		return nil
+	})
+	seen := make(map[string]struct{})
+	best := ""
+	for _, p := range candidates {
+		if p == "" {
+			continue
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		if st, err := os.Stat(p); err == nil && !st.IsDir() {
+			if best == "" || len(p) < len(best) {
+				best = p
+			}
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("conservation_mode not found under %s; ensure ideapad_laptop is loaded and the device exposes the knob", ideapadDir)
+	}
+	return best, nil
+}
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Container marker files, overridable in tests.
+var (
+	flatpakInfo  = "/.flatpak-info"
+	containerEnv = "/run/.containerenv" // podman
+	dockerEnv    = "/.dockerenv"
+)
+
+// Container returns the kind of container the process runs in: "flatpak",
+// "podman", "docker", or the $container value set by systemd-nspawn, LXC
+// and others. It returns "" on the host.
+func Container() string {
+	for _, m := range []struct{ path, kind string }{
+		{flatpakInfo, "flatpak"},
+		{containerEnv, "podman"},
+		{dockerEnv, "docker"},
+	} {
+		if _, err := os.Stat(m.path); err == nil {
+			return m.kind
+		}
+	}
+	return strings.TrimSpace(os.Getenv("container"))
+}
+
+// Writable reports whether the process may write to path. Containers
+// usually mount sysfs read-only, which makes this false even for root.
+func Writable(path string) bool {
+	const wOK = 2 // W_OK
+	return syscall.Access(path, wOK) == nil
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Framework laptops and Chromebooks on kernels without cros_charge-control
+// have no charge_control_end_threshold; their ChromeOS EC takes the limit
+// through ectool.
+var (
+	crosECDev     = "/dev/cros_ec"
+	ectoolPath    = "ectool"
+	dmiVendorPath = "/sys/class/dmi/id/sys_vendor"
+)
+
+func init() {
+	Register(40, frameworkEC{})
+	Register(50, crosECSustainer{})
+}
+
+// frameworkEC drives the Framework EC charge limit ("ectool fwchargelimit").
+type frameworkEC struct{}
+
+func (frameworkEC) Kind() Kind   { return FrameworkEC }
+func (frameworkEC) Name() string { return "framework_ec" }
+
+func (frameworkEC) Capabilities() Capabilities { return Capabilities{Percent: true, Storage: true} }
+
+func (frameworkEC) Detect(string) (string, error) {
+	if p := FindFrameworkEC(); p != "" {
+		return p, nil
+	}
+	return "", fmt.Errorf("%s or %s not found, or not a Framework laptop", crosECDev, ectoolPath)
+}
+
+func (frameworkEC) Read(n Node) (int, error) {
+	limit, err := readChargeLimit()
+	if err != nil {
+		return 0, err
+	}
+	if limit > 0 && limit < 100 {
+		return n.heldValue(limit), nil
+	}
+	return 0, nil
+}
+
+func (frameworkEC) Write(n Node, v int) error { return n.writeChargeLimit(v) }
+
+func (frameworkEC) ValueString(n Node, v int) string {
+	switch v {
+	case 1:
+		return fmt.Sprintf("fwchargelimit=%d", n.hold())
+	case Storage:
+		return fmt.Sprintf("fwchargelimit=%d", n.storageHold())
+	}
+	return "fwchargelimit=100"
+}
+
+// crosECSustainer drives the ChromeOS EC battery sustainer
+// ("ectool chargecontrol").
+type crosECSustainer struct{}
+
+func (crosECSustainer) Kind() Kind   { return CrosECSustainer }
+func (crosECSustainer) Name() string { return "cros_ec_sustainer" }
+
+func (crosECSustainer) Capabilities() Capabilities {
+	return Capabilities{Percent: true, Start: true}
+}
+
+func (crosECSustainer) Detect(string) (string, error) {
+	if p := FindCrosEC(); p != "" {
+		return p, nil
+	}
+	return "", fmt.Errorf("%s or %s not found", crosECDev, ectoolPath)
+}
+
+func (crosECSustainer) Read(Node) (int, error) {
+	on, err := readSustainer()
+	if err != nil || !on {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (crosECSustainer) Write(n Node, v int) error { return n.writeSustainer(v) }
+
+func (crosECSustainer) ValueString(n Node, v int) string {
+	if v == 1 {
+		return fmt.Sprintf("sustainer=%d-%d", n.start(), n.hold())
+	}
+	return "sustainer=off"
+}
+
+// findCrosEC returns the ChromeOS EC device when it exists and ectool is
+// installed, or "" otherwise.
+func findCrosEC() string {
+	if _, err := os.Stat(crosECDev); err != nil {
+		return ""
+	}
+	if _, err := exec.LookPath(ectoolPath); err != nil {
+		return ""
+	}
+	return crosECDev
+}
+
+// framework reports whether DMI names Framework as the vendor, whose EC
+// firmware has its own fwchargelimit command.
+func framework() bool {
+	b, err := os.ReadFile(dmiVendorPath)
+	return err == nil && strings.TrimSpace(string(b)) == "Framework"
+}
+
+// FindFrameworkEC returns the EC device of a Framework laptop, or "".
+func FindFrameworkEC() string {
+	if !framework() {
+		return ""
+	}
+	return findCrosEC()
+}
+
+// FindCrosEC returns the EC device of other ChromeOS EC machines
+// (Chromebooks), driven through the battery sustainer, or "".
+func FindCrosEC() string {
+	if framework() {
+		return ""
+	}
+	return findCrosEC()
+}
+
+var firstInt = regexp.MustCompile(`\d+`)
+
+// readChargeLimit runs "ectool fwchargelimit" and returns the limit it
+// reports.
+func readChargeLimit() (int, error) {
+	out, err := exec.Command(ectoolPath, "fwchargelimit").CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("ectool fwchargelimit: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	m := firstInt.Find(out)
+	if m == nil {
+		return 0, fmt.Errorf("cannot parse ectool fwchargelimit output: %q", strings.TrimSpace(string(out)))
+	}
+	return strconv.Atoi(string(m))
+}
+
+// writeChargeLimit sets the EC charge limit: the hold level while conserving,
+// the storage level in storage mode, 100 otherwise.
+func (n Node) writeChargeLimit(v int) error {
+	limit := 100
+	switch v {
+	case 1:
+		limit = n.hold()
+	case Storage:
+		limit = n.storageHold()
+	}
+	out, err := exec.Command(ectoolPath, "fwchargelimit", strconv.Itoa(limit)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ectool fwchargelimit %d: %v: %s", limit, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+var sustainerOn = regexp.MustCompile(`(?i)sustainer\s*=\s*on`)
+
+// readSustainer runs "ectool chargecontrol" and reports whether the battery
+// sustainer is on.
+func readSustainer() (bool, error) {
+	out, err := exec.Command(ectoolPath, "chargecontrol").CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("ectool chargecontrol: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return sustainerOn.Match(out), nil
+}
+
+// writeSustainer keeps the battery between the start and hold levels while
+// conserving ("chargecontrol normal <lower> <upper>") and turns the
+// sustainer off otherwise.
+func (n Node) writeSustainer(v int) error {
+	args := []string{"chargecontrol", "normal"}
+	if v == 1 {
+		args = append(args, strconv.Itoa(n.start()), strconv.Itoa(n.hold()))
+	}
+	out, err := exec.Command(ectoolPath, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ectool %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CurrentNode is a writable power_supply constant_charge_current_max
+// attribute, used to cap the charge current ("gentle charging").
+// The kernel expresses it in µA; this type works in mA.
+type CurrentNode struct {
+	Path    string
+	Default int // the driver's own limit, restored when no cap is set
+}
+
+// FindCurrentNode looks for a charge current limit on battery. ok is false
+// if the platform doesn't expose one. The default comes from
+// constant_charge_current_max_design where the driver has it: the value found
+// at startup may be a cap an earlier run left behind.
+func FindCurrentNode(battery string) (n CurrentNode, ok bool) {
+	p := filepath.Join(powerSupplyDir, battery, "constant_charge_current_max")
+	st, err := os.Stat(p)
+	if err != nil || st.IsDir() || st.Mode().Perm()&0o222 == 0 {
+		return CurrentNode{}, false
+	}
+	n = CurrentNode{Path: p}
+	if ua, err := readInt(p + "_design"); err == nil && ua > 0 {
+		n.Default = ua / 1000
+		return n, true
+	}
+	if n.Default, err = n.ReadMA(); err != nil {
+		return CurrentNode{}, false
+	}
+	return n, true
+}
+
+func (n CurrentNode) DefaultMA() int {
+	return n.Default
+}
+
+// ReadMA returns the current limit in mA.
+func (n CurrentNode) ReadMA() (int, error) {
+	b, err := os.ReadFile(n.Path)
+	if err != nil {
+		return 0, err
+	}
+	ua, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", n.Path, err)
+	}
+	return ua / 1000, nil
+}
+
+// WriteMA sets the current limit in mA.
+func (n CurrentNode) WriteMA(ma int) error {
+	if ma <= 0 {
+		return fmt.Errorf("invalid charge current %d mA", ma)
+	}
+	return writeFile(n.Path, strconv.Itoa(ma*1000))
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Locations probed by Diagnose, overridable in tests.
+var (
+	moduleDir      = "/sys/module"
+	acpiDevicesDir = "/sys/bus/acpi/devices"
+	secureBootVar  = "/sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+	lockdownPath   = "/sys/kernel/security/lockdown"
+	modinfoCmd     = "modinfo"
+	modprobeCmd    = "modprobe"
+)
+
+// Module is the kernel module providing conservation_mode.
+const Module = "ideapad_laptop"
+
+// LoadModule runs modprobe for Module.
+func LoadModule() error {
+	out, err := exec.Command(modprobeCmd, Module).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("modprobe %s: %v: %s", Module, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Diagnostic explains why no conservation node was found.
+type Diagnostic struct {
+	ModuleLoaded    bool
+	ModuleAvailable bool // modinfo knows the module
+	ACPIDevice      bool // a VPC2004 ideapad ACPI device exists
+	SecureBoot      bool
+	Lockdown        string // active kernel lockdown mode, "" if none
+	Surface         bool   // Microsoft Surface (surface_aggregator loaded)
+}
+
+// Diagnose probes the system for the usual reasons the knob is missing.
+func Diagnose() Diagnostic {
+	var d Diagnostic
+	if _, err := os.Stat(filepath.Join(moduleDir, Module)); err == nil {
+		d.ModuleLoaded = true
+	}
+	d.ModuleAvailable = d.ModuleLoaded || exec.Command(modinfoCmd, Module).Run() == nil
+	if _, err := os.Stat(filepath.Join(moduleDir, "surface_aggregator")); err == nil {
+		d.Surface = true
+	}
+	if m, _ := filepath.Glob(filepath.Join(acpiDevicesDir, "VPC2004:*")); len(m) > 0 {
+		d.ACPIDevice = true
+	}
+	// efivars: 4 attribute bytes, then the value
+	if b, err := os.ReadFile(secureBootVar); err == nil && len(b) >= 5 && b[4] == 1 {
+		d.SecureBoot = true
+	}
+	if b, err := os.ReadFile(lockdownPath); err == nil {
+		s := string(b)
+		if i, j := strings.Index(s, "["), strings.Index(s, "]"); i >= 0 && j > i && s[i+1:j] != "none" {
+			d.Lockdown = s[i+1 : j]
+		}
+	}
+	return d
+}
+
+func (d Diagnostic) String() string {
+	yn := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "  module %s loaded:    %s\n", Module, yn(d.ModuleLoaded))
+	fmt.Fprintf(&b, "  module available:          %s\n", yn(d.ModuleAvailable))
+	fmt.Fprintf(&b, "  ideapad ACPI device found: %s\n", yn(d.ACPIDevice))
+	fmt.Fprintf(&b, "  secure boot:               %s\n", yn(d.SecureBoot))
+	if d.Lockdown != "" {
+		fmt.Fprintf(&b, "  kernel lockdown:           %s\n", d.Lockdown)
+	}
+	if d.Surface {
+		fmt.Fprintf(&b, "  surface_aggregator:        yes\n")
+	}
+	for _, h := range d.Hints() {
+		fmt.Fprintf(&b, "hint: %s\n", h)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// Hints suggests fixes for what Diagnose found.
+func (d Diagnostic) Hints() []string {
+	var hints []string
+	if d.Surface {
+		// surface_battery reports the battery but has no charge_control_* attribute
+		hints = append(hints, "Microsoft Surface: the kernel exposes no charge limit for surface_aggregator batteries; enable \"Battery Limit\" in the Surface UEFI (caps at 50%); a charge_control_end_threshold is used automatically once the kernel provides one")
+		return hints
+	}
+	switch {
+	case !d.ACPIDevice:
+		hints = append(hints, "no VPC2004 ACPI device: this machine is probably not an IdeaPad/Yoga; check -list-backends for a standard charge_types/charge_control knob")
+	case !d.ModuleAvailable:
+		hints = append(hints, "the "+Module+" module is missing from this kernel: install your distribution's full kernel modules package")
+	case !d.ModuleLoaded:
+		hints = append(hints, "load the module with `modprobe "+Module+"` and check `dmesg` for errors")
+	default:
+		hints = append(hints, "the module is loaded but exposes no conservation_mode: the firmware may not support it; check `dmesg | grep ideapad`")
+	}
+	if d.SecureBoot && !d.ModuleLoaded && d.ModuleAvailable {
+		hints = append(hints, "secure boot is on: an out-of-tree (DKMS) "+Module+" must be signed with an enrolled MOK key to load")
+	}
+	return hints
+}
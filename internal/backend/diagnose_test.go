@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	root := t.TempDir()
+	oldMod, oldACPI, oldSB, oldLD, oldInfo := moduleDir, acpiDevicesDir, secureBootVar, lockdownPath, modinfoCmd
+	t.Cleanup(func() {
+		moduleDir, acpiDevicesDir, secureBootVar, lockdownPath, modinfoCmd = oldMod, oldACPI, oldSB, oldLD, oldInfo
+	})
+	moduleDir = filepath.Join(root, "module")
+	acpiDevicesDir = filepath.Join(root, "acpi")
+	secureBootVar = filepath.Join(root, "SecureBoot")
+	lockdownPath = filepath.Join(root, "lockdown")
+	modinfoCmd = "true"
+
+	d := Diagnose()
+	if d.ModuleLoaded || !d.ModuleAvailable || d.ACPIDevice || d.SecureBoot || d.Lockdown != "" {
+		t.Fatalf("empty system: %+v", d)
+	}
+	if h := d.Hints(); len(h) == 0 || !strings.Contains(h[0], "ACPI") {
+		t.Errorf("hints = %q", h)
+	}
+
+	writeNode(t, filepath.Join(acpiDevicesDir, "VPC2004:00", "status"), "15\n")
+	writeNode(t, secureBootVar, "\x06\x00\x00\x00\x01")
+	writeNode(t, lockdownPath, "none [integrity] confidentiality\n")
+	d = Diagnose()
+	if !d.ACPIDevice || !d.SecureBoot || d.Lockdown != "integrity" {
+		t.Fatalf("diagnostic = %+v", d)
+	}
+	h := d.Hints()
+	if len(h) != 2 || !strings.Contains(h[0], "modprobe") || !strings.Contains(h[1], "MOK") {
+		t.Errorf("hints = %q", h)
+	}
+
+	writeNode(t, filepath.Join(moduleDir, "surface_aggregator", "refcnt"), "1\n")
+	d = Diagnose()
+	if h := d.Hints(); !d.Surface || len(h) != 1 || !strings.Contains(h[0], "Battery Limit") {
+		t.Errorf("surface: %+v, hints = %q", d, h)
+	}
+}
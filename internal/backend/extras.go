@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExtraKnobs are the optional ideapad_acpi on/off attributes served over IPC
+// next to conservation mode.
+var ExtraKnobs = []string{
+	"rapid_charge", // faster charging, mutually exclusive with conservation
+	"usb_charging", // always-on USB charging while the lid is closed or off
+}
+
+// FindExtras returns the ExtraKnobs present on this machine, by name.
+func FindExtras() map[string]string {
+	found := make(map[string]string)
+	cons, err := FindConservationNode()
+	if err != nil {
+		return found
+	}
+	for _, name := range ExtraKnobs {
+		p := filepath.Join(filepath.Dir(cons), name)
+		if st, err := os.Stat(p); err == nil && !st.IsDir() {
+			found[name] = p
+		}
+	}
+	return found
+}
+
+// ReadFlag reads a 0/1 sysfs attribute.
+func ReadFlag(path string) (bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	switch strings.TrimSpace(string(b)) {
+	case "1":
+		return true, nil
+	case "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected value %q in %s", b, path)
+}
+
+// WriteFlag writes a 0/1 sysfs attribute.
+func WriteFlag(path string, on bool) error {
+	v := "0"
+	if on {
+		v = "1"
+	}
+	return writeFile(path, v)
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"errors"
+	"io/fs"
+	"sync"
+	"syscall"
+)
+
+// FailoverAfter is how many consecutive failures of the active knob make
+// Fallback move on to the next one. A knob that vanished fails over at once.
+var FailoverAfter = 3
+
+// Knob is a conservation setting: a Node, or a wrapper around one.
+type Knob interface {
+	Read() (int, error)
+	Write(v int) error
+	ValueString(v int) string
+}
+
+// Named is a knob in a Fallback chain, with the backend name it reports.
+type Named struct {
+	Name string
+	Knob Knob
+}
+
+// Fallback drives the first of Knobs and fails over to the next one when it
+// keeps failing, e.g. from conservation_mode to charge_control_end_threshold
+// when the ideapad node disappears. It never goes back.
+type Fallback struct {
+	Knobs []Named
+	// OnSwitch, if set, is called after failing over to to because of err.
+	OnSwitch func(to Named, err error)
+
+	mu       sync.Mutex
+	active   int
+	failures int
+}
+
+func (f *Fallback) current() Knob {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Knobs[f.active].Knob
+}
+
+// Active returns the knob currently driven.
+func (f *Fallback) Active() Named {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Knobs[f.active]
+}
+
+// fail records a failure of k and reports whether Fallback failed over.
+func (f *Fallback) fail(k Knob, err error) bool {
+	f.mu.Lock()
+	if f.Knobs[f.active].Knob != k {
+		// Another caller already failed over
+		f.mu.Unlock()
+		return true
+	}
+	f.failures++
+	gone := errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENODEV)
+	if f.active+1 >= len(f.Knobs) || !gone && f.failures < FailoverAfter {
+		f.mu.Unlock()
+		return false
+	}
+	f.active++
+	f.failures = 0
+	to := f.Knobs[f.active]
+	f.mu.Unlock()
+	if f.OnSwitch != nil {
+		f.OnSwitch(to, err)
+	}
+	return true
+}
+
+func (f *Fallback) ok() {
+	f.mu.Lock()
+	f.failures = 0
+	f.mu.Unlock()
+}
+
+func (f *Fallback) Read() (int, error) {
+	for {
+		k := f.current()
+		v, err := k.Read()
+		if err == nil {
+			f.ok()
+			return v, nil
+		}
+		if !f.fail(k, err) {
+			return 0, err
+		}
+	}
+}
+
+func (f *Fallback) Write(v int) error {
+	for {
+		k := f.current()
+		err := k.Write(v)
+		if err == nil {
+			f.ok()
+			return nil
+		}
+		if !f.fail(k, err) {
+			return err
+		}
+	}
+}
+
+func (f *Fallback) ValueString(v int) string { return f.current().ValueString(v) }
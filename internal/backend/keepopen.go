@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// KeepOpen opens the files at paths for writing and routes this package's
+// later writes to them through the open descriptors. Sysfs checks
+// permissions on open only, so the writes keep working once the daemon has
+// dropped root. Paths that don't exist are skipped; writes to any other
+// path go to the previous writer.
+func KeepOpen(paths []string) error {
+	files := map[string]*os.File{}
+	for _, p := range paths {
+		if _, ok := files[p]; ok {
+			continue
+		}
+		f, err := os.OpenFile(p, os.O_WRONLY, 0)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return fmt.Errorf("open %s: %w", p, err)
+		}
+		files[p] = f
+	}
+	next := writer
+	writer = func(path, value string) error {
+		f, ok := files[path]
+		if !ok {
+			return next(path, value)
+		}
+		// Every write to a sysfs attribute stores a whole value
+		if _, err := f.WriteAt([]byte(value+"\n"), 0); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// WritablePaths lists the sysfs files the daemon may write while driving
+// battery: every detected knob with its companion attributes, and the
+// charge-control attributes of every battery and extra ideapad knob. The
+// EC backends run ectool instead and are left out.
+func WritablePaths(battery string) []string {
+	var paths []string
+	for _, d := range DetectAll(battery) {
+		if d.Err != nil || d.Kind == FrameworkEC || d.Kind == CrosECSustainer {
+			continue
+		}
+		paths = append(paths, d.Path)
+		if d.Kind == ChargeThresholds {
+			n := Node{Path: d.Path}
+			paths = append(paths, n.startPath(), filepath.Join(filepath.Dir(d.Path), "charge_types"))
+		}
+	}
+	for _, b := range ListBatteries() {
+		for _, attr := range []string{"charge_control_end_threshold", "charge_control_start_threshold", "charge_behaviour", "charge_types", "constant_charge_current_max"} {
+			paths = append(paths, filepath.Join(powerSupplyDir, b.Name, attr))
+		}
+	}
+	for _, p := range FindExtras() {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// msiECDir is the msi-ec platform device, overridable in tests.
+var msiECDir = "/sys/devices/platform/msi-ec"
+
+// MSI battery modes, as written to battery_mode. The firmware stops charging
+// around 60% in best (mobility), 80% in balanced and 100% in max.
+const (
+	msiBest     = "best"
+	msiBalanced = "balanced"
+	msiMax      = "max"
+)
+
+func init() { Register(60, msiBatteryMode{}) }
+
+// msiBatteryMode drives msi-ec's battery_mode presets.
+type msiBatteryMode struct{}
+
+func (msiBatteryMode) Kind() Kind                 { return MSIBatteryMode }
+func (msiBatteryMode) Name() string               { return "msi_battery_mode" }
+func (msiBatteryMode) Capabilities() Capabilities { return Capabilities{} }
+
+func (msiBatteryMode) Detect(string) (string, error) {
+	if p := FindMSIBatteryMode(); p != "" {
+		return p, nil
+	}
+	return "", fmt.Errorf("%s not found", filepath.Join(msiECDir, "battery_mode"))
+}
+
+func (msiBatteryMode) Read(n Node) (int, error) {
+	mode, err := readMSIMode(n.Path)
+	if err != nil || mode == msiMax {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (msiBatteryMode) Write(n Node, v int) error { return writeFile(n.Path, n.msiMode(v)) }
+
+func (msiBatteryMode) ValueString(n Node, v int) string { return n.msiMode(v) }
+
+// FindMSIBatteryMode returns the path of msi-ec's battery_mode, or "" if
+// absent.
+func FindMSIBatteryMode() string {
+	p := filepath.Join(msiECDir, "battery_mode")
+	if st, err := os.Stat(p); err == nil && !st.IsDir() {
+		return p
+	}
+	return ""
+}
+
+// msiMode maps the conservation knob onto a battery mode: best for holds up
+// to 60, balanced above, max when off. A hold above 80 still gets balanced,
+// as max doesn't conserve at all and Read would take it for off.
+func (n Node) msiMode(v int) string {
+	switch {
+	case v != 1:
+		return msiMax
+	case n.hold() <= 60:
+		return msiBest
+	}
+	return msiBalanced
+}
+
+// readMSIMode returns the active battery mode, accepting both a bare mode
+// and the bracketed list format.
+func readMSIMode(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(string(b), "[") {
+		return ReadChargeType(path)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
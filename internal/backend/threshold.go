@@ -0,0 +1,301 @@
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"conservationDaemon/internal/logging"
+)
+
+// DefaultHold is the end threshold written while conserving when Node.Hold
+// is unset.
+const DefaultHold = 80
+
+// DefaultStorageHold is the end threshold written in storage mode when
+// Node.StorageHold is unset.
+const DefaultStorageHold = 55
+
+// DefaultStartGap is how far below the end threshold charging resumes while
+// conserving, when Node.Start is unset.
+const DefaultStartGap = 5
+
+func init() { Register(20, chargeThresholds{}) }
+
+// chargeThresholds drives the charge_control start/end thresholds (and their
+// tp_smapi equivalents): conserving holds at Node.Hold, storage mode at
+// Node.StorageHold.
+type chargeThresholds struct{}
+
+func (chargeThresholds) Kind() Kind   { return ChargeThresholds }
+func (chargeThresholds) Name() string { return "charge_thresholds" }
+
+func (chargeThresholds) Capabilities() Capabilities {
+	return Capabilities{Percent: true, Start: true, Storage: true}
+}
+
+func (chargeThresholds) Detect(battery string) (string, error) {
+	if p := FindThresholdNode(battery); p != "" {
+		return p, nil
+	}
+	return "", fmt.Errorf("%s not found", filepath.Join(powerSupplyDir, battery, "charge_control_end_threshold"))
+}
+
+func (chargeThresholds) Read(n Node) (int, error) {
+	end, err := readInt(n.Path)
+	if err != nil {
+		return 0, err
+	}
+	if mp := n.customModePath(); mp != "" {
+		// Dell: the thresholds only apply in Custom mode
+		if mode, err := ReadChargeType(mp); err != nil || mode != "Custom" {
+			return 0, err
+		}
+	}
+	// tp_smapi reports 0 for the firmware default, i.e. no limit
+	if end > 0 && end < 100 {
+		return n.heldValue(end), nil
+	}
+	// Holding at 100 differs from off (start 99) only in the start threshold
+	if end == 100 && n.hold() == 100 {
+		if start, err := readInt(n.startPath()); err == nil && start == n.start() && start != 99 {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (chargeThresholds) Level(n Node) (int, error) {
+	end, err := readInt(n.Path)
+	if err != nil {
+		return 0, err
+	}
+	if mp := n.customModePath(); mp != "" {
+		if mode, err := ReadChargeType(mp); err != nil || mode != "Custom" {
+			return 100, err
+		}
+	}
+	if end <= 0 || end > 100 {
+		return 100, nil
+	}
+	return end, nil
+}
+
+func (chargeThresholds) Write(n Node, v int) error { return n.writeThresholds(v) }
+
+func (chargeThresholds) ValueString(n Node, v int) string {
+	switch v {
+	case 1:
+		return fmt.Sprintf("start=%d end=%d", n.start(), n.hold())
+	case Storage:
+		return fmt.Sprintf("start=%d end=%d", n.storageStart(), n.storageHold())
+	}
+	if n.customModePath() != "" {
+		return "Standard"
+	}
+	return "end=100"
+}
+
+// FindThresholdNode returns the path of
+// /sys/class/power_supply/<battery>/charge_control_end_threshold or, on
+// ThinkPads with the legacy tp_smapi driver,
+// /sys/devices/platform/smapi/<battery>/stop_charge_thresh. It returns ""
+// if neither exists.
+func FindThresholdNode(battery string) string {
+	for _, p := range []string{
+		filepath.Join(powerSupplyDir, battery, "charge_control_end_threshold"),
+		filepath.Join(smapiDir, battery, "stop_charge_thresh"),
+	} {
+		if st, err := os.Stat(p); err == nil && !st.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
+
+// startPath returns the start threshold next to the end threshold n.Path.
+func (n Node) startPath() string {
+	if filepath.Base(n.Path) == "stop_charge_thresh" {
+		return filepath.Join(filepath.Dir(n.Path), "start_charge_thresh")
+	}
+	return filepath.Join(filepath.Dir(n.Path), "charge_control_start_threshold")
+}
+
+// customModePath returns the charge_types node next to n.Path when it offers
+// a Custom mode, as on Dell laptops (dell-laptop via dell-smbios), where the
+// start/stop thresholds only take effect in that mode. It returns "" otherwise.
+func (n Node) customModePath() string {
+	p := filepath.Join(filepath.Dir(n.Path), "charge_types")
+	if offersChargeType(p, "Custom") {
+		return p
+	}
+	return ""
+}
+
+func (n Node) hold() int {
+	h := n.Hold
+	if n.Levels != nil {
+		if lh, _ := n.Levels.get(); lh > 0 {
+			h = lh
+		}
+	}
+	if h <= 0 || h > 100 {
+		return DefaultHold
+	}
+	return h
+}
+
+func (n Node) storageHold() int {
+	if n.StorageHold <= 0 || n.StorageHold > 100 {
+		return DefaultStorageHold
+	}
+	return n.StorageHold
+}
+
+func (n Node) storageStart() int {
+	return max(n.storageHold()-DefaultStartGap, 0)
+}
+
+// heldValue returns the knob value a percentage backend holding at end
+// reports: Storage at the storage level, unless it is the hold level too.
+func (n Node) heldValue(end int) int {
+	if end == n.storageHold() && end != n.hold() {
+		return Storage
+	}
+	return 1
+}
+
+func (n Node) start() int {
+	end := n.hold()
+	s := n.Start
+	if n.Levels != nil {
+		if lh, ls := n.Levels.get(); lh > 0 {
+			s = ls
+		}
+	}
+	if s > 0 && s < end {
+		return s
+	}
+	return max(end-DefaultStartGap, 0)
+}
+
+// writeThresholds maps the conservation knob onto the firmware's charge
+// thresholds. On writes both: charging stops at the hold level and resumes
+// only below the start level, with no cycling by the daemon. Storage does
+// the same at the storage level. Off lets the
+// battery charge to 100% right away (start 99). The writes are ordered so
+// start < end holds at every step; drivers without a start threshold only
+// get the end one. On Dell, on selects the Custom charge mode after writing
+// the thresholds and off returns to Standard, leaving them in place.
+func (n Node) writeThresholds(v int) error {
+	mp := n.customModePath()
+	if mp != "" && v == 0 {
+		return WriteChargeType(mp, "Standard")
+	}
+	end, start := 100, 99
+	switch v {
+	case 1:
+		end, start = n.hold(), n.start()
+	case Storage:
+		end, start = n.storageHold(), n.storageStart()
+	}
+	if err := n.writePair(end, start); err != nil || mp == "" {
+		return err
+	}
+	return WriteChargeType(mp, "Custom")
+}
+
+// writePair writes the end and start thresholds in an order that keeps
+// start below end.
+func (n Node) writePair(end, start int) error {
+	startPath := n.startPath()
+	cur, err := readInt(startPath)
+	if err != nil {
+		return writeFile(n.Path, strconv.Itoa(end))
+	}
+	if start < cur {
+		// Lowering: start first
+		if err := writeFile(startPath, strconv.Itoa(start)); err != nil {
+			return err
+		}
+		return writeFile(n.Path, strconv.Itoa(end))
+	}
+	if err := writeFile(n.Path, strconv.Itoa(end)); err != nil {
+		return err
+	}
+	if start == cur {
+		return nil
+	}
+	return writeFile(startPath, strconv.Itoa(start))
+}
+
+func readInt(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// Counterpart returns the other knob of a machine exposing both
+// charge_control thresholds and conservation_mode, if n is one of them.
+func Counterpart(n Node, battery string) (Node, bool) {
+	switch n.Kind {
+	case ChargeThresholds:
+		if p, err := FindConservationNode(); err == nil {
+			return Node{Path: p, Kind: ConservationMode}, true
+		}
+	case ConservationMode:
+		if p := FindThresholdNode(battery); p != "" {
+			return Node{Path: p, Kind: ChargeThresholds, Hold: n.Hold, StorageHold: n.StorageHold, Levels: n.Levels}, true
+		}
+	}
+	return Node{}, false
+}
+
+// Linked drives Primary and keeps Secondary off, so two knobs acting on the
+// same battery never fight (e.g. conservation_mode capping at 60% below a
+// threshold of 80%).
+type Linked struct {
+	Primary, Secondary Node
+}
+
+// Order returns a and b as (primary, secondary) according to prefer, the
+// name of the preferred kind.
+func Order(a, b Node, prefer string) (Node, Node) {
+	if b.Kind.String() == prefer {
+		return b, a
+	}
+	return a, b
+}
+
+func (l Linked) Read() (int, error)       { return l.Primary.Read() }
+func (l Linked) ValueString(v int) string { return l.Primary.ValueString(v) }
+
+func (l Linked) Write(v int) error {
+	if err := l.Primary.Write(v); err != nil {
+		return err
+	}
+	return l.Neutralize()
+}
+
+// Neutralize turns Secondary off if it isn't already.
+func (l Linked) Neutralize() error {
+	cur, err := l.Secondary.Read()
+	if err != nil || cur == 0 {
+		return err
+	}
+	if err := l.Secondary.Write(0); err != nil {
+		return fmt.Errorf("turn off secondary %s: %w", l.Secondary.Kind, err)
+	}
+	logging.Logf("turned off secondary %s knob %s", l.Secondary.Kind, l.Secondary.Path)
+	return nil
+}
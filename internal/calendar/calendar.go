@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: MIT
+
+// Package calendar reads iCalendar (.ics) files to find upcoming trips.
+package calendar
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a calendar entry with a known start and end.
+type Event struct {
+	Summary    string
+	Start, End time.Time
+}
+
+// Parse reads the VEVENTs of an iCalendar stream. Recurring events are
+// only considered at their first occurrence.
+func Parse(r io.Reader) ([]Event, error) {
+	var (
+		events []Event
+		cur    *Event
+		allDay bool
+	)
+	for _, line := range unfold(r) {
+		name, params, value := splitLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			cur, allDay = &Event{}, false
+		case name == "END" && value == "VEVENT":
+			if cur != nil && !cur.Start.IsZero() {
+				if cur.End.IsZero() {
+					cur.End = cur.Start
+					if allDay {
+						cur.End = cur.Start.AddDate(0, 0, 1)
+					}
+				}
+				events = append(events, *cur)
+			}
+			cur = nil
+		case cur == nil:
+		case name == "SUMMARY":
+			cur.Summary = unescape(value)
+		case name == "DTSTART", name == "DTEND":
+			t, date, err := parseTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if name == "DTSTART" {
+				cur.Start, allDay = t, date
+			} else {
+				cur.End = t
+			}
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events, nil
+}
+
+// unfold joins RFC 5545 continuation lines (starting with a space or tab).
+func unfold(r io.Reader) []string {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		l := strings.TrimRight(sc.Text(), "\r")
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// splitLine splits "NAME;PARAM=V:value" into its parts.
+func splitLine(line string) (name string, params map[string]string, value string) {
+	head, value, _ := strings.Cut(line, ":")
+	parts := strings.Split(head, ";")
+	params = make(map[string]string)
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, value
+}
+
+func parseTime(value string, params map[string]string) (t time.Time, date bool, err error) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err = time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	loc := time.Local
+	if tz := params["TZID"]; tz != "" {
+		if l, lerr := time.LoadLocation(tz); lerr == nil {
+			loc = l
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+func unescape(s string) string {
+	return strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(s)
+}
+
+// Load reads a calendar from a local path or an http(s) URL.
+func Load(ctx context.Context, src string) ([]Event, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return Parse(f)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", src, resp.Status)
+	}
+	return Parse(resp.Body)
+}
+
+// Trips keeps the tagged events of a periodically reloaded calendar.
+type Trips struct {
+	Source string
+	Tag    string // case-insensitive summary marker, e.g. "[travel]"
+
+	mu     sync.Mutex
+	events []Event
+}
+
+// Refresh reloads the calendar, keeping the previous events on error.
+func (t *Trips) Refresh(ctx context.Context) error {
+	all, err := Load(ctx, t.Source)
+	if err != nil {
+		return err
+	}
+	tag := strings.ToLower(t.Tag)
+	var trips []Event
+	for _, e := range all {
+		if strings.Contains(strings.ToLower(e.Summary), tag) {
+			trips = append(trips, e)
+		}
+	}
+	t.mu.Lock()
+	t.events = trips
+	t.mu.Unlock()
+	return nil
+}
+
+// Next returns the trip in progress at now, or else the first one starting
+// within lookahead.
+func (t *Trips) Next(now time.Time, lookahead time.Duration) (Event, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range t.events {
+		if now.Before(e.End) && e.Start.Before(now.Add(lookahead)) {
+			return e, true
+		}
+	}
+	return Event{}, false
+}
@@ -0,0 +1,68 @@
+package calendar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sample = "BEGIN:VCALENDAR\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Flight to Rome [travel]\r\n" +
+	"DTSTART:20250310T090000Z\r\n" +
+	"DTEND:20250310T110000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Team meeting\\, weekly\r\n" +
+	"DTSTART;TZID=Europe/Rome:20250309T140000\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Conference in Milan\r\n" +
+	"  [TRAVEL]\r\n" +
+	"DTSTART;VALUE=DATE:20250312\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events", len(events))
+	}
+	if events[0].Summary != "Team meeting, weekly" {
+		t.Errorf("summary = %q", events[0].Summary)
+	}
+	if !events[1].Start.Equal(time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("start = %v", events[1].Start)
+	}
+	if events[2].Summary != "Conference in Milan [TRAVEL]" || events[2].End.Sub(events[2].Start) != 24*time.Hour {
+		t.Errorf("all-day event = %+v", events[2])
+	}
+}
+
+func TestTripsNext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cal.ics")
+	if err := os.WriteFile(path, []byte(sample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	trips := &Trips{Source: path, Tag: "[travel]"}
+	if err := trips.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	if e, ok := trips.Next(now, 12*time.Hour); !ok || !strings.HasPrefix(e.Summary, "Flight") {
+		t.Errorf("Next = %+v, %t", e, ok)
+	}
+	if _, ok := trips.Next(now, 6*time.Hour); ok {
+		t.Error("trip outside the lookahead returned")
+	}
+	if _, ok := trips.Next(now.Add(10*time.Hour), time.Hour); !ok {
+		t.Error("trip in progress not returned")
+	}
+}
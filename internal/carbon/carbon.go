@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: MIT
+
+// Package carbon fetches grid carbon-intensity forecasts to find low-carbon
+// periods for charging.
+package carbon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Period is a forecast interval with its carbon intensity in gCO2/kWh.
+type Period struct {
+	From, To  time.Time
+	Intensity float64
+}
+
+// Provider returns the carbon-intensity forecast from now on.
+type Provider interface {
+	Forecast(ctx context.Context, now time.Time) ([]Period, error)
+}
+
+// API endpoints, overridable in tests.
+var (
+	ukGridURL          = "https://api.carbonintensity.org.uk"
+	electricityMapsURL = "https://api.electricitymap.org"
+)
+
+// Providers lists the names accepted by New.
+var Providers = []string{"uk", "electricitymaps"}
+
+// New returns the named provider. region is the UK Carbon Intensity region
+// id ("" for the national forecast) or the Electricity Maps zone, e.g. "DE".
+func New(name, region, token string) (Provider, error) {
+	switch name {
+	case "uk":
+		return UKGrid{Region: region}, nil
+	case "electricitymaps":
+		if region == "" || token == "" {
+			return nil, fmt.Errorf("electricitymaps needs a zone and an API token")
+		}
+		return ElectricityMaps{Zone: region, Token: token}, nil
+	default:
+		return nil, fmt.Errorf("unknown carbon provider %q (want uk or electricitymaps)", name)
+	}
+}
+
+// UKGrid is the National Grid ESO Carbon Intensity API (Great Britain). It
+// needs no API key.
+type UKGrid struct {
+	Region string // region id, 1..17; national forecast if empty
+}
+
+type ukPeriod struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Intensity struct {
+		Forecast float64 `json:"forecast"`
+	} `json:"intensity"`
+}
+
+// Forecast returns the next 24 hours in 30-minute periods.
+func (u UKGrid) Forecast(ctx context.Context, now time.Time) ([]Period, error) {
+	from := now.UTC().Format("2006-01-02T15:04Z")
+	var raw []ukPeriod
+	if u.Region == "" {
+		var doc struct {
+			Data []ukPeriod `json:"data"`
+		}
+		if err := getJSON(ctx, ukGridURL+"/intensity/"+from+"/fw24h", nil, &doc); err != nil {
+			return nil, err
+		}
+		raw = doc.Data
+	} else {
+		var doc struct {
+			Data struct {
+				Data []ukPeriod `json:"data"`
+			} `json:"data"`
+		}
+		if err := getJSON(ctx, ukGridURL+"/regional/intensity/"+from+"/fw24h/regionid/"+u.Region, nil, &doc); err != nil {
+			return nil, err
+		}
+		raw = doc.Data.Data
+	}
+	periods := make([]Period, 0, len(raw))
+	for _, p := range raw {
+		f, err1 := time.Parse("2006-01-02T15:04Z", p.From)
+		t, err2 := time.Parse("2006-01-02T15:04Z", p.To)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("carbon forecast: bad period %s..%s", p.From, p.To)
+		}
+		periods = append(periods, Period{From: f, To: t, Intensity: p.Intensity.Forecast})
+	}
+	return periods, nil
+}
+
+// ElectricityMaps is the Electricity Maps API, which covers most grids but
+// needs an API token.
+type ElectricityMaps struct {
+	Zone  string // e.g. "DE", "IT-NO"
+	Token string
+}
+
+// Forecast returns the hourly forecast.
+func (e ElectricityMaps) Forecast(ctx context.Context, _ time.Time) ([]Period, error) {
+	var doc struct {
+		Forecast []struct {
+			CarbonIntensity float64   `json:"carbonIntensity"`
+			Datetime        time.Time `json:"datetime"`
+		} `json:"forecast"`
+	}
+	url := electricityMapsURL + "/v3/carbon-intensity/forecast?zone=" + e.Zone
+	if err := getJSON(ctx, url, map[string]string{"auth-token": e.Token}, &doc); err != nil {
+		return nil, err
+	}
+	periods := make([]Period, 0, len(doc.Forecast))
+	for _, f := range doc.Forecast {
+		periods = append(periods, Period{From: f.Datetime, To: f.Datetime.Add(time.Hour), Intensity: f.CarbonIntensity})
+	}
+	return periods, nil
+}
+
+func getJSON(ctx context.Context, url string, header map[string]string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, val := range header {
+		req.Header.Set(k, val)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("carbon forecast: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Low returns the greenest third of the forecast periods that start before
+// until, in time order.
+func Low(periods []Period, now, until time.Time) []Period {
+	var ahead []Period
+	for _, p := range periods {
+		if p.To.After(now) && p.From.Before(until) {
+			ahead = append(ahead, p)
+		}
+	}
+	if len(ahead) == 0 {
+		return nil
+	}
+	sorted := append([]Period(nil), ahead...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Intensity < sorted[j].Intensity })
+	limit := sorted[(len(sorted)-1)/3].Intensity
+
+	var low []Period
+	for _, p := range ahead {
+		if p.Intensity <= limit {
+			low = append(low, p)
+		}
+	}
+	return low
+}
+
+// Forecaster keeps the latest forecast of a periodically refreshed provider.
+type Forecaster struct {
+	Provider Provider
+
+	mu      sync.Mutex
+	periods []Period
+}
+
+// Refresh fetches a new forecast, keeping the previous one on error.
+func (f *Forecaster) Refresh(ctx context.Context) error {
+	periods, err := f.Provider.Forecast(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.periods = periods
+	f.mu.Unlock()
+	return nil
+}
+
+// Low returns the low-carbon periods between now and until.
+func (f *Forecaster) Low(now, until time.Time) []Period {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return Low(f.periods, now, until)
+}
@@ -0,0 +1,74 @@
+package carbon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUKGridForecast(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/regional/intensity/2024-03-05T22:00Z/fw24h/regionid/13" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":{"regionid":13,"data":[
+			{"from":"2024-03-05T22:00Z","to":"2024-03-05T22:30Z","intensity":{"forecast":180,"index":"moderate"}},
+			{"from":"2024-03-05T22:30Z","to":"2024-03-05T23:00Z","intensity":{"forecast":90,"index":"low"}}]}}`))
+	}))
+	defer srv.Close()
+	old := ukGridURL
+	ukGridURL = srv.URL
+	defer func() { ukGridURL = old }()
+
+	now := time.Date(2024, 3, 5, 22, 0, 0, 0, time.UTC)
+	periods, err := UKGrid{Region: "13"}.Forecast(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(periods) != 2 || periods[1].Intensity != 90 || !periods[1].From.Equal(now.Add(30*time.Minute)) {
+		t.Errorf("periods = %+v", periods)
+	}
+}
+
+func TestElectricityMapsForecast(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("auth-token") != "secret" || r.URL.Query().Get("zone") != "DE" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"zone":"DE","forecast":[{"carbonIntensity":326,"datetime":"2024-03-05T22:00:00.000Z"}]}`))
+	}))
+	defer srv.Close()
+	old := electricityMapsURL
+	electricityMapsURL = srv.URL
+	defer func() { electricityMapsURL = old }()
+
+	periods, err := ElectricityMaps{Zone: "DE", Token: "secret"}.Forecast(context.Background(), time.Now())
+	if err != nil || len(periods) != 1 || periods[0].Intensity != 326 || periods[0].To.Sub(periods[0].From) != time.Hour {
+		t.Errorf("Forecast = %+v, %v", periods, err)
+	}
+	if _, err := (ElectricityMaps{Zone: "DE", Token: "wrong"}).Forecast(context.Background(), time.Now()); err == nil {
+		t.Error("expected error with a bad token")
+	}
+}
+
+func TestLow(t *testing.T) {
+	start := time.Date(2024, 3, 5, 22, 0, 0, 0, time.UTC)
+	var periods []Period
+	for i, g := range []float64{200, 100, 50, 300, 120, 80} {
+		from := start.Add(time.Duration(i) * time.Hour)
+		periods = append(periods, Period{From: from, To: from.Add(time.Hour), Intensity: g})
+	}
+	low := Low(periods, start, start.Add(6*time.Hour))
+	if len(low) != 2 || low[0].Intensity != 50 || low[1].Intensity != 80 {
+		t.Errorf("Low = %+v", low)
+	}
+	// Only periods before the deadline count
+	low = Low(periods, start, start.Add(2*time.Hour))
+	if len(low) != 1 || low[0].Intensity != 100 {
+		t.Errorf("Low before deadline = %+v", low)
+	}
+}
@@ -0,0 +1,606 @@
+// SPDX-License-Identifier: MIT
+
+// Package config holds the daemon configuration and its on-disk state.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lowest conservation threshold accepted. Conservation-mode firmware holds
+// at a fixed level, so only backends with real percentage thresholds can go
+// below ThresholdFloor, and only with Config.LowThresholds.
+const (
+	ThresholdFloor    = 50
+	LowThresholdFloor = 20
+)
+
+// MinThreshold returns the lowest conservation threshold this
+// configuration accepts.
+func (c Config) MinThreshold() float64 {
+	if c.LowThresholds {
+		return LowThresholdFloor
+	}
+	return ThresholdFloor
+}
+
+type Config struct {
+	MaxPercent            float64
+	ConservationThreshold float64
+	SafetyFloor           float64 // always allow charging below this percentage
+	LowThresholds         bool    // opt-in: allow thresholds down to LowThresholdFloor (percentage backends only)
+	StartThreshold        float64 // charge_thresholds: resume charging below this while conserving; 0 = 5 below the threshold
+	BackendFallback       bool    // fail over to the next detected backend when the active one keeps failing
+	RapidChargeConflict   string  // rapid_charge on when conserving: "disable" (turn it off), "refuse" (keep conservation off) or "ignore"
+	PollInterval          time.Duration
+	DryRun                bool
+	Once                  bool
+	EventsJSON            bool          // JSON event stream on stdout
+	LogLevel              string        // lowest level logged: "debug", "info", "warn" or "error"
+	LogFile               string        // log to this file instead of stdout or the journal
+	LogMaxSizeMB          int           // rotate LogFile once it would grow past this; 0 never
+	LogRotateEvery        time.Duration // also rotate LogFile every period; 0 never
+	LogKeep               int           // rotated log files kept
+	Auto                  bool
+	Storage               bool              // storage mode: hold at StorageLevel, whatever max, schedule or auto say
+	StorageLevel          float64           // storage mode target, below the conservation threshold
+	LowPower              bool              // stop polling on battery once settled; rely on events
+	ExternalPolicy        string            // knob changed by another tool (e.g. PowerDevil): "enforce", "adopt" or "ask"
+	OnExit                string            // knob when the daemon stops: "keep" (as last set), "on" or "off"
+	ChargeCurrentMA       int               // cap charge current in mA ("gentle charging"); 0 = platform default
+	Backend               string            // forced backend name; auto-detect if empty
+	KnobPrecedence        string            // "charge_thresholds" or "conservation_mode" when both exist
+	Modprobe              bool              // try loading ideapad_laptop if no knob is found
+	HelperSock            string            // host helper socket for read-only sysfs (containers)
+	BatterySource         string            // "upower", "sysfs" or "auto"
+	Hotkey                string            // input device of the conservation key; disabled if empty
+	PlatformProfiles      map[string]string // mode ("conserve", "charge", "trip", "away") -> platform_profile
+	BatteryThresholds     map[string]int    // multi-battery: battery name -> end threshold
+	ChargeFirst           string            // multi-battery: battery charged before the others
+	HotkeyCode            int
+	SysfsPath             string // explicit conservation_mode path (legacy)
+	BatteryName           string // e.g. "BAT0"; used for charge_types lookup
+
+	// Control socket
+	SockPath       string
+	SockGroup      string
+	AdminGroup     string  // if set, only root and this group may change settings over the socket
+	StatusSockPath string  // read-only socket anyone may connect to; "" for none
+	HTTPAddr       string  // JSON HTTP API address; "" for none
+	HTTPTokenFile  string  // bearer token allowing changes over HTTP; "" keeps it read-only
+	MaxConns       int     // concurrent connection handlers
+	RateLimit      float64 // socket requests per second per client uid; 0 for no limit
+	DBus           bool    // also serve the org.conservationd.Manager API on the system bus
+
+	// Time-based charging
+	TargetTime   *time.Time
+	LevelReached bool     // true when target percentage has been reached
+	OffPeak      []Window // cheap-rate windows for charging above the threshold
+	ChargeWindow []Window // if set, the only hours charging happens at all (opt-in)
+	LowCarbon    []Period // low-carbon periods, refreshed every step (not persisted)
+
+	// Script printing a tariff document with more cheap-rate windows, run
+	// every TariffRefresh; disabled if empty
+	TariffCommand string
+	TariffRefresh time.Duration
+
+	// Recurring rules setting the target, e.g. "mon-fri 07:00 full", and
+	// when one last fired (persisted): manual changes hold until the next
+	Schedule      []Rule
+	ScheduleFired *time.Time
+
+	// Carbon-aware charging: charge above the threshold in low-carbon
+	// periods, by CarbonDeadline (time of day) at the latest
+	Carbon         string // forecast provider, "uk" or "electricitymaps"; disabled if empty
+	CarbonRegion   string // provider region or zone
+	CarbonToken    string
+	CarbonDeadline time.Duration
+	CarbonRefresh  time.Duration
+
+	// State file, and the settings as the flags and the configuration file
+	// set them: the state file only keeps those changed since
+	StatePath  string
+	Configured Settings
+
+	// User to run as once started; stays root if empty
+	User string
+
+	// Per-user policies, keyed by uid, used when MultiUser is set. Treat
+	// the map as immutable: replace it instead of modifying it in place.
+	MultiUser    bool
+	UserPolicies map[uint32]UserPolicy
+
+	// Location profiles (GeoClue, opt-in): away from every place, charge to
+	// AwayMax instead, capping the charge current at AwayChargeCurrentMA if
+	// set.
+	Places              []Place
+	AwayMax             float64
+	AwayChargeCurrentMA int
+
+	// Calendar trips: before events tagged CalendarTag, charge to TripMax
+	Calendar          string // .ics path or http(s) URL
+	CalendarTag       string
+	CalendarLookahead time.Duration
+	CalendarRefresh   time.Duration
+	TripMax           float64
+
+	// Health-adaptive max: cap the target at HealthMax once full capacity
+	// drops below HealthBelow percent of design capacity
+	HealthAdaptive bool
+	HealthBelow    float64
+	HealthMax      float64
+
+	// Temperature rule: cap the target at TempMax while the battery stays
+	// above TempLimit (°C, 0 disables) for TempSustain
+	TempLimit   float64
+	TempSustain time.Duration
+	TempMax     float64
+
+	// Opt-in anonymous health telemetry; disabled if Telemetry is empty
+	Telemetry         string // report endpoint URL
+	TelemetryInterval time.Duration
+	TelemetrySent     *time.Time // last report, or when telemetry was enabled (persisted)
+
+	// node_exporter textfile collector output; disabled if empty
+	PromTextfile string
+	PromInterval time.Duration
+
+	// Calibration scheduling: the next calibration (persisted), how often
+	// to schedule one (0 = only on request) and the results so far
+	CalibrateAt    *time.Time
+	CalibrateEvery time.Duration
+	Calibrations   []CalibrationRecord
+
+	// Threshold preset in use ("" for the thresholds given by flags), and
+	// whether the knob holds at a set percentage so presets can change the
+	// thresholds (read-only, detected at startup)
+	Preset      string
+	PercentKnob bool
+
+	// Hardware quirk profile detected at startup (read-only)
+	Quirks string
+}
+
+// Preset is a named pair of thresholds: conserving stops charging at End
+// and resumes below Start.
+type Preset struct {
+	Name  string  `json:"name"`  // as accepted by clients, e.g. "balanced"
+	Title string  `json:"title"` // for menus, e.g. "Balanced"
+	End   float64 `json:"end"`
+	Start float64 `json:"start"`
+}
+
+// Presets are the built-in threshold presets.
+var Presets = []Preset{
+	{Name: "lifespan", Title: "Max lifespan", End: 60, Start: 55},
+	{Name: "balanced", Title: "Balanced", End: 80, Start: 75},
+	{Name: "full", Title: "Full charge", End: 100, Start: 95},
+}
+
+// FindPreset returns the preset called name, matching its name or title
+// regardless of case.
+func FindPreset(name string) (Preset, bool) {
+	for _, p := range Presets {
+		if strings.EqualFold(name, p.Name) || strings.EqualFold(name, p.Title) {
+			return p, true
+		}
+	}
+	return Preset{}, false
+}
+
+// ApplyPreset makes p the active preset. With a percentage knob it sets the
+// conservation and start thresholds; otherwise the firmware's level stays
+// and only the target follows p. Either way the target becomes p.End, within
+// what the thresholds allow.
+func (c *Config) ApplyPreset(p Preset) {
+	c.Preset = p.Name
+	if c.PercentKnob {
+		c.ConservationThreshold, c.StartThreshold = p.End, p.Start
+	}
+	c.MaxPercent = min(max(p.End, c.ConservationThreshold), 100)
+}
+
+// CalibrationRecord is the outcome of one battery calibration.
+type CalibrationRecord struct {
+	Time   time.Time `json:"time"`             // when it ended
+	Result string    `json:"result"`           // "done" or why it was aborted
+	Health float64   `json:"health,omitempty"` // measured full capacity, percent of design
+	Cycles int       `json:"cycles,omitempty"` // battery cycle count at the time
+}
+
+// MaxCalibrations is how many calibration results are kept.
+const MaxCalibrations = 24
+
+// LastCalibration returns the most recent calibration, if any.
+func (c Config) LastCalibration() (CalibrationRecord, bool) {
+	if len(c.Calibrations) == 0 {
+		return CalibrationRecord{}, false
+	}
+	return c.Calibrations[len(c.Calibrations)-1], true
+}
+
+// ScheduleNextCalibration sets CalibrateAt one CalibrateEvery after the last
+// calibration, or after now when there is none, unless one is already
+// scheduled or periodic calibration is off.
+func (c *Config) ScheduleNextCalibration(now time.Time) {
+	if c.CalibrateEvery <= 0 || c.CalibrateAt != nil {
+		return
+	}
+	next := now.Add(c.CalibrateEvery)
+	if last, ok := c.LastCalibration(); ok {
+		next = last.Time.Add(c.CalibrateEvery)
+	}
+	c.CalibrateAt = &next
+}
+
+// UserPolicy is one user's charge target on a shared machine, and their
+// charge current cap: the global one if 0.
+type UserPolicy struct {
+	Max             float64 `json:"max"`
+	Auto            bool    `json:"auto"`
+	ChargeCurrentMA int     `json:"charge_current_ma,omitempty"`
+}
+
+// Place is a circular area considered "home" (or "office").
+type Place struct {
+	Lat, Lon float64
+	RadiusKm float64
+}
+
+// DefaultPlaceRadiusKm matches GeoClue's city-level accuracy.
+const DefaultPlaceRadiusKm = 20
+
+// ParsePlaces parses "lat,lon[,radius_km]" entries separated by ";".
+func ParsePlaces(s string) ([]Place, error) {
+	var places []Place
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ",")
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("place %q: want lat,lon[,radius_km]", entry)
+		}
+		var vals [3]float64
+		vals[2] = DefaultPlaceRadiusKm
+		for i, p := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return nil, fmt.Errorf("place %q: %w", entry, err)
+			}
+			vals[i] = v
+		}
+		if vals[0] < -90 || vals[0] > 90 || vals[1] < -180 || vals[1] > 180 || vals[2] <= 0 {
+			return nil, fmt.Errorf("place %q: coordinates or radius out of range", entry)
+		}
+		places = append(places, Place{Lat: vals[0], Lon: vals[1], RadiusKm: vals[2]})
+	}
+	return places, nil
+}
+
+// PlatformModes are the daemon modes a platform_profile can be tied to.
+var PlatformModes = []string{"conserve", "charge", "trip", "away"}
+
+// ParsePlatformProfiles parses "mode=profile,..." pairs, e.g.
+// "conserve=low-power,trip=performance".
+func ParsePlatformProfiles(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		mode, profile, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || profile == "" {
+			return nil, fmt.Errorf("platform profile %q: want mode=profile", pair)
+		}
+		if !slices.Contains(PlatformModes, mode) {
+			return nil, fmt.Errorf("platform profile %q: mode must be one of %s", pair, strings.Join(PlatformModes, ", "))
+		}
+		m[mode] = profile
+	}
+	return m, nil
+}
+
+// Validate checks the threshold settings.
+func (c Config) Validate() error {
+	if c.MaxPercent < c.ConservationThreshold || c.MaxPercent > 100 {
+		return fmt.Errorf("max must be in [%.1f,100], got %.1f", c.ConservationThreshold, c.MaxPercent)
+	}
+	if c.ConservationThreshold < c.MinThreshold() || c.ConservationThreshold > 100 {
+		return fmt.Errorf("conservation-threshold must be in [%g,100], got %.1f", c.MinThreshold(), c.ConservationThreshold)
+	}
+	if c.StartThreshold < 0 || c.StartThreshold > 0 && c.StartThreshold >= c.ConservationThreshold {
+		return fmt.Errorf("start-threshold must be below conservation-threshold %.1f, got %.1f", c.ConservationThreshold, c.StartThreshold)
+	}
+	if c.SafetyFloor < 0 || c.SafetyFloor > 50 {
+		return fmt.Errorf("safety-floor must be in [0,50], got %.1f", c.SafetyFloor)
+	}
+	if c.StorageLevel != 0 && (c.StorageLevel < LowThresholdFloor || c.StorageLevel <= c.SafetyFloor || c.StorageLevel >= c.ConservationThreshold) {
+		return fmt.Errorf("storage-level must be in [%d,%.1f) and above safety-floor %.1f, got %.1f", LowThresholdFloor, c.ConservationThreshold, c.SafetyFloor, c.StorageLevel)
+	}
+	if len(c.Places) > 0 && (c.AwayMax < c.ConservationThreshold || c.AwayMax > 100) {
+		return fmt.Errorf("away-max must be in [%.1f,100], got %.1f", c.ConservationThreshold, c.AwayMax)
+	}
+	if c.Calendar != "" && (c.TripMax < c.ConservationThreshold || c.TripMax > 100) {
+		return fmt.Errorf("trip-max must be in [%.1f,100], got %.1f", c.ConservationThreshold, c.TripMax)
+	}
+	if c.HealthAdaptive && (c.HealthMax < c.MinThreshold() || c.HealthMax > 100 || c.HealthBelow <= 0 || c.HealthBelow > 100) {
+		return fmt.Errorf("health-max must be in [%g,100] and health-below in (0,100], got %.1f and %.1f", c.MinThreshold(), c.HealthMax, c.HealthBelow)
+	}
+	if c.TempLimit < 0 || c.TempLimit > 0 && (c.TempMax < c.MinThreshold() || c.TempMax > 100) {
+		return fmt.Errorf("temp-limit must be >= 0 and temp-max in [%g,100], got %.1f and %.1f", c.MinThreshold(), c.TempLimit, c.TempMax)
+	}
+	switch c.BatterySource {
+	case "", "auto", "upower", "sysfs":
+	default:
+		return fmt.Errorf("battery-source must be upower, sysfs or auto, got %q", c.BatterySource)
+	}
+	switch c.ExternalPolicy {
+	case "", "enforce", "adopt", "ask":
+	default:
+		return fmt.Errorf("external-change must be enforce, adopt or ask, got %q", c.ExternalPolicy)
+	}
+	switch c.OnExit {
+	case "", "keep", "on", "off":
+	default:
+		return fmt.Errorf("on-exit must be keep, on or off, got %q", c.OnExit)
+	}
+	if c.LogMaxSizeMB < 0 || c.LogRotateEvery < 0 || c.LogKeep < 0 {
+		return fmt.Errorf("log-max-size, log-rotate-every and log-keep must not be negative")
+	}
+	switch c.RapidChargeConflict {
+	case "", "disable", "refuse", "ignore":
+	default:
+		return fmt.Errorf("rapid-charge-conflict must be disable, refuse or ignore, got %q", c.RapidChargeConflict)
+	}
+	switch c.KnobPrecedence {
+	case "", "charge_thresholds", "conservation_mode":
+	default:
+		return fmt.Errorf("precedence must be charge_thresholds or conservation_mode, got %q", c.KnobPrecedence)
+	}
+	if c.Telemetry != "" && !strings.HasPrefix(c.Telemetry, "https://") && !strings.HasPrefix(c.Telemetry, "http://") {
+		return fmt.Errorf("telemetry endpoint must be an http(s) URL, got %q", c.Telemetry)
+	}
+	if c.PromTextfile != "" && c.PromInterval <= 0 {
+		return fmt.Errorf("prom-interval must be positive, got %s", c.PromInterval)
+	}
+	for _, r := range c.Schedule {
+		if r.Preset == "" && r.Max < c.MinThreshold() {
+			return fmt.Errorf("schedule rule %q: target must be in [%g,100]", r, c.MinThreshold())
+		}
+	}
+	if c.AwayChargeCurrentMA < 0 {
+		return fmt.Errorf("away charge current must be >= 0 mA, got %d", c.AwayChargeCurrentMA)
+	}
+	if c.ChargeCurrentMA < 0 {
+		return fmt.Errorf("charge current must be >= 0 mA, got %d", c.ChargeCurrentMA)
+	}
+	return nil
+}
+
+// Settings are the options conservationctl can change at runtime that the
+// state file keeps once changed.
+type Settings struct {
+	Threshold       float64
+	SafetyFloor     float64
+	ChargeCurrentMA int
+	AwayMax         float64
+	TripMax         float64
+}
+
+// Settings returns the runtime-changeable settings of c.
+func (c Config) Settings() Settings {
+	return Settings{
+		Threshold:       c.ConservationThreshold,
+		SafetyFloor:     c.SafetyFloor,
+		ChargeCurrentMA: c.ChargeCurrentMA,
+		AwayMax:         c.AwayMax,
+		TripMax:         c.TripMax,
+	}
+}
+
+// stateVersion is the schema version SaveState writes. Files without a
+// version field predate versioning and count as version 1.
+const stateVersion = 2
+
+// stateMigrations[v-1] upgrades a raw version v document to version v+1.
+// Add an entry, and bump stateVersion, whenever the schema changes
+// incompatibly.
+var stateMigrations = []func(doc map[string]json.RawMessage) error{
+	// 1 -> 2: only the version field is new
+	func(map[string]json.RawMessage) error { return nil },
+}
+
+// persistedState is the subset of Config that survives daemon restarts,
+// including any in-flight schedule so a crash or reboot resumes it.
+type persistedState struct {
+	Version int `json:"version"`
+
+	Auto    bool    `json:"auto"`
+	Max     float64 `json:"max"`
+	Storage bool    `json:"storage,omitempty"`
+	Preset  string  `json:"preset,omitempty"`
+
+	// Settings a restore or conservationctl changed at runtime, away from
+	// Config.Configured
+	Threshold       *float64 `json:"threshold,omitempty"`
+	SafetyFloor     *float64 `json:"safety_floor,omitempty"`
+	ChargeCurrentMA *int     `json:"charge_current_ma,omitempty"`
+	AwayMax         *float64 `json:"away_max,omitempty"`
+	TripMax         *float64 `json:"trip_max,omitempty"`
+
+	Target       *time.Time `json:"target,omitempty"`
+	LevelReached bool       `json:"level_reached,omitempty"`
+	RuleFired    *time.Time `json:"rule_fired,omitempty"`
+
+	Users map[uint32]UserPolicy `json:"users,omitempty"`
+
+	CalibrateAt    *time.Time          `json:"calibrate_at,omitempty"`
+	CalibrateEvery *time.Duration      `json:"calibrate_every,omitempty"`
+	Calibrations   []CalibrationRecord `json:"calibrations,omitempty"`
+
+	TelemetrySent *time.Time `json:"telemetry_sent,omitempty"`
+}
+
+// LoadState applies the persisted state at path on top of cfg. A schedule
+// whose target time passed while the daemon was down is cancelled.
+func LoadState(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ps, err := decodeState(path, data)
+	if err != nil {
+		return err
+	}
+	cfg.Auto = ps.Auto
+	cfg.Storage = ps.Storage
+	ps.applySettings(cfg)
+	if p, ok := FindPreset(ps.Preset); ok {
+		cfg.ApplyPreset(p)
+	}
+	if ps.Max >= cfg.ConservationThreshold && ps.Max <= 100 {
+		cfg.MaxPercent = ps.Max
+	}
+	if ps.Target != nil && ps.Target.After(time.Now()) {
+		cfg.TargetTime = ps.Target
+	}
+	cfg.LevelReached = ps.LevelReached
+	cfg.ScheduleFired = ps.RuleFired
+	for uid, p := range ps.Users {
+		if p.Max < cfg.ConservationThreshold || p.Max > 100 {
+			delete(ps.Users, uid)
+		}
+	}
+	cfg.UserPolicies = ps.Users
+	cfg.CalibrateAt = ps.CalibrateAt
+	if ps.CalibrateEvery != nil && *ps.CalibrateEvery >= 0 {
+		cfg.CalibrateEvery = *ps.CalibrateEvery
+	}
+	cfg.Calibrations = ps.Calibrations
+	cfg.TelemetrySent = ps.TelemetrySent
+	return nil
+}
+
+// applySettings applies the persisted runtime settings to cfg, unless they
+// don't fit the rest of it, e.g. a threshold above a -storage-level
+// lowered since.
+func (ps persistedState) applySettings(cfg *Config) {
+	c := *cfg
+	if ps.Threshold != nil {
+		c.ConservationThreshold = *ps.Threshold
+		c.MaxPercent = max(c.MaxPercent, c.ConservationThreshold)
+	}
+	if ps.SafetyFloor != nil {
+		c.SafetyFloor = *ps.SafetyFloor
+	}
+	if ps.ChargeCurrentMA != nil {
+		c.ChargeCurrentMA = *ps.ChargeCurrentMA
+	}
+	if ps.AwayMax != nil {
+		c.AwayMax = *ps.AwayMax
+	}
+	if ps.TripMax != nil {
+		c.TripMax = *ps.TripMax
+	}
+	if c.Validate() == nil {
+		*cfg = c
+	}
+}
+
+// decodeState migrates data to the current schema and decodes it. A file
+// written by a newer daemon is copied aside first, since the next save
+// would otherwise overwrite what this version can't read.
+func decodeState(path string, data []byte) (persistedState, error) {
+	var ps persistedState
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return ps, err
+	}
+	version := 1
+	if raw, ok := doc["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return ps, fmt.Errorf("state version: %w", err)
+		}
+	}
+	if version > stateVersion {
+		bak := fmt.Sprintf("%s.v%d", path, version)
+		if err := os.WriteFile(bak, data, 0o644); err != nil {
+			return ps, err
+		}
+		return ps, fmt.Errorf("state version %d is newer than supported %d (kept a copy at %s)", version, stateVersion, bak)
+	}
+	if version < 1 {
+		return ps, fmt.Errorf("invalid state version %d", version)
+	}
+	for v := version; v < stateVersion; v++ {
+		if err := stateMigrations[v-1](doc); err != nil {
+			return ps, fmt.Errorf("migrate state v%d: %w", v, err)
+		}
+	}
+	delete(doc, "version")
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return ps, err
+	}
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return ps, err
+	}
+	ps.Version = stateVersion
+	return ps, nil
+}
+
+// SaveState atomically writes the persistent subset of cfg to path. Of the
+// Settings, only those changed from cfg.Configured are written, so a value
+// left alone at runtime follows the flags and the configuration file.
+func SaveState(path string, cfg Config) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	ps := persistedState{
+		Version:         stateVersion,
+		Auto:            cfg.Auto,
+		Max:             cfg.MaxPercent,
+		Storage:         cfg.Storage,
+		Preset:          cfg.Preset,
+		Threshold:       changed(cfg.ConservationThreshold, cfg.Configured.Threshold),
+		SafetyFloor:     changed(cfg.SafetyFloor, cfg.Configured.SafetyFloor),
+		ChargeCurrentMA: changed(cfg.ChargeCurrentMA, cfg.Configured.ChargeCurrentMA),
+		AwayMax:         changed(cfg.AwayMax, cfg.Configured.AwayMax),
+		TripMax:         changed(cfg.TripMax, cfg.Configured.TripMax),
+		Target:          cfg.TargetTime,
+		LevelReached:    cfg.LevelReached,
+		RuleFired:       cfg.ScheduleFired,
+		Users:           cfg.UserPolicies,
+		CalibrateAt:     cfg.CalibrateAt,
+		CalibrateEvery:  &cfg.CalibrateEvery,
+		Calibrations:    cfg.Calibrations,
+		TelemetrySent:   cfg.TelemetrySent,
+	}
+	data, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// changed returns &v if v differs from the configured value, nil otherwise.
+func changed[T comparable](v, configured T) *T {
+	if v == configured {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,480 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		max, threshold float64
+		low            bool
+		ok             bool
+	}{
+		{80, 80, false, true},
+		{100, 80, false, true},
+		{79, 80, false, false},
+		{101, 80, false, false},
+		{60, 40, false, false},
+		{60, 40, true, true},
+		{30, 15, true, false},
+	}
+	for _, tt := range tests {
+		err := Config{MaxPercent: tt.max, ConservationThreshold: tt.threshold, LowThresholds: tt.low}.Validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("Validate(max=%.0f, threshold=%.0f) = %v", tt.max, tt.threshold, err)
+		}
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "state.json")
+	if err := SaveState(path, Config{Auto: true, MaxPercent: 95, Storage: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{MaxPercent: 80, ConservationThreshold: 80}
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Auto || cfg.MaxPercent != 95 || !cfg.Storage {
+		t.Errorf("loaded %+v", cfg)
+	}
+}
+
+func TestStateRoundTripSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	saved := Config{MaxPercent: 90, ConservationThreshold: 70, LowThresholds: true, SafetyFloor: 10, ChargeCurrentMA: 1500, AwayMax: 95, TripMax: 100}
+	if err := SaveState(path, saved); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{MaxPercent: 80, ConservationThreshold: 80, LowThresholds: true, SafetyFloor: 15}
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ConservationThreshold != 70 || cfg.SafetyFloor != 10 || cfg.ChargeCurrentMA != 1500 || cfg.AwayMax != 95 || cfg.MaxPercent != 90 {
+		t.Errorf("loaded %+v", cfg)
+	}
+
+	// A threshold that no longer fits is dropped with the other settings
+	cfg = Config{MaxPercent: 80, ConservationThreshold: 80, SafetyFloor: 15, StorageLevel: 75}
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ConservationThreshold != 80 || cfg.SafetyFloor != 15 || cfg.ChargeCurrentMA != 0 {
+		t.Errorf("loaded invalid settings: %+v", cfg)
+	}
+}
+
+func TestStateKeepsOnlyChangedSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	first := Config{MaxPercent: 80, ConservationThreshold: 80, SafetyFloor: 15}
+	first.Configured = first.Settings()
+	if err := SaveState(path, first); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags and the configuration file changed since: they win
+	cfg := Config{MaxPercent: 60, ConservationThreshold: 60, LowThresholds: true, SafetyFloor: 20}
+	cfg.Configured = cfg.Settings()
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ConservationThreshold != 60 || cfg.SafetyFloor != 20 {
+		t.Errorf("unchanged settings beat the configuration: %+v", cfg)
+	}
+
+	// ...except over a setting changed at runtime
+	first.SafetyFloor = 10
+	if err := SaveState(path, first); err != nil {
+		t.Fatal(err)
+	}
+	cfg = Config{MaxPercent: 60, ConservationThreshold: 60, LowThresholds: true, SafetyFloor: 20}
+	cfg.Configured = cfg.Settings()
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ConservationThreshold != 60 || cfg.SafetyFloor != 10 {
+		t.Errorf("loaded %+v", cfg)
+	}
+}
+
+func TestLoadStateIgnoresOutOfRangeMax(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"auto":false,"max":42}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{MaxPercent: 85, ConservationThreshold: 80}
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxPercent != 85 {
+		t.Errorf("MaxPercent = %.1f, want unchanged 85", cfg.MaxPercent)
+	}
+}
+
+func TestStateResumesSchedule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	future := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := SaveState(path, Config{MaxPercent: 95, TargetTime: &future, LevelReached: true}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{MaxPercent: 80, ConservationThreshold: 80}
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TargetTime == nil || !cfg.TargetTime.Equal(future) || !cfg.LevelReached {
+		t.Errorf("schedule not resumed: %+v", cfg)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if err := SaveState(path, Config{MaxPercent: 95, TargetTime: &past}); err != nil {
+		t.Fatal(err)
+	}
+	cfg = Config{MaxPercent: 80, ConservationThreshold: 80}
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TargetTime != nil {
+		t.Errorf("expired schedule resumed: %v", cfg.TargetTime)
+	}
+}
+
+func TestCalibrationSchedule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	last := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	saved := Config{MaxPercent: 80, CalibrateEvery: 30 * 24 * time.Hour,
+		Calibrations: []CalibrationRecord{{Time: last, Result: "done", Health: 91.5, Cycles: 120}}}
+	if err := SaveState(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{MaxPercent: 80, ConservationThreshold: 80}
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CalibrateEvery != saved.CalibrateEvery || len(cfg.Calibrations) != 1 || cfg.Calibrations[0] != saved.Calibrations[0] {
+		t.Fatalf("calibration state not restored: %+v", cfg)
+	}
+
+	cfg.ScheduleNextCalibration(last.Add(time.Hour))
+	if want := last.Add(30 * 24 * time.Hour); cfg.CalibrateAt == nil || !cfg.CalibrateAt.Equal(want) {
+		t.Errorf("CalibrateAt = %v, want %v", cfg.CalibrateAt, want)
+	}
+	// An explicit schedule is kept
+	at := last.Add(48 * time.Hour)
+	cfg.CalibrateAt = &at
+	cfg.ScheduleNextCalibration(last)
+	if !cfg.CalibrateAt.Equal(at) {
+		t.Errorf("explicit schedule replaced by %v", cfg.CalibrateAt)
+	}
+
+	cfg = Config{}
+	cfg.ScheduleNextCalibration(last)
+	if cfg.CalibrateAt != nil {
+		t.Errorf("scheduled with periodic calibration off: %v", cfg.CalibrateAt)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	p, ok := FindPreset("Max Lifespan")
+	if !ok || p.Name != "lifespan" {
+		t.Fatalf("FindPreset = %+v, %t", p, ok)
+	}
+	if _, ok := FindPreset("turbo"); ok {
+		t.Error("unknown preset found")
+	}
+
+	cfg := Config{MaxPercent: 80, ConservationThreshold: 80, PercentKnob: true}
+	cfg.ApplyPreset(p)
+	if cfg.ConservationThreshold != 60 || cfg.StartThreshold != 55 || cfg.MaxPercent != 60 || cfg.Validate() != nil {
+		t.Errorf("lifespan on a percentage knob: %+v", cfg)
+	}
+	// Fixed-level firmware keeps its threshold
+	cfg = Config{MaxPercent: 100, ConservationThreshold: 80}
+	cfg.ApplyPreset(p)
+	if cfg.ConservationThreshold != 80 || cfg.MaxPercent != 80 || cfg.Preset != "lifespan" {
+		t.Errorf("lifespan on a fixed knob: %+v", cfg)
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	saved := Config{MaxPercent: 60, ConservationThreshold: 60, StartThreshold: 55, Preset: "lifespan"}
+	if err := SaveState(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	cfg = Config{MaxPercent: 80, ConservationThreshold: 80, PercentKnob: true}
+	if err := LoadState(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Preset != "lifespan" || cfg.ConservationThreshold != 60 || cfg.MaxPercent != 60 {
+		t.Errorf("preset not restored: %+v", cfg)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	doc := `# conservationd
+max = 90
+conservation-threshold=80 # firmware level
+interval = "1m"
+sock-group = 'wheel'
+auto = true
+calendar-tag = "[travel] \"trip\""
+`
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Setting{
+		{"max", "90", 2}, {"conservation-threshold", "80", 3}, {"interval", "1m", 4},
+		{"sock-group", "wheel", 5}, {"auto", "true", 6}, {"calendar-tag", `[travel] "trip"`, 7},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseFile = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"[daemon]\nmax = 90\n", "max\n", "max = 90\nmax = 95\n", "tag = \"open\n", "tag = two words\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseFile(path); err == nil {
+			t.Errorf("ParseFile(%q) accepted", bad)
+		}
+	}
+}
+
+func TestParsePlaces(t *testing.T) {
+	places, err := ParsePlaces("45.46,9.19; 41.9,12.5,3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(places) != 2 || places[0].RadiusKm != DefaultPlaceRadiusKm || places[1].RadiusKm != 3 {
+		t.Errorf("ParsePlaces = %+v", places)
+	}
+	for _, bad := range []string{"45.46", "95,9", "45,9,0", "a,b"} {
+		if _, err := ParsePlaces(bad); err == nil {
+			t.Errorf("ParsePlaces(%q) accepted", bad)
+		}
+	}
+}
+
+func TestParsePlatformProfiles(t *testing.T) {
+	m, err := ParsePlatformProfiles("conserve=low-power, trip=performance")
+	if err != nil || m["conserve"] != "low-power" || m["trip"] != "performance" || len(m) != 2 {
+		t.Errorf("ParsePlatformProfiles = %v, %v", m, err)
+	}
+	for _, bad := range []string{"conserve", "sleep=quiet", "charge="} {
+		if _, err := ParsePlatformProfiles(bad); err == nil {
+			t.Errorf("ParsePlatformProfiles(%q) accepted", bad)
+		}
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	src := Config{MaxPercent: 95, ConservationThreshold: 80, SafetyFloor: 10, Auto: true, TargetTime: &future,
+		UserPolicies: map[uint32]UserPolicy{1000: {Max: 90}}}
+	snap := src.Snapshot()
+
+	dst := Config{MaxPercent: 80, ConservationThreshold: 80, StatePath: "/keep"}
+	if err := snap.Restore(&dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.MaxPercent != 95 || !dst.Auto || dst.TargetTime == nil || dst.UserPolicies[1000].Max != 90 || dst.StatePath != "/keep" {
+		t.Errorf("restored %+v", dst)
+	}
+
+	bad := snap
+	bad.Users = map[uint32]UserPolicy{1000: {Max: 20}}
+	before := dst
+	if err := bad.Restore(&dst); err == nil {
+		t.Error("out-of-range user policy accepted")
+	}
+	if dst.MaxPercent != before.MaxPercent || dst.UserPolicies[1000].Max != 90 {
+		t.Errorf("failed restore changed config: %+v", dst)
+	}
+	bad = snap
+	bad.Version = SnapshotVersion + 1
+	if err := bad.Restore(&dst); err == nil {
+		t.Error("future snapshot version accepted")
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	later := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	src := Config{
+		Auto: true, Storage: true, MaxPercent: 90, ConservationThreshold: 80, SafetyFloor: 10, ChargeCurrentMA: 1500,
+		TargetTime:       &later,
+		UserPolicies:     map[uint32]UserPolicy{1000: {Max: 85, Auto: true, ChargeCurrentMA: 1000}},
+		PlatformProfiles: map[string]string{"conserve": "quiet"},
+		AwayMax:          95, TripMax: 100,
+		StartThreshold: 70, Preset: "balanced", AwayChargeCurrentMA: 2000,
+		Schedule: []Rule{
+			{Days: []time.Weekday{time.Monday, time.Tuesday}, At: 7*time.Hour + 30*time.Minute, Preset: "lifespan"},
+			{At: 18 * time.Hour, Max: 85.5},
+		},
+		OffPeak:             []Window{{Start: 23 * time.Hour, End: 7 * time.Hour, Days: []time.Weekday{time.Saturday}}},
+		ChargeWindow:        []Window{{Start: 9 * time.Hour, End: 17 * time.Hour}},
+		CalibrateEvery:      720 * time.Hour,
+		CalibrateAt:         &later,
+		RapidChargeConflict: "refuse",
+		Quirks:              "ideapad-5",
+	}
+	snap := src.Snapshot()
+	// Every field is filled, so one added without a round trip fails here
+	v := reflect.ValueOf(snap)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Errorf("snapshot field %s not filled", v.Type().Field(i).Name)
+		}
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Snapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("%v in %s", err, data)
+	}
+	dst := Config{MaxPercent: 80, ConservationThreshold: 80, Quirks: src.Quirks}
+	if err := decoded.Restore(&dst); err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.Snapshot(); !reflect.DeepEqual(got, snap) {
+		t.Errorf("round trip changed the snapshot:\n got %+v\nwant %+v", got, snap)
+	}
+
+	// Version 1 documents leave the newer settings alone
+	old := decoded
+	old.Version = 1
+	old.Schedule, old.StartThreshold, old.RapidChargeConflict = nil, 0, ""
+	if err := old.Restore(&dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.Schedule) != 2 || dst.StartThreshold != 70 || dst.RapidChargeConflict != "refuse" {
+		t.Errorf("version 1 restore changed newer settings: %+v", dst)
+	}
+}
+
+func TestLoadStateVersions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	// Unversioned files from older daemons still load
+	os.WriteFile(path, []byte(`{"auto":true,"max":90}`), 0o644)
+	cfg := Config{MaxPercent: 80, ConservationThreshold: 80}
+	if err := LoadState(path, &cfg); err != nil || !cfg.Auto || cfg.MaxPercent != 90 {
+		t.Errorf("v1 state: %+v, %v", cfg, err)
+	}
+
+	// Newer files are refused and kept aside
+	newer := `{"version":99,"auto":true,"max":90}`
+	os.WriteFile(path, []byte(newer), 0o644)
+	cfg = Config{MaxPercent: 80, ConservationThreshold: 80}
+	if err := LoadState(path, &cfg); err == nil {
+		t.Error("newer state version accepted")
+	}
+	if cfg.Auto {
+		t.Error("newer state partially applied")
+	}
+	if b, err := os.ReadFile(path + ".v99"); err != nil || string(b) != newer {
+		t.Errorf("backup = %q, %v", b, err)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	ws, err := ParseWindows("23:00-07:00, 13:00-15:00")
+	if err != nil || len(ws) != 2 {
+		t.Fatalf("ParseWindows = %+v, %v", ws, err)
+	}
+	at := func(day, hh, mm int) time.Time { return time.Date(2024, 3, day, hh, mm, 0, 0, time.Local) } // 2024-03-04 is a Monday
+	for _, tt := range []struct {
+		t    time.Time
+		w    int
+		want bool
+	}{
+		{at(4, 23, 30), 0, true},
+		{at(5, 6, 59), 0, true},
+		{at(5, 7, 0), 0, false},
+		{at(5, 14, 0), 1, true},
+		{at(5, 15, 0), 1, false},
+	} {
+		if got := ws[tt.w].Contains(tt.t); got != tt.want {
+			t.Errorf("window %d Contains(%s) = %t", tt.w, tt.t, got)
+		}
+	}
+	for _, bad := range []string{"23:00", "25:00-07:00", "07:00-07:00"} {
+		if _, err := ParseWindows(bad); err == nil {
+			t.Errorf("ParseWindows(%q) accepted", bad)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "tariff.json")
+	os.WriteFile(path, []byte(`{"windows":[{"start":"22:00","end":"06:00","days":["Sat","sun"]}]}`), 0o644)
+	ws, err = LoadTariff(path)
+	if err != nil || len(ws) != 1 {
+		t.Fatalf("LoadTariff = %+v, %v", ws, err)
+	}
+	// Saturday night into Sunday morning, but not Friday night
+	if !ws[0].Contains(at(10, 5, 0)) || ws[0].Contains(at(9, 5, 0)) || ws[0].Contains(at(8, 23, 0)) {
+		t.Errorf("weekend window misplaced: %+v", ws[0])
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules, err := ParseRules("mon-fri 06:30 full, mon-fri 18:00 -> 80%, sat/sun 09:00 90")
+	if err != nil || len(rules) != 3 {
+		t.Fatalf("ParseRules = %+v, %v", rules, err)
+	}
+	if s := rules[0].String(); s != "mon/tue/wed/thu/fri 06:30 full" {
+		t.Errorf("String() = %q", s)
+	}
+	at := func(day, hh, mm int) time.Time { return time.Date(2024, 3, day, hh, mm, 0, 0, time.Local) } // 2024-03-04 is a Monday
+	for _, tt := range []struct {
+		t       time.Time
+		last    time.Time
+		max     float64
+		next    time.Time
+		nextMax float64
+	}{
+		{at(5, 12, 0), at(5, 6, 30), 100, at(5, 18, 0), 80},
+		{at(5, 18, 0), at(5, 18, 0), 80, at(6, 6, 30), 100},
+		{at(8, 23, 0), at(8, 18, 0), 80, at(9, 9, 0), 90},    // Friday night: the weekend next
+		{at(11, 6, 0), at(10, 9, 0), 90, at(11, 6, 30), 100}, // Monday morning
+	} {
+		when, r, ok := LastRule(rules, tt.t)
+		if !ok || !when.Equal(tt.last) || r.Max != tt.max {
+			t.Errorf("LastRule(%s) = %s %v", tt.t, when, r)
+		}
+		when, r, ok = NextRule(rules, tt.t)
+		if !ok || !when.Equal(tt.next) || r.Max != tt.nextMax {
+			t.Errorf("NextRule(%s) = %s %v", tt.t, when, r)
+		}
+	}
+	if _, _, ok := LastRule(nil, at(5, 12, 0)); ok {
+		t.Error("LastRule without rules")
+	}
+	if rules, err := ParseRules("fri-mon 08:00 full"); err != nil || len(rules[0].Days) != 4 {
+		t.Errorf("wrapping range = %+v, %v", rules, err)
+	}
+	if rules, err := ParseRules("weekdays 00:00 lifespan, weekend 00:00 Balanced"); err != nil || len(rules[0].Days) != 5 || rules[1].Preset != "balanced" || rules[1].String() != "sat/sun 00:00 balanced" {
+		t.Errorf("preset rules = %+v, %v", rules, err)
+	}
+	for _, bad := range []string{"mon-fri 08:00", "mon-xyz 08:00 full", "daily 25:00 80", "daily 08:00 120", "daily 08:00 -> cheap", "weekday 08:00 80"} {
+		if _, err := ParseRules(bad); err == nil {
+			t.Errorf("ParseRules(%q) accepted", bad)
+		}
+	}
+	if err := (Config{MaxPercent: 80, ConservationThreshold: 80, Schedule: []Rule{{At: time.Hour, Max: 30}}}).Validate(); err == nil {
+		t.Error("Validate accepted a rule below the threshold floor")
+	}
+}
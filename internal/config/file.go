@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultFile is the daemon's configuration file.
+const DefaultFile = "/etc/conservationd/config.toml"
+
+// Setting is one key = value line of a configuration file.
+type Setting struct {
+	Key, Value string
+	Line       int
+}
+
+// ParseFile reads a configuration file: a flat subset of TOML where every
+// key is a daemon flag name, e.g.
+//
+//	# /etc/conservationd/config.toml
+//	max = 90
+//	conservation-threshold = 80
+//	interval = "1m"
+//	sock-group = "wheel"
+//
+// Strings are quoted, numbers and booleans bare; # starts a comment.
+// Tables are not supported: the flags have no sections.
+func ParseFile(path string) ([]Setting, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []Setting
+	seen := map[string]int{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			return nil, fmt.Errorf("%s:%d: tables are not supported, put every key at the top level", path, n)
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: want key = value", path, n)
+		}
+		val, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+		}
+		if prev, dup := seen[key]; dup {
+			return nil, fmt.Errorf("%s:%d: %s already set on line %d", path, n, key, prev)
+		}
+		seen[key] = n
+		out = append(out, Setting{Key: key, Value: val, Line: n})
+	}
+	return out, sc.Err()
+}
+
+// parseValue decodes a TOML basic or literal string, or returns a bare
+// value (number, boolean) as written, without any trailing comment.
+func parseValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case raw[0] == '"':
+		end := closingQuote(raw)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if err := trailing(raw[end+1:]); err != nil {
+			return "", err
+		}
+		return strconv.Unquote(raw[:end+1])
+	case raw[0] == '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if err := trailing(raw[end+2:]); err != nil {
+			return "", err
+		}
+		return raw[1 : end+1], nil
+	}
+	val, _, _ := strings.Cut(raw, "#")
+	val = strings.TrimSpace(val)
+	if strings.ContainsAny(val, " \t") {
+		return "", fmt.Errorf("quote values containing spaces")
+	}
+	return val, nil
+}
+
+// closingQuote returns the index of the quote ending the basic string s, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func trailing(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && rest[0] != '#' {
+		return fmt.Errorf("unexpected %q after the value", rest)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule is a recurring schedule entry: from At on Days, the target is Max,
+// or the thresholds are those of Preset if set. An empty Days means every
+// day.
+type Rule struct {
+	Days   []time.Weekday
+	At     time.Duration // since midnight, local time
+	Max    float64
+	Preset string
+}
+
+// String formats r the way ParseRules reads it.
+func (r Rule) String() string {
+	days := "daily"
+	if len(r.Days) > 0 {
+		names := make([]string, len(r.Days))
+		for i, d := range r.Days {
+			names[i] = strings.ToLower(d.String()[:3])
+		}
+		days = strings.Join(names, "/")
+	}
+	target := strconv.FormatFloat(r.Max, 'f', -1, 64)
+	if r.Max == 100 {
+		target = "full"
+	}
+	if r.Preset != "" {
+		target = r.Preset
+	}
+	return fmt.Sprintf("%s %02d:%02d %s", days, int(r.At.Hours()), int(r.At.Minutes())%60, target)
+}
+
+// MarshalText writes r as String does, e.g. "mon/tue/wed/thu/fri 07:30 full".
+func (r Rule) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rule) UnmarshalText(text []byte) error {
+	rules, err := ParseRules(string(text))
+	if err != nil {
+		return err
+	}
+	if len(rules) != 1 {
+		return fmt.Errorf("schedule rule %q: want exactly one rule", text)
+	}
+	*r = rules[0]
+	return nil
+}
+
+// ParseRules parses recurring schedule rules separated by ",", each
+// "DAYS HH:MM TARGET", e.g. "mon-fri 07:30 full, mon-fri 18:00 80".
+// DAYS is daily, weekdays, weekend, a day (mon), a range (mon-fri, which
+// may wrap: fri-mon) or days joined by "/" (sat/sun). TARGET is full, a
+// percentage or the name of a threshold preset. An arrow (->) before the
+// target is allowed.
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(s, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == 4 && (fields[2] == "->" || fields[2] == "→") {
+			fields = append(fields[:2], fields[3])
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("schedule rule %q: want DAYS HH:MM TARGET, e.g. mon-fri 07:30 full", strings.TrimSpace(entry))
+		}
+		r, err := parseRule(fields[0], fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("schedule rule %q: %w", strings.TrimSpace(entry), err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func parseRule(days, at, target string) (Rule, error) {
+	var r Rule
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return r, fmt.Errorf("time must be in HH:MM format, got %s", at)
+	}
+	r.At = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if r.Days, err = parseDays(strings.ToLower(days)); err != nil {
+		return r, err
+	}
+	switch target = strings.TrimSuffix(strings.ToLower(target), "%"); target {
+	case "full":
+		r.Max = 100
+	default:
+		if p, ok := FindPreset(target); ok {
+			r.Preset = p.Name
+			break
+		}
+		if r.Max, err = strconv.ParseFloat(target, 64); err != nil || r.Max <= 0 || r.Max > 100 {
+			return r, fmt.Errorf("target must be full, a percentage or a preset, got %s", target)
+		}
+	}
+	return r, nil
+}
+
+func parseDays(s string) ([]time.Weekday, error) {
+	switch s {
+	case "daily", "*":
+		return nil, nil
+	case "weekdays":
+		s = "mon-fri"
+	case "weekend":
+		s = "sat/sun"
+	}
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		first, ok1 := weekdays[from]
+		last, ok2 := weekdays[to]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("unknown day range %q", s)
+		}
+		var days []time.Weekday
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				return days, nil
+			}
+		}
+	}
+	var days []time.Weekday
+	for _, name := range strings.Split(s, "/") {
+		d, ok := weekdays[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", name)
+		}
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+// occurrences calls fn with every time one of rules fires from a week
+// before t to a week after it.
+func occurrences(rules []Rule, t time.Time, fn func(at time.Time, r Rule)) {
+	y, m, d := t.Date()
+	for off := -7; off <= 7; off++ {
+		day := time.Date(y, m, d+off, 0, 0, 0, 0, t.Location())
+		for _, r := range rules {
+			if len(r.Days) == 0 || containsDay(r.Days, day.Weekday()) {
+				// Dates rather than durations, for days with a DST change
+				at := time.Date(day.Year(), day.Month(), day.Day(), int(r.At.Hours()), int(r.At.Minutes())%60, 0, 0, t.Location())
+				fn(at, r)
+			}
+		}
+	}
+}
+
+func containsDay(days []time.Weekday, d time.Weekday) bool {
+	for _, x := range days {
+		if x == d {
+			return true
+		}
+	}
+	return false
+}
+
+// LastRule returns the latest time at or before t one of rules fired, and
+// the rule. ok is false if there are no rules.
+func LastRule(rules []Rule, t time.Time) (at time.Time, rule Rule, ok bool) {
+	occurrences(rules, t, func(when time.Time, r Rule) {
+		if !when.After(t) && (!ok || when.After(at)) {
+			at, rule, ok = when, r, true
+		}
+	})
+	return at, rule, ok
+}
+
+// NextRule returns the earliest time after t one of rules fires, and the
+// rule. ok is false if there are no rules.
+func NextRule(rules []Rule, t time.Time) (at time.Time, rule Rule, ok bool) {
+	occurrences(rules, t, func(when time.Time, r Rule) {
+		if when.After(t) && (!ok || when.Before(at)) {
+			at, rule, ok = when, r, true
+		}
+	})
+	return at, rule, ok
+}
@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// SnapshotVersion is the current Snapshot document version.
+const SnapshotVersion = 2
+
+// Snapshot is the complete runtime configuration as one versioned
+// document, for backups and fleet tooling. Quirks is informational: quirk
+// profiles are detected from DMI and never restored. Version 1 documents
+// lack the fields from StartThreshold on, so restoring one leaves them
+// alone.
+type Snapshot struct {
+	Version int `json:"version"`
+
+	Auto                  bool    `json:"auto"`
+	Storage               bool    `json:"storage,omitempty"`
+	Max                   float64 `json:"max"`
+	ConservationThreshold float64 `json:"conservation_threshold"`
+	SafetyFloor           float64 `json:"safety_floor"`
+	ChargeCurrentMA       int     `json:"charge_current_ma,omitempty"`
+
+	Target *time.Time `json:"target,omitempty"` // pending schedule
+
+	Users            map[uint32]UserPolicy `json:"users,omitempty"`
+	PlatformProfiles map[string]string     `json:"platform_profiles,omitempty"`
+	AwayMax          float64               `json:"away_max,omitempty"`
+	TripMax          float64               `json:"trip_max,omitempty"`
+
+	// Since version 2
+	StartThreshold      float64       `json:"start_threshold,omitempty"`
+	Preset              string        `json:"preset,omitempty"`
+	AwayChargeCurrentMA int           `json:"away_charge_current_ma,omitempty"`
+	Schedule            []Rule        `json:"schedule,omitempty"`
+	OffPeak             []Window      `json:"offpeak,omitempty"`
+	ChargeWindow        []Window      `json:"charge_window,omitempty"`
+	CalibrateEvery      time.Duration `json:"calibrate_every,omitempty"`
+	CalibrateAt         *time.Time    `json:"calibrate_at,omitempty"` // next calibration
+	RapidChargeConflict string        `json:"rapid_charge_conflict,omitempty"`
+
+	Quirks string `json:"quirks,omitempty"`
+}
+
+// Snapshot captures the restorable subset of c.
+func (c Config) Snapshot() Snapshot {
+	return Snapshot{
+		Version:               SnapshotVersion,
+		Auto:                  c.Auto,
+		Storage:               c.Storage,
+		Max:                   c.MaxPercent,
+		ConservationThreshold: c.ConservationThreshold,
+		SafetyFloor:           c.SafetyFloor,
+		ChargeCurrentMA:       c.ChargeCurrentMA,
+		Target:                c.TargetTime,
+		Users:                 c.UserPolicies,
+		PlatformProfiles:      c.PlatformProfiles,
+		AwayMax:               c.AwayMax,
+		TripMax:               c.TripMax,
+		StartThreshold:        c.StartThreshold,
+		Preset:                c.Preset,
+		AwayChargeCurrentMA:   c.AwayChargeCurrentMA,
+		Schedule:              c.Schedule,
+		OffPeak:               c.OffPeak,
+		ChargeWindow:          c.ChargeWindow,
+		CalibrateEvery:        c.CalibrateEvery,
+		CalibrateAt:           c.CalibrateAt,
+		RapidChargeConflict:   c.RapidChargeConflict,
+		Quirks:                c.Quirks,
+	}
+}
+
+// Restore applies s to cfg. Either every setting is applied or, if any of
+// them is invalid, cfg is left untouched. A schedule or calibration date
+// already in the past is dropped; the next calibration is then due
+// CalibrateEvery after the last. The restored target holds until the next
+// schedule rule.
+func (s Snapshot) Restore(cfg *Config) error {
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d (want 1..%d)", s.Version, SnapshotVersion)
+	}
+	c := *cfg
+	c.Auto = s.Auto
+	c.Storage = s.Storage
+	c.MaxPercent = s.Max
+	c.ConservationThreshold = s.ConservationThreshold
+	c.SafetyFloor = s.SafetyFloor
+	c.ChargeCurrentMA = s.ChargeCurrentMA
+	c.TargetTime = nil
+	if s.Target != nil && s.Target.After(time.Now()) {
+		c.TargetTime = s.Target
+	}
+	c.LevelReached = false
+	c.UserPolicies = s.Users
+	c.PlatformProfiles = s.PlatformProfiles
+	if s.AwayMax != 0 {
+		c.AwayMax = s.AwayMax
+	}
+	if s.TripMax != 0 {
+		c.TripMax = s.TripMax
+	}
+	if s.Version >= 2 {
+		c.StartThreshold = s.StartThreshold
+		c.Preset = s.Preset
+		if _, ok := FindPreset(s.Preset); !ok && s.Preset != "" {
+			return fmt.Errorf("unknown preset %q", s.Preset)
+		}
+		c.AwayChargeCurrentMA = s.AwayChargeCurrentMA
+		c.Schedule = s.Schedule
+		now := time.Now()
+		c.ScheduleFired = &now
+		c.OffPeak = s.OffPeak
+		c.ChargeWindow = s.ChargeWindow
+		if s.CalibrateEvery < 0 {
+			return fmt.Errorf("calibrate-every must be >= 0, got %v", s.CalibrateEvery)
+		}
+		c.CalibrateEvery = s.CalibrateEvery
+		c.CalibrateAt = nil
+		if s.CalibrateAt != nil && s.CalibrateAt.After(time.Now()) {
+			c.CalibrateAt = s.CalibrateAt
+		}
+		c.ScheduleNextCalibration(time.Now())
+		c.RapidChargeConflict = s.RapidChargeConflict
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	for uid, p := range c.UserPolicies {
+		if p.Max < c.ConservationThreshold || p.Max > 100 {
+			return fmt.Errorf("user %d: max must be in [%.1f,100], got %.1f", uid, c.ConservationThreshold, p.Max)
+		}
+		if p.ChargeCurrentMA < 0 {
+			return fmt.Errorf("user %d: charge current must be >= 0 mA, got %d", uid, p.ChargeCurrentMA)
+		}
+	}
+	for mode := range c.PlatformProfiles {
+		if !slices.Contains(PlatformModes, mode) {
+			return fmt.Errorf("platform profile mode %q unknown", mode)
+		}
+	}
+	*cfg = c
+	return nil
+}
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Window is a daily cheap-rate electricity window. End before Start wraps
+// past midnight. An empty Days means every day.
+type Window struct {
+	Start, End time.Duration // since midnight, local time
+	Days       []time.Weekday
+}
+
+// Contains reports whether t falls inside the window. For windows wrapping
+// past midnight, Days refers to the day the window opens.
+func (w Window) Contains(t time.Time) bool {
+	y, m, d := t.Date()
+	since := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	day := t.Weekday()
+	switch {
+	case w.Start <= w.End:
+		return since >= w.Start && since < w.End && w.onDay(day)
+	case since >= w.Start:
+		return w.onDay(day)
+	case since < w.End:
+		return w.onDay((day + 6) % 7) // opened yesterday
+	}
+	return false
+}
+
+func (w Window) onDay(d time.Weekday) bool {
+	return len(w.Days) == 0 || slices.Contains(w.Days, d)
+}
+
+// Period is a one-off span of time, e.g. a low-carbon forecast interval.
+type Period struct {
+	From, To time.Time
+}
+
+// Contains reports whether t falls inside the period.
+func (p Period) Contains(t time.Time) bool {
+	return !t.Before(p.From) && t.Before(p.To)
+}
+
+// ParseWindows parses "HH:MM-HH:MM" windows separated by ",".
+func ParseWindows(s string) ([]Window, error) {
+	var windows []Window
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(entry, "-")
+		if !ok {
+			return nil, fmt.Errorf("window %q: want HH:MM-HH:MM", entry)
+		}
+		w, err := parseWindow(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", entry, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseWindow(start, end string) (Window, error) {
+	var w Window
+	for i, s := range []string{start, end} {
+		t, err := time.Parse("15:04", strings.TrimSpace(s))
+		if err != nil {
+			return w, fmt.Errorf("time must be in HH:MM format, got %s", s)
+		}
+		d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if i == 0 {
+			w.Start = d
+		} else {
+			w.End = d
+		}
+	}
+	if w.Start == w.End {
+		return w, fmt.Errorf("empty window")
+	}
+	return w, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// LoadTariff reads cheap-rate windows from a JSON tariff file:
+//
+//	{"windows": [{"start": "23:00", "end": "07:00"},
+//	             {"start": "13:00", "end": "15:00", "days": ["sat", "sun"]}]}
+func LoadTariff(path string) ([]Window, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	windows, err := ParseTariff(data)
+	if err != nil {
+		return nil, fmt.Errorf("tariff %s: %w", path, err)
+	}
+	return windows, nil
+}
+
+// ParseTariff parses a tariff document, as read by LoadTariff or printed by
+// a -tariff-command script.
+func ParseTariff(data []byte) ([]Window, error) {
+	var doc struct {
+		Windows []struct {
+			Start string   `json:"start"`
+			End   string   `json:"end"`
+			Days  []string `json:"days"`
+		} `json:"windows"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var windows []Window
+	for i, e := range doc.Windows {
+		w, err := tariffWindow(e.Start, e.End, e.Days)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", i+1, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// tariffWindow parses one window of a tariff document.
+func tariffWindow(start, end string, days []string) (Window, error) {
+	w, err := parseWindow(start, end)
+	if err != nil {
+		return w, err
+	}
+	for _, name := range days {
+		day, ok := weekdays[strings.ToLower(name)[:min(3, len(name))]]
+		if !ok {
+			return w, fmt.Errorf("unknown day %q", name)
+		}
+		w.Days = append(w.Days, day)
+	}
+	return w, nil
+}
+
+// windowJSON is a window as tariff documents write it.
+type windowJSON struct {
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Days  []string `json:"days,omitempty"`
+}
+
+// MarshalJSON writes w the way tariff documents do, e.g.
+// {"start": "23:00", "end": "07:00", "days": ["sat"]}.
+func (w Window) MarshalJSON() ([]byte, error) {
+	clock := func(d time.Duration) string { return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60) }
+	j := windowJSON{Start: clock(w.Start), End: clock(w.End)}
+	for _, d := range w.Days {
+		j.Days = append(j.Days, strings.ToLower(d.String()[:3]))
+	}
+	return json.Marshal(j)
+}
+
+func (w *Window) UnmarshalJSON(data []byte) error {
+	var j windowJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	parsed, err := tariffWindow(j.Start, j.End, j.Days)
+	if err != nil {
+		return fmt.Errorf("window %s-%s: %w", j.Start, j.End, err)
+	}
+	*w = parsed
+	return nil
+}
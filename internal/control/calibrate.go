@@ -0,0 +1,289 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+	"conservationDaemon/internal/monitor"
+)
+
+// Calibration phases: force-discharge down to the floor, then charge to full.
+const (
+	calDischarge = "discharge"
+	calCharge    = "charge"
+)
+
+// calibrationPhaseLimit aborts a phase that never completes, e.g. a
+// discharge that makes no progress because the firmware ignores it.
+var calibrationPhaseLimit = 12 * time.Hour
+
+// DefaultCalibrationFloor is where the discharge phase stops when no floor
+// is given.
+const DefaultCalibrationFloor = 20
+
+// calibration is a battery calibration in progress. The configured
+// thresholds are never touched: once it completes, or is aborted, the next
+// decision restores them.
+type calibration struct {
+	phase string // "" when not calibrating
+	floor float64
+	since time.Time // start of the current phase
+}
+
+func (c calibration) String() string {
+	switch c.phase {
+	case calDischarge:
+		return fmt.Sprintf("discharging to %g%%", c.floor)
+	case calCharge:
+		return "charging to 100%"
+	}
+	return ""
+}
+
+// calibrate overrides d while a calibration runs: force-discharge down to
+// the floor, then charge to full, then hand back to d.
+func (c *Controller) calibrate(cal calibration, d Decision, pct float64, state monitor.BatteryState, now time.Time) Decision {
+	if now.Sub(cal.since) > calibrationPhaseLimit {
+		c.State.abortCalibration(fmt.Sprintf("%s phase exceeded %v", cal.phase, calibrationPhaseLimit))
+		return d
+	}
+	if cal.phase == calDischarge && pct <= cal.floor {
+		logging.Logf("calibration: reached %.1f%%, charging to full", pct)
+		cal = calibration{phase: calCharge, floor: cal.floor, since: now}
+		c.State.setCalibration(cal)
+	}
+	if cal.phase == calDischarge {
+		return Decision{Want: backend.ForceDischarge, Action: "force_discharge_calibration", LevelReached: d.LevelReached}
+	}
+	if pct >= 100 || state == monitor.BatteryStateFull {
+		rec := config.CalibrationRecord{Time: now, Result: "done"}
+		if c.Gauge != nil {
+			if health, cycles, err := c.Gauge(); err == nil {
+				rec.Health, rec.Cycles = health, cycles
+			} else {
+				logging.Warnf("calibration: read full capacity: %v", err)
+			}
+		}
+		logging.Logf("calibration: battery full (health %.1f%%), restoring the configured thresholds", rec.Health)
+		logging.Event("calibration_done", map[string]any{"pct": pct, "health": rec.Health, "cycles": rec.Cycles})
+		c.State.finishCalibration(rec)
+		return d
+	}
+	return Decision{Want: 0, Action: "disable_conservation_calibration", LevelReached: d.LevelReached}
+}
+
+// StartCalibration begins a calibration that discharges to floor. The floor
+// must not be below the safety floor, which always allows charging.
+func (s *State) StartCalibration(floor float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cal.phase != "" {
+		return fmt.Errorf("calibration already running: %s", s.cal)
+	}
+	if s.pause.active(time.Now()) {
+		return fmt.Errorf("paused: resume before calibrating")
+	}
+	if floor == 0 {
+		floor = max(DefaultCalibrationFloor, s.cfg.SafetyFloor)
+	}
+	if floor < s.cfg.SafetyFloor || floor > 50 {
+		return fmt.Errorf("calibration floor must be in [%g,50], got %g", s.cfg.SafetyFloor, floor)
+	}
+	s.cal = calibration{phase: calDischarge, floor: floor, since: time.Now()}
+	logging.Logf("calibration started: discharging to %g%%", floor)
+	logging.Event("calibration_started", map[string]any{"floor": floor})
+	return nil
+}
+
+// AbortCalibration stops a running calibration; it reports whether one was
+// running.
+func (s *State) AbortCalibration() bool {
+	s.mu.Lock()
+	running := s.cal.phase != ""
+	s.mu.Unlock()
+	if running {
+		s.abortCalibration("aborted on request")
+	}
+	return running
+}
+
+func (s *State) abortCalibration(why string) {
+	s.mu.Lock()
+	cycles := s.cycles
+	s.mu.Unlock()
+	logging.Logf("calibration aborted: %s", why)
+	logging.Event("calibration_aborted", map[string]any{"reason": why})
+	s.finishCalibration(config.CalibrationRecord{Time: time.Now(), Result: why, Cycles: cycles})
+}
+
+// finishCalibration ends the running calibration, adds rec to the history
+// and schedules the next periodic calibration.
+func (s *State) finishCalibration(rec config.CalibrationRecord) {
+	s.mu.Lock()
+	s.cal = calibration{}
+	s.mu.Unlock()
+	s.Update(func(cfg *config.Config) error {
+		// Copy: snapshots taken before share the backing array
+		hist := append(cfg.Calibrations[:len(cfg.Calibrations):len(cfg.Calibrations)], rec)
+		if n := len(hist); n > config.MaxCalibrations {
+			hist = hist[n-config.MaxCalibrations:]
+		}
+		cfg.Calibrations = hist
+		cfg.ScheduleNextCalibration(rec.Time)
+		if cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// startScheduledCalibration starts the calibration scheduled for now. The
+// schedule is consumed even if it can't start, so it isn't retried every
+// step; the next periodic one is scheduled when this one ends.
+func (c *Controller) startScheduledCalibration() {
+	c.State.Update(func(cfg *config.Config) error {
+		cfg.CalibrateAt = nil
+		if cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+	if err := c.State.StartCalibration(0); err != nil {
+		logging.Errorf("scheduled calibration: %v", err)
+		c.State.Update(func(cfg *config.Config) error {
+			cfg.ScheduleNextCalibration(time.Now())
+			return nil
+		})
+	}
+}
+
+// ScheduleCalibration sets the next calibration to at and persists it.
+func (s *State) ScheduleCalibration(at time.Time) config.Config {
+	cfg, _ := s.Update(func(cfg *config.Config) error {
+		cfg.CalibrateAt = &at
+		if cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+	logging.Logf("calibration scheduled for %s", at.Format("2006-01-02 15:04"))
+	return cfg
+}
+
+// SetCalibrationInterval sets how often a calibration is scheduled, 0 for
+// only on request, reschedules the next one and persists the result.
+func (s *State) SetCalibrationInterval(every time.Duration) config.Config {
+	cfg, _ := s.Update(func(cfg *config.Config) error {
+		cfg.CalibrateEvery = every
+		cfg.CalibrateAt = nil
+		cfg.ScheduleNextCalibration(time.Now())
+		if cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+	return cfg
+}
+
+// calibrationHour is the time of day a calibration scheduled for a day
+// starts: early enough for the discharge and charge to finish that day.
+const calibrationHour = 10
+
+// ParseCalibrationTime parses when to calibrate: "tomorrow", "weekend" (or
+// "next-weekend", the coming Saturday), a weekday name, a date
+// (2006-01-02) or a date and time (2006-01-02 15:04). Days start at 10:00.
+// The result must be in the future.
+func ParseCalibrationTime(s string, now time.Time) (time.Time, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	day := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), calibrationHour, 0, 0, 0, now.Location())
+	}
+	nextWeekday := func(wd time.Weekday) time.Time {
+		n := (int(wd) - int(now.Weekday()) + 7) % 7
+		if n == 0 && !day(now).After(now) {
+			n = 7
+		}
+		return day(now.AddDate(0, 0, n))
+	}
+	var t time.Time
+	switch s {
+	case "tomorrow":
+		t = day(now.AddDate(0, 0, 1))
+	case "weekend", "next-weekend", "next weekend":
+		t = nextWeekday(time.Saturday)
+	default:
+		for wd := time.Sunday; wd <= time.Saturday; wd++ {
+			if name := strings.ToLower(wd.String()); s == name || s == name[:3] {
+				return nextWeekday(wd), nil
+			}
+		}
+		var err error
+		if t, err = time.ParseInLocation("2006-01-02 15:04", s, now.Location()); err != nil {
+			if t, err = time.ParseInLocation("2006-01-02", s, now.Location()); err != nil {
+				return time.Time{}, fmt.Errorf("calibration time must be tomorrow, weekend, a weekday, YYYY-MM-DD or \"YYYY-MM-DD HH:MM\", got %q", s)
+			}
+			t = day(t)
+		}
+	}
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("calibration time %s is in the past", t.Format("2006-01-02 15:04"))
+	}
+	return t, nil
+}
+
+// MinCalibrationInterval keeps periodic calibration from wearing the battery
+// it is meant to measure.
+const MinCalibrationInterval = 7 * 24 * time.Hour
+
+// ParseCalibrationInterval parses how often to calibrate: "weekly",
+// "monthly", "off", or a Go duration of at least a week.
+func ParseCalibrationInterval(s string) (time.Duration, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "off", "", "0":
+		return 0, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	case "monthly":
+		return 30 * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("calibration interval must be weekly, monthly, off or a duration, got %q", s)
+	}
+	if d < MinCalibrationInterval {
+		return 0, fmt.Errorf("calibration interval must be at least %v, got %v", MinCalibrationInterval, d)
+	}
+	return d, nil
+}
+
+func (s *State) calibration() calibration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cal
+}
+
+func (s *State) setCycles(cycles int) {
+	s.mu.Lock()
+	s.cycles = cycles
+	s.mu.Unlock()
+}
+
+func (s *State) setCalibration(c calibration) {
+	s.mu.Lock()
+	s.cal = c
+	s.mu.Unlock()
+}
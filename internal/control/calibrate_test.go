@@ -0,0 +1,139 @@
+package control
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/monitor"
+)
+
+func TestCalibration(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, SafetyFloor: 15})
+	bat := &fakeBattery{pct: 80, state: monitor.BatteryStateCharging}
+	knob := &fakeKnob{val: 1}
+	c := &Controller{State: st, Battery: bat, Knob: knob}
+	ctx := context.Background()
+
+	if err := st.StartCalibration(10); err == nil {
+		t.Fatal("floor below the safety floor accepted")
+	}
+	if err := st.StartCalibration(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.StartCalibration(0); err == nil {
+		t.Error("second calibration accepted")
+	}
+
+	c.Step(ctx)
+	if knob.val != backend.ForceDischarge || st.Status().Calibration != "discharging to 20%" {
+		t.Fatalf("discharge: knob=%d calibration=%q", knob.val, st.Status().Calibration)
+	}
+
+	bat.pct = 19
+	c.Step(ctx)
+	if knob.val != 0 || st.Status().Calibration != "charging to 100%" {
+		t.Fatalf("charge: knob=%d calibration=%q", knob.val, st.Status().Calibration)
+	}
+
+	bat.pct, bat.state = 100, monitor.BatteryStateFull
+	c.Step(ctx)
+	if knob.val != 1 || st.Status().Calibration != "" {
+		t.Fatalf("done: knob=%d calibration=%q, want the configured hold restored", knob.val, st.Status().Calibration)
+	}
+	if st.AbortCalibration() {
+		t.Error("abort reported a calibration after it finished")
+	}
+}
+
+func TestCalibrationPhaseLimit(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80})
+	knob := &fakeKnob{val: 1}
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 80}, Knob: knob}
+	if err := st.StartCalibration(30); err != nil {
+		t.Fatal(err)
+	}
+	st.setCalibration(calibration{phase: calDischarge, floor: 30, since: time.Now().Add(-calibrationPhaseLimit - time.Minute)})
+
+	c.Step(context.Background())
+	if knob.val != 1 || st.Status().Calibration != "" {
+		t.Errorf("stuck discharge not aborted: knob=%d calibration=%q", knob.val, st.Status().Calibration)
+	}
+}
+
+func TestScheduledCalibration(t *testing.T) {
+	at := time.Now().Add(-time.Minute)
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80,
+		CalibrateAt: &at, CalibrateEvery: 30 * 24 * time.Hour})
+	bat := &fakeBattery{pct: 80, state: monitor.BatteryStateCharging}
+	knob := &fakeKnob{val: 1}
+	c := &Controller{State: st, Battery: bat, Knob: knob,
+		Gauge: func() (float64, int, error) { return 92.5, 140, nil }}
+	ctx := context.Background()
+
+	c.Step(ctx)
+	if knob.val != backend.ForceDischarge || st.Config().CalibrateAt != nil {
+		t.Fatalf("scheduled calibration not started: knob=%d at=%v", knob.val, st.Config().CalibrateAt)
+	}
+	if st.Status().Cycles != 140 {
+		t.Errorf("cycles = %d, want 140", st.Status().Cycles)
+	}
+
+	bat.pct = 20
+	c.Step(ctx)
+	bat.pct, bat.state = 100, monitor.BatteryStateFull
+	c.Step(ctx)
+
+	cfg := st.Config()
+	last, ok := cfg.LastCalibration()
+	if !ok || last.Result != "done" || last.Health != 92.5 || last.Cycles != 140 {
+		t.Fatalf("history = %+v", cfg.Calibrations)
+	}
+	if cfg.CalibrateAt == nil || !cfg.CalibrateAt.Equal(last.Time.Add(cfg.CalibrateEvery)) {
+		t.Errorf("next calibration = %v, want %v after the last", cfg.CalibrateAt, cfg.CalibrateEvery)
+	}
+
+	if err := st.StartCalibration(0); err != nil {
+		t.Fatal(err)
+	}
+	st.AbortCalibration()
+	if last, _ := st.Config().LastCalibration(); last.Result != "aborted on request" {
+		t.Errorf("abort recorded as %+v", last)
+	}
+}
+
+func TestParseCalibrationTime(t *testing.T) {
+	now := time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC) // Wednesday
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"tomorrow", time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)},
+		{"weekend", time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)},
+		{"next-weekend", time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)},
+		{"Wednesday", time.Date(2024, 3, 13, 10, 0, 0, 0, time.UTC)},
+		{"fri", time.Date(2024, 3, 8, 10, 0, 0, 0, time.UTC)},
+		{"2024-04-01", time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)},
+		{"2024-04-01 21:30", time.Date(2024, 4, 1, 21, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseCalibrationTime(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseCalibrationTime(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"2024-03-01", "someday"} {
+		if _, err := ParseCalibrationTime(in, now); err == nil {
+			t.Errorf("ParseCalibrationTime(%q) accepted", in)
+		}
+	}
+
+	if d, err := ParseCalibrationInterval("monthly"); err != nil || d != 30*24*time.Hour {
+		t.Errorf("monthly = %v, %v", d, err)
+	}
+	if _, err := ParseCalibrationInterval("1h"); err == nil {
+		t.Error("hourly calibration accepted")
+	}
+}
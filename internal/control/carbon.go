@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"time"
+
+	"conservationDaemon/internal/carbon"
+	"conservationDaemon/internal/config"
+)
+
+// applyCarbon makes charging above the threshold happen in low-carbon
+// periods, finishing by the daily cfg.CarbonDeadline at the latest. An
+// explicit schedule keeps its own target time as the deadline.
+func applyCarbon(cfg config.Config, low func(now, until time.Time) []carbon.Period, now time.Time) config.Config {
+	if cfg.TargetTime == nil {
+		deadline := nextDeadline(now, cfg.CarbonDeadline)
+		cfg.TargetTime = &deadline
+	}
+	cfg.LowCarbon = nil
+	for _, p := range low(now, *cfg.TargetTime) {
+		cfg.LowCarbon = append(cfg.LowCarbon, config.Period{From: p.From, To: p.To})
+	}
+	return cfg
+}
+
+// nextDeadline returns the next occurrence of the time of day tod after now.
+func nextDeadline(now time.Time, tod time.Duration) time.Time {
+	y, m, d := now.Date()
+	t := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(tod)
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
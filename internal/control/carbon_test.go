@@ -0,0 +1,39 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/carbon"
+	"conservationDaemon/internal/config"
+)
+
+func TestApplyCarbon(t *testing.T) {
+	now := time.Date(2024, 3, 5, 22, 0, 0, 0, time.Local)
+	green := carbon.Period{From: now.Add(3 * time.Hour), To: now.Add(4 * time.Hour), Intensity: 60}
+	low := func(_, until time.Time) []carbon.Period {
+		if green.From.Before(until) {
+			return []carbon.Period{green}
+		}
+		return nil
+	}
+	cfg := config.Config{MaxPercent: 100, ConservationThreshold: 80, CarbonDeadline: 7 * time.Hour}
+
+	got := applyCarbon(cfg, low, now)
+	if got.TargetTime == nil || !got.TargetTime.Equal(now.Add(9*time.Hour)) || len(got.LowCarbon) != 1 {
+		t.Fatalf("applyCarbon = %+v", got)
+	}
+	// Waits outside the low-carbon period, charges inside it
+	if d := Decide(got, 85, 0, false, now); d.Want != 1 {
+		t.Errorf("high-carbon: %+v", d)
+	}
+	inside := now.Add(3*time.Hour + 10*time.Minute)
+	if d := Decide(applyCarbon(cfg, low, inside), 85, 1, false, inside); d.Want != 0 || d.Action != "disable_conservation_low_carbon_charging" {
+		t.Errorf("low-carbon: %+v", d)
+	}
+	// The deadline still forces charging in time
+	late := now.Add(8*time.Hour + 50*time.Minute)
+	if d := Decide(applyCarbon(cfg, low, late), 85, 1, false, late); d.Want != 0 {
+		t.Errorf("deadline: %+v", d)
+	}
+}
@@ -0,0 +1,492 @@
+// SPDX-License-Identifier: MIT
+
+// Package control implements the daemon's control loop.
+package control
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/calendar"
+	"conservationDaemon/internal/carbon"
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/geo"
+	"conservationDaemon/internal/logging"
+	"conservationDaemon/internal/monitor"
+)
+
+// BatterySource reports the battery charge percentage and state.
+type BatterySource interface {
+	Read(ctx context.Context) (float64, monitor.BatteryState, error)
+}
+
+// Knob reads and writes the conservation setting.
+type Knob interface {
+	Read() (int, error)
+	Write(v int) error
+	ValueString(v int) string
+}
+
+// CurrentLimiter caps the battery charge current.
+type CurrentLimiter interface {
+	ReadMA() (int, error)
+	WriteMA(ma int) error
+	DefaultMA() int
+}
+
+// PlatformProfile sets the ACPI platform_profile.
+type PlatformProfile interface {
+	Read() (string, error)
+	Write(name string) error
+}
+
+// BatterySet manages the other batteries of multi-battery machines.
+type BatterySet interface {
+	Apply(dryRun bool) error
+	Reset(dryRun bool) error // undo Apply's charge order on exit
+}
+
+// Controller ties a battery source and a conservation knob to shared state.
+type Controller struct {
+	State   *State
+	Battery BatterySource
+	Knob    Knob
+	KnobID  string // knob path, for log messages
+
+	// Display reports whether an external display is connected (auto mode).
+	Display func() (bool, error)
+
+	// Events, if set, triggers an immediate control step whenever the
+	// battery or AC state changes.
+	Events <-chan struct{}
+
+	// OnBattery reports whether the system runs on battery (low-power mode).
+	OnBattery func(ctx context.Context) (bool, error)
+
+	// Current, if set, enforces Config.ChargeCurrentMA.
+	Current CurrentLimiter
+
+	// Sessions lists active logind sessions (multi-user policies).
+	Sessions func(ctx context.Context) ([]monitor.Session, error)
+
+	// Location returns the latest location fix (location profiles).
+	Location func() (geo.Fix, bool)
+
+	// Trip returns the calendar trip in progress or coming up, if any.
+	Trip func(now time.Time) (calendar.Event, bool)
+
+	// LowCarbon returns the low-carbon forecast periods between now and
+	// until (carbon-aware charging).
+	LowCarbon func(now, until time.Time) []carbon.Period
+
+	// Tariff returns cheap-rate windows from -tariff-command, on top of
+	// Config.OffPeak.
+	Tariff func() []config.Window
+
+	// Platform, if set, follows Config.PlatformProfiles.
+	Platform PlatformProfile
+
+	// Batteries, if set, applies per-battery thresholds and charge order.
+	Batteries BatterySet
+
+	// Levels, if set, follows Config.ConservationThreshold and
+	// StartThreshold, which threshold presets change at runtime.
+	Levels *backend.Levels
+
+	// Health returns full capacity as a percentage of design capacity
+	// (health-adaptive max).
+	Health func() (float64, error)
+
+	// Gauge returns full capacity as a percentage of design capacity and the
+	// cycle count (calibration history).
+	Gauge func() (float64, int, error)
+
+	// Temperature returns the battery temperature in °C (Config.TempLimit).
+	Temperature func() (float64, error)
+
+	// Level, if set, reads the end threshold a percentage knob holds at, so
+	// another tool moving it (from 80 to 60, say) is an external change
+	// too.
+	Level func() (int, error)
+
+	// Refresh, if set, re-applies a software hold (charge_behaviour) every
+	// step while conserving: the battery level moves it, not a write.
+	Refresh func() error
+
+	temp tempTracker
+
+	lastKnob  int  // knob value last read or written by Step
+	lastLevel int  // Level at the same time
+	lastKnown bool // lastKnob is valid
+}
+
+// Run performs a control step every interval, and on every event, until ctx
+// is cancelled. In low-power mode periodic polling is suspended while on
+// battery with conservation settled; only events wake the loop then. A
+// PollInterval changed at runtime replaces interval from the next step.
+func (c *Controller) Run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	suspended := false
+	for {
+		settled := c.Step(ctx)
+		if iv := c.State.Config().PollInterval; iv > 0 && iv != interval {
+			interval = iv
+			t.Reset(interval)
+		}
+
+		if c.canSuspend(ctx, settled) {
+			if !suspended {
+				logging.Logf("low-power: on battery and settled, polling suspended until the next battery/AC event")
+				suspended = true
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-c.Events:
+			}
+			t.Reset(interval)
+			continue
+		}
+		if suspended {
+			logging.Logf("low-power: polling resumed")
+			suspended = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		case <-c.Events:
+		}
+	}
+}
+
+// canSuspend reports whether periodic polling may stop until the next event.
+func (c *Controller) canSuspend(ctx context.Context, settled bool) bool {
+	if !settled || c.Events == nil || c.OnBattery == nil || !c.State.Config().LowPower {
+		return false
+	}
+	onBat, err := c.OnBattery(ctx)
+	if err != nil {
+		logging.Warnf("read on-battery error: %v", err)
+		return false
+	}
+	return onBat
+}
+
+// Step reads the battery and knob, decides and applies the desired state.
+// It reports whether the knob already matched the desired state.
+func (c *Controller) Step(ctx context.Context) bool {
+	now := time.Now()
+	c.State.fireRules(now)
+
+	// Snapshot thresholds under lock
+	cfg := c.State.Config()
+
+	pct, state, err := c.Battery.Read(ctx)
+	if err != nil {
+		c.State.setError(err)
+		logging.Warnf("read upower error: %v", err)
+		logging.Event("read_failed", map[string]any{"source": "battery", "error": err.Error()})
+		return false
+	}
+	cur, err := c.Knob.Read()
+	if err != nil {
+		c.State.setError(err)
+		logging.Warnf("read cons error: %v", err)
+		logging.Event("read_failed", map[string]any{"source": "knob", "knob": c.KnobID, "error": err.Error()})
+		return false
+	}
+	level := c.readLevel()
+	if paused, until := c.State.pausedAt(now); paused {
+		// Changes made while paused are the user's, not external ones
+		c.externalChange(cur, level)
+		reason := "paused until resumed"
+		if !until.IsZero() {
+			reason = "paused until " + until.Format("15:04")
+		}
+		logging.Debugf("%s: not writing %s", reason, c.KnobID)
+		c.State.setReason(reason)
+		c.State.publish(pct, state, cur)
+		// Not settled while a timed pause runs, so low-power mode keeps
+		// polling and notices the end
+		return until.IsZero()
+	}
+
+	var notes []string // overlays shaping the decision, for the status reason
+	trip := false
+	if c.Trip != nil {
+		var e calendar.Event
+		if e, trip = c.Trip(now); trip {
+			cfg = applyTrip(cfg, e, now)
+			logging.Logf("trip mode for %q: %.1f%% by %s", e.Summary, cfg.MaxPercent, e.Start.Format("2006-01-02 15:04"))
+			notes = append(notes, fmt.Sprintf("trip mode for %q active until %s", e.Summary, e.End.Format("15:04")))
+		}
+	}
+
+	if !trip && cfg.MultiUser && c.Sessions != nil {
+		sessions, err := c.Sessions(ctx)
+		if err != nil {
+			logging.Warnf("list sessions error: %v", err)
+		}
+		var policy string
+		cfg, policy = applyUserPolicy(cfg, sessions)
+		c.State.setPolicy(policy)
+		if policy != "global" {
+			notes = append(notes, "policy of "+policy)
+		}
+	}
+	away := false
+	if !trip && c.Location != nil {
+		fix, ok := c.Location()
+		if cfg, away = applyLocation(cfg, fix, ok); away {
+			logging.Logf("away from configured places: target %.1f%%", cfg.MaxPercent)
+			notes = append(notes, "away from configured places")
+		}
+	}
+
+	if !trip && c.LowCarbon != nil {
+		cfg = applyCarbon(cfg, c.LowCarbon, now)
+	}
+	if c.Tariff != nil {
+		cfg.OffPeak = append(slices.Clip(cfg.OffPeak), c.Tariff()...)
+	}
+
+	if cfg.HealthAdaptive && c.Health != nil {
+		health, err := c.Health()
+		if err != nil {
+			logging.Warnf("read battery health error: %v", err)
+		}
+		var capped bool
+		if cfg, capped = applyHealth(cfg, health); capped {
+			logging.Logf("battery health %.1f%% below %.1f%%: target capped at %.1f%%", health, cfg.HealthBelow, cfg.MaxPercent)
+			notes = append(notes, fmt.Sprintf("health %.1f%% < %g%%", health, cfg.HealthBelow))
+		}
+		c.State.setHealth(health, capped)
+	}
+
+	// Over trip mode and the policies: the user asked for these thresholds
+	// for a while
+	ov, overridden := c.State.overrideAt(now)
+	if overridden {
+		cfg = ov.apply(cfg)
+		notes = append(notes, "override until "+ov.Until.Format("15:04"))
+	}
+
+	// Over the health cap, which is only a preference, but not the
+	// temperature one
+	full := c.State.chargingFull(pct, state)
+	if full {
+		cfg = applyFull(cfg)
+		notes = append(notes, "charging to full once")
+	}
+
+	if cfg.TempLimit > 0 && c.Temperature != nil {
+		if temp, err := c.Temperature(); err != nil {
+			logging.Warnf("read battery temperature error: %v", err)
+		} else {
+			var capped bool
+			if cfg, capped = applyTemperature(cfg, c.temp.update(cfg, temp, now)); capped {
+				logging.Logf("battery at %.1f°C, above %.1f°C: target capped at %.1f%%", temp, cfg.TempLimit, cfg.MaxPercent)
+				notes = append(notes, fmt.Sprintf("battery hot at %.1f°C", temp))
+			}
+			c.State.setTemp(temp, capped)
+		}
+	}
+
+	if c.externalChange(cur, level) {
+		switch cfg.ExternalPolicy {
+		case "adopt":
+			cfg = c.adoptExternal(cur, level)
+		case "ask":
+			c.askExternal(cur, level)
+		}
+	}
+
+	// Determine base desired state from auto mode
+	extConn := false
+	if cfg.Auto && c.Display != nil {
+		extConn, err = c.Display()
+		if err != nil {
+			logging.Warnf("check external display error: %v", err)
+		}
+	}
+
+	d := Decide(cfg, pct, cur, extConn, now)
+	if c.Gauge != nil {
+		if _, cycles, err := c.Gauge(); err == nil {
+			c.State.setCycles(cycles)
+		}
+	}
+	if cfg.CalibrateAt != nil && !now.Before(*cfg.CalibrateAt) && c.State.calibration().phase == "" {
+		c.startScheduledCalibration()
+	}
+	if cal := c.State.calibration(); cal.phase != "" {
+		d = c.calibrate(cal, d, pct, state, now)
+	}
+	if cfg.TargetTime != nil {
+		logging.Debugf("schedule mode: target=%.1f%% at %s, current=%.1f%%, start_time=%s, level_reached=%t",
+			cfg.MaxPercent, cfg.TargetTime.Format("2006-01-02 15:04"), pct, d.StartTime.Format("15:04"), d.LevelReached)
+		if d.ClearSchedule && !d.LevelReached {
+			logging.Logf("target time passed without reaching level, clearing schedule")
+		}
+	}
+	// Trip mode, full charges and overrides are overlays: their progress
+	// must not touch the stored schedule
+	if overridden && !full {
+		c.State.overrideProgress(d)
+	}
+	target := cfg.MaxPercent
+	c.State.Update(func(cfg *config.Config) error {
+		if trip || full || overridden {
+			return nil
+		}
+		changed := false
+		// Reaching a target the policies, the location, health or heat
+		// lowered isn't reaching the stored one
+		if d.LevelReached && !cfg.LevelReached && target == cfg.MaxPercent {
+			cfg.LevelReached = true
+			changed = true
+		}
+		if d.ClearSchedule && cfg.TargetTime != nil {
+			cfg.TargetTime = nil
+			changed = true
+		}
+		// Persist so a restart doesn't resume a finished schedule
+		if changed && cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+
+	logging.Info("decision", "pct", pct, "state", state.String(), "conservation", cur, "action", d.Action,
+		"target", cfg.MaxPercent, "level_reached", d.LevelReached, "knob", c.KnobID)
+	logging.Event("decision", map[string]any{
+		"pct": pct, "state": state.String(), "conservation": cur, "want": d.Want,
+		"action": d.Action, "target": cfg.MaxPercent, "level_reached": d.LevelReached,
+	})
+
+	reason := explain(cfg, d, pct, notes...)
+	// New levels only reach the firmware with a write
+	relevel := c.Levels != nil && c.Levels.Set(int(cfg.ConservationThreshold), int(cfg.StartThreshold)) && d.Want == 1
+	// ...and so does a level another tool moved
+	drift := c.Level != nil && d.Want == 1 && cur == 1 && level != int(cfg.ConservationThreshold)
+	cons := d.Want
+	// Below the safety floor the battery charges, decision or not
+	if pct >= cfg.SafetyFloor && c.State.externalPending(d.Want == cur && !drift) {
+		logging.Logf("external change to %s pending a decision: not writing %s", c.describe(cur, level), c.KnobID)
+		cons = cur
+		reason = fmt.Sprintf("external change to %s pending a decision", c.describe(cur, level))
+	} else if d.Want != cur || relevel || drift {
+		wantStr := c.Knob.ValueString(d.Want)
+		if cfg.DryRun {
+			logging.Logf("[dry-run] would write %s to %s", wantStr, c.KnobID)
+		} else {
+			if err := c.writeKnob(ctx, d.Want); err != nil {
+				c.State.recordWriteFailure(err)
+				logging.Errorf("write cons error: %v", err)
+				if d.Want == backend.ForceDischarge {
+					c.State.abortCalibration("force-discharge failed: " + err.Error())
+				}
+				logging.Event("write_failed", map[string]any{"knob": c.KnobID, "value": wantStr, "error": err.Error()})
+				cons = cur
+			} else {
+				c.lastKnob, c.lastLevel = d.Want, c.readLevel()
+				logging.Logf("conservation set to %s", wantStr)
+				logging.Event("conservation_changed", map[string]any{"knob": c.KnobID, "value": wantStr})
+			}
+		}
+	} else if d.Want == 1 && c.Refresh != nil && !cfg.DryRun {
+		if err := c.Refresh(); err != nil {
+			logging.Warnf("refresh %s: %v", c.KnobID, err)
+		}
+	}
+
+	if c.Current != nil {
+		c.applyCurrent(cfg)
+	}
+	if c.Batteries != nil {
+		if err := c.Batteries.Apply(cfg.DryRun); err != nil {
+			logging.Warnf("batteries: %v", err)
+		}
+	}
+	if c.Platform != nil {
+		mode := "charge"
+		switch {
+		case trip:
+			mode = "trip"
+		case away:
+			mode = "away"
+		case d.Want == 1 || d.Want == backend.Storage:
+			mode = "conserve"
+		}
+		c.applyPlatform(cfg, mode)
+	}
+
+	// Publish new measurements
+	c.State.setReason(reason)
+	c.State.publish(pct, state, cons)
+	// Not settled while an override runs, so low-power mode keeps polling
+	// and notices the end
+	return d.Want == cur && !overridden
+}
+
+// applyCurrent makes the charge current limit match the configuration,
+// restoring the platform default when no cap is set.
+func (c *Controller) applyCurrent(cfg config.Config) {
+	want := cfg.ChargeCurrentMA
+	if want <= 0 {
+		want = c.Current.DefaultMA()
+	}
+	cur, err := c.Current.ReadMA()
+	if err != nil {
+		logging.Warnf("read charge current error: %v", err)
+		return
+	}
+	if cur == want {
+		return
+	}
+	if cfg.DryRun {
+		logging.Logf("[dry-run] would limit charge current to %d mA", want)
+		return
+	}
+	if err := c.Current.WriteMA(want); err != nil {
+		logging.Errorf("write charge current error: %v", err)
+		return
+	}
+	logging.Logf("charge current limit set to %d mA", want)
+	logging.Event("charge_current_changed", map[string]any{"ma": want})
+}
+
+// applyPlatform sets the platform_profile tied to mode, if one is configured.
+func (c *Controller) applyPlatform(cfg config.Config, mode string) {
+	want, ok := cfg.PlatformProfiles[mode]
+	if !ok {
+		return
+	}
+	cur, err := c.Platform.Read()
+	if err != nil {
+		logging.Warnf("read platform_profile error: %v", err)
+		return
+	}
+	if cur == want {
+		return
+	}
+	if cfg.DryRun {
+		logging.Logf("[dry-run] would set platform_profile to %s (%s)", want, mode)
+		return
+	}
+	if err := c.Platform.Write(want); err != nil {
+		logging.Errorf("write platform_profile error: %v", err)
+		return
+	}
+	logging.Logf("platform_profile set to %s (%s)", want, mode)
+	logging.Event("platform_profile_changed", map[string]any{"profile": want, "mode": mode})
+}
@@ -0,0 +1,590 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/monitor"
+)
+
+type fakeBattery struct {
+	pct   float64
+	state monitor.BatteryState
+	err   error
+	reads atomic.Int32
+}
+
+func (b *fakeBattery) Read(context.Context) (float64, monitor.BatteryState, error) {
+	b.reads.Add(1)
+	return b.pct, b.state, b.err
+}
+
+type fakeKnob struct {
+	val    int
+	writes int
+}
+
+func (k *fakeKnob) Read() (int, error)       { return k.val, nil }
+func (k *fakeKnob) Write(v int) error        { k.val = v; k.writes++; return nil }
+func (k *fakeKnob) ValueString(v int) string { return strconv.Itoa(v) }
+
+func TestStepPresetRewritesLevels(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, PercentKnob: true})
+	knob := &fakeKnob{val: 1}
+	levels := &backend.Levels{}
+	levels.Set(80, 0)
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 70}, Knob: knob, Levels: levels}
+	ctx := context.Background()
+
+	c.Step(ctx)
+	if knob.writes != 0 {
+		t.Fatalf("%d writes with unchanged levels", knob.writes)
+	}
+	p, _ := config.FindPreset("lifespan")
+	if _, err := st.ApplyPreset(p); err != nil {
+		t.Fatal(err)
+	}
+	c.Step(ctx)
+	if knob.writes != 1 {
+		t.Fatalf("%d writes after the preset changed the levels, want 1", knob.writes)
+	}
+	if h, s := levels.Set(60, 55), st.Config().MaxPercent; h || s != 60 {
+		t.Errorf("levels not 60/55 or max %g not 60", s)
+	}
+}
+
+func TestExit(t *testing.T) {
+	tests := []struct {
+		onExit   string
+		cur, val int
+	}{
+		{"keep", 1, 1},
+		{"", 0, 0},
+		{"on", 0, 1},
+		{"off", 1, 0},
+		{"keep", backend.ForceDischarge, 1},
+		{"off", backend.ForceDischarge, 0},
+	}
+	for _, tt := range tests {
+		knob := &fakeKnob{val: tt.cur}
+		c := &Controller{State: NewState(config.Config{OnExit: tt.onExit}), Knob: knob}
+		c.Exit()
+		if knob.val != tt.val {
+			t.Errorf("on-exit %q from %d: knob = %d, want %d", tt.onExit, tt.cur, knob.val, tt.val)
+		}
+	}
+}
+
+type fakeCurrent struct{ ma, def int }
+
+func (f *fakeCurrent) ReadMA() (int, error) { return f.ma, nil }
+func (f *fakeCurrent) WriteMA(ma int) error { f.ma = ma; return nil }
+func (f *fakeCurrent) DefaultMA() int       { return f.def }
+
+func TestExitRestoresCurrent(t *testing.T) {
+	for _, dry := range []bool{false, true} {
+		cur := &fakeCurrent{ma: 1000, def: 3000}
+		c := &Controller{State: NewState(config.Config{OnExit: "keep", DryRun: dry}), Knob: &fakeKnob{}, Current: cur}
+		c.Exit()
+		if want := map[bool]int{false: 3000, true: 1000}[dry]; cur.ma != want {
+			t.Errorf("dry run %t: charge current %d mA after exit, want %d", dry, cur.ma, want)
+		}
+	}
+}
+
+type fakeBatteries struct{ applies, resets int }
+
+func (f *fakeBatteries) Apply(bool) error { f.applies++; return nil }
+func (f *fakeBatteries) Reset(bool) error { f.resets++; return nil }
+
+func TestExitResetsBatteries(t *testing.T) {
+	for onExit, want := range map[string]int{"keep": 0, "on": 1, "off": 1} {
+		bats := &fakeBatteries{}
+		c := &Controller{State: NewState(config.Config{OnExit: onExit}), Knob: &fakeKnob{}, Batteries: bats}
+		c.Exit()
+		if bats.resets != want {
+			t.Errorf("on-exit %s: %d resets, want %d", onExit, bats.resets, want)
+		}
+	}
+}
+
+func TestStepWritesKnob(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 90, ConservationThreshold: 80})
+	knob := &fakeKnob{}
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 91, state: monitor.BatteryStateCharging}, Knob: knob}
+
+	c.Step(context.Background())
+
+	if knob.val != 1 || knob.writes != 1 {
+		t.Fatalf("knob = %d after %d writes, want 1 after 1", knob.val, knob.writes)
+	}
+	s := st.Status()
+	if s.Pct != 91 || s.Cons != 1 || s.BatteryState != monitor.BatteryStateCharging {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if !s.Config.LevelReached {
+		t.Error("LevelReached not recorded")
+	}
+	if s.Reason != "pct 91.0 ≥ max 90" {
+		t.Errorf("reason = %q", s.Reason)
+	}
+}
+
+func TestStepDryRun(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, DryRun: true})
+	knob := &fakeKnob{}
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 50}, Knob: knob}
+
+	c.Step(context.Background())
+
+	if knob.writes != 0 {
+		t.Errorf("dry run wrote the knob %d times", knob.writes)
+	}
+}
+
+func TestStepRefresh(t *testing.T) {
+	for _, dry := range []bool{false, true} {
+		st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, PercentKnob: true, DryRun: dry})
+		knob := &fakeKnob{val: 1}
+		refreshes := 0
+		c := &Controller{State: st, Battery: &fakeBattery{pct: 70}, Knob: knob,
+			Refresh: func() error { refreshes++; return nil }}
+
+		c.Step(context.Background())
+
+		if want := map[bool]int{false: 1, true: 0}[dry]; refreshes != want || knob.writes != 0 {
+			t.Errorf("dry run %t: %d refreshes, %d writes; want %d, 0", dry, refreshes, knob.writes, want)
+		}
+	}
+}
+
+func TestStepBatteryError(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80})
+	knob := &fakeKnob{}
+	c := &Controller{State: st, Battery: &fakeBattery{err: errors.New("no upower")}, Knob: knob}
+
+	c.Step(context.Background())
+
+	if knob.writes != 0 {
+		t.Error("knob written despite read error")
+	}
+	if got := st.Status().LastErr; got != "no upower" {
+		t.Errorf("LastErr = %q", got)
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80})
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 50}, Knob: &fakeKnob{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx, time.Hour) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestRunLowPowerWaitsForEvents(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, LowPower: true})
+	bat := &fakeBattery{pct: 60, state: monitor.BatteryStateDischarge}
+	events := make(chan struct{}, 1)
+	c := &Controller{
+		State:     st,
+		Battery:   bat,
+		Knob:      &fakeKnob{val: 1},
+		Events:    events,
+		OnBattery: func(context.Context) (bool, error) { return true, nil },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, 5*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	if n := bat.reads.Load(); n != 1 {
+		t.Fatalf("polled %d times while suspended, want 1", n)
+	}
+
+	events <- struct{}{}
+	deadline := time.Now().Add(2 * time.Second)
+	for bat.reads.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("event did not trigger a step")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStepFollowsExternalChange(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, ExternalPolicy: "adopt"})
+	knob := &fakeKnob{}
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 70}, Knob: knob}
+
+	c.Step(context.Background())
+	if knob.val != 1 {
+		t.Fatalf("knob = %d, want 1", knob.val)
+	}
+
+	// Another tool turns conservation off: the daemon must not revert it
+	knob.val = 0
+	c.Step(context.Background())
+	if knob.val != 0 {
+		t.Errorf("external change reverted")
+	}
+	if got := st.Config().MaxPercent; got != 100 {
+		t.Errorf("MaxPercent = %.1f, want 100", got)
+	}
+
+	// Without the option the daemon keeps enforcing its own setting
+	st = NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80})
+	c = &Controller{State: st, Battery: &fakeBattery{pct: 70}, Knob: knob}
+	c.Step(context.Background())
+	knob.val = 0
+	c.Step(context.Background())
+	if knob.val != 1 {
+		t.Errorf("external change not reverted with the default policy")
+	}
+}
+
+// levelKnob is a percentage knob: on holds at its level, which Write sets
+// to the threshold.
+type levelKnob struct {
+	fakeKnob
+	st    *State
+	level int
+}
+
+func (k *levelKnob) Write(v int) error {
+	k.fakeKnob.Write(v)
+	k.level = 100
+	if v == 1 {
+		k.level = int(k.st.Config().ConservationThreshold)
+	}
+	return nil
+}
+
+func (k *levelKnob) Level() (int, error) { return k.level, nil }
+
+func TestStepExternalLevelChange(t *testing.T) {
+	for _, policy := range []string{"adopt", "enforce"} {
+		st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, PercentKnob: true, ExternalPolicy: policy})
+		knob := &levelKnob{st: st}
+		c := &Controller{State: st, Battery: &fakeBattery{pct: 70}, Knob: knob, Levels: &backend.Levels{}, Level: knob.Level}
+		c.Step(context.Background())
+		if knob.val != 1 || knob.level != 80 {
+			t.Fatalf("%s: knob %d at %d", policy, knob.val, knob.level)
+		}
+
+		// A desktop's battery settings move the limit to 60
+		knob.level = 60
+		c.Step(context.Background())
+		cfg := st.Config()
+		switch policy {
+		case "adopt":
+			if cfg.ConservationThreshold != 60 || cfg.MaxPercent != 60 || knob.level != 60 {
+				t.Errorf("adopt: threshold %.0f, max %.0f, level %d", cfg.ConservationThreshold, cfg.MaxPercent, knob.level)
+			}
+		default:
+			if cfg.ConservationThreshold != 80 || knob.level != 80 {
+				t.Errorf("enforce: threshold %.0f, level %d", cfg.ConservationThreshold, knob.level)
+			}
+		}
+		// Settled: nothing more to write
+		writes := knob.writes
+		c.Step(context.Background())
+		if knob.writes != writes {
+			t.Errorf("%s: wrote again once settled", policy)
+		}
+	}
+}
+
+func TestToggle(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80})
+	if cfg := Toggle(st); cfg.MaxPercent != 100 {
+		t.Errorf("toggle from conserving: max=%.0f", cfg.MaxPercent)
+	}
+	if cfg := Toggle(st); cfg.MaxPercent != 80 {
+		t.Errorf("toggle back: max=%.0f", cfg.MaxPercent)
+	}
+}
+
+type fakeProfile struct{ name string }
+
+func (p *fakeProfile) Read() (string, error)   { return p.name, nil }
+func (p *fakeProfile) Write(name string) error { p.name = name; return nil }
+
+func TestStepPlatformProfile(t *testing.T) {
+	st := NewState(config.Config{
+		MaxPercent: 80, ConservationThreshold: 80,
+		PlatformProfiles: map[string]string{"conserve": "low-power", "charge": "balanced"},
+	})
+	prof := &fakeProfile{name: "performance"}
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 70}, Knob: &fakeKnob{}, Platform: prof}
+
+	c.Step(context.Background())
+	if prof.name != "low-power" {
+		t.Errorf("conserving: profile %q", prof.name)
+	}
+	st.Update(func(cfg *config.Config) error { cfg.MaxPercent = 100; return nil })
+	c.Step(context.Background())
+	if prof.name != "balanced" {
+		t.Errorf("charging: profile %q", prof.name)
+	}
+}
+
+func TestStepAsksAboutExternalChange(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, ExternalPolicy: "ask"})
+	knob := &fakeKnob{}
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 70}, Knob: knob}
+	c.Step(context.Background())
+
+	// Paused: the external value stays until the user decides
+	knob.val = 0
+	c.Step(context.Background())
+	c.Step(context.Background())
+	if knob.val != 0 || st.Status().External == "" {
+		t.Fatalf("knob = %d, pending = %q", knob.val, st.Status().External)
+	}
+
+	if _, err := st.ResolveExternal(false); err != nil {
+		t.Fatal(err)
+	}
+	c.Step(context.Background())
+	if knob.val != 1 {
+		t.Errorf("enforce did not restore the knob")
+	}
+
+	knob.val = 0
+	c.Step(context.Background())
+	cfg, err := st.ResolveExternal(true)
+	if err != nil || cfg.MaxPercent != 100 {
+		t.Fatalf("adopt: %+v, %v", cfg, err)
+	}
+	c.Step(context.Background())
+	if knob.val != 0 {
+		t.Errorf("adopted change reverted")
+	}
+	if _, err := st.ResolveExternal(true); err == nil {
+		t.Error("resolved with nothing pending")
+	}
+}
+
+func TestStepExternalPendingBelowFloor(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, SafetyFloor: 15, ExternalPolicy: "ask"})
+	knob := &fakeKnob{}
+	bat := &fakeBattery{pct: 70}
+	c := &Controller{State: st, Battery: bat, Knob: knob}
+	c.Step(context.Background())
+
+	// Another tool turns conservation back on while the daemon charges
+	st.Update(func(cfg *config.Config) error { cfg.MaxPercent = 100; return nil })
+	c.Step(context.Background())
+	knob.val = 1
+	c.Step(context.Background())
+	if knob.val != 1 || st.Status().External == "" {
+		t.Fatalf("knob = %d, pending = %q", knob.val, st.Status().External)
+	}
+
+	bat.pct = 10
+	c.Step(context.Background())
+	if knob.val != 0 {
+		t.Error("held below the safety floor while a decision was pending")
+	}
+}
+
+func TestStepPaused(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, ExternalPolicy: "adopt"})
+	knob := &fakeKnob{}
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 85}, Knob: knob}
+	ctx := context.Background()
+
+	if _, err := st.Pause(0); err != nil {
+		t.Fatal(err)
+	}
+	if settled := c.Step(ctx); !settled || knob.writes != 0 {
+		t.Fatalf("paused step: settled %t, %d writes", settled, knob.writes)
+	}
+	if s := st.Status(); !s.Paused || s.Pct != 85 || s.Reason != "paused until resumed" {
+		t.Errorf("status while paused: %+v", s)
+	}
+	if err := st.StartCalibration(0); err == nil {
+		t.Error("calibration started while paused")
+	}
+
+	// The user flips the knob by hand: not an external change to adopt
+	knob.val = 0
+	c.Step(ctx)
+	if !st.Resume() || st.Resume() {
+		t.Error("Resume should report the pause once")
+	}
+	c.Step(ctx)
+	if knob.val != 1 || st.Config().MaxPercent != 80 {
+		t.Errorf("after resume: knob %d, max %g", knob.val, st.Config().MaxPercent)
+	}
+
+	// A timed pause ends by itself
+	if _, err := st.Pause(20 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	knob.val = 0
+	if settled := c.Step(ctx); settled {
+		t.Error("timed pause reported settled")
+	}
+	time.Sleep(25 * time.Millisecond)
+	c.Step(ctx)
+	if paused, _ := st.Paused(); paused || knob.val != 1 {
+		t.Errorf("timed pause: paused %t, knob %d", paused, knob.val)
+	}
+}
+
+func TestStepOverride(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80})
+	knob := &fakeKnob{val: 1}
+	bat := &fakeBattery{pct: 85}
+	c := &Controller{State: st, Battery: bat, Knob: knob}
+	ctx := context.Background()
+
+	st.SetOverride(Override{Max: 90, Until: time.Now().Add(30 * time.Millisecond)})
+	if settled := c.Step(ctx); settled || knob.val != 0 {
+		t.Errorf("override: settled %t, knob %d", settled, knob.val)
+	}
+	if s := st.Status(); s.Override == nil || s.Override.Max != 90 || s.Config.MaxPercent != 80 {
+		t.Errorf("status under override: %+v", s)
+	}
+
+	// The override keeps its own progress, away from the stored config
+	bat.pct = 90
+	c.Step(ctx)
+	bat.pct = 89
+	c.Step(ctx)
+	if knob.val != 1 || st.Config().LevelReached {
+		t.Errorf("override level reached: knob %d, stored level reached %t", knob.val, st.Config().LevelReached)
+	}
+
+	time.Sleep(35 * time.Millisecond)
+	bat.pct = 85
+	c.Step(ctx)
+	if s := st.Status(); s.Override != nil || knob.val != 1 {
+		t.Errorf("after the override: knob %d, override %+v", knob.val, s.Override)
+	}
+	if st.EndOverride() {
+		t.Error("EndOverride reported an override that had expired")
+	}
+}
+
+func TestScheduleRules(t *testing.T) {
+	rules, err := config.ParseRules("mon-fri 06:30 full, mon-fri 18:00 80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := NewState(config.Config{MaxPercent: 90, ConservationThreshold: 80, LevelReached: true, Schedule: rules})
+	at := func(day, hh, mm int) time.Time { return time.Date(2024, 3, day, hh, mm, 0, 0, time.Local) } // 2024-03-04 is a Monday
+
+	st.fireRules(at(5, 7, 0))
+	if cfg := st.Config(); cfg.MaxPercent != 100 || cfg.LevelReached || cfg.ScheduleFired == nil || !cfg.ScheduleFired.Equal(at(5, 6, 30)) {
+		t.Errorf("morning rule: %+v", cfg)
+	}
+
+	// A manual change holds until the next rule
+	st.Update(func(cfg *config.Config) error { cfg.MaxPercent = 85; return nil })
+	st.fireRules(at(5, 12, 0))
+	if cfg := st.Config(); cfg.MaxPercent != 85 {
+		t.Errorf("manual change undone before the next rule: %+v", cfg)
+	}
+	st.fireRules(at(5, 18, 1))
+	if cfg := st.Config(); cfg.MaxPercent != 80 {
+		t.Errorf("evening rule: %+v", cfg)
+	}
+}
+
+func TestSchedulePresets(t *testing.T) {
+	rules, err := config.ParseRules("weekdays 00:00 lifespan, weekend 00:00 balanced, sun 12:00 50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := NewState(config.Config{MaxPercent: 100, ConservationThreshold: 80, PercentKnob: true, LowThresholds: true, Schedule: rules})
+	at := func(day, hh, mm int) time.Time { return time.Date(2024, 3, day, hh, mm, 0, 0, time.Local) } // 2024-03-04 is a Monday
+
+	st.fireRules(at(8, 9, 0))
+	if cfg := st.Config(); cfg.Preset != "lifespan" || cfg.ConservationThreshold != 60 || cfg.MaxPercent != 60 {
+		t.Errorf("weekday preset: %+v", cfg)
+	}
+	st.fireRules(at(9, 0, 0))
+	if cfg := st.Config(); cfg.Preset != "balanced" || cfg.ConservationThreshold != 80 || cfg.MaxPercent != 80 {
+		t.Errorf("weekend preset: %+v", cfg)
+	}
+
+	// Below the balanced threshold: conserve at it
+	st.fireRules(at(10, 12, 0))
+	if cfg := st.Config(); cfg.MaxPercent != 80 || cfg.Preset != "balanced" {
+		t.Errorf("rule under the threshold: %+v", cfg)
+	}
+
+	// A preset under the storage level is skipped, and not tried again
+	st.Update(func(cfg *config.Config) error { cfg.Storage, cfg.StorageLevel = true, 70; return nil })
+	st.fireRules(at(11, 1, 0))
+	st.fireRules(at(11, 2, 0))
+	if cfg := st.Config(); cfg.Preset != "balanced" || cfg.ScheduleFired == nil || !cfg.ScheduleFired.Equal(at(11, 0, 0)) {
+		t.Errorf("preset under the storage level: %+v", cfg)
+	}
+}
+
+func TestStepChargeFull(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80, LevelReached: true})
+	knob := &fakeKnob{val: 1}
+	bat := &fakeBattery{pct: 85, state: monitor.BatteryStatePending}
+	c := &Controller{State: st, Battery: bat, Knob: knob}
+	ctx := context.Background()
+
+	c.Step(ctx)
+	if err := st.ChargeFull(); err != nil {
+		t.Fatal(err)
+	}
+	c.Step(ctx)
+	if s := st.Status(); knob.val != 0 || !s.ChargingFull || s.Config.MaxPercent != 80 || !s.Config.LevelReached {
+		t.Errorf("charging to full: knob %d, status %+v", knob.val, s)
+	}
+
+	// Thresholds come back once full
+	bat.pct, bat.state = 100, monitor.BatteryStateFull
+	c.Step(ctx)
+	if s := st.Status(); knob.val != 1 || s.ChargingFull {
+		t.Errorf("full: knob %d, charging full %t", knob.val, s.ChargingFull)
+	}
+	if err := st.ChargeFull(); err == nil {
+		t.Error("full charge started on a full battery")
+	}
+
+	// ...or once unplugged
+	bat.pct, bat.state = 90, monitor.BatteryStateCharging
+	c.Step(ctx)
+	if err := st.ChargeFull(); err != nil {
+		t.Fatal(err)
+	}
+	c.Step(ctx)
+	bat.state = monitor.BatteryStateDischarge
+	c.Step(ctx)
+	if s := st.Status(); knob.val != 1 || s.ChargingFull {
+		t.Errorf("unplugged: knob %d, charging full %t", knob.val, s.ChargingFull)
+	}
+	if err := st.ChargeFull(); err == nil {
+		t.Error("full charge started on battery")
+	}
+	if st.CancelFull() {
+		t.Error("CancelFull reported a charge that had ended")
+	}
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/config"
+)
+
+// Decision is the outcome of one control step.
+type Decision struct {
+	Want          int    // desired conservation value (0 or 1)
+	Action        string // short machine-friendly description for logs
+	LevelReached  bool   // new value of Config.LevelReached
+	ClearSchedule bool   // the target time should be dropped
+	StartTime     time.Time
+}
+
+// chargeDuration estimates how long charging by delta percent takes
+// (assuming 1 minute per 1%).
+func chargeDuration(delta float64) time.Duration {
+	return time.Duration(delta) * time.Minute
+}
+
+// Decide computes the desired conservation state from the configuration,
+// the battery percentage, the current knob value and whether an external
+// display is connected. Cheap-rate windows and low-carbon periods, if any,
+// decide when charging above the threshold happens, and charge windows
+// when any charging happens. Storage mode holds at the storage level
+// instead. Below the safety floor charging is always allowed, whatever the
+// mode, schedule, window or auto state says.
+func Decide(cfg config.Config, pct float64, cur int, extConn bool, now time.Time) Decision {
+	d := decide(cfg, pct, cur, extConn, now)
+	switch {
+	case cfg.Storage:
+		d.Want, d.Action = backend.Storage, "enable_storage_mode"
+	case len(cfg.OffPeak) > 0 || len(cfg.LowCarbon) > 0:
+		d = offPeak(cfg, d, pct, now)
+	}
+	if len(cfg.ChargeWindow) > 0 {
+		d = chargeWindow(cfg, d, now)
+	}
+	if pct < cfg.SafetyFloor {
+		d.Want, d.Action = 0, "disable_conservation_safety_floor"
+	}
+	return d
+}
+
+func decide(cfg config.Config, pct float64, cur int, extConn bool, now time.Time) Decision {
+	d := Decision{Want: cur, Action: "none", LevelReached: cfg.LevelReached}
+
+	// If max percentage is at or below conservation threshold, enable conservation
+	// BUT if auto mode is on, defer to the display connection status
+	if cfg.MaxPercent <= cfg.ConservationThreshold {
+		if cfg.Auto && !extConn {
+			d.Want, d.Action = 0, "disable_conservation_display_disconnected"
+		} else {
+			d.Want, d.Action = 1, "enable_conservation_threshold_mode"
+		}
+		return d
+	}
+
+	// Check if we've reached the target level
+	if !d.LevelReached && pct >= cfg.MaxPercent {
+		d.LevelReached = true
+	}
+
+	if cfg.TargetTime == nil {
+		// Immediate charging logic
+		switch {
+		case d.LevelReached:
+			d.Want, d.Action = 1, "enable_conservation_level_reached"
+		case cfg.Auto:
+			d.Want, d.Action = autoDecision(extConn)
+		default:
+			// Level not reached yet - disable conservation to charge
+			d.Want, d.Action = 0, "disable_conservation_charging_to_target"
+		}
+		return d
+	}
+
+	// Time-based charging logic
+	target := *cfg.TargetTime
+	d.StartTime = target.Add(-chargeDuration(cfg.MaxPercent - pct))
+
+	switch {
+	case d.LevelReached:
+		// Level reached - keep conservation enabled and clear schedule if target time passed
+		d.Want, d.Action = 1, "enable_conservation_level_reached"
+		if now.After(target) {
+			d.ClearSchedule = true
+			d.Action = "enable_conservation_schedule_completed"
+		}
+	case now.After(target):
+		// Target time passed but level not reached - clear schedule and apply immediate logic
+		d.ClearSchedule = true
+		if cfg.Auto {
+			d.Want, d.Action = autoDecision(extConn)
+		} else {
+			d.Want, d.Action = 0, "disable_conservation_immediate"
+		}
+	case now.After(d.StartTime):
+		// Time to start charging
+		d.Want, d.Action = 0, "disable_conservation_scheduled_charging"
+	case cfg.Auto && !extConn:
+		// Not time to charge yet, but auto mode with the monitor disconnected forces conservation off
+		d.Want, d.Action = 0, "disable_conservation_display_disconnected"
+	default:
+		// Either Auto mode with display connected, or normal schedule waiting
+		d.Want, d.Action = 1, "enable_conservation_waiting_for_schedule"
+	}
+	return d
+}
+
+func autoDecision(extConn bool) (int, string) {
+	if extConn {
+		return 1, "enable_conservation_display_connected"
+	}
+	return 0, "disable_conservation_display_disconnected"
+}
+
+// ParseTargetTime parses an HH:MM time relative to now. Times in the past
+// (or less than a minute away) refer to the next day.
+func ParseTargetTime(timeStr string, now time.Time) (time.Time, error) {
+	if timeStr == "now" {
+		return now, nil
+	}
+
+	t, err := time.Parse("15:04", timeStr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time must be in HH:MM format, got %s", timeStr)
+	}
+
+	// Set the date to today but with the specified time
+	target := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+
+	// If target time is in the past (or very close), assume user means tomorrow
+	if target.Before(now.Add(time.Minute)) {
+		target = target.Add(24 * time.Hour)
+	}
+
+	return target, nil
+}
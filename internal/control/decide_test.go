@@ -0,0 +1,158 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/config"
+)
+
+func TestDecide(t *testing.T) {
+	now := time.Date(2025, 3, 10, 6, 0, 0, 0, time.UTC)
+	at := func(h, m int) *time.Time {
+		t := time.Date(2025, 3, 10, h, m, 0, 0, time.UTC)
+		return &t
+	}
+	base := config.Config{MaxPercent: 90, ConservationThreshold: 80}
+
+	tests := []struct {
+		name      string
+		mutate    func(*config.Config)
+		pct       float64
+		extConn   bool
+		want      int
+		action    string
+		reached   bool
+		clearSchd bool
+	}{
+		{
+			name:   "threshold mode",
+			mutate: func(c *config.Config) { c.MaxPercent = 80 },
+			pct:    50, want: 1, action: "enable_conservation_threshold_mode",
+		},
+		{
+			name:   "threshold mode auto undocked",
+			mutate: func(c *config.Config) { c.MaxPercent = 80; c.Auto = true },
+			pct:    50, want: 0, action: "disable_conservation_display_disconnected",
+		},
+		{
+			name: "charging to target",
+			pct:  70, want: 0, action: "disable_conservation_charging_to_target",
+		},
+		{
+			name: "target reached",
+			pct:  90, want: 1, action: "enable_conservation_level_reached", reached: true,
+		},
+		{
+			name:   "level stays reached after drop",
+			mutate: func(c *config.Config) { c.LevelReached = true },
+			pct:    85, want: 1, action: "enable_conservation_level_reached", reached: true,
+		},
+		{
+			name:   "auto docked",
+			mutate: func(c *config.Config) { c.Auto = true },
+			pct:    70, extConn: true, want: 1, action: "enable_conservation_display_connected",
+		},
+		{
+			name:   "schedule waiting",
+			mutate: func(c *config.Config) { c.TargetTime = at(9, 0) },
+			pct:    70, want: 1, action: "enable_conservation_waiting_for_schedule",
+		},
+		{
+			name:   "schedule started",
+			mutate: func(c *config.Config) { c.TargetTime = at(6, 10) },
+			pct:    70, want: 0, action: "disable_conservation_scheduled_charging",
+		},
+		{
+			name:   "schedule missed",
+			mutate: func(c *config.Config) { c.TargetTime = at(5, 0) },
+			pct:    70, want: 0, action: "disable_conservation_immediate", clearSchd: true,
+		},
+		{
+			name:   "schedule completed",
+			mutate: func(c *config.Config) { c.TargetTime = at(5, 0); c.LevelReached = true },
+			pct:    88, want: 1, action: "enable_conservation_schedule_completed", reached: true, clearSchd: true,
+		},
+		{
+			name:   "schedule auto undocked",
+			mutate: func(c *config.Config) { c.TargetTime = at(9, 0); c.Auto = true },
+			pct:    70, want: 0, action: "disable_conservation_display_disconnected",
+		},
+		{
+			name:   "safety floor overrides threshold mode",
+			mutate: func(c *config.Config) { c.MaxPercent = 80; c.SafetyFloor = 15 },
+			pct:    10, want: 0, action: "disable_conservation_safety_floor",
+		},
+		{
+			name:   "safety floor overrides schedule",
+			mutate: func(c *config.Config) { c.TargetTime = at(9, 0); c.SafetyFloor = 15 },
+			pct:    14.9, want: 0, action: "disable_conservation_safety_floor",
+		},
+		{
+			name:   "storage mode overrides auto",
+			mutate: func(c *config.Config) { c.Storage = true; c.Auto = true },
+			pct:    70, extConn: false, want: backend.Storage, action: "enable_storage_mode",
+		},
+		{
+			name:   "storage mode overrides max",
+			mutate: func(c *config.Config) { c.Storage = true; c.MaxPercent = 100 },
+			pct:    95, want: backend.Storage, action: "enable_storage_mode",
+		},
+		{
+			name:   "safety floor overrides storage mode",
+			mutate: func(c *config.Config) { c.Storage = true; c.SafetyFloor = 15 },
+			pct:    10, want: 0, action: "disable_conservation_safety_floor",
+		},
+		{
+			name:   "above safety floor",
+			mutate: func(c *config.Config) { c.MaxPercent = 80; c.SafetyFloor = 15 },
+			pct:    15, want: 1, action: "enable_conservation_threshold_mode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			if tt.mutate != nil {
+				tt.mutate(&cfg)
+			}
+			d := Decide(cfg, tt.pct, 0, tt.extConn, now)
+			if d.Want != tt.want || d.Action != tt.action {
+				t.Errorf("got want=%d action=%s, expected want=%d action=%s", d.Want, d.Action, tt.want, tt.action)
+			}
+			if d.LevelReached != tt.reached {
+				t.Errorf("LevelReached = %t, expected %t", d.LevelReached, tt.reached)
+			}
+			if d.ClearSchedule != tt.clearSchd {
+				t.Errorf("ClearSchedule = %t, expected %t", d.ClearSchedule, tt.clearSchd)
+			}
+		})
+	}
+}
+
+func TestParseTargetTime(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+
+	got, err := ParseTargetTime("18:30", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 3, 10, 18, 30, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("later today: got %v, want %v", got, want)
+	}
+
+	got, err = ParseTargetTime("09:00", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 3, 11, 9, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("past time: got %v, want %v", got, want)
+	}
+
+	if got, _ := ParseTargetTime("now", now); !got.Equal(now) {
+		t.Errorf("now: got %v", got)
+	}
+	if _, err := ParseTargetTime("25:99", now); err == nil {
+		t.Error("expected error for invalid time")
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"context"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+)
+
+// Exit sets the knob as Config.OnExit asks when the daemon stops: "on" or
+// "off" force conservation, "keep" leaves whatever the last step wrote. A
+// calibration is never left force-discharging: conservation goes on
+// instead. A charge current cap is lifted back to the driver's default.
+// Unless OnExit is "keep", batteries held back for the charge order may
+// charge again.
+func (c *Controller) Exit() {
+	cfg := c.State.Config()
+	if c.Current != nil {
+		c.restoreCurrent(cfg)
+	}
+	if c.Batteries != nil && cfg.OnExit != "" && cfg.OnExit != "keep" {
+		if err := c.Batteries.Reset(cfg.DryRun); err != nil {
+			logging.Warnf("on exit: batteries: %v", err)
+		}
+	}
+	cur, err := c.Knob.Read()
+	if err != nil {
+		logging.Warnf("on exit: read cons error: %v", err)
+		return
+	}
+	want := cur
+	switch {
+	case cfg.OnExit == "on":
+		want = 1
+	case cfg.OnExit == "off":
+		want = 0
+	case cur == backend.ForceDischarge:
+		logging.Logf("on exit: stopping the calibration's force-discharge")
+		want = 1
+	}
+	if want == cur {
+		return
+	}
+	wantStr := c.Knob.ValueString(want)
+	if cfg.DryRun {
+		logging.Logf("[dry-run] on exit: would write %s to %s", wantStr, c.KnobID)
+		return
+	}
+	// ctx is already cancelled by now: write without it
+	if err := c.writeKnob(context.Background(), want); err != nil {
+		logging.Errorf("on exit: write cons error: %v", err)
+		logging.Event("write_failed", map[string]any{"knob": c.KnobID, "value": wantStr, "error": err.Error()})
+		return
+	}
+	logging.Logf("on exit: conservation set to %s", wantStr)
+	logging.Event("conservation_changed", map[string]any{"knob": c.KnobID, "value": wantStr, "source": "exit"})
+}
+
+// restoreCurrent writes the driver's default charge current back, so a cap
+// doesn't outlive the daemon.
+func (c *Controller) restoreCurrent(cfg config.Config) {
+	want := c.Current.DefaultMA()
+	cur, err := c.Current.ReadMA()
+	if err != nil || want <= 0 || cur == want {
+		return
+	}
+	if cfg.DryRun {
+		logging.Logf("[dry-run] on exit: would restore the charge current limit to %d mA", want)
+		return
+	}
+	if err := c.Current.WriteMA(want); err != nil {
+		logging.Errorf("on exit: write charge current error: %v", err)
+		return
+	}
+	logging.Logf("on exit: charge current limit restored to %d mA", want)
+	logging.Event("charge_current_changed", map[string]any{"ma": want, "source": "exit"})
+}
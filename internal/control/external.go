@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"errors"
+	"fmt"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+)
+
+// externalChange reports whether the knob or its level moved since the
+// daemon last saw or wrote them, which means another tool (e.g. Plasma's
+// PowerDevil battery settings) changed them behind our back.
+func (c *Controller) externalChange(cur, level int) bool {
+	changed := c.lastKnown && (cur != c.lastKnob || level != c.lastLevel)
+	c.lastKnob, c.lastLevel, c.lastKnown = cur, level, true
+	return changed
+}
+
+// readLevel returns the knob's level, 0 without Controller.Level. A failed
+// read counts as unchanged.
+func (c *Controller) readLevel() int {
+	if c.Level == nil {
+		return 0
+	}
+	level, err := c.Level()
+	if err != nil {
+		logging.Warnf("read %s level error: %v", c.KnobID, err)
+		return c.lastLevel
+	}
+	return level
+}
+
+// describe formats a knob value and level for messages.
+func (c *Controller) describe(cur, level int) string {
+	if c.Level != nil && cur == 1 {
+		return fmt.Sprintf("end=%d", level)
+	}
+	return c.Knob.ValueString(cur)
+}
+
+// adoptValue turns a knob value into configuration: conservation on means
+// "hold at the threshold", off means "charge to 100%". On a percentage knob
+// (level > 0) on holds at the level found, which becomes the threshold if
+// the configuration allows it.
+func adoptValue(cfg *config.Config, cur, level int) {
+	if cur == 1 {
+		cfg.MaxPercent = cfg.ConservationThreshold
+		if level > 0 && cfg.PercentKnob && float64(level) != cfg.ConservationThreshold {
+			c := *cfg
+			c.ConservationThreshold, c.MaxPercent, c.Preset = float64(level), float64(level), ""
+			if c.StartThreshold >= c.ConservationThreshold {
+				c.StartThreshold = 0
+			}
+			if err := c.Validate(); err != nil {
+				logging.Warnf("not adopting level %d%%: %v", level, err)
+			} else {
+				*cfg = c
+			}
+		}
+	} else {
+		cfg.MaxPercent = 100
+	}
+	cfg.TargetTime = nil
+	cfg.LevelReached = false
+	cfg.Auto = false
+	if cfg.StatePath != "" {
+		if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+			logging.Errorf("save state: %v", err)
+		}
+	}
+}
+
+// adoptExternal makes the daemon follow an external knob change instead of
+// reverting it.
+func (c *Controller) adoptExternal(cur, level int) config.Config {
+	cfg, _ := c.State.Update(func(cfg *config.Config) error {
+		adoptValue(cfg, cur, level)
+		return nil
+	})
+	v := c.describe(cur, level)
+	logging.Logf("%s changed externally to %s, following it: max=%.1f", c.KnobID, v, cfg.MaxPercent)
+	logging.Event("external_change", map[string]any{"knob": c.KnobID, "value": v, "max": cfg.MaxPercent, "policy": "adopt"})
+	return cfg
+}
+
+// askExternal leaves an external knob change in place until the user picks
+// a side with ResolveExternal.
+func (c *Controller) askExternal(cur, level int) {
+	v := c.describe(cur, level)
+	c.State.mu.Lock()
+	c.State.pending, c.State.pendingVal, c.State.pendingLevel, c.State.pendingStr = true, cur, level, v
+	c.State.mu.Unlock()
+	logging.Logf("%s changed externally to %s, waiting for a decision (adopt or enforce)", c.KnobID, v)
+	logging.Event("external_change", map[string]any{"knob": c.KnobID, "value": v, "policy": "ask"})
+}
+
+// externalPending reports whether an external change still awaits a
+// decision. One that the daemon agrees with anyway (settled) is dropped.
+func (s *State) externalPending(settled bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending && settled {
+		s.pending, s.pendingStr = false, ""
+	}
+	return s.pending
+}
+
+// ResolveExternal settles a pending external change: adopt makes it the new
+// setting, otherwise the daemon's own setting is enforced again on the next
+// step.
+func (s *State) ResolveExternal(adopt bool) (config.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.pending {
+		return s.cfg, errors.New("no external change pending")
+	}
+	if adopt {
+		adoptValue(&s.cfg, s.pendingVal, s.pendingLevel)
+		s.rev++
+		s.notify()
+	}
+	s.pending, s.pendingStr = false, ""
+	return s.cfg, nil
+}
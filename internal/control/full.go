@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"errors"
+	"time"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+	"conservationDaemon/internal/monitor"
+)
+
+// fullCharge is a one-shot charge to 100%, e.g. before a long trip. Like
+// trip mode it is an overlay: the stored thresholds are left untouched, so
+// ending it is all it takes to restore them.
+type fullCharge struct {
+	on    bool
+	since time.Time
+}
+
+// ChargeFull lets the battery charge to 100% once. The configured
+// thresholds come back as soon as the battery is full or the charger is
+// unplugged, whichever comes first.
+func (s *State) ChargeFull() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.cal.phase != "":
+		return errors.New("calibration in progress: it charges to full by itself")
+	case s.pause.active(time.Now()):
+		return errors.New("paused: resume first")
+	case !s.updated.IsZero() && s.bstate == monitor.BatteryStateDischarge:
+		return errors.New("on battery: plug in the charger first")
+	case !s.updated.IsZero() && (s.pct >= 100 || s.bstate == monitor.BatteryStateFull):
+		return errors.New("battery already full")
+	}
+	if s.full.on {
+		return nil
+	}
+	s.full = fullCharge{on: true, since: time.Now()}
+	s.notify()
+	logging.Logf("charging to full once, from %.1f%%", s.pct)
+	logging.Event("full_charge_started", map[string]any{"pct": s.pct})
+	return nil
+}
+
+// CancelFull ends a full charge early; it reports whether one was running.
+func (s *State) CancelFull() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.endFull("cancelled")
+}
+
+// chargingFull reports whether a full charge is running, ending it once the
+// battery at pct in state is full or off the charger.
+func (s *State) chargingFull(pct float64, state monitor.BatteryState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !s.full.on:
+	case pct >= 100 || state == monitor.BatteryStateFull:
+		s.endFull("full")
+	case state == monitor.BatteryStateDischarge:
+		s.endFull("unplugged")
+	}
+	return s.full.on
+}
+
+// endFull ends the full charge, if any. s.mu must be held.
+func (s *State) endFull(why string) bool {
+	if !s.full.on {
+		return false
+	}
+	took := time.Since(s.full.since).Round(time.Minute)
+	s.full = fullCharge{}
+	s.notify()
+	logging.Logf("full charge %s after %s: back to the configured thresholds", why, took)
+	logging.Event("full_charge_done", map[string]any{"reason": why, "pct": s.pct})
+	return true
+}
+
+// applyFull charges to 100% right away, whatever schedule, auto, storage,
+// off-peak mode or charge windows say.
+func applyFull(cfg config.Config) config.Config {
+	cfg.MaxPercent = 100
+	cfg.TargetTime = nil
+	cfg.LevelReached = false
+	cfg.Auto = false
+	cfg.Storage = false
+	cfg.OffPeak = nil
+	cfg.ChargeWindow = nil
+	cfg.LowCarbon = nil
+	return cfg
+}
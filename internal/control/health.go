@@ -0,0 +1,16 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import "conservationDaemon/internal/config"
+
+// applyHealth caps the target at cfg.HealthMax once the battery's full
+// capacity has dropped below cfg.HealthBelow percent of its design
+// capacity, so an aging battery is spared the top of the charge range.
+func applyHealth(cfg config.Config, health float64) (config.Config, bool) {
+	if !cfg.HealthAdaptive || health <= 0 || health >= cfg.HealthBelow || cfg.MaxPercent <= cfg.HealthMax {
+		return cfg, false
+	}
+	cfg.MaxPercent = cfg.HealthMax
+	return cfg, true
+}
@@ -0,0 +1,24 @@
+package control
+
+import (
+	"testing"
+
+	"conservationDaemon/internal/config"
+)
+
+func TestApplyHealth(t *testing.T) {
+	cfg := config.Config{MaxPercent: 90, ConservationThreshold: 80, HealthAdaptive: true, HealthBelow: 85, HealthMax: 78}
+	if got, capped := applyHealth(cfg, 92); capped || got.MaxPercent != 90 {
+		t.Error("healthy battery capped")
+	}
+	if got, capped := applyHealth(cfg, 0); capped || got.MaxPercent != 90 {
+		t.Error("unknown health capped")
+	}
+	if got, capped := applyHealth(cfg, 84); !capped || got.MaxPercent != 78 {
+		t.Errorf("worn battery: max %.0f, capped %t", got.MaxPercent, capped)
+	}
+	cfg.HealthAdaptive = false
+	if _, capped := applyHealth(cfg, 84); capped {
+		t.Error("capped with -health-adaptive off")
+	}
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import "time"
+
+// Sample is one point of the charge history.
+type Sample struct {
+	Time time.Time
+	Pct  float64
+	Cons int
+}
+
+// History keeps at most one sample per HistoryStep, for HistorySpan; older
+// samples are dropped. It lives in memory only, so it starts empty with the
+// daemon.
+const (
+	HistorySpan = 24 * time.Hour
+	HistoryStep = time.Minute
+)
+
+type history []Sample
+
+// add records smp, replacing the last sample if it falls within the same
+// HistoryStep.
+func (h *history) add(smp Sample) {
+	if n := len(*h); n > 0 && smp.Time.Truncate(HistoryStep).Equal((*h)[n-1].Time.Truncate(HistoryStep)) {
+		(*h)[n-1] = smp
+	} else {
+		*h = append(*h, smp)
+	}
+	cut := smp.Time.Add(-HistorySpan)
+	i := 0
+	for i < len(*h) && (*h)[i].Time.Before(cut) {
+		i++
+	}
+	if i > 0 {
+		*h = append((*h)[:0], (*h)[i:]...)
+	}
+}
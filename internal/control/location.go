@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/geo"
+)
+
+// applyLocation switches to the away target, and charge current cap if
+// set, when a location fix is known and lies outside every configured place.
+// Without a fix nothing changes.
+func applyLocation(cfg config.Config, fix geo.Fix, ok bool) (config.Config, bool) {
+	if !ok || len(cfg.Places) == 0 {
+		return cfg, false
+	}
+	for _, p := range cfg.Places {
+		if geo.DistanceKm(p.Lat, p.Lon, fix.Lat, fix.Lon) <= p.RadiusKm {
+			return cfg, false
+		}
+	}
+	cfg.MaxPercent = cfg.AwayMax
+	cfg.Auto = false
+	if cfg.AwayChargeCurrentMA > 0 {
+		cfg.ChargeCurrentMA = cfg.AwayChargeCurrentMA
+	}
+	return cfg, true
+}
@@ -0,0 +1,32 @@
+package control
+
+import (
+	"testing"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/geo"
+)
+
+func TestApplyLocation(t *testing.T) {
+	cfg := config.Config{
+		MaxPercent: 80, ConservationThreshold: 80, AwayMax: 100,
+		Places: []config.Place{{Lat: 45.46, Lon: 9.19, RadiusKm: 20}},
+	}
+	if got, away := applyLocation(cfg, geo.Fix{}, false); away || got.MaxPercent != 80 {
+		t.Error("unknown location switched to away")
+	}
+	if got, away := applyLocation(cfg, geo.Fix{Lat: 45.5, Lon: 9.2}, true); away || got.MaxPercent != 80 {
+		t.Error("home location switched to away")
+	}
+	if got, away := applyLocation(cfg, geo.Fix{Lat: 41.9, Lon: 12.5}, true); !away || got.MaxPercent != 100 || got.ChargeCurrentMA != 0 {
+		t.Errorf("away location kept max %.0f", got.MaxPercent)
+	}
+
+	cfg.ChargeCurrentMA, cfg.AwayChargeCurrentMA = 2000, 1000
+	if got, _ := applyLocation(cfg, geo.Fix{Lat: 45.5, Lon: 9.2}, true); got.ChargeCurrentMA != 2000 {
+		t.Errorf("home charge current %d", got.ChargeCurrentMA)
+	}
+	if got, _ := applyLocation(cfg, geo.Fix{Lat: 41.9, Lon: 12.5}, true); got.ChargeCurrentMA != 1000 {
+		t.Errorf("away charge current %d", got.ChargeCurrentMA)
+	}
+}
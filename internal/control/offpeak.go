@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"slices"
+	"time"
+
+	"conservationDaemon/internal/config"
+)
+
+// offPeak moves the charge above the conservation threshold into cheap-rate
+// windows and low-carbon periods. Without a schedule, charging waits for the
+// next one. With one, an open window starts charging early, and the
+// schedule's own start time still applies as a deadline. Auto mode is left
+// alone.
+func offPeak(cfg config.Config, d Decision, pct float64, now time.Time) Decision {
+	if cfg.Auto || d.LevelReached || pct >= cfg.MaxPercent {
+		return d
+	}
+	cheap := slices.ContainsFunc(cfg.OffPeak, func(w config.Window) bool { return w.Contains(now) })
+	green := slices.ContainsFunc(cfg.LowCarbon, func(p config.Period) bool { return p.Contains(now) })
+	switch d.Action {
+	case "disable_conservation_charging_to_target":
+		if !cheap && !green {
+			d.Want, d.Action = 1, "enable_conservation_waiting_for_offpeak"
+		}
+	case "enable_conservation_waiting_for_schedule":
+		switch {
+		case cheap:
+			d.Want, d.Action = 0, "disable_conservation_offpeak_charging"
+		case green:
+			d.Want, d.Action = 0, "disable_conservation_low_carbon_charging"
+		}
+	}
+	return d
+}
+
+// chargeWindow holds the battery outside the charge windows: whatever the
+// decision, conservation goes back on, even below the threshold. Storage
+// mode is left alone, and the safety floor still wins.
+func chargeWindow(cfg config.Config, d Decision, now time.Time) Decision {
+	if d.Want != 0 || slices.ContainsFunc(cfg.ChargeWindow, func(w config.Window) bool { return w.Contains(now) }) {
+		return d
+	}
+	d.Want, d.Action = 1, "enable_conservation_outside_charge_window"
+	return d
+}
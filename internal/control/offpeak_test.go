@@ -0,0 +1,101 @@
+package control
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/config"
+)
+
+func TestDecideOffPeak(t *testing.T) {
+	night, _ := config.ParseWindows("23:00-07:00")
+	cfg := config.Config{MaxPercent: 100, ConservationThreshold: 80, OffPeak: night}
+	day := time.Date(2024, 3, 5, 15, 0, 0, 0, time.Local)
+	late := time.Date(2024, 3, 5, 23, 30, 0, 0, time.Local)
+
+	if d := Decide(cfg, 85, 0, false, day); d.Want != 1 || d.Action != "enable_conservation_waiting_for_offpeak" {
+		t.Errorf("daytime: %+v", d)
+	}
+	if d := Decide(cfg, 85, 1, false, late); d.Want != 0 {
+		t.Errorf("inside window: %+v", d)
+	}
+
+	// Scheduled for 08:00: charge inside the window, ahead of the start time
+	target := time.Date(2024, 3, 6, 8, 0, 0, 0, time.Local)
+	cfg.TargetTime = &target
+	if d := Decide(cfg, 85, 1, false, late); d.Want != 0 || d.Action != "disable_conservation_offpeak_charging" {
+		t.Errorf("scheduled, inside window: %+v", d)
+	}
+	if d := Decide(cfg, 85, 1, false, day); d.Want != 1 {
+		t.Errorf("scheduled, outside window: %+v", d)
+	}
+
+	// The safety floor still wins
+	cfg.TargetTime, cfg.SafetyFloor = nil, 15
+	if d := Decide(cfg, 10, 1, false, day); d.Want != 0 {
+		t.Errorf("below safety floor: %+v", d)
+	}
+}
+
+func TestDecideChargeWindow(t *testing.T) {
+	night, _ := config.ParseWindows("23:00-07:00")
+	cfg := config.Config{MaxPercent: 90, ConservationThreshold: 80, ChargeWindow: night}
+	day := time.Date(2024, 3, 5, 15, 0, 0, 0, time.Local)
+	late := time.Date(2024, 3, 5, 23, 30, 0, 0, time.Local)
+
+	// Held even below the threshold during the day
+	if d := Decide(cfg, 50, 0, false, day); d.Want != 1 || d.Action != "enable_conservation_outside_charge_window" {
+		t.Errorf("outside window: %+v", d)
+	}
+	if d := Decide(cfg, 50, 1, false, late); d.Want != 0 {
+		t.Errorf("inside window: %+v", d)
+	}
+	cfg.Auto = true
+	if d := Decide(cfg, 50, 0, false, day); d.Want != 1 {
+		t.Errorf("auto mode, outside window: %+v", d)
+	}
+
+	cfg.Auto, cfg.SafetyFloor = false, 15
+	if d := Decide(cfg, 10, 1, false, day); d.Want != 0 {
+		t.Errorf("below safety floor: %+v", d)
+	}
+	cfg.Storage, cfg.StorageLevel = true, 55
+	if d := Decide(cfg, 50, 1, false, day); d.Want != backend.Storage {
+		t.Errorf("storage mode: %+v", d)
+	}
+}
+
+func TestTariffHook(t *testing.T) {
+	ctx := context.Background()
+	h := &TariffHook{Command: `echo '{"windows": [{"start": "01:00", "end": "05:00"}]}'`}
+	if err := h.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ws := h.Windows(); len(ws) != 1 || ws[0].Start != time.Hour || ws[0].End != 5*time.Hour {
+		t.Fatalf("windows = %+v", ws)
+	}
+
+	// A failing run keeps the previous windows
+	for _, bad := range []string{"echo oops >&2; exit 1", "echo not json"} {
+		h.Command = bad
+		if err := h.Refresh(ctx); err == nil {
+			t.Errorf("Refresh with %q succeeded", bad)
+		}
+		if len(h.Windows()) != 1 {
+			t.Errorf("windows dropped after %q", bad)
+		}
+	}
+
+	// The windows join the static ones in Step
+	st := NewState(config.Config{MaxPercent: 100, ConservationThreshold: 80})
+	knob := &fakeKnob{val: 0}
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 85}, Knob: knob, Tariff: func() []config.Window {
+		return []config.Window{{Start: 0, End: 0}} // never open
+	}}
+	c.Step(ctx)
+	if knob.val != 1 {
+		t.Errorf("outside the tariff windows: knob %d", knob.val)
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"time"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+)
+
+// Override is a temporary change of the thresholds, e.g. "-set -max 100
+// -for 4h". It is an overlay on the stored configuration, which it leaves
+// untouched: when Until passes, the daemon simply goes back to it.
+type Override struct {
+	Max          float64
+	TargetTime   *time.Time // nil: charge now
+	Auto         bool
+	LevelReached bool // progress toward Max, kept apart from the stored one
+	Until        time.Time
+}
+
+// apply puts o over cfg.
+func (o *Override) apply(cfg config.Config) config.Config {
+	cfg.MaxPercent = o.Max
+	cfg.TargetTime = o.TargetTime
+	cfg.Auto = o.Auto
+	cfg.LevelReached = o.LevelReached
+	return cfg
+}
+
+// SetOverride applies o until o.Until, replacing any override in place.
+func (s *State) SetOverride(o Override) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endOverride("replaced")
+	s.override = &o
+	s.notify()
+	logging.Logf("thresholds overridden until %s: max %.1f%%", o.Until.Format("15:04"), o.Max)
+	logging.Event("override_started", map[string]any{"max": o.Max, "until": o.Until.Unix()})
+}
+
+// EndOverride goes back to the stored thresholds early; it reports whether
+// an override was in place.
+func (s *State) EndOverride() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.endOverride("cancelled")
+}
+
+// overrideAt returns a copy of the override in place at now, if any,
+// ending one whose time is up.
+func (s *State) overrideAt(now time.Time) (Override, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.override == nil {
+		return Override{}, false
+	}
+	if !now.Before(s.override.Until) {
+		s.endOverride("expired")
+		return Override{}, false
+	}
+	return *s.override, true
+}
+
+// overrideProgress records a decision's progress on the override, as Step
+// does on the stored configuration without one.
+func (s *State) overrideProgress(d Decision) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.override == nil {
+		return
+	}
+	if d.LevelReached {
+		s.override.LevelReached = true
+	}
+	if d.ClearSchedule {
+		s.override.TargetTime = nil
+	}
+}
+
+// endOverride drops the override, if any. s.mu must be held.
+func (s *State) endOverride(why string) bool {
+	if s.override == nil {
+		return false
+	}
+	s.override = nil
+	s.notify()
+	if why != "replaced" {
+		logging.Logf("threshold override %s: back to max %.1f%%", why, s.cfg.MaxPercent)
+	}
+	logging.Event("override_ended", map[string]any{"reason": why})
+	return true
+}
+
+// overrideCopy returns a copy of the override, if any. s.mu must be held.
+func (s *State) overrideCopy() *Override {
+	if s.override == nil || !time.Now().Before(s.override.Until) {
+		return nil
+	}
+	o := *s.override
+	return &o
+}
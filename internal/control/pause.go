@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"errors"
+	"time"
+
+	"conservationDaemon/internal/logging"
+)
+
+// pause is a break from writing the hardware, for manual control.
+type pause struct {
+	on    bool
+	until time.Time // zero: until Resume
+}
+
+// active reports whether the pause holds at now.
+func (p pause) active(now time.Time) bool {
+	return p.on && (p.until.IsZero() || now.Before(p.until))
+}
+
+// Pause stops the controller from writing any knob for d, or until Resume
+// if d is 0. It keeps reading the battery and knob, so status stays
+// current. A running calibration must be aborted first, as pausing would
+// leave the battery force-discharging.
+func (s *State) Pause(d time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cal.phase != "" {
+		return time.Time{}, errors.New("calibration in progress: abort it before pausing")
+	}
+	s.pause = pause{on: true}
+	if d > 0 {
+		s.pause.until = time.Now().Add(d)
+	}
+	s.notify()
+	fields := map[string]any{}
+	if d > 0 {
+		fields["until"] = s.pause.until.Unix()
+		logging.Logf("paused until %s: leaving the knobs alone", s.pause.until.Format("15:04"))
+	} else {
+		logging.Logf("paused until resumed: leaving the knobs alone")
+	}
+	logging.Event("paused", fields)
+	return s.pause.until, nil
+}
+
+// Resume ends a pause; it reports whether there was one.
+func (s *State) Resume() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resume("resumed")
+}
+
+// Paused reports whether the controller is paused and until when (zero:
+// until Resume).
+func (s *State) Paused() (bool, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pause.active(time.Now()), s.pause.until
+}
+
+// pausedAt reports whether the controller is paused at now, ending a pause
+// whose time is up.
+func (s *State) pausedAt(now time.Time) (bool, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pause.on && !s.pause.active(now) {
+		s.resume("pause over")
+	}
+	return s.pause.on, s.pause.until
+}
+
+// resume ends the pause, if any. s.mu must be held.
+func (s *State) resume(why string) bool {
+	if !s.pause.on {
+		return false
+	}
+	s.pause = pause{}
+	s.notify()
+	logging.Logf("%s: taking control of the knobs again", why)
+	logging.Event("resumed", map[string]any{"reason": why})
+	return true
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"fmt"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/monitor"
+)
+
+// applyUserPolicy resolves which policy drives the knob on a shared machine.
+// Precedence: 1) the user of the active session on seat0  2) among other
+// active sessions, the user asking for the highest max, so nobody is denied
+// a charge they asked for  3) the global configuration. A policy without a
+// charge current cap keeps the global one. It returns the effective
+// configuration and a description of its source.
+func applyUserPolicy(cfg config.Config, sessions []monitor.Session) (config.Config, string) {
+	var (
+		best  config.UserPolicy
+		uid   uint32
+		found bool
+	)
+	for _, s := range sessions {
+		p, ok := cfg.UserPolicies[s.UID]
+		if !ok {
+			continue
+		}
+		if s.Seat == "seat0" {
+			best, uid, found = p, s.UID, true
+			break
+		}
+		if !found || p.Max > best.Max {
+			best, uid, found = p, s.UID, true
+		}
+	}
+	if !found {
+		return cfg, "global"
+	}
+	cfg.MaxPercent = best.Max
+	cfg.Auto = best.Auto
+	if best.ChargeCurrentMA > 0 {
+		cfg.ChargeCurrentMA = best.ChargeCurrentMA
+	}
+	return cfg, fmt.Sprintf("user %d", uid)
+}
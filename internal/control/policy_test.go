@@ -0,0 +1,39 @@
+package control
+
+import (
+	"testing"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/monitor"
+)
+
+func TestApplyUserPolicy(t *testing.T) {
+	cfg := config.Config{
+		MaxPercent:            80,
+		ConservationThreshold: 80,
+		UserPolicies: map[uint32]config.UserPolicy{
+			1000: {Max: 90},
+			1001: {Max: 100, Auto: true, ChargeCurrentMA: 1000},
+		},
+		ChargeCurrentMA: 2000,
+	}
+
+	tests := []struct {
+		name     string
+		sessions []monitor.Session
+		max      float64
+		current  int
+		policy   string
+	}{
+		{"nobody", nil, 80, 2000, "global"},
+		{"no policy", []monitor.Session{{UID: 1002, Seat: "seat0"}}, 80, 2000, "global"},
+		{"seat0 wins", []monitor.Session{{UID: 1001, Seat: "seat1"}, {UID: 1000, Seat: "seat0"}}, 90, 2000, "user 1000"},
+		{"highest max", []monitor.Session{{UID: 1000, Seat: "seat1"}, {UID: 1001, Seat: "seat2"}}, 100, 1000, "user 1001"},
+	}
+	for _, tt := range tests {
+		got, policy := applyUserPolicy(cfg, tt.sessions)
+		if got.MaxPercent != tt.max || got.ChargeCurrentMA != tt.current || policy != tt.policy {
+			t.Errorf("%s: max=%.0f current=%d policy=%q, want %.0f %d %q", tt.name, got.MaxPercent, got.ChargeCurrentMA, policy, tt.max, tt.current, tt.policy)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+)
+
+// ApplyPreset switches to the threshold preset p and persists the result.
+// It replaces any schedule and ends storage mode, as picking a preset says
+// how the battery should be kept from now on.
+func (s *State) ApplyPreset(p config.Preset) (config.Config, error) {
+	cfg, err := s.Update(func(cfg *config.Config) error {
+		cfg.ApplyPreset(p)
+		cfg.TargetTime = nil
+		cfg.LevelReached = false
+		cfg.Storage = false
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		if cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return cfg, err
+	}
+	logging.Logf("preset %q: threshold=%.0f start=%.0f max=%.0f", p.Title, cfg.ConservationThreshold, cfg.StartThreshold, cfg.MaxPercent)
+	logging.Event("preset_applied", map[string]any{"preset": p.Name, "threshold": cfg.ConservationThreshold, "max": cfg.MaxPercent})
+	return cfg, nil
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"fmt"
+	"strings"
+
+	"conservationDaemon/internal/config"
+)
+
+// explain turns a decision into the one-line reason shown by clients, e.g.
+// "pct 81.2 ≥ max 80". cfg is the effective configuration the decision was
+// made with; notes name the overlays that shaped it and go first.
+func explain(cfg config.Config, d Decision, pct float64, notes ...string) string {
+	var r string
+	switch d.Action {
+	case "enable_conservation_threshold_mode":
+		r = fmt.Sprintf("max %g ≤ conservation threshold %g", cfg.MaxPercent, cfg.ConservationThreshold)
+	case "enable_conservation_display_connected":
+		r = "auto mode: external display connected"
+	case "disable_conservation_display_disconnected":
+		r = "auto mode: no external display, charging"
+	case "enable_conservation_level_reached":
+		if pct >= cfg.MaxPercent {
+			r = fmt.Sprintf("pct %.1f ≥ max %g", pct, cfg.MaxPercent)
+		} else {
+			r = fmt.Sprintf("max %g reached, holding at pct %.1f", cfg.MaxPercent, pct)
+		}
+	case "enable_conservation_schedule_completed":
+		r = fmt.Sprintf("schedule completed: max %g reached", cfg.MaxPercent)
+	case "disable_conservation_charging_to_target":
+		r = fmt.Sprintf("pct %.1f < max %g: charging", pct, cfg.MaxPercent)
+	case "disable_conservation_immediate":
+		r = fmt.Sprintf("target time passed: charging to %g", cfg.MaxPercent)
+	case "disable_conservation_scheduled_charging":
+		r = fmt.Sprintf("charging to %g by %s", cfg.MaxPercent, cfg.TargetTime.Format("15:04"))
+	case "enable_conservation_waiting_for_schedule":
+		r = fmt.Sprintf("charging to %g starts at %s for %s", cfg.MaxPercent, d.StartTime.Format("15:04"), cfg.TargetTime.Format("15:04"))
+	case "enable_conservation_waiting_for_offpeak":
+		r = fmt.Sprintf("waiting for an off-peak or low-carbon period to charge to %g", cfg.MaxPercent)
+	case "disable_conservation_offpeak_charging":
+		r = fmt.Sprintf("off-peak window open: charging to %g", cfg.MaxPercent)
+	case "disable_conservation_low_carbon_charging":
+		r = fmt.Sprintf("low-carbon period: charging to %g", cfg.MaxPercent)
+	case "enable_conservation_outside_charge_window":
+		r = "outside the charge window: holding"
+	case "enable_storage_mode":
+		r = fmt.Sprintf("storage mode: holding at %g", cfg.StorageLevel)
+	case "force_discharge_calibration":
+		r = fmt.Sprintf("calibration: pct %.1f, force-discharging", pct)
+	case "disable_conservation_calibration":
+		r = fmt.Sprintf("calibration: pct %.1f, charging to 100", pct)
+	case "disable_conservation_safety_floor":
+		r = fmt.Sprintf("pct %.1f below safety floor %g: charging", pct, cfg.SafetyFloor)
+	default:
+		r = strings.ReplaceAll(d.Action, "_", " ")
+	}
+	return strings.Join(append(notes, r), "; ")
+}
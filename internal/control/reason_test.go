@@ -0,0 +1,33 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/config"
+)
+
+func TestExplain(t *testing.T) {
+	now := time.Date(2024, 3, 5, 5, 0, 0, 0, time.Local)
+	target := time.Date(2024, 3, 5, 7, 30, 0, 0, time.Local)
+	tests := []struct {
+		name  string
+		cfg   config.Config
+		pct   float64
+		notes []string
+		want  string
+	}{
+		{"charging", config.Config{MaxPercent: 90, ConservationThreshold: 80}, 62.4, nil, "pct 62.4 < max 90: charging"},
+		{"threshold", config.Config{MaxPercent: 80, ConservationThreshold: 80}, 62, nil, "max 80 ≤ conservation threshold 80"},
+		{"waiting", config.Config{MaxPercent: 100, ConservationThreshold: 80, TargetTime: &target}, 70, nil, "charging to 100 starts at 07:00 for 07:30"},
+		{"trip", config.Config{MaxPercent: 100, ConservationThreshold: 80, TargetTime: &target}, 70, []string{"trip mode for \"Flight\" active until 09:00"},
+			"trip mode for \"Flight\" active until 09:00; charging to 100 starts at 07:00 for 07:30"},
+		{"floor", config.Config{MaxPercent: 80, ConservationThreshold: 80, SafetyFloor: 20}, 15, nil, "pct 15.0 below safety floor 20: charging"},
+	}
+	for _, tt := range tests {
+		d := Decide(tt.cfg, tt.pct, 1, false, now)
+		if got := explain(tt.cfg, d, tt.pct, tt.notes...); got != tt.want {
+			t.Errorf("%s: explain = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
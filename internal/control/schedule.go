@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"time"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+)
+
+// fireRules applies the latest recurring schedule rule due at now, if it
+// hasn't fired yet, to the stored thresholds: like a change made with -set
+// or -preset, it holds until the next rule or the next manual change. A
+// target below the threshold, which a preset rule may have raised, conserves
+// at the threshold.
+func (s *State) fireRules(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, rule, ok := config.LastRule(s.cfg.Schedule, now)
+	if !ok || s.cfg.ScheduleFired != nil && !at.After(*s.cfg.ScheduleFired) {
+		return
+	}
+	_, err := s.update(func(cfg *config.Config) error {
+		if p, ok := config.FindPreset(rule.Preset); ok {
+			cfg.ApplyPreset(p)
+		} else {
+			cfg.MaxPercent = min(max(rule.Max, cfg.ConservationThreshold), 100)
+		}
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		cfg.TargetTime = nil
+		cfg.LevelReached = false
+		cfg.ScheduleFired = &at
+		if cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Skipped for good: a preset under the storage level, say
+		logging.Errorf("schedule rule %q: %v", rule.String(), err)
+		s.update(func(cfg *config.Config) error {
+			cfg.ScheduleFired = &at
+			return nil
+		})
+		return
+	}
+	next := "none"
+	if when, r, ok := config.NextRule(s.cfg.Schedule, now); ok {
+		next = r.String() + " on " + when.Format("Mon 2006-01-02")
+	}
+	logging.Logf("schedule rule %q: threshold %.1f%%, target %.1f%% (next: %s)", rule.String(), s.cfg.ConservationThreshold, s.cfg.MaxPercent, next)
+	logging.Event("schedule_rule", map[string]any{"rule": rule.String(), "max": s.cfg.MaxPercent, "preset": rule.Preset, "at": at.Unix()})
+}
@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+	"conservationDaemon/internal/monitor"
+)
+
+// State is the configuration and latest measurements shared between the
+// control loop and the IPC server.
+type State struct {
+	mu      sync.Mutex
+	cfg     config.Config
+	rev     uint64 // goes up with every change of cfg
+	pct     float64
+	bstate  monitor.BatteryState
+	cons    int
+	lastErr string
+	updated time.Time // when pct/bstate/cons were last published
+	policy  string    // source of the effective policy ("global", "user N")
+	reason  string    // why the last decision was taken
+	backend string    // name of the backend driving the knob
+
+	health       float64 // full vs. design capacity, percent; 0 if unknown
+	healthCapped bool    // health-adaptive max lowered the target
+	temp         float64 // battery temperature, °C; 0 if unknown
+	tempCapped   bool    // temperature rule lowered the target
+
+	// External knob change awaiting a decision (external policy "ask")
+	pending      bool
+	pendingVal   int
+	pendingLevel int
+	pendingStr   string
+
+	writeFailures int // knob writes that failed after all retries
+
+	cal    calibration
+	cycles int // battery cycle count; 0 if unknown
+
+	summary Summary
+	history history
+
+	pause pause // set while the user has taken manual control
+	full  fullCharge
+	// set by a temporary "-set ... -for"; nil for the stored thresholds
+	override *Override
+
+	changed chan struct{} // closed by the next change; see Changed
+}
+
+// Status is a point-in-time copy of State.
+type Status struct {
+	Config        config.Config
+	Pct           float64
+	BatteryState  monitor.BatteryState
+	Cons          int
+	LastErr       string
+	WriteFailures int
+	Updated       time.Time
+	Policy        string
+	External      string // externally set knob value awaiting a decision, if any
+	Reason        string // why the last decision was taken, e.g. "pct 81.2 ≥ max 80"
+	Backend       string // backend driving the knob, after any failover
+	Calibration   string // calibration phase in progress, e.g. "discharging to 20%"; "" if none
+	Cycles        int    // battery cycle count; 0 if unknown
+	Health        float64
+	HealthCapped  bool
+	Temp          float64
+	TempCapped    bool
+	Paused        bool
+	PausedUntil   time.Time // zero while paused until resumed
+	ChargingFull  bool      // a one-shot charge to 100% is running
+	Override      *Override // temporary thresholds in place of Config's, if any
+	Revision      uint64    // of Config; see UpdateIf
+}
+
+func NewState(cfg config.Config) *State {
+	return &State{cfg: cfg, rev: 1, summary: Summary{Since: time.Now()}}
+}
+
+// Config returns a copy of the current configuration.
+func (s *State) Config() config.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg
+}
+
+// Update runs fn on the configuration under the state lock. If fn returns an
+// error the configuration is left unchanged.
+func (s *State) Update(fn func(cfg *config.Config) error) (config.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(fn)
+}
+
+// ErrStale is returned by UpdateIf when the configuration changed since the
+// caller read it.
+var ErrStale = errors.New("settings changed since they were read")
+
+// UpdateIf is Update if the configuration is still at revision rev, as
+// returned with Status, and fails with ErrStale otherwise. Two clients
+// changing settings at once can't silently undo each other's change: the
+// second is told to read again. A rev of 0 always updates.
+func (s *State) UpdateIf(rev uint64, fn func(cfg *config.Config) error) (config.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rev != 0 && rev != s.rev {
+		return s.cfg, fmt.Errorf("%w (revision %d, now %d)", ErrStale, rev, s.rev)
+	}
+	return s.update(fn)
+}
+
+// Revision returns the configuration's revision.
+func (s *State) Revision() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rev
+}
+
+// update is Update with s.mu held.
+func (s *State) update(fn func(cfg *config.Config) error) (config.Config, error) {
+	cfg := s.cfg
+	if err := fn(&cfg); err != nil {
+		return s.cfg, err
+	}
+	// The control loop updates every step, mostly changing nothing
+	if !reflect.DeepEqual(cfg, s.cfg) {
+		s.rev++
+	}
+	s.cfg = cfg
+	s.notify()
+	return cfg, nil
+}
+
+// Changed returns a channel that is closed at the next change of the
+// configuration or the next published measurement, which may well leave
+// everything as it was: watchers compare Status themselves.
+func (s *State) Changed() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return s.changed
+}
+
+// notify wakes Changed watchers. s.mu must be held.
+func (s *State) notify() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// Status returns a snapshot of configuration and measurements.
+func (s *State) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Status{
+		Config:        s.cfg,
+		Pct:           s.pct,
+		BatteryState:  s.bstate,
+		Cons:          s.cons,
+		LastErr:       s.lastErr,
+		WriteFailures: s.writeFailures,
+		Updated:       s.updated,
+		Policy:        s.policy,
+		External:      s.pendingStr,
+		Reason:        s.reason,
+		Backend:       s.backend,
+		Calibration:   s.cal.String(),
+		Cycles:        s.cycles,
+		Health:        s.health,
+		HealthCapped:  s.healthCapped,
+		Temp:          s.temp,
+		TempCapped:    s.tempCapped,
+		Paused:        s.pause.active(time.Now()),
+		PausedUntil:   s.pause.until,
+		ChargingFull:  s.full.on,
+		Override:      s.overrideCopy(),
+		Revision:      s.rev,
+	}
+}
+
+// Summary returns the session summary so far.
+func (s *State) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.summary
+}
+
+// History returns the charge history, oldest first.
+func (s *State) History() []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Sample(nil), s.history...)
+}
+
+func (s *State) setError(err error) {
+	s.mu.Lock()
+	s.lastErr = err.Error()
+	s.summary.Errors++
+	s.mu.Unlock()
+}
+
+func (s *State) recordWriteFailure(err error) {
+	s.mu.Lock()
+	s.lastErr = err.Error()
+	s.writeFailures++
+	s.summary.Errors++
+	s.mu.Unlock()
+}
+
+// SetBackend records the backend driving the knob.
+func (s *State) SetBackend(name string) {
+	s.mu.Lock()
+	s.backend = name
+	s.mu.Unlock()
+}
+
+func (s *State) setPolicy(policy string) {
+	s.mu.Lock()
+	s.policy = policy
+	s.mu.Unlock()
+}
+
+func (s *State) setReason(reason string) {
+	s.mu.Lock()
+	s.reason = reason
+	s.mu.Unlock()
+}
+
+func (s *State) setHealth(health float64, capped bool) {
+	s.mu.Lock()
+	s.health, s.healthCapped = health, capped
+	s.mu.Unlock()
+}
+
+func (s *State) setTemp(temp float64, capped bool) {
+	s.mu.Lock()
+	s.temp, s.tempCapped = temp, capped
+	s.mu.Unlock()
+}
+
+func (s *State) publish(pct float64, bstate monitor.BatteryState, cons int) {
+	s.mu.Lock()
+	now := time.Now()
+	s.summary.observe(pct, bstate, cons, now, s.bstate, s.cons, s.updated)
+	s.history.add(Sample{Time: now, Pct: pct, Cons: cons})
+	changed := !s.updated.IsZero() && bstate != s.bstate
+	prev := s.bstate
+	s.pct = pct
+	s.bstate = bstate
+	s.cons = cons
+	s.updated = now
+	s.notify()
+	s.mu.Unlock()
+	if changed {
+		logging.Event("battery_state_changed", map[string]any{"state": bstate.String(), "previous": prev.String(), "pct": pct})
+	}
+}
@@ -0,0 +1,82 @@
+package control
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/monitor"
+)
+
+func TestStateChanged(t *testing.T) {
+	s := NewState(config.Config{MaxPercent: 80})
+	closed := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	ch := s.Changed()
+	if closed(ch) {
+		t.Fatal("closed before any change")
+	}
+	s.Update(func(cfg *config.Config) error { cfg.MaxPercent = 90; return nil })
+	if !closed(ch) {
+		t.Error("Update didn't signal")
+	}
+
+	ch = s.Changed()
+	s.publish(81, monitor.BatteryStateCharging, 1)
+	if !closed(ch) {
+		t.Error("publish didn't signal")
+	}
+	if closed(s.Changed()) {
+		t.Error("new channel already closed")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	var h history
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h.add(Sample{Time: t0, Pct: 50})
+	h.add(Sample{Time: t0.Add(20 * time.Second), Pct: 51})
+	if len(h) != 1 || h[0].Pct != 51 {
+		t.Fatalf("same minute: %+v", h)
+	}
+	h.add(Sample{Time: t0.Add(time.Minute), Pct: 52})
+	if len(h) != 2 {
+		t.Fatalf("next minute: %+v", h)
+	}
+	h.add(Sample{Time: t0.Add(HistorySpan + 30*time.Second), Pct: 60})
+	if len(h) != 2 || h[0].Pct != 52 || h[1].Pct != 60 {
+		t.Errorf("after a day: %+v", h)
+	}
+}
+
+func TestUpdateIf(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 80})
+	rev := st.Status().Revision
+	if rev == 0 {
+		t.Fatal("revision 0")
+	}
+	// Updates that change nothing keep the revision
+	st.Update(func(*config.Config) error { return nil })
+	if st.Revision() != rev {
+		t.Errorf("no-op update moved the revision to %d", st.Revision())
+	}
+	if _, err := st.UpdateIf(rev, func(cfg *config.Config) error { cfg.MaxPercent = 90; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// A second client still at rev
+	_, err := st.UpdateIf(rev, func(cfg *config.Config) error { cfg.MaxPercent = 100; return nil })
+	if !errors.Is(err, ErrStale) || st.Config().MaxPercent != 90 {
+		t.Errorf("stale update: %v, max %g", err, st.Config().MaxPercent)
+	}
+	if _, err := st.UpdateIf(0, func(cfg *config.Config) error { cfg.MaxPercent = 100; return nil }); err != nil || st.Revision() != rev+2 {
+		t.Errorf("unconditional update: %v, revision %d", err, st.Revision())
+	}
+}
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+)
+
+// SetStorage turns storage mode on or off and persists the result. The
+// regular settings are kept, and apply again once storage mode is off.
+func (s *State) SetStorage(on bool) config.Config {
+	cfg, _ := s.Update(func(cfg *config.Config) error {
+		cfg.Storage = on
+		cfg.LevelReached = false
+		if cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+	logging.Event("storage_mode", map[string]any{"on": on, "level": cfg.StorageLevel})
+	return cfg
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"conservationDaemon/internal/monitor"
+)
+
+// Summary describes the daemon's session since it started: what the battery
+// went through and how often the daemon had to act.
+type Summary struct {
+	Since          time.Time
+	ChargeSessions int           // times the battery started charging
+	Toggles        int           // conservation knob changes
+	MinPct, MaxPct float64       // charge range seen; both 0 before the first reading
+	Capped         time.Duration // time spent with conservation on
+	Errors         int           // failed reads and writes
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("session since %s: %d charge sessions, %d conservation toggles, charge %.1f%%..%.1f%%, capped for %s, %d errors",
+		s.Since.Format("2006-01-02 15:04"), s.ChargeSessions, s.Toggles, s.MinPct, s.MaxPct, s.Capped.Round(time.Minute), s.Errors)
+}
+
+// observe accounts for a new measurement. prev* are the values published
+// before it, at prevAt (zero for the first measurement).
+func (s *Summary) observe(pct float64, bstate monitor.BatteryState, cons int, now time.Time,
+	prevState monitor.BatteryState, prevCons int, prevAt time.Time) {
+	first := prevAt.IsZero()
+	if first || pct < s.MinPct {
+		s.MinPct = pct
+	}
+	if first || pct > s.MaxPct {
+		s.MaxPct = pct
+	}
+	if bstate == monitor.BatteryStateCharging && (first || prevState != monitor.BatteryStateCharging) {
+		s.ChargeSessions++
+	}
+	if first {
+		return
+	}
+	if cons != prevCons {
+		s.Toggles++
+	}
+	if prevCons != 0 {
+		s.Capped += now.Sub(prevAt)
+	}
+}
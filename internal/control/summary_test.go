@@ -0,0 +1,38 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/monitor"
+)
+
+func TestSummaryObserve(t *testing.T) {
+	var s Summary
+	t0 := time.Date(2024, 3, 5, 8, 0, 0, 0, time.UTC)
+	samples := []struct {
+		pct   float64
+		state monitor.BatteryState
+		cons  int
+	}{
+		{60, monitor.BatteryStateCharging, 0},
+		{80, monitor.BatteryStateCharging, 1},
+		{80, monitor.BatteryStatePending, 1},
+		{55, monitor.BatteryStateDischarge, 0},
+		{58, monitor.BatteryStateCharging, 0},
+	}
+	var (
+		prevState monitor.BatteryState
+		prevCons  int
+		prevAt    time.Time
+	)
+	for i, x := range samples {
+		now := t0.Add(time.Duration(i) * 10 * time.Minute)
+		s.observe(x.pct, x.state, x.cons, now, prevState, prevCons, prevAt)
+		prevState, prevCons, prevAt = x.state, x.cons, now
+	}
+	want := Summary{ChargeSessions: 2, Toggles: 2, MinPct: 55, MaxPct: 80, Capped: 20 * time.Minute}
+	if s != want {
+		t.Errorf("summary = %+v, want %+v", s, want)
+	}
+}
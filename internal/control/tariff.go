@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"conservationDaemon/internal/config"
+)
+
+// tariffTimeout bounds one run of the tariff command.
+const tariffTimeout = 30 * time.Second
+
+// TariffHook gets cheap-rate windows from a script, e.g. one asking an
+// energy supplier's API. The script prints a tariff document (see
+// config.LoadTariff) on stdout.
+type TariffHook struct {
+	Command string // run with sh -c
+
+	mu      sync.Mutex
+	windows []config.Window
+}
+
+// Refresh runs the command and keeps the windows it printed. On failure the
+// previous windows stay, as a tariff rarely changes from one run to the next.
+func (h *TariffHook) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, tariffTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	windows, err := config.ParseTariff(out)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.windows = windows
+	return nil
+}
+
+// Windows returns the windows of the last successful run.
+func (h *TariffHook) Windows() []config.Window {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.windows
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"time"
+
+	"conservationDaemon/internal/config"
+)
+
+// tempHysteresis is how far below Config.TempLimit the battery must cool
+// before the regular target returns, so it doesn't flap around the limit.
+const tempHysteresis = 3.0
+
+// tempTracker turns temperature readings into a hot/normal verdict: hot
+// once above the limit for Config.TempSustain, normal again once below the
+// limit minus tempHysteresis.
+type tempTracker struct {
+	above time.Time // start of the current stretch above the limit
+	hot   bool
+}
+
+func (t *tempTracker) update(cfg config.Config, temp float64, now time.Time) bool {
+	switch {
+	case temp > cfg.TempLimit:
+		if t.above.IsZero() {
+			t.above = now
+		}
+		if now.Sub(t.above) >= cfg.TempSustain {
+			t.hot = true
+		}
+	case temp < cfg.TempLimit-tempHysteresis:
+		t.above, t.hot = time.Time{}, false
+	default:
+		t.above = time.Time{}
+	}
+	return t.hot
+}
+
+// applyTemperature caps the target at cfg.TempMax while the battery is hot.
+func applyTemperature(cfg config.Config, hot bool) (config.Config, bool) {
+	if !hot || cfg.MaxPercent <= cfg.TempMax {
+		return cfg, false
+	}
+	cfg.MaxPercent = cfg.TempMax
+	return cfg, true
+}
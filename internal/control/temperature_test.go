@@ -0,0 +1,60 @@
+package control
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/monitor"
+)
+
+func TestTempTracker(t *testing.T) {
+	cfg := config.Config{MaxPercent: 100, TempLimit: 40, TempSustain: 10 * time.Minute, TempMax: 80}
+	var tr tempTracker
+	now := time.Date(2024, 7, 1, 14, 0, 0, 0, time.UTC)
+
+	if tr.update(cfg, 42, now) {
+		t.Fatal("hot before the limit was sustained")
+	}
+	if tr.update(cfg, 39, now.Add(5*time.Minute)) || tr.update(cfg, 42, now.Add(6*time.Minute)) {
+		t.Fatal("a dip below the limit should restart the sustain window")
+	}
+	if !tr.update(cfg, 42, now.Add(16*time.Minute)) {
+		t.Fatal("not hot after sustained heat")
+	}
+	if !tr.update(cfg, 39, now.Add(17*time.Minute)) {
+		t.Error("cooled within hysteresis")
+	}
+	if tr.update(cfg, 36, now.Add(18*time.Minute)) {
+		t.Error("still hot after cooling down")
+	}
+
+	if got, capped := applyTemperature(cfg, true); !capped || got.MaxPercent != 80 {
+		t.Errorf("hot: max %.0f, capped %t", got.MaxPercent, capped)
+	}
+	if _, capped := applyTemperature(cfg, false); capped {
+		t.Error("capped while normal")
+	}
+}
+
+func TestStepTemperatureCap(t *testing.T) {
+	st := NewState(config.Config{MaxPercent: 100, ConservationThreshold: 60, TempLimit: 40, TempMax: 80})
+	knob := &fakeKnob{}
+	bat := &fakeBattery{pct: 85, state: monitor.BatteryStateCharging}
+	temp := 45.0
+	c := &Controller{State: st, Battery: bat, Knob: knob, Temperature: func() (float64, error) { return temp, nil }}
+	ctx := context.Background()
+
+	c.Step(ctx)
+	if s := st.Status(); knob.val != 1 || s.Config.LevelReached {
+		t.Errorf("hot: knob %d, level reached %t", knob.val, s.Config.LevelReached)
+	}
+
+	// The capped target was reached, not the stored one: charging resumes
+	temp = 30
+	c.Step(ctx)
+	if s := st.Status(); knob.val != 0 || s.Config.LevelReached {
+		t.Errorf("cooled down: knob %d, level reached %t", knob.val, s.Config.LevelReached)
+	}
+}
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+)
+
+// Toggle flips between holding at the conservation threshold and charging
+// to 100%, as a hardware conservation key would, and persists the result.
+func Toggle(st *State) config.Config {
+	cfg, _ := st.Update(func(cfg *config.Config) error {
+		if cfg.MaxPercent > cfg.ConservationThreshold {
+			cfg.MaxPercent = cfg.ConservationThreshold
+		} else {
+			cfg.MaxPercent = 100
+		}
+		cfg.TargetTime = nil
+		cfg.LevelReached = false
+		if cfg.StatePath != "" {
+			if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+				logging.Errorf("save state: %v", err)
+			}
+		}
+		return nil
+	})
+	return cfg
+}
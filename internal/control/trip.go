@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"time"
+
+	"conservationDaemon/internal/calendar"
+	"conservationDaemon/internal/config"
+)
+
+// applyTrip overlays trip mode for a calendar event: charge to TripMax by
+// the event start, and keep that target until the event ends. The regular
+// configuration applies again afterwards.
+func applyTrip(cfg config.Config, e calendar.Event, now time.Time) config.Config {
+	cfg.MaxPercent = cfg.TripMax
+	cfg.Auto = false
+	cfg.LevelReached = false
+	cfg.TargetTime = nil
+	if now.Before(e.Start) {
+		start := e.Start
+		cfg.TargetTime = &start
+	}
+	return cfg
+}
@@ -0,0 +1,31 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/calendar"
+	"conservationDaemon/internal/config"
+)
+
+func TestApplyTrip(t *testing.T) {
+	now := time.Date(2025, 3, 10, 6, 0, 0, 0, time.UTC)
+	e := calendar.Event{Start: now.Add(3 * time.Hour), End: now.Add(5 * time.Hour)}
+	cfg := config.Config{MaxPercent: 80, ConservationThreshold: 80, TripMax: 100, Auto: true, LevelReached: true}
+
+	got := applyTrip(cfg, e, now)
+	if got.MaxPercent != 100 || got.Auto || got.LevelReached || got.TargetTime == nil || !got.TargetTime.Equal(e.Start) {
+		t.Errorf("before trip: %+v", got)
+	}
+	// Far from the start only conservation is wanted; charging begins in time
+	if d := Decide(got, 70, 1, false, now); d.Want != 1 {
+		t.Errorf("charging started too early: %+v", d)
+	}
+	if d := Decide(got, 70, 1, false, e.Start.Add(-20*time.Minute)); d.Want != 0 {
+		t.Errorf("charging not started before the trip: %+v", d)
+	}
+
+	if got := applyTrip(cfg, e, now.Add(4*time.Hour)); got.TargetTime != nil || got.MaxPercent != 100 {
+		t.Errorf("during trip: %+v", got)
+	}
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"conservationDaemon/internal/logging"
+)
+
+// Retry tuning for knob writes, overridable in tests.
+var (
+	writeAttempts = 3
+	writeBackoff  = 200 * time.Millisecond
+)
+
+var errReadBack = errors.New("value did not stick")
+
+// isTransient reports whether err is one of the errors EC-backed sysfs
+// attributes return intermittently under load.
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EIO) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, errReadBack)
+}
+
+// writeKnob writes v and verifies it by reading the knob back, retrying
+// transient failures with exponential backoff.
+func (c *Controller) writeKnob(ctx context.Context, v int) error {
+	delay := writeBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = c.Knob.Write(v)
+		if err == nil {
+			var got int
+			if got, err = c.Knob.Read(); err == nil {
+				if got == v {
+					return nil
+				}
+				err = fmt.Errorf("%w: wrote %s, read back %s", errReadBack, c.Knob.ValueString(v), c.Knob.ValueString(got))
+			}
+		}
+		if attempt >= writeAttempts || !isTransient(err) {
+			return err
+		}
+		logging.Warnf("write cons attempt %d/%d failed: %v; retrying in %v", attempt, writeAttempts, err, delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,86 @@
+package control
+
+import (
+	"context"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/config"
+)
+
+// flakyKnob fails the first failures writes with err; if stuck is set,
+// writes succeed but never change the value.
+type flakyKnob struct {
+	val      int
+	failures int
+	err      error
+	stuck    bool
+	writes   int
+}
+
+func (k *flakyKnob) Read() (int, error) { return k.val, nil }
+func (k *flakyKnob) Write(v int) error {
+	k.writes++
+	if k.writes <= k.failures {
+		return k.err
+	}
+	if !k.stuck {
+		k.val = v
+	}
+	return nil
+}
+func (k *flakyKnob) ValueString(v int) string { return strconv.Itoa(v) }
+
+func fastRetries(t *testing.T) {
+	oldAttempts, oldBackoff := writeAttempts, writeBackoff
+	writeAttempts, writeBackoff = 3, time.Millisecond
+	t.Cleanup(func() { writeAttempts, writeBackoff = oldAttempts, oldBackoff })
+}
+
+func TestWriteKnobRetriesTransient(t *testing.T) {
+	fastRetries(t)
+	knob := &flakyKnob{failures: 2, err: syscall.EAGAIN}
+	c := &Controller{Knob: knob}
+
+	if err := c.writeKnob(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if knob.writes != 3 || knob.val != 1 {
+		t.Errorf("writes=%d val=%d", knob.writes, knob.val)
+	}
+}
+
+func TestWriteKnobGivesUpOnPermanentError(t *testing.T) {
+	fastRetries(t)
+	knob := &flakyKnob{failures: 5, err: syscall.EACCES}
+	c := &Controller{Knob: knob}
+
+	if err := c.writeKnob(context.Background(), 1); err == nil {
+		t.Fatal("expected error")
+	}
+	if knob.writes != 1 {
+		t.Errorf("permanent error retried %d times", knob.writes)
+	}
+}
+
+func TestStepCountsPersistentFailures(t *testing.T) {
+	fastRetries(t)
+	st := NewState(config.Config{MaxPercent: 80, ConservationThreshold: 80})
+	knob := &flakyKnob{stuck: true}
+	c := &Controller{State: st, Battery: &fakeBattery{pct: 50}, Knob: knob}
+
+	c.Step(context.Background())
+
+	s := st.Status()
+	if s.WriteFailures != 1 || s.LastErr == "" {
+		t.Errorf("WriteFailures=%d LastErr=%q", s.WriteFailures, s.LastErr)
+	}
+	if s.Cons != 0 {
+		t.Errorf("Cons=%d, want the unchanged hardware value", s.Cons)
+	}
+	if knob.writes != writeAttempts {
+		t.Errorf("writes=%d, want %d", knob.writes, writeAttempts)
+	}
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MIT
+
+// Package geo tracks the machine's coarse location through GeoClue.
+package geo
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// DesktopID identifies the daemon to GeoClue; it must be allowed as a system
+// client in /etc/geoclue/geoclue.conf.
+const DesktopID = "conservationd"
+
+// accuracyCity is GeoClue's GCLUE_ACCURACY_LEVEL_CITY: enough to tell home
+// from away without asking for a precise fix.
+const accuracyCity uint32 = 4
+
+const (
+	geoclueDest   = "org.freedesktop.GeoClue2"
+	clientIface   = "org.freedesktop.GeoClue2.Client"
+	locationIface = "org.freedesktop.GeoClue2.Location"
+)
+
+// Fix is a location reading.
+type Fix struct {
+	Lat, Lon float64
+	Accuracy float64 // metres
+}
+
+// Tracker holds the latest location reported by GeoClue.
+type Tracker struct {
+	mu  sync.Mutex
+	fix Fix
+	ok  bool
+}
+
+// Location returns the latest fix; ok is false until GeoClue reported one.
+func (t *Tracker) Location() (Fix, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fix, t.ok
+}
+
+func (t *Tracker) set(f Fix) {
+	t.mu.Lock()
+	t.fix, t.ok = f, true
+	t.mu.Unlock()
+}
+
+// Track registers a city-accuracy GeoClue client on conn and keeps the
+// returned Tracker updated until ctx is cancelled.
+func Track(ctx context.Context, conn *dbus.Conn) (*Tracker, error) {
+	mgr := conn.Object(geoclueDest, "/org/freedesktop/GeoClue2/Manager")
+	var path dbus.ObjectPath
+	if err := mgr.CallWithContext(ctx, "org.freedesktop.GeoClue2.Manager.GetClient", 0).Store(&path); err != nil {
+		return nil, fmt.Errorf("GetClient: %w", err)
+	}
+	client := conn.Object(geoclueDest, path)
+	for prop, v := range map[string]any{"DesktopId": DesktopID, "RequestedAccuracyLevel": accuracyCity} {
+		if err := client.SetProperty(clientIface+"."+prop, dbus.MakeVariant(v)); err != nil {
+			return nil, fmt.Errorf("set %s: %w", prop, err)
+		}
+	}
+	if err := conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(clientIface),
+		dbus.WithMatchMember("LocationUpdated"),
+	); err != nil {
+		return nil, fmt.Errorf("watch LocationUpdated: %w", err)
+	}
+	sigs := make(chan *dbus.Signal, 4)
+	conn.Signal(sigs)
+	if err := client.CallWithContext(ctx, clientIface+".Start", 0).Err; err != nil {
+		conn.RemoveSignal(sigs)
+		return nil, fmt.Errorf("start GeoClue client: %w", err)
+	}
+
+	t := &Tracker{}
+	go func() {
+		defer func() {
+			conn.RemoveSignal(sigs)
+			client.Call(clientIface+".Stop", 0)
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig, ok := <-sigs:
+				if !ok {
+					return
+				}
+				if sig.Path != path || sig.Name != clientIface+".LocationUpdated" || len(sig.Body) != 2 {
+					continue
+				}
+				loc, _ := sig.Body[1].(dbus.ObjectPath)
+				if f, err := readFix(ctx, conn, loc); err == nil {
+					t.set(f)
+				}
+			}
+		}
+	}()
+	return t, nil
+}
+
+func readFix(ctx context.Context, conn *dbus.Conn, path dbus.ObjectPath) (Fix, error) {
+	var props map[string]dbus.Variant
+	if err := conn.Object(geoclueDest, path).CallWithContext(ctx,
+		"org.freedesktop.DBus.Properties.GetAll", 0, locationIface).Store(&props); err != nil {
+		return Fix{}, err
+	}
+	var f Fix
+	var ok1, ok2 bool
+	f.Lat, ok1 = props["Latitude"].Value().(float64)
+	f.Lon, ok2 = props["Longitude"].Value().(float64)
+	f.Accuracy, _ = props["Accuracy"].Value().(float64)
+	if !ok1 || !ok2 {
+		return Fix{}, fmt.Errorf("location %s: missing coordinates", path)
+	}
+	return f, nil
+}
+
+// DistanceKm returns the great-circle distance between two points.
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat, dLon := rad(lat2-lat1), rad(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
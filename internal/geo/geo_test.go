@@ -0,0 +1,16 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistanceKm(t *testing.T) {
+	// Rome to Milan is about 477 km as the crow flies
+	if d := DistanceKm(41.9028, 12.4964, 45.4642, 9.1900); math.Abs(d-477) > 5 {
+		t.Errorf("Rome-Milan = %.0f km", d)
+	}
+	if d := DistanceKm(45, 9, 45, 9); d != 0 {
+		t.Errorf("same point = %f", d)
+	}
+}
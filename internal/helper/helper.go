@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: MIT
+
+// Package helper implements the privileged host helper that performs sysfs
+// writes for a daemon running in a container or Flatpak, where sysfs is
+// read-only.
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"conservationDaemon/internal/logging"
+)
+
+// DefaultSock is where the helper listens by default.
+const DefaultSock = "/run/conservation-helper/helper.sock"
+
+// Req is one helper request: "write" a value to a knob, or "ping".
+type Req struct {
+	Cmd   string `json:"cmd"`
+	Path  string `json:"path,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// Resp answers a Req.
+type Resp struct {
+	Ok  bool   `json:"ok"`
+	Msg string `json:"msg,omitempty"`
+}
+
+// Only these sysfs attributes, under these directories, may be written:
+// the helper must not become a general-purpose root file writer.
+var (
+	allowedDirs  = []string{"/sys/bus/platform/drivers/ideapad_acpi/", "/sys/class/power_supply/", "/sys/firmware/acpi/", "/sys/devices/platform/smapi/", "/sys/devices/platform/msi-ec/"}
+	allowedNames = []string{
+		"conservation_mode", "charge_types", "charge_behaviour",
+		"charge_control_start_threshold", "charge_control_end_threshold",
+		"start_charge_thresh", "stop_charge_thresh", "battery_mode",
+		"constant_charge_current_max", "rapid_charge", "usb_charging", "platform_profile",
+	}
+)
+
+// Allowed reports whether the helper may write to path.
+func Allowed(path string) bool {
+	if path != filepath.Clean(path) || strings.Contains(path, "..") {
+		return false
+	}
+	if !slices.Contains(allowedNames, filepath.Base(path)) {
+		return false
+	}
+	return slices.ContainsFunc(allowedDirs, func(dir string) bool { return strings.HasPrefix(path, dir) })
+}
+
+// Authorize decides whether the process at the other end of c may write.
+type Authorize func(c net.Conn) error
+
+// AuthTimeout bounds a write waiting for authorization, which may ask the
+// user for a password.
+const AuthTimeout = 2 * time.Minute
+
+// Serve answers requests on ln until ctx is cancelled or ln is closed.
+// write performs the actual writes (backend.WriteDirect outside tests).
+// authorize, if not nil, vets every write; otherwise any client that can
+// open the socket may write.
+func Serve(ctx context.Context, ln net.Listener, write func(path, value string) error, authorize Authorize) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			logging.Warnf("accept: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go handle(c, write, authorize)
+	}
+}
+
+func handle(c net.Conn, write func(path, value string) error, authorize Authorize) {
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	var r Req
+	if err := json.NewDecoder(c).Decode(&r); err != nil {
+		_ = json.NewEncoder(c).Encode(Resp{Msg: err.Error()})
+		return
+	}
+	if r.Cmd == "write" && authorize != nil {
+		_ = c.SetDeadline(time.Now().Add(AuthTimeout))
+		if err := authorize(c); err != nil {
+			logging.Warnf("refused write to %s: %v", r.Path, err)
+			_ = json.NewEncoder(c).Encode(Resp{Msg: err.Error()})
+			return
+		}
+	}
+	_ = json.NewEncoder(c).Encode(answer(r, write))
+}
+
+func answer(r Req, write func(path, value string) error) Resp {
+	switch r.Cmd {
+	case "ping":
+		return Resp{Ok: true, Msg: "pong"}
+	case "write":
+		if !Allowed(r.Path) {
+			logging.Warnf("refused write to %s", r.Path)
+			return Resp{Msg: fmt.Sprintf("%s is not a charge knob", r.Path)}
+		}
+		if strings.ContainsAny(r.Value, "\n\x00") || len(r.Value) > 32 {
+			return Resp{Msg: "invalid value"}
+		}
+		if err := write(r.Path, r.Value); err != nil {
+			return Resp{Msg: err.Error()}
+		}
+		logging.Logf("wrote %s to %s", r.Value, r.Path)
+		return Resp{Ok: true}
+	default:
+		return Resp{Msg: "unknown cmd"}
+	}
+}
+
+// Client talks to a running helper.
+type Client struct {
+	Sock string
+}
+
+func (c Client) call(r Req) error {
+	conn, err := net.DialTimeout("unix", c.Sock, 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("helper: %w", err)
+	}
+	defer conn.Close()
+	// Leave time for a polkit password prompt
+	_ = conn.SetDeadline(time.Now().Add(AuthTimeout))
+	if err := json.NewEncoder(conn).Encode(r); err != nil {
+		return fmt.Errorf("helper: %w", err)
+	}
+	var resp Resp
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("helper: %w", err)
+	}
+	if !resp.Ok {
+		return fmt.Errorf("helper: %s", resp.Msg)
+	}
+	return nil
+}
+
+// Ping checks that the helper is up.
+func (c Client) Ping() error {
+	return c.call(Req{Cmd: "ping"})
+}
+
+// Write asks the helper to write value to the sysfs file at path. It has the
+// signature backend.SetWriter expects.
+func (c Client) Write(path, value string) error {
+	return c.call(Req{Cmd: "write", Path: path, Value: value})
+}
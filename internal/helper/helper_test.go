@@ -0,0 +1,95 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/sys/bus/platform/drivers/ideapad_acpi/VPC2004:00/conservation_mode": true,
+		"/sys/class/power_supply/BAT0/charge_control_end_threshold":           true,
+		"/sys/firmware/acpi/platform_profile":                                 true,
+		"/sys/class/power_supply/BAT0/../../../etc/shadow":                    false,
+		"/etc/conservation_mode":                                              false,
+		"/sys/class/power_supply/BAT0/uevent":                                 false,
+	} {
+		if got := Allowed(path); got != want {
+			t.Errorf("Allowed(%s) = %t", path, got)
+		}
+	}
+}
+
+func TestClientServer(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "helper.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	written := map[string]string{}
+	go Serve(ctx, ln, func(path, value string) error {
+		written[path] = value
+		return nil
+	}, nil)
+
+	c := Client{Sock: sock}
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	knob := "/sys/class/power_supply/BAT0/charge_types"
+	if err := c.Write(knob, "Long_Life"); err != nil {
+		t.Fatal(err)
+	}
+	if written[knob] != "Long_Life" {
+		t.Errorf("written = %v", written)
+	}
+	if err := c.Write("/etc/passwd", "x"); err == nil {
+		t.Error("write outside the allowlist accepted")
+	}
+	if err := (Client{Sock: filepath.Join(t.TempDir(), "none")}).Ping(); err == nil {
+		t.Error("ping without a helper succeeded")
+	}
+}
+
+func TestServeAuthorize(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "helper.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var writes atomic.Int32
+	refuse := func(net.Conn) error { return errors.New("not authorized") }
+	go Serve(ctx, ln, func(path, value string) error {
+		writes.Add(1)
+		return nil
+	}, refuse)
+
+	c := Client{Sock: sock}
+	if err := c.Ping(); err != nil {
+		t.Fatalf("ping needs no authorization: %v", err)
+	}
+	err = c.Write("/sys/class/power_supply/BAT0/charge_types", "Long_Life")
+	if err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("unauthorized write: %v", err)
+	}
+	if writes.Load() != 0 {
+		t.Error("unauthorized write reached sysfs")
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	start, err := startTime(int32(os.Getpid()))
+	if err != nil || start == 0 {
+		t.Errorf("startTime = %d, %v", start, err)
+	}
+}
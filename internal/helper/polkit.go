@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: MIT
+
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// PolkitAction is the polkit action that guards knob writes; see
+// packaging/polkit for its default policy.
+const PolkitAction = "org.conservationd.write-knob"
+
+// Polkit authorizes each writer with polkit over the system bus, as the
+// process on the other end of the socket. polkit may have the user's agent
+// ask for their password, so an unprivileged conservationd in a desktop
+// session can write once the user approves.
+func Polkit(bus *dbus.Conn) Authorize {
+	authority := bus.Object("org.freedesktop.PolicyKit1", "/org/freedesktop/PolicyKit1/Authority")
+	return func(c net.Conn) error {
+		cred, err := peerCred(c)
+		if err != nil {
+			return err
+		}
+		start, err := startTime(cred.Pid)
+		if err != nil {
+			return err
+		}
+		subject := struct {
+			Kind    string
+			Details map[string]dbus.Variant
+		}{"unix-process", map[string]dbus.Variant{
+			"pid":        dbus.MakeVariant(uint32(cred.Pid)),
+			"start-time": dbus.MakeVariant(start),
+			"uid":        dbus.MakeVariant(int32(cred.Uid)),
+		}}
+		const allowUserInteraction = 1
+		var result struct {
+			Authorized bool
+			Challenge  bool
+			Details    map[string]string
+		}
+		err = authority.Call("org.freedesktop.PolicyKit1.Authority.CheckAuthorization", 0,
+			subject, PolkitAction, map[string]string{}, uint32(allowUserInteraction), "").Store(&result)
+		if err != nil {
+			return fmt.Errorf("polkit: %w", err)
+		}
+		if !result.Authorized {
+			return fmt.Errorf("uid %d is not authorized for %s", cred.Uid, PolkitAction)
+		}
+		return nil
+	}
+}
+
+// peerCred returns the credentials of the process on the other end of c.
+func peerCred(c net.Conn) (*syscall.Ucred, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("polkit needs a unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("peer credentials: %w", credErr)
+	}
+	return cred, nil
+}
+
+// startTime returns the start time of process pid in clock ticks since
+// boot, which polkit pairs with the pid against pid reuse.
+func startTime(pid int32) (uint64, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name may hold spaces: fields count from its closing ")"
+	s := string(b)
+	fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("/proc/%d/stat: too few fields", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+
+// Package hotkey listens for a hardware key on an evdev input device.
+package hotkey
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeyBattery is KEY_BATTERY from linux/input-event-codes.h, emitted by the
+// battery/conservation key of some IdeaPads.
+const KeyBattery = 236
+
+// DefaultDevice is the input device name the ideapad_laptop driver registers.
+const DefaultDevice = "Ideapad extra buttons"
+
+// sysInputDir lists input devices, overridable in tests.
+var sysInputDir = "/sys/class/input"
+
+const (
+	evKey     = 1
+	keyPress  = 1
+	eventSize = 24 // struct input_event on 64-bit: timeval, type, code, value
+)
+
+// Find returns the /dev/input/eventN node of the device whose name
+// contains name (case-insensitive).
+func Find(name string) (string, error) {
+	nodes, _ := filepath.Glob(filepath.Join(sysInputDir, "event*"))
+	for _, n := range nodes {
+		b, err := os.ReadFile(filepath.Join(n, "device", "name"))
+		if err == nil && strings.Contains(strings.ToLower(string(b)), strings.ToLower(name)) {
+			return filepath.Join("/dev/input", filepath.Base(n)), nil
+		}
+	}
+	return "", fmt.Errorf("no input device named %q", name)
+}
+
+// Listen reports presses of key on the evdev device at path until ctx is
+// cancelled.
+func Listen(ctx context.Context, path string, key uint16) (<-chan struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	out := make(chan struct{}, 1)
+	go func() {
+		for {
+			pressed, err := readPress(f, key)
+			if err != nil {
+				return
+			}
+			if !pressed {
+				continue
+			}
+			select {
+			case out <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return out, nil
+}
+
+// readPress reads one input_event and reports whether it is a press of key.
+func readPress(r io.Reader, key uint16) (bool, error) {
+	var buf [eventSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return false, err
+	}
+	typ := binary.LittleEndian.Uint16(buf[16:])
+	code := binary.LittleEndian.Uint16(buf[18:])
+	value := int32(binary.LittleEndian.Uint32(buf[20:]))
+	return typ == evKey && code == key && value == keyPress, nil
+}
@@ -0,0 +1,57 @@
+package hotkey
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func event(typ, code uint16, value int32) []byte {
+	b := make([]byte, eventSize)
+	binary.LittleEndian.PutUint16(b[16:], typ)
+	binary.LittleEndian.PutUint16(b[18:], code)
+	binary.LittleEndian.PutUint32(b[20:], uint32(value))
+	return b
+}
+
+func TestReadPress(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(event(evKey, KeyBattery, 1)) // press
+	stream.Write(event(evKey, KeyBattery, 0)) // release
+	stream.Write(event(0, 0, 0))              // EV_SYN
+	stream.Write(event(evKey, 30, 1))         // another key
+
+	want := []bool{true, false, false, false}
+	for i, w := range want {
+		got, err := readPress(&stream, KeyBattery)
+		if err != nil || got != w {
+			t.Errorf("event %d: %t, %v", i, got, err)
+		}
+	}
+	if _, err := readPress(&stream, KeyBattery); err == nil {
+		t.Error("expected EOF")
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := t.TempDir()
+	old := sysInputDir
+	sysInputDir = root
+	t.Cleanup(func() { sysInputDir = old })
+
+	dir := filepath.Join(root, "event7", "device")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "name"), []byte("Ideapad extra buttons\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := Find("ideapad extra"); err != nil || p != "/dev/input/event7" {
+		t.Errorf("Find = %q, %v", p, err)
+	}
+	if _, err := Find("thinkpad"); err == nil {
+		t.Error("Find matched the wrong device")
+	}
+}
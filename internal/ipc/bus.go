@@ -0,0 +1,277 @@
+// SPDX-License-Identifier: MIT
+
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// The daemon's D-Bus API on the system bus, for desktop widgets and other
+// tools that would rather not speak the socket protocol. Calls go through
+// the same handlers as socket requests; who may make them is up to the
+// bus policy (packaging/dbus/org.conservationd.conf) and, for changes, to
+// Server.AdminGroup as on the socket.
+const (
+	BusName      = "org.conservationd"
+	BusPath      = dbus.ObjectPath("/org/conservationd/Manager")
+	BusInterface = "org.conservationd.Manager"
+)
+
+const propsInterface = "org.freedesktop.DBus.Properties"
+
+const busIntrospection = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+  <interface name="` + BusInterface + `">
+    <method name="Get">
+      <arg name="max_percent" type="d" direction="out"/>
+      <arg name="time" type="s" direction="out"/>
+      <arg name="auto" type="b" direction="out"/>
+    </method>
+    <method name="Set">
+      <arg name="max_percent" type="d" direction="in"/>
+      <arg name="time" type="s" direction="in"/>
+    </method>
+    <property name="Percentage" type="d" access="read"/>
+    <property name="ConservationEnabled" type="b" access="read"/>
+    <property name="MaxPercent" type="d" access="readwrite"/>
+    <property name="MinPercent" type="d" access="read"/>
+    <property name="State" type="s" access="read"/>
+    <signal name="ConservationChanged">
+      <arg name="enabled" type="b"/>
+    </signal>
+    <signal name="ThresholdsChanged">
+      <arg name="max_percent" type="d"/>
+      <arg name="min_percent" type="d"/>
+    </signal>
+  </interface>
+  <interface name="` + propsInterface + `">
+    <method name="Get">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="property" type="s" direction="in"/>
+      <arg name="value" type="v" direction="out"/>
+    </method>
+    <method name="GetAll">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="properties" type="a{sv}" direction="out"/>
+    </method>
+    <method name="Set">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="property" type="s" direction="in"/>
+      <arg name="value" type="v" direction="in"/>
+    </method>
+    <signal name="PropertiesChanged">
+      <arg name="interface" type="s"/>
+      <arg name="changed_properties" type="a{sv}"/>
+      <arg name="invalidated_properties" type="as"/>
+    </signal>
+  </interface>
+  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect">
+      <arg name="xml_data" type="s" direction="out"/>
+    </method>
+  </interface>
+</node>`
+
+// ServeBus exports the Manager object on conn, takes BusName and signals
+// changes as the control loop publishes them until ctx is cancelled, then
+// releases the name.
+func (s *Server) ServeBus(ctx context.Context, conn *dbus.Conn) error {
+	m := &busManager{s: s, credOf: func(sender dbus.Sender) (*syscall.Ucred, error) {
+		return busCred(conn, sender)
+	}}
+	if err := conn.Export(m, BusPath, BusInterface); err != nil {
+		return fmt.Errorf("dbus: %w", err)
+	}
+	if err := conn.Export(busProps{m}, BusPath, propsInterface); err != nil {
+		return fmt.Errorf("dbus: %w", err)
+	}
+	if err := conn.Export(busIntrospectable{}, BusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return fmt.Errorf("dbus: %w", err)
+	}
+	reply, err := conn.RequestName(BusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return fmt.Errorf("dbus: own %s: %w", BusName, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("dbus: %s is already owned", BusName)
+	}
+	defer conn.ReleaseName(BusName)
+
+	last := m.props()
+	for {
+		changed := m.s.State.Changed()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		}
+		cur := m.props()
+		emitChanges(conn, last, cur)
+		last = cur
+	}
+}
+
+// emitChanges signals PropertiesChanged for the properties that differ
+// between last and cur and, when they are among them, ConservationChanged
+// and ThresholdsChanged.
+func emitChanges(conn *dbus.Conn, last, cur map[string]dbus.Variant) {
+	changed := changedProps(last, cur)
+	if len(changed) == 0 {
+		return
+	}
+	conn.Emit(BusPath, propsInterface+".PropertiesChanged", BusInterface, changed, []string{})
+	if v, ok := changed["ConservationEnabled"]; ok {
+		conn.Emit(BusPath, BusInterface+".ConservationChanged", v.Value())
+	}
+	_, maxChanged := changed["MaxPercent"]
+	_, minChanged := changed["MinPercent"]
+	if maxChanged || minChanged {
+		conn.Emit(BusPath, BusInterface+".ThresholdsChanged", cur["MaxPercent"].Value(), cur["MinPercent"].Value())
+	}
+}
+
+// busManager implements org.conservationd.Manager.
+type busManager struct {
+	s *Server
+
+	// credOf returns the credentials of the sender of a call.
+	credOf func(sender dbus.Sender) (*syscall.Ucred, error)
+}
+
+// busCred asks the bus for the credentials of sender. The groups are
+// looked up from its pid when checked, like a socket peer's; the gid is
+// the primary group of its user.
+func busCred(conn *dbus.Conn, sender dbus.Sender) (*syscall.Ucred, error) {
+	var creds map[string]dbus.Variant
+	if err := conn.BusObject().Call("org.freedesktop.DBus.GetConnectionCredentials", 0, string(sender)).Store(&creds); err != nil {
+		return nil, err
+	}
+	uid, ok1 := creds["UnixUserID"].Value().(uint32)
+	pid, ok2 := creds["ProcessID"].Value().(uint32)
+	if !ok1 || !ok2 {
+		return nil, errors.New("the bus didn't report the caller's uid and pid")
+	}
+	cred := &syscall.Ucred{Pid: int32(pid), Uid: uid, Gid: ^uint32(0)}
+	if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+		if gid, err := strconv.ParseUint(u.Gid, 10, 32); err == nil {
+			cred.Gid = uint32(gid)
+		}
+	}
+	return cred, nil
+}
+
+// Get returns the target, like conservationctl without flags.
+func (m *busManager) Get() (float64, string, bool, *dbus.Error) {
+	resp := m.s.handle(Req{Cmd: CmdGet})
+	return resp.Max, resp.Time, resp.Auto, nil
+}
+
+// Set sets the target: max_percent by time (HH:MM), or now if time is ""
+// or "now", like conservationctl -set.
+func (m *busManager) Set(sender dbus.Sender, max float64, at string) *dbus.Error {
+	return m.call(sender, Req{Cmd: CmdSet, Max: max, Time: at})
+}
+
+func (m *busManager) call(sender dbus.Sender, r Req) *dbus.Error {
+	if m.s.AdminGroup != "" && r.Mutates() {
+		cred, err := m.credOf(sender)
+		if err == nil {
+			err = m.s.authorizeCred(cred, r.Cmd)
+		}
+		if err != nil {
+			return dbus.NewError("org.freedesktop.DBus.Error.AccessDenied", []any{err.Error()})
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []any{err.Error()})
+	}
+	if resp := m.s.handle(r); !resp.Ok {
+		return dbus.NewError(BusInterface+".Error.Failed", []any{resp.Msg})
+	}
+	return nil
+}
+
+// props returns the Manager properties, from the control loop's last
+// snapshot.
+func (m *busManager) props() map[string]dbus.Variant {
+	st := m.s.State.Status()
+	return map[string]dbus.Variant{
+		"Percentage":          dbus.MakeVariant(st.Pct),
+		"ConservationEnabled": dbus.MakeVariant(st.Cons == 1),
+		"MaxPercent":          dbus.MakeVariant(st.Config.MaxPercent),
+		"MinPercent":          dbus.MakeVariant(st.Config.ConservationThreshold),
+		"State":               dbus.MakeVariant(st.BatteryState.String()),
+	}
+}
+
+// changedProps returns the properties of cur whose value differs in last.
+func changedProps(last, cur map[string]dbus.Variant) map[string]dbus.Variant {
+	changed := map[string]dbus.Variant{}
+	for k, v := range cur {
+		if old, ok := last[k]; !ok || old.Value() != v.Value() {
+			changed[k] = v
+		}
+	}
+	return changed
+}
+
+// busProps implements org.freedesktop.DBus.Properties for the Manager.
+type busProps struct {
+	m *busManager
+}
+
+func (p busProps) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	all, derr := p.GetAll(iface)
+	if derr != nil {
+		return dbus.Variant{}, derr
+	}
+	v, ok := all[name]
+	if !ok {
+		return dbus.Variant{}, unknownProperty(name)
+	}
+	return v, nil
+}
+
+func (p busProps) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	if iface != BusInterface {
+		return nil, dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface", []any{iface})
+	}
+	return p.m.props(), nil
+}
+
+// Set changes MaxPercent, effective now; the other properties are
+// read-only.
+func (p busProps) Set(sender dbus.Sender, iface, name string, v dbus.Variant) *dbus.Error {
+	if iface != BusInterface {
+		return dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface", []any{iface})
+	}
+	switch name {
+	case "MaxPercent":
+		max, ok := v.Value().(float64)
+		if !ok {
+			return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []any{"MaxPercent is a double"})
+		}
+		return p.m.call(sender, Req{Cmd: CmdSet, Max: max, Time: "now"})
+	case "Percentage", "ConservationEnabled", "MinPercent", "State":
+		return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly", []any{name + " is read-only"})
+	}
+	return unknownProperty(name)
+}
+
+func unknownProperty(name string) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []any{"no property " + name})
+}
+
+type busIntrospectable struct{}
+
+func (busIntrospectable) Introspect() (string, *dbus.Error) {
+	return busIntrospection, nil
+}
@@ -0,0 +1,84 @@
+package ipc
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestBusManager(t *testing.T) {
+	s := newTestServer(t)
+	m := &busManager{s: s}
+	p := busProps{m}
+
+	if derr := m.Set("", 90, "now"); derr != nil {
+		t.Fatalf("Set: %v", derr)
+	}
+	if max, at, _, derr := m.Get(); derr != nil || max != 90 || at != "now" {
+		t.Errorf("Get = %v, %q, %v", max, at, derr)
+	}
+	if derr := m.Set("", 101, ""); derr == nil || derr.Name != "org.freedesktop.DBus.Error.InvalidArgs" {
+		t.Errorf("Set(101) = %v", derr)
+	}
+
+	if derr := p.Set("", BusInterface, "MaxPercent", dbus.MakeVariant(85.0)); derr != nil {
+		t.Fatalf("set MaxPercent: %v", derr)
+	}
+	if v, derr := p.Get(BusInterface, "MaxPercent"); derr != nil || v.Value() != 85.0 {
+		t.Errorf("MaxPercent = %v, %v", v, derr)
+	}
+	if derr := p.Set("", BusInterface, "Percentage", dbus.MakeVariant(50.0)); derr == nil {
+		t.Error("Percentage is writable")
+	}
+	if _, derr := p.Get(BusInterface, "Nope"); derr == nil {
+		t.Error("unknown property read")
+	}
+	if _, derr := p.GetAll("org.example.Other"); derr == nil {
+		t.Error("unknown interface read")
+	}
+
+	last := m.props()
+	all, _ := p.GetAll(BusInterface)
+	for _, name := range []string{"Percentage", "ConservationEnabled", "MaxPercent", "MinPercent", "State"} {
+		if _, ok := all[name]; !ok {
+			t.Errorf("GetAll lacks %s", name)
+		}
+	}
+	m.Set("", 95, "now")
+	changed := changedProps(last, m.props())
+	if len(changed) != 1 || changed["MaxPercent"].Value() != 95.0 {
+		t.Errorf("changed = %v", changed)
+	}
+}
+
+func TestBusAdminGroup(t *testing.T) {
+	s := newTestServer(t)
+	s.AdminGroup = otherGroup(t)
+	nobody := &syscall.Ucred{Pid: int32(os.Getpid()), Uid: 65534, Gid: 65534}
+	m := &busManager{s: s, credOf: func(sender dbus.Sender) (*syscall.Ucred, error) {
+		if sender == ":1.42" {
+			return &syscall.Ucred{Pid: int32(os.Getpid())}, nil
+		}
+		return nobody, nil
+	}}
+	p := busProps{m}
+
+	if derr := m.Set(":1.7", 90, "now"); derr == nil || derr.Name != "org.freedesktop.DBus.Error.AccessDenied" {
+		t.Errorf("Set from a non-admin = %v", derr)
+	}
+	if derr := p.Set(":1.7", BusInterface, "MaxPercent", dbus.MakeVariant(85.0)); derr == nil {
+		t.Error("MaxPercent set by a non-admin")
+	}
+	if got := s.State.Config().MaxPercent; got != 80 {
+		t.Errorf("max changed to %v by a non-admin", got)
+	}
+	if _, _, _, derr := m.Get(); derr != nil {
+		t.Errorf("Get from a non-admin: %v", derr)
+	}
+	// Root may
+	if derr := m.Set(":1.42", 90, "now"); derr != nil {
+		t.Errorf("Set from root: %v", derr)
+	}
+}
@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: MIT
+
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encode writes one protocol message to w.
+func Encode(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Decode reads one protocol message from r.
+func Decode(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// decodeReq reads a request, refusing fields this daemon doesn't know: a
+// newer client gets an error instead of having part of its request
+// silently ignored.
+func decodeReq(dec *json.Decoder, req *Req) error {
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil {
+		if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			return errorf(ErrUnsupportedField, "unsupported request field %s (daemon protocol %d; update conservationd)",
+				strings.TrimPrefix(err.Error(), "json: unknown field "), ProtocolVersion)
+		}
+		return err
+	}
+	return nil
+}
+
+// Abstract reports whether sock names a Linux abstract socket, like
+// "@conservationd": it lives in the network namespace rather than the
+// filesystem, so it needs no writable directory and leaves nothing behind.
+func Abstract(sock string) bool {
+	return strings.HasPrefix(sock, "@")
+}
+
+// SocketExists reports whether sock is there to connect to: a socket file,
+// or an abstract socket something listens on.
+func SocketExists(sock string) bool {
+	if Abstract(sock) {
+		c, err := net.DialTimeout("unix", sock, time.Second)
+		if err != nil {
+			return false
+		}
+		c.Close()
+		return true
+	}
+	fi, err := os.Stat(sock)
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+// DiscoverSocket picks the control socket: an explicit path wins, then
+// $CONSERVATIOND_SOCK, then preferred (e.g. a socket picked in the tray's
+// preferences), then a per-user socket under $XDG_RUNTIME_DIR if it exists,
+// and finally DefaultSock.
+func DiscoverSocket(explicit, preferred string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if env := os.Getenv("CONSERVATIOND_SOCK"); env != "" {
+		return env
+	}
+	if preferred != "" {
+		return preferred
+	}
+	if rt := os.Getenv("XDG_RUNTIME_DIR"); rt != "" {
+		p := filepath.Join(rt, "conservationd", "conservationd.sock")
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return DefaultSock
+}
+
+// Hello asks the daemon at sock for its protocol version, commands and
+// features. A daemon from before hello answers with Protocol 0 and no
+// commands.
+func Hello(sock string, timeout time.Duration) (*Resp, error) {
+	resp, err := Call(sock, timeout, Req{Cmd: CmdHello})
+	// Daemons from before hello are also from before error codes
+	if err != nil && strings.Contains(err.Error(), fmt.Sprintf("unknown cmd %q", CmdHello)) {
+		return &Resp{Ok: true}, nil
+	}
+	return resp, err
+}
+
+// Subscribe streams the events named in types (all if none) from the
+// daemon at sock, calling fn with each event's JSON object, until ctx is
+// cancelled, fn returns false or the daemon goes away.
+func Subscribe(ctx context.Context, sock string, types []string, fn func(json.RawMessage) bool) error {
+	req := Req{Cmd: CmdSubscribe, Events: types}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "unix", sock)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+	if err := Encode(c, req); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(c)
+	var resp Resp
+	if err := dec.Decode(&resp); err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return &Error{Code: resp.Code, Msg: resp.Msg}
+	}
+	for {
+		var ev json.RawMessage
+		if err := dec.Decode(&ev); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !fn(ev) {
+			return nil
+		}
+	}
+}
+
+// Conn is a connection to the daemon kept open across requests, for
+// clients that ask often, like the tray. It redials once when the daemon
+// has closed it (idle timeout, restart). It is safe for concurrent use.
+type Conn struct {
+	Sock    string
+	Timeout time.Duration // per request, dial included; zero means no limit
+
+	mu  sync.Mutex
+	c   net.Conn
+	dec *json.Decoder
+}
+
+// Call sends req and returns the daemon's response, like the function Call.
+func (cn *Conn) Call(req Req) (*Resp, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if req.Cmd == CmdSubscribe {
+		return nil, errors.New("subscribe needs a connection of its own: use Subscribe")
+	}
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	reused := cn.c != nil
+	resp, err := cn.roundTrip(req)
+	if err != nil && reused {
+		// Most likely closed by the daemon since the last request
+		resp, err = cn.roundTrip(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Ok {
+		return nil, &Error{Code: resp.Code, Msg: resp.Msg}
+	}
+	return resp, nil
+}
+
+// roundTrip sends req on the open connection, dialing first if there is
+// none, and drops the connection on any failure.
+func (cn *Conn) roundTrip(req Req) (*Resp, error) {
+	if cn.c == nil {
+		c, err := net.DialTimeout("unix", cn.Sock, cn.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		cn.c, cn.dec = c, json.NewDecoder(c)
+	}
+	if cn.Timeout > 0 {
+		_ = cn.c.SetDeadline(time.Now().Add(cn.Timeout))
+	}
+	var resp Resp
+	err := Encode(cn.c, req)
+	if err == nil {
+		err = cn.dec.Decode(&resp)
+	}
+	if err != nil {
+		cn.c.Close()
+		cn.c, cn.dec = nil, nil
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Close closes the connection, if open. The Conn can still be used: the
+// next Call dials again.
+func (cn *Conn) Close() error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if cn.c == nil {
+		return nil
+	}
+	err := cn.c.Close()
+	cn.c, cn.dec = nil, nil
+	return err
+}
+
+// Call sends req to the daemon at sock and returns its response. A response
+// with Ok unset is returned as an *Error carrying the daemon's code and
+// message. A zero timeout means no limit.
+func Call(sock string, timeout time.Duration, req Req) (*Resp, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := net.DialTimeout("unix", sock, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if timeout > 0 {
+		_ = c.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := Encode(c, req); err != nil {
+		return nil, err
+	}
+	var resp Resp
+	if err := Decode(c, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Ok {
+		return nil, &Error{Code: resp.Code, Msg: resp.Msg}
+	}
+	return &resp, nil
+}
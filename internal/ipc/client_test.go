@@ -0,0 +1,246 @@
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/logging"
+)
+
+func TestCall(t *testing.T) {
+	s := newTestServer(t)
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Serve(context.Background(), ln)
+		close(done)
+	}()
+	defer func() {
+		ln.Close()
+		<-done
+	}()
+
+	resp, err := Call(sock, time.Second, Req{Cmd: CmdSet, Max: 95, Time: "now"})
+	if err != nil || resp.Max != 95 {
+		t.Fatalf("set: %+v, %v", resp, err)
+	}
+	// Rejected by the daemon: below the conservation threshold
+	if _, err := Call(sock, time.Second, Req{Cmd: CmdSet, Max: 50}); ErrorCode(err) != ErrRange {
+		t.Errorf("daemon error: %v", err)
+	}
+	// Rejected before dialing
+	if _, err := Call(sock, time.Second, Req{Cmd: "bogus"}); ErrorCode(err) != ErrUnsupportedCmd {
+		t.Errorf("unknown command: %v", err)
+	}
+	if _, err := Call(sock, time.Second, Req{Cmd: CmdSet, Max: 90, Time: "noon"}); ErrorCode(err) != ErrInvalid {
+		t.Errorf("bad time: %v", err)
+	}
+	if ErrorCode(errors.New("plain")) != "" {
+		t.Error("code for a plain error")
+	}
+}
+
+func TestConn(t *testing.T) {
+	s := newTestServer(t)
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.IdleTimeout = 50 * time.Millisecond
+	go s.Serve(ctx, ln)
+
+	cn := &Conn{Sock: sock, Timeout: time.Second}
+	defer cn.Close()
+	if resp, err := cn.Call(Req{Cmd: CmdSet, Max: 90, Time: "now"}); err != nil || resp.Max != 90 {
+		t.Fatalf("set: %+v, %v", resp, err)
+	}
+	first := cn.c
+	if resp, err := cn.Call(Req{Cmd: CmdGet}); err != nil || resp.Max != 90 {
+		t.Fatalf("get: %+v, %v", resp, err)
+	}
+	if cn.c != first {
+		t.Error("second request dialed again")
+	}
+	if _, err := cn.Call(Req{Cmd: CmdSet, Max: 50}); err == nil {
+		t.Error("daemon error not returned")
+	}
+
+	// Closed by the daemon once idle: the next call redials
+	time.Sleep(150 * time.Millisecond)
+	if resp, err := cn.Call(Req{Cmd: CmdGet}); err != nil || resp.Max != 90 {
+		t.Fatalf("get after idle timeout: %+v, %v", resp, err)
+	}
+	if cn.c == first {
+		t.Error("kept using the connection the daemon closed")
+	}
+}
+
+func TestHello(t *testing.T) {
+	s := newTestServer(t)
+	s.CanStore = true
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, ln)
+
+	hello, err := Hello(sock, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello.Protocol != ProtocolVersion || !hello.Supports(CmdLogLevel) || !hello.Has("storage") || hello.Has("calibrate") {
+		t.Errorf("hello: %+v", hello)
+	}
+
+	// A field from a newer client is refused, not dropped
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte(`{"cmd":"set","max":90,"eco":true}` + "\n"))
+	var resp Resp
+	if err := Decode(c, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Ok || !strings.Contains(resp.Msg, `unsupported request field "eco"`) {
+		t.Errorf("unknown field: %+v", resp)
+	}
+
+	// A daemon from before hello
+	old := filepath.Join(t.TempDir(), "old.sock")
+	oldLn, err := net.Listen("unix", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldLn.Close()
+	go func() {
+		c, err := oldLn.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var r Req
+		Decode(c, &r)
+		Encode(c, Resp{Ok: false, Msg: fmt.Sprintf("unknown cmd %q", r.Cmd)})
+	}()
+	if hello, err := Hello(old, time.Second); err != nil || hello.Protocol != 0 || hello.Supports(CmdGet) {
+		t.Errorf("old daemon hello: %+v, %v", hello, err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := newTestServer(t)
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, ln)
+
+	got := make(chan string, 1)
+	done := make(chan error, 1)
+	subCtx, unsubscribe := context.WithCancel(ctx)
+	go func() {
+		done <- Subscribe(subCtx, sock, []string{"conservation_changed"}, func(ev json.RawMessage) bool {
+			var e struct{ Event, Value string }
+			json.Unmarshal(ev, &e)
+			select {
+			case got <- e.Event + " " + e.Value:
+			default:
+			}
+			return true
+		})
+	}()
+
+	// Events until the subscription is in place; the filter drops decisions
+	deadline := time.After(2 * time.Second)
+	for ev := ""; ev == ""; {
+		logging.Event("decision", map[string]any{"action": "none"})
+		logging.Event("conservation_changed", map[string]any{"value": "1"})
+		select {
+		case ev = <-got:
+			if ev != "conservation_changed 1" {
+				t.Errorf("got %q", ev)
+			}
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no event received")
+		}
+	}
+
+	unsubscribe()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Subscribe returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Subscribe did not return after cancel")
+	}
+}
+
+func TestReqValidate(t *testing.T) {
+	tests := []struct {
+		req Req
+		ok  bool
+	}{
+		{Req{Cmd: CmdStatus}, true},
+		{Req{Cmd: CmdSet, Max: 80, Time: "07:30"}, true},
+		{Req{Cmd: CmdSet, Max: 80, Time: "7h"}, false},
+		{Req{Cmd: CmdSet, Max: 120}, false},
+		{Req{Cmd: CmdExternal, Resolve: "ignore"}, false},
+		{Req{Cmd: CmdRestore}, false},
+		{Req{}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.req.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v", tt.req, err)
+		}
+	}
+}
+
+func TestDiscoverSocket(t *testing.T) {
+	rt := t.TempDir()
+	t.Setenv("CONSERVATIOND_SOCK", "")
+	t.Setenv("XDG_RUNTIME_DIR", rt)
+	if got := DiscoverSocket("", ""); got != DefaultSock {
+		t.Errorf("no per-user socket: %s", got)
+	}
+	if got := DiscoverSocket("", "/tmp/picked.sock"); got != "/tmp/picked.sock" {
+		t.Errorf("preferred: %s", got)
+	}
+	user := filepath.Join(rt, "conservationd", "conservationd.sock")
+	os.MkdirAll(filepath.Dir(user), 0o755)
+	os.WriteFile(user, nil, 0o600)
+	if got := DiscoverSocket("", ""); got != user {
+		t.Errorf("per-user socket: %s", got)
+	}
+	t.Setenv("CONSERVATIOND_SOCK", "/env.sock")
+	if got := DiscoverSocket("", "/tmp/picked.sock"); got != "/env.sock" {
+		t.Errorf("env: %s", got)
+	}
+	if got := DiscoverSocket("/flag.sock", ""); got != "/flag.sock" {
+		t.Errorf("explicit: %s", got)
+	}
+}
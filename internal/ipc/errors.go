@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: MIT
+
+package ipc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes, sent in Resp.Code along with Msg when a request is refused.
+// Msg is for people and may change between versions; the codes are stable,
+// so clients branch on them. Daemons before protocol 7 send no code.
+const (
+	ErrInvalid          = "ERR_INVALID"           // malformed request or bad value, e.g. a time that doesn't parse
+	ErrRange            = "ERR_RANGE"             // a value out of range, e.g. max under the conservation threshold
+	ErrUnsupportedCmd   = "ERR_UNSUPPORTED_CMD"   // unknown command: the daemon is older than the client
+	ErrUnsupportedField = "ERR_UNSUPPORTED_FIELD" // unknown request field: the daemon is older than the client
+	ErrUnsupported      = "ERR_UNSUPPORTED"       // the machine or daemon can't do this, e.g. no force-discharge knob
+	ErrPermission       = "ERR_PERMISSION"        // the caller may not do this, or not on this socket
+	ErrState            = "ERR_STATE"             // refused for now, e.g. while paused or calibrating
+	ErrConflict         = "ERR_CONFLICT"          // the settings changed since the client read them ("if_revision")
+	ErrBackendRead      = "ERR_BACKEND_READ"      // reading the hardware failed
+	ErrBackendWrite     = "ERR_BACKEND_WRITE"     // writing the hardware failed
+	ErrConfig           = "ERR_CONFIG"            // the configuration file can't be read or is invalid
+	ErrLimit            = "ERR_LIMIT"             // too many connections, or a request too large
+	ErrInternal         = "ERR_INTERNAL"          // anything else going wrong in the daemon
+)
+
+// Error is a refused request. Call and Conn.Call return one for a response
+// with Ok unset, and Req.Validate for a request they won't send.
+type Error struct {
+	Code string // one of the Err codes; "" from daemons before protocol 7
+	Msg  string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+// ErrorCode returns the code of err if it is, or wraps, an *Error, and ""
+// otherwise, e.g. for a daemon that can't be reached at all.
+func ErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// errorf returns an *Error with code.
+func errorf(code, format string, a ...any) error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, a...)}
+}
+
+// fail answers a refused request: with err's own code if it has one, code
+// otherwise.
+func fail(code string, err error) Resp {
+	if c := ErrorCode(err); c != "" {
+		code = c
+	}
+	return Resp{Ok: false, Code: code, Msg: err.Error()}
+}
+
+// failf answers a refused request with code and a formatted message.
+func failf(code, format string, a ...any) Resp {
+	return Resp{Ok: false, Code: code, Msg: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MIT
+
+package ipc
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"conservationDaemon/internal/control"
+)
+
+//go:embed web/index.html
+var dashboard []byte
+
+// HTTPHandler serves the dashboard at / and the socket protocol's commands
+// as a small JSON API:
+//
+//	GET  /status, /summary, /presets, /hello
+//	GET  /history      [{"t": 1714564800, "pct": 80, "cons": 1}, ...]
+//	PUT  /thresholds   {"max": 90, "time": "07:30", "auto": true}
+//	POST /storage      {"storage": true}
+//	POST /preset       {"preset": "lifespan"}
+//	POST /calibrate    {"calibrate": "start", "floor": 20}
+//	POST /pause        {"for": "2h"}, or no body to pause until resumed
+//	POST /resume
+//	POST /full         {"cancel": true} to end it early, or no body to start
+//	POST /reload
+//
+// Request bodies use the socket protocol's field names; responses are its
+// Resp objects. The GET endpoints are open to anyone who can reach the
+// listener. The others need "Authorization: Bearer <token>", and are refused
+// altogether if token is empty.
+func (s *Server) HTTPHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	get := func(path, cmd string) {
+		mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+			s.serveHTTP(w, Req{Cmd: cmd})
+		})
+	}
+	get("/status", CmdStatus)
+	get("/summary", CmdSummary)
+	get("/presets", CmdPreset)
+	get("/hello", CmdHello)
+	mux.HandleFunc("GET /history", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, historyOf(s.State.History()))
+	})
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboard)
+	})
+
+	change := func(pattern, cmd string) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if err := checkToken(r, token); err != nil {
+				code := http.StatusUnauthorized
+				if token == "" {
+					code = http.StatusForbidden
+				}
+				writeJSON(w, code, fail(ErrPermission, err))
+				return
+			}
+			var req Req
+			if r.ContentLength != 0 {
+				if err := decodeReq(json.NewDecoder(io.LimitReader(r.Body, 1<<16)), &req); err != nil && !errors.Is(err, io.EOF) {
+					writeJSON(w, http.StatusBadRequest, fail(ErrInvalid, err))
+					return
+				}
+			}
+			req.Cmd = cmd
+			s.serveHTTP(w, req)
+		})
+	}
+	change("PUT /thresholds", CmdSet)
+	change("POST /storage", CmdStorage)
+	change("POST /preset", CmdPreset)
+	change("POST /calibrate", CmdCalibrate)
+	change("POST /pause", CmdPause)
+	change("POST /resume", CmdResume)
+	change("POST /full", CmdFull)
+	change("POST /reload", CmdReload)
+	return mux
+}
+
+// serveHTTP answers one request: 200 with the response, or 400 if the
+// daemon refused it.
+func (s *Server) serveHTTP(w http.ResponseWriter, r Req) {
+	if err := r.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, fail(ErrInvalid, err))
+		return
+	}
+	resp := s.handle(r)
+	code := http.StatusOK
+	if !resp.Ok {
+		code = http.StatusBadRequest
+	}
+	writeJSON(w, code, resp)
+}
+
+// HistorySample is one point of GET /history.
+type HistorySample struct {
+	T    int64   `json:"t"` // unix time
+	Pct  float64 `json:"pct"`
+	Cons int     `json:"cons"`
+}
+
+func historyOf(h []control.Sample) []HistorySample {
+	out := make([]HistorySample, len(h))
+	for i, smp := range h {
+		out[i] = HistorySample{T: smp.Time.Unix(), Pct: smp.Pct, Cons: smp.Cons}
+	}
+	return out
+}
+
+func checkToken(r *http.Request, token string) error {
+	if token == "" {
+		return errors.New("changes over HTTP are disabled: start the daemon with -http-token-file")
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		return fmt.Errorf("missing or wrong bearer token")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,87 @@
+package ipc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	s := newTestServer(t)
+	ts := httptest.NewServer(s.HTTPHandler("secret"))
+	defer ts.Close()
+
+	do := func(method, path, token, body string) (int, Resp) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var resp Resp
+		json.NewDecoder(res.Body).Decode(&resp)
+		return res.StatusCode, resp
+	}
+
+	if code, resp := do("GET", "/status", "", ""); code != 200 || resp.Max != 80 {
+		t.Errorf("GET /status: %d %+v", code, resp)
+	}
+	if code, _ := do("PUT", "/thresholds", "", `{"max":90}`); code != http.StatusUnauthorized {
+		t.Errorf("PUT without token: %d", code)
+	}
+	if code, _ := do("PUT", "/thresholds", "wrong", `{"max":90}`); code != http.StatusUnauthorized {
+		t.Errorf("PUT with wrong token: %d", code)
+	}
+	if code, resp := do("PUT", "/thresholds", "secret", `{"max":90,"time":"now"}`); code != 200 || resp.Max != 90 {
+		t.Errorf("PUT /thresholds: %d %+v", code, resp)
+	}
+	if code, resp := do("PUT", "/thresholds", "secret", `{"max":20}`); code != 400 || resp.Ok {
+		t.Errorf("PUT below threshold: %d %+v", code, resp)
+	}
+	if code, resp := do("PUT", "/thresholds", "secret", `{"maxx":90}`); code != 400 || !strings.Contains(resp.Msg, "maxx") {
+		t.Errorf("PUT unknown field: %d %+v", code, resp)
+	}
+	if code, resp := do("POST", "/pause", "secret", ""); code != 200 || !resp.Paused || resp.PausedUntil != 0 {
+		t.Errorf("POST /pause: %d %+v", code, resp)
+	}
+	if code, resp := do("POST", "/resume", "secret", ""); code != 200 || resp.Msg != "resumed" {
+		t.Errorf("POST /resume: %d %+v", code, resp)
+	}
+	if code, _ := do("GET", "/thresholds", "secret", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /thresholds: %d", code)
+	}
+
+	res, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("GET /: %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	res, err = http.Get(ts.URL + "/history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h []HistorySample
+	if err := json.NewDecoder(res.Body).Decode(&h); err != nil {
+		t.Errorf("GET /history: %v", err)
+	}
+	res.Body.Close()
+
+	ro := httptest.NewServer(s.HTTPHandler(""))
+	defer ro.Close()
+	req, _ := http.NewRequest("POST", ro.URL+"/reload", nil)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("POST /reload without a token configured: %v, %v", res, err)
+	}
+}
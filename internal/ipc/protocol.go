@@ -0,0 +1,330 @@
+// SPDX-License-Identifier: MIT
+
+// Package ipc is the control protocol between conservationd and its clients:
+// one JSON request and one JSON response per connection on a UNIX socket.
+// The daemon serves it with Server; conservationctl and the tray use Call.
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/logging"
+)
+
+// DefaultSock is the system-wide control socket.
+const DefaultSock = "/run/conservationd/conservationd.sock"
+
+// ProtocolVersion is the version of the protocol this package speaks. It
+// goes up whenever a command or request field is added, so a client can
+// tell from the daemon's hello whether what it is about to send will be
+// understood. Daemons from before hello are version 0; 1 added hello, 2
+// subscribe, 3 config, 4 pause and resume, 5 full, 6 set for a while, 7
+// error codes, 8 revisions.
+const ProtocolVersion = 8
+
+// Commands understood by the daemon.
+const (
+	CmdHello     = "hello" // protocol version and capabilities
+	CmdPing      = "ping"
+	CmdGet       = "get"
+	CmdStatus    = "status"
+	CmdSet       = "set"
+	CmdClear     = "clear"    // remove the caller's own policy (PerUser)
+	CmdKnobs     = "knobs"    // list or set extra ideapad knobs
+	CmdExternal  = "external" // settle a pending external knob change
+	CmdSummary   = "summary"
+	CmdSnapshot  = "snapshot"
+	CmdRestore   = "restore"
+	CmdCalibrate = "calibrate" // start, abort or schedule a force-discharge calibration
+	CmdStorage   = "storage"   // turn storage mode on or off
+	CmdPreset    = "preset"    // list the threshold presets, or apply one
+	CmdReload    = "reload"    // re-read the configuration file
+	CmdLogLevel  = "loglevel"  // show or change the log level
+	CmdSubscribe = "subscribe" // stream events until the client hangs up
+	CmdConfig    = "config"    // show every daemon option, or change some
+	CmdPause     = "pause"     // stop writing the hardware for a while, or until resumed
+	CmdResume    = "resume"    // end a pause
+	CmdFull      = "full"      // charge to 100% once, then go back to the thresholds
+)
+
+// Commands lists every command of ProtocolVersion, as hello reports them.
+var Commands = []string{
+	CmdHello, CmdPing, CmdGet, CmdStatus, CmdSet, CmdClear, CmdKnobs, CmdExternal, CmdSummary, CmdSnapshot,
+	CmdRestore, CmdCalibrate, CmdStorage, CmdPreset, CmdReload, CmdLogLevel, CmdSubscribe,
+	CmdConfig, CmdPause, CmdResume, CmdFull,
+}
+
+type Req struct {
+	Cmd  string  `json:"cmd"`
+	Max  float64 `json:"max,omitempty"`
+	Time string  `json:"time,omitempty"` // Time in HH:MM format or "now"
+	Auto *bool   `json:"auto,omitempty"`
+
+	Storage *bool `json:"storage,omitempty"` // "storage": storage mode on or off
+
+	Preset string `json:"preset,omitempty"` // "preset": name or title of the preset to apply; "" lists them
+
+	ChargeCurrentMA *int `json:"charge_current_ma,omitempty"` // 0 removes the cap
+
+	PerUser bool `json:"per_user,omitempty"` // set/clear the caller's own policy (multi-user)
+
+	IfRevision uint64 `json:"if_revision,omitempty"` // "set": refuse with ErrConflict unless the settings are still at this Resp.Revision
+
+	Knobs map[string]bool `json:"knobs,omitempty"` // "knobs": extra ideapad knobs to set
+
+	Snapshot *config.Snapshot `json:"snapshot,omitempty"` // "restore": document to apply
+
+	Resolve string `json:"resolve,omitempty"` // "external": "adopt" or "enforce"
+
+	Calibrate string  `json:"calibrate,omitempty"` // "calibrate": "start", "abort", "schedule", "every" or "history"
+	Floor     float64 `json:"floor,omitempty"`     // "calibrate": discharge floor, 0 for the default
+	At        string  `json:"at,omitempty"`        // "calibrate schedule": e.g. "weekend" or "2006-01-02"
+	Every     string  `json:"every,omitempty"`     // "calibrate every": "monthly", "weekly", a duration or "off"
+
+	Level string `json:"level,omitempty"` // "loglevel": debug, info, warn or error; "" shows the current one
+	For   string `json:"for,omitempty"`   // "loglevel": go back to the previous level after this duration; "pause": resume after it; "set": go back to the stored thresholds after it
+
+	Events []string `json:"events,omitempty"` // "subscribe": event names to stream, e.g. "conservation_changed"; all if empty
+
+	Settings map[string]string `json:"settings,omitempty"` // "config": daemon options to change by flag name, e.g. "interval": "30s"; none shows them
+
+	Cancel bool `json:"cancel,omitempty"` // "full": end a running full charge instead of starting one
+}
+
+// Validate checks that r is well formed. Limits that depend on the daemon's
+// configuration, like the conservation threshold, are checked by the server.
+// The error is an *Error: ErrInvalid unless a more precise code applies.
+func (r Req) Validate() error {
+	err := r.validate()
+	if err != nil && ErrorCode(err) == "" {
+		err = &Error{Code: ErrInvalid, Msg: err.Error()}
+	}
+	return err
+}
+
+func (r Req) validate() error {
+	switch r.Cmd {
+	case CmdHello, CmdPing, CmdGet, CmdStatus, CmdClear, CmdKnobs, CmdSummary, CmdSnapshot, CmdPreset, CmdReload, CmdConfig, CmdResume, CmdFull:
+	case CmdSet:
+		if r.Max <= 0 || r.Max > 100 {
+			return errorf(ErrRange, "max must be in (0,100], got %.1f", r.Max)
+		}
+		if r.Time != "" && r.Time != "now" {
+			if _, err := time.Parse("15:04", r.Time); err != nil {
+				return fmt.Errorf("time must be in HH:MM format or now, got %s", r.Time)
+			}
+		}
+		if r.ChargeCurrentMA != nil && *r.ChargeCurrentMA < 0 {
+			return errorf(ErrRange, "charge_current_ma must be >= 0")
+		}
+		if r.For != "" {
+			if d, err := time.ParseDuration(r.For); err != nil || d <= 0 {
+				return fmt.Errorf("for must be a positive duration, got %q", r.For)
+			}
+			if r.PerUser || r.ChargeCurrentMA != nil {
+				return errors.New("for only applies to the global max, time and auto")
+			}
+		}
+	case CmdExternal:
+		if r.Resolve != "adopt" && r.Resolve != "enforce" {
+			return fmt.Errorf("resolve must be adopt or enforce, got %q", r.Resolve)
+		}
+	case CmdRestore:
+		if r.Snapshot == nil {
+			return errors.New("restore needs a snapshot")
+		}
+	case CmdStorage:
+		if r.Storage == nil {
+			return errors.New("storage needs on or off")
+		}
+	case CmdSubscribe:
+		for _, e := range r.Events {
+			if e == "" {
+				return errors.New("subscribe: empty event name")
+			}
+		}
+	case CmdLogLevel:
+		if r.Level != "" {
+			if _, err := logging.ParseLevel(r.Level); err != nil {
+				return err
+			}
+		}
+		if r.For != "" {
+			if r.Level == "" {
+				return errors.New("for needs a level")
+			}
+			if d, err := time.ParseDuration(r.For); err != nil || d <= 0 {
+				return fmt.Errorf("for must be a positive duration, got %q", r.For)
+			}
+		}
+	case CmdPause:
+		if r.For != "" {
+			if d, err := time.ParseDuration(r.For); err != nil || d <= 0 {
+				return fmt.Errorf("for must be a positive duration, got %q", r.For)
+			}
+		}
+	case CmdCalibrate:
+		switch r.Calibrate {
+		case "start", "abort", "history":
+		case "schedule":
+			if r.At == "" {
+				return errors.New("calibrate schedule needs a time")
+			}
+		case "every":
+			if r.Every == "" {
+				return errors.New("calibrate every needs an interval")
+			}
+		default:
+			return fmt.Errorf("calibrate must be start, abort, schedule, every or history, got %q", r.Calibrate)
+		}
+		if r.Floor < 0 || r.Floor > 50 {
+			return errorf(ErrRange, "floor must be in [0,50], got %.1f", r.Floor)
+		}
+	case "":
+		return errors.New("missing cmd")
+	default:
+		return errorf(ErrUnsupportedCmd, "unknown cmd %q", r.Cmd)
+	}
+	if r.PerUser {
+		switch r.Cmd {
+		case CmdSet, CmdClear, CmdGet, CmdStatus:
+		default:
+			return fmt.Errorf("per_user only applies to set, clear, get and status, not %s", r.Cmd)
+		}
+	}
+	return nil
+}
+
+// ownPolicy reports whether r only changes the caller's own per-user
+// policy, which anyone on the control socket may do.
+func (r Req) ownPolicy() bool {
+	return r.PerUser && (r.Cmd == CmdSet || r.Cmd == CmdClear)
+}
+
+// Mutates reports whether r changes settings or the machine's state rather
+// than only reading them.
+func (r Req) Mutates() bool {
+	switch r.Cmd {
+	case CmdSet, CmdClear, CmdExternal, CmdRestore, CmdStorage, CmdReload, CmdPause, CmdResume, CmdFull:
+		return true
+	case CmdKnobs:
+		return len(r.Knobs) > 0
+	case CmdCalibrate:
+		return r.Calibrate != "history"
+	case CmdPreset:
+		return r.Preset != ""
+	case CmdLogLevel:
+		return r.Level != ""
+	case CmdConfig:
+		return len(r.Settings) > 0
+	}
+	return false
+}
+
+type Resp struct {
+	Ok    bool    `json:"ok"`
+	Msg   string  `json:"msg,omitempty"`
+	Code  string  `json:"code,omitempty"` // why Ok is unset, one of the Err codes
+	Max   float64 `json:"max,omitempty"`
+	Pct   float64 `json:"pct,omitempty"`
+	State string  `json:"state,omitempty"`
+	Cons  int     `json:"cons,omitempty"`
+	Time  string  `json:"time,omitempty"` // Target time or "now"
+	Auto  bool    `json:"auto,omitempty"`
+
+	Storage      bool    `json:"storage,omitempty"`       // storage mode on
+	StorageLevel float64 `json:"storage_level,omitempty"` // level storage mode holds at
+
+	Preset  string          `json:"preset,omitempty"`  // active threshold preset
+	Presets []config.Preset `json:"presets,omitempty"` // "preset": the built-in presets, for clients to list
+
+	WriteFailures int    `json:"write_failures,omitempty"` // knob writes that failed after retries
+	Quirks        string `json:"quirks,omitempty"`         // active hardware quirk profile
+	Backend       string `json:"backend,omitempty"`        // backend driving the knob, after any failover
+	Calibration   string `json:"calibration,omitempty"`    // calibration phase in progress
+
+	NextCalibration   int64                      `json:"next_calibration,omitempty"`   // unix time of the next scheduled calibration
+	CalibrationCycles int                        `json:"calibration_cycles,omitempty"` // battery cycles since the last calibration
+	Calibrations      []config.CalibrationRecord `json:"calibrations,omitempty"`       // "calibrate history": oldest first
+
+	ChargeCurrentMA int   `json:"charge_current_ma,omitempty"` // configured charge current cap
+	Updated         int64 `json:"updated,omitempty"`           // unix time of the last measurement
+
+	Paused      bool  `json:"paused,omitempty"`       // the daemon leaves the hardware alone
+	PausedUntil int64 `json:"paused_until,omitempty"` // unix time the pause ends; 0 until resumed
+
+	ChargingFull bool `json:"charging_full,omitempty"` // a one-shot charge to 100% is running
+
+	Revision uint64 `json:"revision,omitempty"` // of the settings, for "set" with "if_revision"
+
+	OverrideMax   float64 `json:"override_max,omitempty"`   // temporary max in place of Max, from "set" with "for"
+	OverrideUntil int64   `json:"override_until,omitempty"` // unix time Max comes back
+
+	Policy string `json:"policy,omitempty"` // effective policy source in multi-user mode
+	Reason string `json:"reason,omitempty"` // why the daemon is doing what it does
+
+	Knobs map[string]bool `json:"knobs,omitempty"` // extra ideapad knobs available on this machine
+
+	Batteries []BatteryStatus `json:"batteries,omitempty"` // multi-battery machines only
+
+	Snapshot *config.Snapshot `json:"snapshot,omitempty"` // "snapshot": full configuration
+
+	External string `json:"external_pending,omitempty"` // external knob change awaiting a decision
+
+	Health    float64 `json:"health,omitempty"`     // full vs. design capacity, percent
+	HealthMax float64 `json:"health_max,omitempty"` // effective max when -health-adaptive caps it
+
+	Temp    float64 `json:"temp,omitempty"`     // battery temperature, °C
+	TempMax float64 `json:"temp_max,omitempty"` // effective max while the battery is hot
+
+	Summary *Summary `json:"summary,omitempty"` // "summary": the daemon's session so far
+
+	Protocol int      `json:"protocol,omitempty"` // "hello": the daemon's ProtocolVersion
+	Commands []string `json:"commands,omitempty"` // "hello": commands the daemon understands
+	Features []string `json:"features,omitempty"` // "hello": optional features this daemon has, e.g. "calibrate"
+
+	Settings map[string]string `json:"settings,omitempty"` // "config": every daemon option's effective value by flag name
+}
+
+// Supports reports whether a hello response lists cmd.
+func (r *Resp) Supports(cmd string) bool {
+	for _, c := range r.Commands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether a hello response lists the optional feature f.
+func (r *Resp) Has(f string) bool {
+	for _, x := range r.Features {
+		if x == f {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary is the daemon's session since it started.
+type Summary struct {
+	Since          int64   `json:"since"` // unix time the daemon started
+	ChargeSessions int     `json:"charge_sessions"`
+	Toggles        int     `json:"toggles"` // conservation knob changes
+	MinPct         float64 `json:"min_pct"`
+	MaxPct         float64 `json:"max_pct"`
+	CappedSeconds  int64   `json:"capped_seconds"` // time spent with conservation on
+	Errors         int     `json:"errors"`
+}
+
+// BatteryStatus is one battery of a multi-battery machine.
+type BatteryStatus struct {
+	Name      string  `json:"name"`
+	Pct       float64 `json:"pct"`
+	Status    string  `json:"status,omitempty"`
+	Threshold int     `json:"threshold,omitempty"` // end threshold, if supported
+	Behaviour string  `json:"behaviour,omitempty"` // charge_behaviour, if supported
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: MIT
+
+package ipc
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"conservationDaemon/internal/logging"
+)
+
+// DefaultRateLimit is the sustained requests per second each client (by
+// uid) may send, for the daemon's -rate-limit. The tray asks every few
+// seconds; a client far above this is stuck in a loop.
+const DefaultRateLimit = 20
+
+// maxThrottle is the longest a request waits for its turn. A client further
+// behind is refused instead, and its connection closed.
+const maxThrottle = 2 * time.Second
+
+// limiter is a token bucket per peer uid: each connection and request takes
+// a token, and tokens come back at rate per second up to twice that, so
+// short bursts pass untouched.
+type limiter struct {
+	rate float64
+
+	mu    sync.Mutex
+	peers map[uint32]*bucket
+}
+
+type bucket struct {
+	tokens    float64
+	last      time.Time
+	throttled int       // connections and requests held up since warned
+	warned    time.Time // last warning, so a looping client can't flood the log either
+}
+
+func newLimiter(rate float64) *limiter {
+	return &limiter{rate: rate, peers: map[uint32]*bucket{}}
+}
+
+// refill returns uid's bucket with the tokens earned by now. l.mu must be
+// held.
+func (l *limiter) refill(uid uint32, now time.Time) *bucket {
+	burst := 2 * l.rate
+	b := l.peers[uid]
+	if b == nil {
+		b = &bucket{tokens: burst, last: now}
+		l.peers[uid] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+// allow takes a token for a new connection from uid, if one is left.
+func (l *limiter) allow(uid uint32, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(uid, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	l.throttled(uid, b, now)
+	return false
+}
+
+// wait takes a token for a request from uid and returns how long it must
+// wait for it: 0 if one was left. A token further away than maxThrottle
+// isn't taken, and wait returns false.
+func (l *limiter) wait(uid uint32, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(uid, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	l.throttled(uid, b, now)
+	d := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	if d > maxThrottle {
+		return 0, false
+	}
+	b.tokens--
+	return d, true
+}
+
+// throttled counts a held-up connection or request, warning at most once a
+// minute per uid. l.mu must be held.
+func (l *limiter) throttled(uid uint32, b *bucket, now time.Time) {
+	b.throttled++
+	if now.Sub(b.warned) < time.Minute {
+		return
+	}
+	logging.Warnf("uid %d is over %g requests/s: throttling it (%d held up)", uid, l.rate, b.throttled)
+	logging.Event("client_throttled", map[string]any{"uid": uid, "held_up": b.throttled})
+	b.throttled = 0
+	b.warned = now
+}
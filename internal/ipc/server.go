@@ -0,0 +1,1131 @@
+// SPDX-License-Identifier: MIT
+
+// Package ipc implements the daemon's JSON control socket.
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/control"
+	"conservationDaemon/internal/logging"
+)
+
+// ShutdownGrace is how long Serve waits for requests in flight once it
+// stops accepting connections.
+const ShutdownGrace = 2 * time.Second
+
+// DefaultIdleTimeout is Server.IdleTimeout when unset.
+const DefaultIdleTimeout = 30 * time.Second
+
+// DefaultWriteTimeout is Server.WriteTimeout when unset.
+const DefaultWriteTimeout = 5 * time.Second
+
+// MaxRequestSize is the most the daemon reads for one request. A bigger one
+// gets an error and the connection is closed.
+const MaxRequestSize = 1 << 20
+
+var errRequestTooLarge = errorf(ErrLimit, "request larger than %d bytes", MaxRequestSize)
+
+// requestLimit reads from r until n runs out. handleConn refills n before
+// each request.
+type requestLimit struct {
+	r io.Reader
+	n int64
+}
+
+func (l *requestLimit) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errRequestTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// Listen creates the control socket, readable and writable by group. An
+// abstract socket (see Abstract) has no file to set a group and mode on, so
+// the server must check the group itself: see Server.Group.
+func Listen(sockPath, group string) (net.Listener, error) {
+	if Abstract(sockPath) {
+		ln, err := net.Listen("unix", sockPath)
+		if err != nil {
+			return nil, fmt.Errorf("listen %s: %w", sockPath, err)
+		}
+		logging.Logf("control socket listening at abstract %s (group %s, checked per connection)", sockPath, group)
+		return ln, nil
+	}
+	dir := filepath.Dir(sockPath)
+	if err := os.MkdirAll(dir, 0o770); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	if err := removeStale(sockPath); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", sockPath, err)
+	}
+	// chgrp directory and socket so group members can connect
+	if g, err := user.LookupGroup(group); err == nil {
+		if gid, err2 := strconv.Atoi(g.Gid); err2 == nil {
+			_ = syscall.Chown(dir, 0, gid)
+			_ = syscall.Chown(sockPath, 0, gid)
+		}
+	}
+	_ = os.Chmod(dir, 0o750)
+	_ = os.Chmod(sockPath, 0o660)
+	logging.Logf("control socket listening at %s (group %s, mode 0660)", sockPath, group)
+	return ln, nil
+}
+
+// ListenStatus creates the read-only status socket, which anyone may
+// connect to. Its directory is made traversable by others if it isn't: that
+// exposes nothing else, as the control socket keeps its own mode.
+func ListenStatus(sockPath string) (net.Listener, error) {
+	if Abstract(sockPath) {
+		ln, err := net.Listen("unix", sockPath)
+		if err != nil {
+			return nil, fmt.Errorf("listen %s: %w", sockPath, err)
+		}
+		logging.Logf("status socket listening at abstract %s (read-only)", sockPath)
+		return ln, nil
+	}
+	dir := filepath.Dir(sockPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	if err := removeStale(sockPath); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", sockPath, err)
+	}
+	if fi, err := os.Stat(dir); err == nil && fi.Mode().Perm()&0o001 == 0 {
+		_ = os.Chmod(dir, fi.Mode().Perm()|0o001)
+	}
+	_ = os.Chmod(sockPath, 0o666)
+	logging.Logf("status socket listening at %s (read-only, mode 0666)", sockPath)
+	return ln, nil
+}
+
+// removeStale unlinks sockPath only if nothing is listening on it. A live
+// daemon that answers a ping makes startup fail instead of being knocked out.
+func removeStale(sockPath string) error {
+	fi, err := os.Lstat(sockPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", sockPath, err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket; refusing to remove it", sockPath)
+	}
+
+	c, err := net.DialTimeout("unix", sockPath, time.Second)
+	if err != nil {
+		// Nobody listening (ECONNREFUSED): a leftover from a previous run
+		logging.Logf("removing stale socket %s", sockPath)
+		if err := os.Remove(sockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove stale socket %s: %w", sockPath, err)
+		}
+		return nil
+	}
+	defer c.Close()
+
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+	var resp Resp
+	if err := Encode(c, Req{Cmd: CmdPing}); err == nil {
+		if err := Decode(c, &resp); err == nil && resp.Ok {
+			return fmt.Errorf("conservationd is already running (socket %s)", sockPath)
+		}
+	}
+	return fmt.Errorf("socket %s is in use by another process", sockPath)
+}
+
+// DefaultMaxConns bounds concurrent connection handlers when Server.MaxConns
+// is zero.
+const DefaultMaxConns = 16
+
+// Server answers control requests against shared daemon state.
+type Server struct {
+	State    *control.State
+	MaxConns int // concurrent connection handlers; excess connections are rejected
+
+	// RateLimit is the sustained connections and requests per second each
+	// client uid may make, with bursts of twice that. Requests over it wait
+	// their turn, connections are refused. 0 means no limit.
+	RateLimit float64
+
+	// Extras are optional ideapad knobs (rapid_charge, usb_charging) by
+	// name, as found by backend.FindExtras.
+	Extras map[string]string
+
+	// Batteries lists the machine's batteries; status shows them when
+	// there is more than one.
+	Batteries func() []backend.BatteryInfo
+
+	// CanCalibrate is set when the knob supports force-discharge.
+	CanCalibrate bool
+
+	// CanStore is set when the knob supports storage mode.
+	CanStore bool
+
+	// ReadOnly refuses every request that changes something, per-user
+	// requests and snapshots: the server behind the status socket.
+	ReadOnly bool
+
+	// AdminGroup, if set, limits the commands that change something (see
+	// Req.Mutates) to root and members of this group. Everyone who can
+	// open the socket can still read, and set their own policy.
+	AdminGroup string
+
+	// IdleTimeout is how long a connection may wait for its next request
+	// before the daemon closes it. It also bounds how long one request may
+	// take to arrive, so a client trickling bytes can't hold its handler
+	// either. 0 means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+
+	// WriteTimeout bounds each write to a client, so one that stops reading
+	// can't hold its handler. 0 means DefaultWriteTimeout.
+	WriteTimeout time.Duration
+
+	// Group, if set, refuses connections from anyone but root and members
+	// of this group, as the file mode does for a filesystem socket. Set it
+	// for abstract sockets, which anyone may connect to otherwise.
+	Group string
+
+	// credOf returns the credentials of a connection's peer: peerCred if
+	// nil. Tests use it to pose as other users.
+	credOf func(c net.Conn) (*syscall.Ucred, error)
+
+	// Reload re-reads the configuration file and describes what changed;
+	// nil if the daemon can't reload.
+	Reload func() (string, error)
+
+	// Configure changes the daemon options in set (by flag name), if any,
+	// and returns every option's effective value and what it did; nil if
+	// the daemon can't.
+	Configure func(set map[string]string) (map[string]string, string, error)
+}
+
+// Serve accepts connections on ln until ctx is cancelled or ln is closed.
+// Closing ln removes the socket file. Serve then drops the connections
+// that haven't sent their request yet and waits up to ShutdownGrace for the
+// requests in flight before returning.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	var wg sync.WaitGroup
+	idle := &idleConns{conns: map[net.Conn]struct{}{}}
+	defer drain(&wg, idle)
+
+	max := s.MaxConns
+	if max <= 0 {
+		max = DefaultMaxConns
+	}
+	sem := make(chan struct{}, max)
+	var rl *limiter
+	if s.RateLimit > 0 {
+		rl = newLimiter(s.RateLimit)
+	}
+
+	var backoff time.Duration
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			// Transient failure (e.g. EMFILE): back off instead of spinning
+			if backoff == 0 {
+				backoff = 5 * time.Millisecond
+			} else if backoff *= 2; backoff > time.Second {
+				backoff = time.Second
+			}
+			logging.Warnf("accept: %v; retrying in %v", err, backoff)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+
+		// Peers without credentials share one bucket
+		uid := ^uint32(0)
+		if cred, err := s.peer(c); err == nil {
+			uid = cred.Uid
+		}
+		if rl != nil && !rl.allow(uid, time.Now()) {
+			go reject(c, "too many connections: slow down")
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				s.handleConn(ctx, c, idle, rl, uid)
+			}()
+		default:
+			go reject(c, fmt.Sprintf("server busy: too many connections (max %d)", max))
+		}
+	}
+}
+
+// idleConns are the connections still waiting for their request.
+type idleConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (ic *idleConns) add(c net.Conn) {
+	ic.mu.Lock()
+	ic.conns[c] = struct{}{}
+	ic.mu.Unlock()
+}
+
+func (ic *idleConns) remove(c net.Conn) {
+	ic.mu.Lock()
+	delete(ic.conns, c)
+	ic.mu.Unlock()
+}
+
+// expire makes every pending read on the idle connections fail at once.
+func (ic *idleConns) expire() {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	for c := range ic.conns {
+		_ = c.SetReadDeadline(time.Now())
+	}
+}
+
+// drain drops the idle connections and waits for the handlers in wg, or
+// ShutdownGrace at most.
+func drain(wg *sync.WaitGroup, idle *idleConns) {
+	idle.expire()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(ShutdownGrace):
+		logging.Warnf("control socket: requests still in flight after %v, not waiting", ShutdownGrace)
+	}
+}
+
+// reply writes resp to c within s.WriteTimeout.
+func (s *Server) reply(c net.Conn, resp Resp) error {
+	_ = c.SetWriteDeadline(time.Now().Add(s.writeTimeout()))
+	return Encode(c, resp)
+}
+
+func (s *Server) writeTimeout() time.Duration {
+	if s.WriteTimeout <= 0 {
+		return DefaultWriteTimeout
+	}
+	return s.WriteTimeout
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout <= 0 {
+		return DefaultIdleTimeout
+	}
+	return s.IdleTimeout
+}
+
+// reject answers a connection that exceeded the handler limit and closes it.
+func reject(c net.Conn, msg string) {
+	defer c.Close()
+	_ = c.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = Encode(c, Resp{Ok: false, Code: ErrLimit, Msg: msg})
+}
+
+// handleConn answers requests on c, one response per request, until the
+// client hangs up, stays idle for s.IdleTimeout or sends something that isn't
+// a request or is over MaxRequestSize. Clients may send one request per
+// connection or keep it open. Requests from uid over rl's rate wait their
+// turn; rl may be nil.
+func (s *Server) handleConn(ctx context.Context, c net.Conn, idle *idleConns, rl *limiter, uid uint32) {
+	defer c.Close()
+	if s.Group != "" {
+		if err := s.memberOf(c, s.Group); err != nil {
+			_ = s.reply(c, failf(ErrPermission, "permission denied: the control socket needs root or membership in group %s", s.Group))
+			logging.Debugf("refused connection: %v", err)
+			return
+		}
+	}
+	lim := &requestLimit{r: c}
+	dec := json.NewDecoder(lim)
+	for {
+		var r Req
+		lim.n = MaxRequestSize
+		_ = c.SetReadDeadline(time.Now().Add(s.idleTimeout()))
+		idle.add(c)
+		if ctx.Err() != nil {
+			// Shutting down: expire may already have run
+			idle.remove(c)
+			return
+		}
+		err := decodeReq(dec, &r)
+		idle.remove(c)
+		if err != nil {
+			var ne net.Error
+			if !errors.Is(err, io.EOF) && !(errors.As(err, &ne) && ne.Timeout()) {
+				_ = s.reply(c, fail(ErrInvalid, err))
+			}
+			return
+		}
+		_ = c.SetReadDeadline(time.Time{})
+		if rl != nil {
+			d, ok := rl.wait(uid, time.Now())
+			if !ok {
+				_ = s.reply(c, failf(ErrLimit, "too many requests: slow down"))
+				return
+			}
+			if d > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(d):
+				}
+			}
+		}
+		if r.Cmd == CmdSubscribe {
+			if err := r.Validate(); err != nil {
+				_ = s.reply(c, fail(ErrInvalid, err))
+				return
+			}
+			s.subscribe(ctx, c, r.Events)
+			return
+		}
+		if err := s.reply(c, s.answer(c, r)); err != nil {
+			return
+		}
+	}
+}
+
+// answer handles one request from c. The admin check comes first: whatever
+// else a request says, per_user included, only root and AdminGroup may
+// change more than their own policy.
+func (s *Server) answer(c net.Conn, r Req) Resp {
+	if s.AdminGroup != "" && r.Mutates() && !r.ownPolicy() {
+		if err := s.authorize(c, r.Cmd); err != nil {
+			return fail(ErrPermission, err)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return fail(ErrInvalid, err)
+	}
+	if s.ReadOnly && (r.Mutates() || r.PerUser || r.Cmd == CmdSnapshot || r.Cmd == CmdConfig) {
+		return failf(ErrPermission, "%s is not available on the read-only status socket; use the control socket", r.Cmd)
+	}
+	if r.PerUser {
+		cred, err := s.peer(c)
+		if err != nil {
+			return fail(ErrInternal, err)
+		}
+		return s.handleUser(r, cred.Uid)
+	}
+	return s.handle(r)
+}
+
+// authorize lets root and members of AdminGroup run cmd.
+func (s *Server) authorize(c net.Conn, cmd string) error {
+	cred, err := s.peer(c)
+	if err != nil {
+		return err
+	}
+	return s.authorizeCred(cred, cmd)
+}
+
+// authorizeCred is authorize for a caller known by its credentials, such as
+// one on D-Bus.
+func (s *Server) authorizeCred(cred *syscall.Ucred, cmd string) error {
+	err := member(cred, s.AdminGroup)
+	if errors.Is(err, errNotMember) {
+		return fmt.Errorf("permission denied: %s needs root or membership in group %s", cmd, s.AdminGroup)
+	}
+	return err
+}
+
+var errNotMember = errors.New("not a member")
+
+// memberOf returns nil if the peer of c is root or in group, and an error
+// wrapping errNotMember if it is neither.
+func (s *Server) memberOf(c net.Conn, group string) error {
+	cred, err := s.peer(c)
+	if err != nil {
+		return err
+	}
+	return member(cred, group)
+}
+
+// member is memberOf for the process with cred.
+func member(cred *syscall.Ucred, group string) error {
+	if cred.Uid == 0 {
+		return nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return fmt.Errorf("group %s: %w", group, err)
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("group %s: bad gid %q", group, g.Gid)
+	}
+	if cred.Gid == uint32(gid) {
+		return nil
+	}
+	groups, err := procGroups(cred.Pid)
+	if err != nil {
+		return fmt.Errorf("peer groups: %w", err)
+	}
+	for _, g := range groups {
+		if g == uint32(gid) {
+			return nil
+		}
+	}
+	return fmt.Errorf("uid %d: %w of group %s", cred.Uid, errNotMember, group)
+}
+
+// procGroups returns the supplementary groups of process pid, which
+// SO_PEERCRED doesn't carry.
+func procGroups(pid int32) ([]uint32, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		rest, ok := strings.CutPrefix(line, "Groups:")
+		if !ok {
+			continue
+		}
+		var groups []uint32
+		for _, f := range strings.Fields(rest) {
+			g, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("pid %d: bad group %q", pid, f)
+			}
+			groups = append(groups, uint32(g))
+		}
+		return groups, nil
+	}
+	return nil, fmt.Errorf("pid %d: no Groups line", pid)
+}
+
+// subscribe acknowledges a subscription, then streams the events named in
+// types (all if none) to c, one JSON object per line, until the client hangs
+// up or ctx is cancelled. A client too slow to keep up misses events rather
+// than hold up the daemon.
+func (s *Server) subscribe(ctx context.Context, c net.Conn, types []string) {
+	ch, cancel := logging.SubscribeEvents(64)
+	defer cancel()
+	if err := s.reply(c, Resp{Ok: true, Msg: "subscribed"}); err != nil {
+		return
+	}
+	want := make(map[string]bool, len(types))
+	for _, t := range types {
+		want[t] = true
+	}
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, c)
+		close(gone)
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-gone:
+			return
+		case b := <-ch:
+			if len(want) > 0 {
+				var ev struct {
+					Event string `json:"event"`
+				}
+				if json.Unmarshal(b, &ev) != nil || !want[ev.Event] {
+					continue
+				}
+			}
+			_ = c.SetWriteDeadline(time.Now().Add(s.writeTimeout()))
+			if _, err := c.Write(append(b, '\n')); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// peer returns the credentials of the peer of c.
+func (s *Server) peer(c net.Conn) (*syscall.Ucred, error) {
+	if s.credOf != nil {
+		return s.credOf(c)
+	}
+	return peerCred(c)
+}
+
+// peerCred returns the credentials of the process on the other end of c.
+func peerCred(c net.Conn) (*syscall.Ucred, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("peer credentials need a unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("peer credentials: %w", credErr)
+	}
+	return cred, nil
+}
+
+// handleUser serves requests on the caller's own policy (multi-user mode).
+func (s *Server) handleUser(r Req, uid uint32) Resp {
+	switch r.Cmd {
+	case CmdSet:
+		cfg, err := s.State.UpdateIf(r.IfRevision, func(cfg *config.Config) error {
+			if !cfg.MultiUser {
+				return errorf(ErrUnsupported, "per-user policies need the daemon's -multi-user option")
+			}
+			if r.Max < cfg.ConservationThreshold || r.Max > 100 {
+				return errorf(ErrRange, "max must be %.1f..100", cfg.ConservationThreshold)
+			}
+			p := config.UserPolicy{Max: r.Max, Auto: cfg.Auto}
+			if r.Auto != nil {
+				p.Auto = *r.Auto
+			}
+			if r.ChargeCurrentMA != nil {
+				p.ChargeCurrentMA = *r.ChargeCurrentMA
+			} else {
+				p.ChargeCurrentMA = cfg.UserPolicies[uid].ChargeCurrentMA
+			}
+			users := make(map[uint32]config.UserPolicy, len(cfg.UserPolicies)+1)
+			for k, v := range cfg.UserPolicies {
+				users[k] = v
+			}
+			users[uid] = p
+			cfg.UserPolicies = users
+			if cfg.StatePath != "" {
+				if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+					logging.Errorf("save state: %v", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return failUpdate(err)
+		}
+		p := cfg.UserPolicies[uid]
+		logging.Event("user_policy_changed", map[string]any{"uid": uid, "max": p.Max, "auto": p.Auto, "charge_current_ma": p.ChargeCurrentMA})
+		return Resp{Ok: true, Max: p.Max, Time: "now", Auto: p.Auto, ChargeCurrentMA: p.ChargeCurrentMA, Revision: s.State.Revision()}
+	case CmdClear:
+		_, err := s.State.Update(func(cfg *config.Config) error {
+			if _, ok := cfg.UserPolicies[uid]; !ok {
+				return errorf(ErrState, "no policy set for this user")
+			}
+			users := make(map[uint32]config.UserPolicy, len(cfg.UserPolicies))
+			for k, v := range cfg.UserPolicies {
+				if k != uid {
+					users[k] = v
+				}
+			}
+			cfg.UserPolicies = users
+			if cfg.StatePath != "" {
+				if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+					logging.Errorf("save state: %v", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fail(ErrInternal, err)
+		}
+		return Resp{Ok: true}
+	case CmdGet, CmdStatus:
+		return s.handle(r)
+	default:
+		// Req.Validate refuses these already
+		return failf(ErrInvalid, "per_user doesn't apply to %s", r.Cmd)
+	}
+}
+
+func (s *Server) handle(r Req) Resp {
+	switch r.Cmd {
+	case CmdSet:
+		if r.For != "" {
+			return s.setFor(r)
+		}
+		cfg, err := s.State.UpdateIf(r.IfRevision, func(cfg *config.Config) error {
+			if r.Max < cfg.ConservationThreshold || r.Max > 100 {
+				return errorf(ErrRange, "max must be %.1f..100", cfg.ConservationThreshold)
+			}
+
+			// Handle time parameter
+			if r.Time != "" && r.Time != "now" {
+				targetTime, err := control.ParseTargetTime(r.Time, time.Now())
+				if err != nil {
+					return errorf(ErrInvalid, "invalid time format: %v", err)
+				}
+				cfg.TargetTime = &targetTime
+			} else {
+				// Time is "now" or not specified - immediate mode
+				cfg.TargetTime = nil
+			}
+
+			cfg.MaxPercent = r.Max
+			cfg.LevelReached = false // Reset level reached on new configuration
+
+			if r.Auto != nil {
+				cfg.Auto = *r.Auto
+			}
+			if r.ChargeCurrentMA != nil {
+				if *r.ChargeCurrentMA < 0 {
+					return errorf(ErrRange, "charge_current_ma must be >= 0")
+				}
+				cfg.ChargeCurrentMA = *r.ChargeCurrentMA
+			}
+
+			// Persist state to disk
+			if cfg.StatePath != "" {
+				if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+					logging.Errorf("save state: %v", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return failUpdate(err)
+		}
+		// New thresholds end a temporary override rather than wait under it
+		s.State.EndOverride()
+		logging.Event("config_changed", map[string]any{
+			"max": cfg.MaxPercent, "time": timeString(cfg), "auto": cfg.Auto, "charge_current_ma": cfg.ChargeCurrentMA,
+		})
+		return Resp{Ok: true, Max: cfg.MaxPercent, Time: timeString(cfg), Auto: cfg.Auto, ChargeCurrentMA: cfg.ChargeCurrentMA, Revision: s.State.Revision()}
+	case CmdHello:
+		return Resp{Ok: true, Protocol: ProtocolVersion, Commands: Commands, Features: s.features()}
+	case CmdPing:
+		return Resp{Ok: true, Msg: "pong"}
+	case CmdGet, CmdStatus:
+		// Served from the control loop's last snapshot: cheap enough for
+		// frequent polling, never a DBus round-trip.
+		st := s.State.Status()
+		resp := Resp{
+			Ok:    true,
+			Max:   st.Config.MaxPercent,
+			Pct:   st.Pct,
+			State: st.BatteryState.String(),
+			Cons:  st.Cons,
+			Time:  timeString(st.Config),
+			Auto:  st.Config.Auto,
+
+			Storage:      st.Config.Storage,
+			StorageLevel: st.Config.StorageLevel,
+			Preset:       st.Config.Preset,
+
+			WriteFailures: st.WriteFailures,
+			Quirks:        st.Config.Quirks,
+			Backend:       st.Backend,
+			Calibration:   st.Calibration,
+
+			ChargeCurrentMA: st.Config.ChargeCurrentMA,
+
+			Paused:       st.Paused,
+			ChargingFull: st.ChargingFull,
+			Revision:     st.Revision,
+		}
+		if o := st.Override; o != nil {
+			resp.OverrideMax = o.Max
+			resp.OverrideUntil = o.Until.Unix()
+		}
+		if st.Paused && !st.PausedUntil.IsZero() {
+			resp.PausedUntil = st.PausedUntil.Unix()
+		}
+		if st.Config.CalibrateAt != nil {
+			resp.NextCalibration = st.Config.CalibrateAt.Unix()
+		}
+		if last, ok := st.Config.LastCalibration(); ok && last.Cycles > 0 && st.Cycles >= last.Cycles {
+			resp.CalibrationCycles = st.Cycles - last.Cycles
+		}
+		resp.Policy = st.Policy
+		resp.Reason = st.Reason
+		if len(s.Extras) > 0 {
+			resp.Knobs = make(map[string]bool, len(s.Extras))
+			for name, path := range s.Extras {
+				if on, err := backend.ReadFlag(path); err == nil {
+					resp.Knobs[name] = on
+				}
+			}
+		}
+		resp.External = st.External
+		resp.Health = st.Health
+		if st.HealthCapped {
+			resp.HealthMax = st.Config.HealthMax
+		}
+		resp.Temp = st.Temp
+		if st.TempCapped {
+			resp.TempMax = st.Config.TempMax
+		}
+		if s.Batteries != nil {
+			if bats := s.Batteries(); len(bats) > 1 {
+				for _, b := range bats {
+					resp.Batteries = append(resp.Batteries, BatteryStatus{
+						Name: b.Name, Pct: b.Pct, Status: b.Status, Threshold: b.EndThreshold, Behaviour: b.Behaviour,
+					})
+				}
+			}
+		}
+		if !st.Updated.IsZero() {
+			resp.Updated = st.Updated.Unix()
+		}
+		return resp
+	case CmdKnobs:
+		return s.handleKnobs(r)
+	case CmdExternal:
+		var adopt bool
+		switch r.Resolve {
+		case "adopt":
+			adopt = true
+		case "enforce":
+		default:
+			return failf(ErrInvalid, "resolve must be adopt or enforce")
+		}
+		cfg, err := s.State.ResolveExternal(adopt)
+		if err != nil {
+			return fail(ErrState, err)
+		}
+		logging.Event("external_resolved", map[string]any{"resolve": r.Resolve, "max": cfg.MaxPercent})
+		return Resp{Ok: true, Max: cfg.MaxPercent, Time: timeString(cfg), Auto: cfg.Auto}
+	case CmdSummary:
+		sum := s.State.Summary()
+		return Resp{Ok: true, Summary: &Summary{
+			Since: sum.Since.Unix(), ChargeSessions: sum.ChargeSessions, Toggles: sum.Toggles,
+			MinPct: sum.MinPct, MaxPct: sum.MaxPct, CappedSeconds: int64(sum.Capped.Seconds()), Errors: sum.Errors,
+		}}
+	case CmdCalibrate:
+		return s.handleCalibrate(r)
+	case CmdPause:
+		var d time.Duration
+		if r.For != "" {
+			d, _ = time.ParseDuration(r.For)
+		}
+		until, err := s.State.Pause(d)
+		if err != nil {
+			return fail(ErrState, err)
+		}
+		resp := Resp{Ok: true, Paused: true, Msg: "paused until resumed"}
+		if !until.IsZero() {
+			resp.PausedUntil = until.Unix()
+			resp.Msg = "paused until " + until.Format("15:04")
+		}
+		return resp
+	case CmdResume:
+		if !s.State.Resume() {
+			return Resp{Ok: true, Msg: "not paused"}
+		}
+		return Resp{Ok: true, Msg: "resumed"}
+	case CmdFull:
+		if r.Cancel {
+			if !s.State.CancelFull() {
+				return Resp{Ok: true, Msg: "not charging to full"}
+			}
+			return Resp{Ok: true, Msg: "full charge cancelled: back to the configured thresholds"}
+		}
+		if err := s.State.ChargeFull(); err != nil {
+			return fail(ErrState, err)
+		}
+		return Resp{Ok: true, ChargingFull: true, Msg: "charging to full once; the thresholds come back when full or unplugged"}
+	case CmdConfig:
+		if s.Configure == nil {
+			return failf(ErrUnsupported, "config not supported")
+		}
+		settings, msg, err := s.Configure(r.Settings)
+		if err != nil {
+			return fail(ErrInvalid, err)
+		}
+		return Resp{Ok: true, Msg: msg, Settings: settings}
+	case CmdReload:
+		if s.Reload == nil {
+			return failf(ErrUnsupported, "reload not supported")
+		}
+		msg, err := s.Reload()
+		if err != nil {
+			return fail(ErrConfig, err)
+		}
+		return Resp{Ok: true, Msg: msg}
+	case CmdLogLevel:
+		return handleLogLevel(r)
+	case CmdPreset:
+		if r.Preset == "" {
+			return Resp{Ok: true, Presets: config.Presets, Preset: s.State.Config().Preset}
+		}
+		p, ok := config.FindPreset(r.Preset)
+		if !ok {
+			return failf(ErrInvalid, "unknown preset %q", r.Preset)
+		}
+		cfg, err := s.State.ApplyPreset(p)
+		if err != nil {
+			return fail(ErrState, err)
+		}
+		return Resp{Ok: true, Preset: cfg.Preset, Presets: config.Presets, Max: cfg.MaxPercent, Time: timeString(cfg), Auto: cfg.Auto}
+	case CmdStorage:
+		if r.Storage == nil {
+			return failf(ErrInvalid, "storage needs on or off")
+		}
+		if *r.Storage && !s.CanStore {
+			return failf(ErrUnsupported, "storage mode needs a percentage threshold backend (e.g. charge_control_end_threshold)")
+		}
+		cfg := s.State.SetStorage(*r.Storage)
+		return Resp{Ok: true, Storage: cfg.Storage, StorageLevel: cfg.StorageLevel, Max: cfg.MaxPercent, Time: timeString(cfg), Auto: cfg.Auto}
+	case CmdSnapshot:
+		snap := s.State.Config().Snapshot()
+		return Resp{Ok: true, Snapshot: &snap}
+	case CmdRestore:
+		if r.Snapshot == nil {
+			return failf(ErrInvalid, "restore needs a snapshot")
+		}
+		if r.Snapshot.Storage && !s.CanStore {
+			return failf(ErrUnsupported, "snapshot has storage mode on, which this machine's knob doesn't support")
+		}
+		if r.Snapshot.CalibrateEvery > 0 && !s.CanCalibrate {
+			return failf(ErrUnsupported, "snapshot schedules calibrations, which need a knob with force-discharge")
+		}
+		cfg, err := s.State.Update(func(cfg *config.Config) error {
+			if err := r.Snapshot.Restore(cfg); err != nil {
+				return err
+			}
+			if cfg.StatePath != "" {
+				if err := config.SaveState(cfg.StatePath, *cfg); err != nil {
+					logging.Errorf("save state: %v", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fail(ErrInvalid, err)
+		}
+		logging.Event("config_changed", map[string]any{
+			"max": cfg.MaxPercent, "time": timeString(cfg), "auto": cfg.Auto, "charge_current_ma": cfg.ChargeCurrentMA, "source": "restore",
+		})
+		return Resp{Ok: true, Max: cfg.MaxPercent, Time: timeString(cfg), Auto: cfg.Auto, ChargeCurrentMA: cfg.ChargeCurrentMA}
+	default:
+		return failf(ErrUnsupportedCmd, "unknown cmd %q", r.Cmd)
+	}
+}
+
+// features lists the optional features this daemon has, for hello.
+func (s *Server) features() []string {
+	var f []string
+	if s.CanCalibrate {
+		f = append(f, "calibrate")
+	}
+	if s.CanStore {
+		f = append(f, "storage")
+	}
+	if s.Reload != nil {
+		f = append(f, "reload")
+	}
+	if len(s.Extras) > 0 {
+		f = append(f, "knobs")
+	}
+	if s.State.Config().MultiUser {
+		f = append(f, "multi-user")
+	}
+	return f
+}
+
+// handleLogLevel changes the log level, for r.For if set, and describes
+// the level in force. The level is the daemon's, not the caller's: it stays
+// until changed again, a reload or a restart.
+func handleLogLevel(r Req) Resp {
+	if r.Level != "" {
+		l, err := logging.ParseLevel(r.Level)
+		if err != nil {
+			return fail(ErrInvalid, err)
+		}
+		if r.For != "" {
+			d, err := time.ParseDuration(r.For)
+			if err != nil || d <= 0 {
+				return failf(ErrInvalid, "for must be a positive duration, got %q", r.For)
+			}
+			logging.SetLevelFor(l, d)
+		} else {
+			logging.SetLevel(l)
+		}
+	}
+	cur, until, then := logging.Level()
+	msg := "log level " + strings.ToLower(cur.String())
+	if !until.IsZero() {
+		msg += fmt.Sprintf(" until %s, then %s", until.Format("15:04:05"), strings.ToLower(then.String()))
+	}
+	if r.Level != "" {
+		logging.Logf("%s", msg)
+	}
+	return Resp{Ok: true, Msg: msg}
+}
+
+// handleCalibrate starts, aborts or schedules a calibration, or reports the
+// calibration history.
+func (s *Server) handleCalibrate(r Req) Resp {
+	const noDischarge = "calibration needs a knob with force-discharge (charge_behaviour)"
+	switch r.Calibrate {
+	case "abort":
+		if !s.State.AbortCalibration() {
+			return failf(ErrState, "no calibration running")
+		}
+		return Resp{Ok: true, Msg: "calibration aborted"}
+	case "history":
+		cfg := s.State.Config()
+		resp := Resp{Ok: true, Calibrations: cfg.Calibrations}
+		if cfg.CalibrateAt != nil {
+			resp.NextCalibration = cfg.CalibrateAt.Unix()
+		}
+		return resp
+	case "schedule":
+		if !s.CanCalibrate {
+			return failf(ErrUnsupported, noDischarge)
+		}
+		at, err := control.ParseCalibrationTime(r.At, time.Now())
+		if err != nil {
+			return fail(ErrInvalid, err)
+		}
+		s.State.ScheduleCalibration(at)
+		logging.Event("calibration_scheduled", map[string]any{"at": at.Format(time.RFC3339)})
+		return Resp{Ok: true, NextCalibration: at.Unix()}
+	case "every":
+		every, err := control.ParseCalibrationInterval(r.Every)
+		if err != nil {
+			return fail(ErrInvalid, err)
+		}
+		if every > 0 && !s.CanCalibrate {
+			return failf(ErrUnsupported, noDischarge)
+		}
+		cfg := s.State.SetCalibrationInterval(every)
+		logging.Event("calibration_interval", map[string]any{"every": every.String()})
+		resp := Resp{Ok: true, Msg: "periodic calibration off"}
+		if cfg.CalibrateAt != nil {
+			resp.Msg = ""
+			resp.NextCalibration = cfg.CalibrateAt.Unix()
+		}
+		return resp
+	}
+	if !s.CanCalibrate {
+		return failf(ErrUnsupported, noDischarge)
+	}
+	if err := s.State.StartCalibration(r.Floor); err != nil {
+		return fail(ErrState, err)
+	}
+	return Resp{Ok: true, Calibration: s.State.Status().Calibration}
+}
+
+// handleKnobs sets the requested extra knobs, then reports all of them.
+func (s *Server) handleKnobs(r Req) Resp {
+	for name, on := range r.Knobs {
+		path, ok := s.Extras[name]
+		if !ok {
+			return failf(ErrUnsupported, "knob %q not available on this machine", name)
+		}
+		if name == "rapid_charge" && on && s.State.Config().RapidChargeConflict != "ignore" && s.State.Status().Cons == 1 {
+			return failf(ErrState, "rapid charge conflicts with conservation mode; turn conservation off first")
+		}
+		if s.State.Config().DryRun {
+			logging.Logf("[dry-run] would set %s to %t", name, on)
+			continue
+		}
+		if err := backend.WriteFlag(path, on); err != nil {
+			return fail(ErrBackendWrite, err)
+		}
+		logging.Logf("%s set to %t", name, on)
+		logging.Event("knob_changed", map[string]any{"knob": name, "on": on})
+	}
+	knobs := make(map[string]bool, len(s.Extras))
+	for name, path := range s.Extras {
+		on, err := backend.ReadFlag(path)
+		if err != nil {
+			return fail(ErrBackendRead, err)
+		}
+		knobs[name] = on
+	}
+	return Resp{Ok: true, Knobs: knobs}
+}
+
+// setFor applies r's thresholds for r.For, after which the stored ones
+// come back. Nothing is persisted: a restart ends the override too.
+func (s *Server) setFor(r Req) Resp {
+	d, _ := time.ParseDuration(r.For)
+	cfg := s.State.Config()
+	if rev := s.State.Revision(); r.IfRevision != 0 && r.IfRevision != rev {
+		return failf(ErrConflict, "%v (revision %d, now %d)", control.ErrStale, r.IfRevision, rev)
+	}
+	if r.Max < cfg.ConservationThreshold || r.Max > 100 {
+		return failf(ErrRange, "max must be %.1f..100", cfg.ConservationThreshold)
+	}
+	o := control.Override{Max: r.Max, Auto: cfg.Auto, Until: time.Now().Add(d)}
+	if r.Time != "" && r.Time != "now" {
+		targetTime, err := control.ParseTargetTime(r.Time, time.Now())
+		if err != nil {
+			return failf(ErrInvalid, "invalid time format: %v", err)
+		}
+		o.TargetTime = &targetTime
+	}
+	if r.Auto != nil {
+		o.Auto = *r.Auto
+	}
+	s.State.SetOverride(o)
+	cfg.MaxPercent, cfg.TargetTime, cfg.Auto = o.Max, o.TargetTime, o.Auto
+	return Resp{
+		Ok: true, Max: o.Max, Time: timeString(cfg), Auto: o.Auto,
+		OverrideMax: o.Max, OverrideUntil: o.Until.Unix(),
+		Msg: fmt.Sprintf("until %s, then back to max %.1f", o.Until.Format("15:04"), s.State.Config().MaxPercent),
+	}
+}
+
+// failUpdate answers a refused settings update, telling a stale revision
+// apart from the update's own errors.
+func failUpdate(err error) Resp {
+	if errors.Is(err, control.ErrStale) {
+		return fail(ErrConflict, err)
+	}
+	return fail(ErrInternal, err)
+}
+
+func timeString(cfg config.Config) string {
+	if cfg.TargetTime != nil {
+		return cfg.TargetTime.Format("15:04")
+	}
+	return "now"
+}
@@ -0,0 +1,810 @@
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/config"
+	"conservationDaemon/internal/control"
+	"conservationDaemon/internal/logging"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	return &Server{State: control.NewState(config.Config{
+		MaxPercent:            80,
+		ConservationThreshold: 80,
+		StatePath:             filepath.Join(t.TempDir(), "state.json"),
+	})}
+}
+
+func TestHandleSetAndStatus(t *testing.T) {
+	s := newTestServer(t)
+	auto := true
+
+	resp := s.handle(Req{Cmd: "set", Max: 95, Time: "now", Auto: &auto})
+	if !resp.Ok || resp.Max != 95 || resp.Time != "now" || !resp.Auto {
+		t.Fatalf("set: %+v", resp)
+	}
+
+	resp = s.handle(Req{Cmd: "status"})
+	if !resp.Ok || resp.Max != 95 || !resp.Auto || resp.State != "unknown" {
+		t.Fatalf("status: %+v", resp)
+	}
+
+	var cfg config.Config
+	cfg.ConservationThreshold = 80
+	if err := config.LoadState(s.State.Config().StatePath, &cfg); err != nil || cfg.MaxPercent != 95 {
+		t.Errorf("state not persisted: %+v, %v", cfg, err)
+	}
+}
+
+func TestHandleSetRejectsOutOfRange(t *testing.T) {
+	s := newTestServer(t)
+	if resp := s.handle(Req{Cmd: "set", Max: 50}); resp.Ok || resp.Code != ErrRange {
+		t.Fatalf("expected a range error, got %+v", resp)
+	}
+	if got := s.State.Config().MaxPercent; got != 80 {
+		t.Errorf("MaxPercent changed to %.1f", got)
+	}
+}
+
+func TestHandleSetSchedule(t *testing.T) {
+	s := newTestServer(t)
+	resp := s.handle(Req{Cmd: "set", Max: 90, Time: "07:30"})
+	if !resp.Ok || resp.Time != "07:30" {
+		t.Fatalf("set: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: "set", Max: 90, Time: "7h30"}); resp.Ok {
+		t.Error("expected invalid time error")
+	}
+}
+
+func TestHandleStorage(t *testing.T) {
+	s := newTestServer(t)
+	on := true
+	if resp := s.handle(Req{Cmd: CmdStorage, Storage: &on}); resp.Ok {
+		t.Fatalf("storage mode accepted without a capable knob: %+v", resp)
+	}
+	s.CanStore = true
+	if resp := s.handle(Req{Cmd: CmdStorage, Storage: &on}); !resp.Ok || !resp.Storage {
+		t.Fatalf("storage on: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdStatus}); !resp.Storage || resp.Max != 80 {
+		t.Errorf("status: %+v", resp)
+	}
+	var cfg config.Config
+	if err := config.LoadState(s.State.Config().StatePath, &cfg); err != nil || !cfg.Storage {
+		t.Errorf("storage mode not persisted: %+v, %v", cfg, err)
+	}
+}
+
+func TestHandlePreset(t *testing.T) {
+	s := newTestServer(t)
+	if resp := s.handle(Req{Cmd: CmdPreset}); !resp.Ok || len(resp.Presets) != len(config.Presets) {
+		t.Fatalf("list: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdPreset, Preset: "turbo"}); resp.Ok {
+		t.Error("unknown preset accepted")
+	}
+	resp := s.handle(Req{Cmd: CmdPreset, Preset: "Full charge"})
+	if !resp.Ok || resp.Preset != "full" || resp.Max != 100 {
+		t.Fatalf("apply: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdStatus}); resp.Preset != "full" {
+		t.Errorf("status preset = %q", resp.Preset)
+	}
+}
+
+func TestHandleLogLevel(t *testing.T) {
+	s := newTestServer(t)
+	t.Cleanup(func() { logging.SetLevel(slog.LevelInfo) })
+	if err := (Req{Cmd: CmdLogLevel, For: "10m"}).Validate(); err == nil {
+		t.Error("for without a level accepted")
+	}
+	if err := (Req{Cmd: CmdLogLevel, Level: "loud"}).Validate(); err == nil {
+		t.Error("level loud accepted")
+	}
+	if resp := s.handle(Req{Cmd: CmdLogLevel}); !resp.Ok || resp.Msg != "log level info" {
+		t.Errorf("show: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdLogLevel, Level: "debug", For: "10m"}); !resp.Ok || !strings.HasSuffix(resp.Msg, ", then info") {
+		t.Errorf("temporary debug: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdLogLevel, Level: "warn"}); !resp.Ok || resp.Msg != "log level warn" {
+		t.Errorf("warn: %+v", resp)
+	}
+}
+
+func TestHandleReload(t *testing.T) {
+	s := newTestServer(t)
+	if resp := s.handle(Req{Cmd: CmdReload}); resp.Ok {
+		t.Error("reload accepted without a Reload hook")
+	}
+	s.Reload = func() (string, error) { return "reloaded max", nil }
+	if resp := s.handle(Req{Cmd: CmdReload}); !resp.Ok || resp.Msg != "reloaded max" {
+		t.Errorf("reload: %+v", resp)
+	}
+	s.Reload = func() (string, error) { return "", errors.New("config.toml:3: unknown option maxx") }
+	if resp := s.handle(Req{Cmd: CmdReload}); resp.Ok || !strings.Contains(resp.Msg, "maxx") {
+		t.Errorf("failed reload: %+v", resp)
+	}
+}
+
+func TestHandleUnknown(t *testing.T) {
+	if resp := newTestServer(t).handle(Req{Cmd: "bogus"}); resp.Ok || resp.Code != ErrUnsupportedCmd {
+		t.Errorf("unknown command: %+v", resp)
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := newTestServer(t)
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, ln) }()
+
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewEncoder(c).Encode(Req{Cmd: "get"}); err != nil {
+		t.Fatal(err)
+	}
+	var resp Resp
+	if err := json.NewDecoder(c).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if !resp.Ok || resp.Max != 80 {
+		t.Errorf("get: %+v", resp)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not stop after cancel")
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	s := newTestServer(t)
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, ln) }()
+
+	// A request in flight when the daemon stops still gets its answer
+	started, release := make(chan struct{}), make(chan struct{})
+	s.Reload = func() (string, error) {
+		close(started)
+		<-release
+		return "reloaded", nil
+	}
+	answer := make(chan *Resp, 1)
+	go func() {
+		resp, err := Call(sock, 5*time.Second, Req{Cmd: CmdReload})
+		if err != nil {
+			t.Error(err)
+		}
+		answer <- resp
+	}()
+	<-started
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("Serve returned with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if resp := <-answer; resp == nil || !resp.Ok {
+		t.Errorf("in-flight reload: %+v", resp)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(ShutdownGrace + time.Second):
+		t.Fatal("Serve did not stop after cancel")
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket file left behind: %v", err)
+	}
+}
+
+func TestServeRejectsExcessConnections(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxConns = 1
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(context.Background(), ln) }()
+
+	// Occupy the only handler slot without sending a request
+	idle, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp Resp
+	if err := json.NewDecoder(c).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if resp.Ok || !strings.Contains(resp.Msg, "too many connections") {
+		t.Errorf("excess connection got %+v", resp)
+	}
+
+	// Closing the listener directly must stop Serve instead of spinning
+	ln.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after listener close")
+	}
+}
+
+func TestListenRefusesLiveSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "run", "conservationd.sock")
+	ln, err := Listen(sock, "nonexistent-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go newTestServer(t).Serve(ctx, ln)
+
+	if _, err := Listen(sock, "nonexistent-group"); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Fatalf("second Listen: %v", err)
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "conservationd.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave the socket file behind like a crashed daemon would
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	ln, err = Listen(sock, "nonexistent-group")
+	if err != nil {
+		t.Fatalf("Listen over stale socket: %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenRefusesNonSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conservationd.sock")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path, "nonexistent-group"); err == nil {
+		t.Fatal("Listen replaced a regular file")
+	}
+}
+
+func TestHandleUserPolicy(t *testing.T) {
+	s := newTestServer(t)
+	if resp := s.handleUser(Req{Cmd: "set", Max: 90, PerUser: true}, 1000); resp.Ok {
+		t.Fatal("per-user set accepted without -multi-user")
+	}
+
+	s.State.Update(func(cfg *config.Config) error { cfg.MultiUser = true; return nil })
+	if resp := s.handleUser(Req{Cmd: "set", Max: 90, PerUser: true}, 1000); !resp.Ok || resp.Max != 90 {
+		t.Fatalf("set: %+v", resp)
+	}
+	cfg := s.State.Config()
+	if cfg.MaxPercent != 80 || cfg.UserPolicies[1000].Max != 90 {
+		t.Errorf("per-user set leaked into global config: %+v", cfg)
+	}
+
+	ma := 1000
+	if resp := s.handleUser(Req{Cmd: "set", Max: 90, PerUser: true, ChargeCurrentMA: &ma}, 1000); !resp.Ok || resp.ChargeCurrentMA != 1000 {
+		t.Fatalf("set charge current: %+v", resp)
+	}
+	// Kept by a set that doesn't mention it
+	if resp := s.handleUser(Req{Cmd: "set", Max: 95, PerUser: true}, 1000); !resp.Ok || resp.ChargeCurrentMA != 1000 {
+		t.Fatalf("set without charge current: %+v", resp)
+	}
+	cfg = s.State.Config()
+	if cfg.ChargeCurrentMA != 0 {
+		t.Errorf("per-user charge current leaked into global config: %d", cfg.ChargeCurrentMA)
+	}
+
+	loaded := config.Config{ConservationThreshold: 80}
+	if err := config.LoadState(cfg.StatePath, &loaded); err != nil || loaded.UserPolicies[1000] != (config.UserPolicy{Max: 95, ChargeCurrentMA: 1000}) {
+		t.Errorf("user policy not persisted: %+v, %v", loaded.UserPolicies, err)
+	}
+
+	if resp := s.handleUser(Req{Cmd: "clear", PerUser: true}, 1000); !resp.Ok {
+		t.Fatalf("clear: %+v", resp)
+	}
+	if _, ok := s.State.Config().UserPolicies[1000]; ok {
+		t.Error("policy not cleared")
+	}
+}
+
+func TestHandleKnobs(t *testing.T) {
+	s := newTestServer(t)
+	usb := filepath.Join(t.TempDir(), "usb_charging")
+	if err := os.WriteFile(usb, []byte("0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.Extras = map[string]string{"usb_charging": usb}
+
+	resp := s.handle(Req{Cmd: "knobs"})
+	if !resp.Ok || len(resp.Knobs) != 1 || resp.Knobs["usb_charging"] {
+		t.Fatalf("get: %+v", resp)
+	}
+	resp = s.handle(Req{Cmd: "knobs", Knobs: map[string]bool{"usb_charging": true}})
+	if !resp.Ok || !resp.Knobs["usb_charging"] {
+		t.Fatalf("set: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: "knobs", Knobs: map[string]bool{"fn_lock": true}}); resp.Ok {
+		t.Error("unknown knob accepted")
+	}
+}
+
+func TestStatusBatteries(t *testing.T) {
+	s := newTestServer(t)
+	s.Batteries = func() []backend.BatteryInfo {
+		return []backend.BatteryInfo{{Name: "BAT0", Pct: 50}, {Name: "BAT1", Pct: 20, EndThreshold: 60}}
+	}
+	resp := s.handle(Req{Cmd: "status"})
+	if len(resp.Batteries) != 2 || resp.Batteries[1].Threshold != 60 {
+		t.Errorf("batteries: %+v", resp.Batteries)
+	}
+	s.Batteries = func() []backend.BatteryInfo { return []backend.BatteryInfo{{Name: "BAT0"}} }
+	if resp := s.handle(Req{Cmd: "status"}); resp.Batteries != nil {
+		t.Errorf("single battery listed: %+v", resp.Batteries)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := newTestServer(t)
+	resp := s.handle(Req{Cmd: "summary"})
+	if !resp.Ok || resp.Summary == nil || resp.Summary.Since == 0 {
+		t.Errorf("summary: %+v", resp)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	s := newTestServer(t)
+	resp := s.handle(Req{Cmd: "snapshot"})
+	if !resp.Ok || resp.Snapshot == nil || resp.Snapshot.Version != config.SnapshotVersion {
+		t.Fatalf("snapshot: %+v", resp)
+	}
+	snap := *resp.Snapshot
+	snap.Max, snap.Auto = 95, true
+	if resp := s.handle(Req{Cmd: "restore", Snapshot: &snap}); !resp.Ok || resp.Max != 95 {
+		t.Fatalf("restore: %+v", resp)
+	}
+	if cfg := s.State.Config(); cfg.MaxPercent != 95 || !cfg.Auto {
+		t.Errorf("config after restore: %+v", cfg)
+	}
+	snap.Max = 50
+	if resp := s.handle(Req{Cmd: "restore", Snapshot: &snap}); resp.Ok {
+		t.Error("invalid snapshot restored")
+	}
+	if resp := s.handle(Req{Cmd: "restore"}); resp.Ok {
+		t.Error("restore without snapshot accepted")
+	}
+}
+
+func TestMutates(t *testing.T) {
+	for _, tc := range []struct {
+		r    Req
+		want bool
+	}{
+		{Req{Cmd: CmdStatus}, false},
+		{Req{Cmd: CmdSet, Max: 90}, true},
+		{Req{Cmd: CmdKnobs}, false},
+		{Req{Cmd: CmdKnobs, Knobs: map[string]bool{"usb_charging": true}}, true},
+		{Req{Cmd: CmdCalibrate, Calibrate: "history"}, false},
+		{Req{Cmd: CmdCalibrate, Calibrate: "start"}, true},
+		{Req{Cmd: CmdPreset}, false},
+		{Req{Cmd: CmdPreset, Preset: "full"}, true},
+		{Req{Cmd: CmdLogLevel}, false},
+		{Req{Cmd: CmdLogLevel, Level: "debug"}, true},
+		{Req{Cmd: CmdReload}, true},
+		{Req{Cmd: CmdPause}, true},
+		{Req{Cmd: CmdResume}, true},
+		{Req{Cmd: CmdConfig}, false},
+		{Req{Cmd: CmdConfig, Settings: map[string]string{"interval": "30s"}}, true},
+	} {
+		if got := tc.r.Mutates(); got != tc.want {
+			t.Errorf("%+v: Mutates() = %t", tc.r, got)
+		}
+	}
+}
+
+func TestProcGroups(t *testing.T) {
+	got, err := procGroups(int32(os.Getpid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.Getgroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("procGroups = %v, Getgroups = %v", got, want)
+	}
+	for i := range want {
+		if got[i] != uint32(want[i]) {
+			t.Errorf("procGroups = %v, Getgroups = %v", got, want)
+		}
+	}
+}
+
+// otherGroup returns an existing group this process isn't in, for the
+// admin group, or skips the test.
+func otherGroup(t *testing.T) string {
+	t.Helper()
+	mine, _ := os.Getgroups()
+	for gid := 1; gid < 1000; gid++ {
+		if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil && !slices.Contains(mine, gid) && gid != os.Getgid() {
+			return g.Name
+		}
+	}
+	t.Skip("no group to use as the admin group")
+	return ""
+}
+
+func TestAdminGroup(t *testing.T) {
+	s := newTestServer(t)
+	s.AdminGroup = otherGroup(t)
+	s.credOf = func(net.Conn) (*syscall.Ucred, error) {
+		return &syscall.Ucred{Pid: int32(os.Getpid()), Uid: 65534, Gid: 65534}, nil
+	}
+	on := true
+	for _, r := range []Req{
+		{Cmd: CmdSet, Max: 90},
+		{Cmd: CmdStorage, Storage: &on},
+		{Cmd: CmdStorage, Storage: &on, PerUser: true},
+		{Cmd: CmdPause, PerUser: true},
+		{Cmd: CmdConfig, Settings: map[string]string{"max": "90"}, PerUser: true},
+		{Cmd: CmdCalibrate, Calibrate: "start", PerUser: true},
+	} {
+		if resp := s.answer(nil, r); resp.Ok || resp.Code != ErrPermission {
+			t.Errorf("%+v from a non-admin: %+v", r, resp)
+		}
+	}
+	// Their own policy and reading are fine
+	if resp := s.answer(nil, Req{Cmd: CmdSet, Max: 90, PerUser: true}); resp.Code == ErrPermission {
+		t.Errorf("per-user set from a non-admin: %+v", resp)
+	}
+	if resp := s.answer(nil, Req{Cmd: CmdStatus, PerUser: true}); !resp.Ok {
+		t.Errorf("status from a non-admin: %+v", resp)
+	}
+	if got := s.State.Config().MaxPercent; got != 80 {
+		t.Errorf("max changed to %v by a non-admin", got)
+	}
+
+	if err := (Req{Cmd: CmdPause, PerUser: true}).Validate(); ErrorCode(err) != ErrInvalid {
+		t.Errorf("per_user pause: %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s := newTestServer(t)
+	s.ReadOnly = true
+	sock := filepath.Join(t.TempDir(), "status", "status.sock")
+	ln, err := ListenStatus(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, ln)
+
+	if fi, err := os.Stat(sock); err != nil || fi.Mode().Perm() != 0o666 {
+		t.Errorf("status socket mode: %v, %v", fi, err)
+	}
+	if resp, err := Call(sock, time.Second, Req{Cmd: CmdStatus}); err != nil || resp.Max != 80 {
+		t.Errorf("status: %+v, %v", resp, err)
+	}
+	for _, r := range []Req{
+		{Cmd: CmdSet, Max: 90},
+		{Cmd: CmdSet, Max: 90, PerUser: true},
+		{Cmd: CmdSnapshot},
+		{Cmd: CmdConfig},
+		{Cmd: CmdPreset, Preset: "full"},
+	} {
+		if _, err := Call(sock, time.Second, r); ErrorCode(err) != ErrPermission || !strings.Contains(err.Error(), "read-only") {
+			t.Errorf("%+v on the status socket: %v", r, err)
+		}
+	}
+	if got := s.State.Config().MaxPercent; got != 80 {
+		t.Errorf("max changed to %v through the status socket", got)
+	}
+}
+
+func TestAbstractSocket(t *testing.T) {
+	s := newTestServer(t)
+	s.Group = "conservationd-test-no-such-group"
+	sock := fmt.Sprintf("@conservationd-test-%d", os.Getpid())
+	if !Abstract(sock) || Abstract("/run/conservationd/conservationd.sock") {
+		t.Fatal("Abstract")
+	}
+	if SocketExists(sock) {
+		t.Fatal("exists before Listen")
+	}
+	ln, err := Listen(sock, s.Group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, ln)
+	if !SocketExists(sock) {
+		t.Error("doesn't exist after Listen")
+	}
+
+	resp, err := Call(sock, time.Second, Req{Cmd: CmdStatus})
+	if os.Getuid() == 0 {
+		if err != nil || resp.Max != 80 {
+			t.Errorf("root: %+v, %v", resp, err)
+		}
+	} else if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("outside the group: %+v, %v", resp, err)
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	s := newTestServer(t)
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, ln)
+
+	// A request that never ends: refused once it passes MaxRequestSize
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go func() {
+		c.Write([]byte(`{"cmd":"status","time":"`))
+		c.Write([]byte(strings.Repeat("x", 2*MaxRequestSize)))
+	}()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var resp Resp
+	if err := Decode(c, &resp); err != nil || resp.Ok || resp.Code != ErrLimit || !strings.Contains(resp.Msg, "larger than") {
+		t.Errorf("oversized request: %+v, %v", resp, err)
+	}
+
+	// Under the limit, with a request before it on the same connection
+	c2, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	dec := json.NewDecoder(c2)
+	for i := 0; i < 2; i++ {
+		if err := Encode(c2, Req{Cmd: CmdStatus}); err != nil {
+			t.Fatal(err)
+		}
+		var resp Resp
+		if err := dec.Decode(&resp); err != nil || !resp.Ok {
+			t.Errorf("request %d: %+v, %v", i, resp, err)
+		}
+	}
+}
+
+func TestConfigCmd(t *testing.T) {
+	s := newTestServer(t)
+	if resp := s.handle(Req{Cmd: CmdConfig}); resp.Ok {
+		t.Errorf("config without Configure: %+v", resp)
+	}
+	var got map[string]string
+	s.Configure = func(set map[string]string) (map[string]string, string, error) {
+		if set["interval"] == "0s" {
+			return nil, "", errors.New("interval must be positive")
+		}
+		got = set
+		return map[string]string{"interval": "30s"}, "set interval", nil
+	}
+	resp := s.handle(Req{Cmd: CmdConfig, Settings: map[string]string{"interval": "30s"}})
+	if !resp.Ok || resp.Settings["interval"] != "30s" || got["interval"] != "30s" {
+		t.Errorf("config interval=30s: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdConfig, Settings: map[string]string{"interval": "0s"}}); resp.Ok || resp.Msg != "interval must be positive" {
+		t.Errorf("config interval=0s: %+v", resp)
+	}
+}
+
+func TestPauseCmd(t *testing.T) {
+	s := newTestServer(t)
+	if err := (Req{Cmd: CmdPause, For: "soon"}).Validate(); err == nil {
+		t.Error("pause for soon validated")
+	}
+	resp := s.handle(Req{Cmd: CmdPause, For: "1h"})
+	if !resp.Ok || !resp.Paused || resp.PausedUntil < time.Now().Add(59*time.Minute).Unix() {
+		t.Fatalf("pause 1h: %+v", resp)
+	}
+	if st := s.handle(Req{Cmd: CmdStatus}); !st.Paused || st.PausedUntil != resp.PausedUntil {
+		t.Errorf("status while paused: paused %t until %d", st.Paused, st.PausedUntil)
+	}
+	if resp := s.handle(Req{Cmd: CmdResume}); !resp.Ok || resp.Msg != "resumed" {
+		t.Errorf("resume: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdResume}); !resp.Ok || resp.Msg != "not paused" {
+		t.Errorf("second resume: %+v", resp)
+	}
+	if st := s.handle(Req{Cmd: CmdStatus}); st.Paused {
+		t.Error("status paused after resume")
+	}
+}
+
+func TestSetFor(t *testing.T) {
+	s := newTestServer(t)
+	max := s.State.Config().MaxPercent
+	if err := (Req{Cmd: CmdSet, Max: 90, For: "1h", PerUser: true}).Validate(); err == nil {
+		t.Error("per-user set for validated")
+	}
+	resp := s.handle(Req{Cmd: CmdSet, Max: 100, For: "1h"})
+	if !resp.Ok || resp.Max != 100 || resp.OverrideUntil < time.Now().Add(59*time.Minute).Unix() {
+		t.Fatalf("set for 1h: %+v", resp)
+	}
+	st := s.handle(Req{Cmd: CmdStatus})
+	if st.Max != max || st.OverrideMax != 100 || st.OverrideUntil != resp.OverrideUntil {
+		t.Errorf("status under override: max %g, override %g until %d", st.Max, st.OverrideMax, st.OverrideUntil)
+	}
+
+	// A plain set ends it
+	if resp := s.handle(Req{Cmd: CmdSet, Max: 95}); !resp.Ok {
+		t.Fatalf("set: %+v", resp)
+	}
+	if st := s.handle(Req{Cmd: CmdStatus}); st.Max != 95 || st.OverrideUntil != 0 {
+		t.Errorf("status after set: max %g, override until %d", st.Max, st.OverrideUntil)
+	}
+}
+
+func TestSetIfRevision(t *testing.T) {
+	s := newTestServer(t)
+	rev := s.handle(Req{Cmd: CmdStatus}).Revision
+	resp := s.handle(Req{Cmd: CmdSet, Max: 90, IfRevision: rev})
+	if !resp.Ok || resp.Revision <= rev {
+		t.Fatalf("set at the current revision: %+v", resp)
+	}
+	// A second client that read the settings before the first one's change
+	for _, r := range []Req{{Cmd: CmdSet, Max: 100, IfRevision: rev}, {Cmd: CmdSet, Max: 100, For: "1h", IfRevision: rev}} {
+		if resp := s.handle(r); resp.Ok || resp.Code != ErrConflict {
+			t.Errorf("%+v at a stale revision: %+v", r, resp)
+		}
+	}
+	if got := s.State.Config().MaxPercent; got != 90 {
+		t.Errorf("max %g after the stale set", got)
+	}
+}
+
+func TestFullCmd(t *testing.T) {
+	s := newTestServer(t)
+	if !(Req{Cmd: CmdFull, Cancel: true}).Mutates() {
+		t.Error("full cancel does not mutate")
+	}
+	if resp := s.handle(Req{Cmd: CmdFull}); !resp.Ok || !resp.ChargingFull {
+		t.Fatalf("full: %+v", resp)
+	}
+	if st := s.handle(Req{Cmd: CmdStatus}); !st.ChargingFull {
+		t.Error("status not charging full")
+	}
+	if resp := s.handle(Req{Cmd: CmdPause}); !resp.Ok {
+		t.Fatalf("pause: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdFull, Cancel: true}); !resp.Ok || resp.ChargingFull {
+		t.Errorf("cancel: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdFull, Cancel: true}); !resp.Ok || resp.Msg != "not charging to full" {
+		t.Errorf("second cancel: %+v", resp)
+	}
+	if resp := s.handle(Req{Cmd: CmdFull}); resp.Ok || resp.Code != ErrState {
+		t.Errorf("full charge while paused: %+v", resp)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	l := newLimiter(10)
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		if !l.allow(1, now) {
+			t.Fatalf("connection %d of the burst refused", i)
+		}
+	}
+	if l.allow(1, now) {
+		t.Error("connection over the burst allowed")
+	}
+	if !l.allow(2, now) {
+		t.Error("another uid throttled")
+	}
+
+	// Requests wait for their token, up to maxThrottle
+	if d, ok := l.wait(1, now); !ok || d != 100*time.Millisecond {
+		t.Errorf("first request over the burst: %v, %t", d, ok)
+	}
+	if d, ok := l.wait(1, now); !ok || d != 200*time.Millisecond {
+		t.Errorf("second request over the burst: %v, %t", d, ok)
+	}
+	for i := 0; i < 18; i++ {
+		l.wait(1, now)
+	}
+	if _, ok := l.wait(1, now); ok {
+		t.Error("request more than maxThrottle behind not refused")
+	}
+	if d, ok := l.wait(1, now.Add(3*time.Second)); !ok || d != 0 {
+		t.Errorf("after a pause: %v, %t", d, ok)
+	}
+
+	// End to end: a client in a tight loop is slowed down to the rate
+	s := newTestServer(t)
+	s.RateLimit = 50
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, ln)
+	cn := &Conn{Sock: sock, Timeout: 5 * time.Second}
+	defer cn.Close()
+	start := time.Now()
+	for i := 0; i < 120; i++ {
+		if _, err := cn.Call(Req{Cmd: CmdPing}); err != nil {
+			t.Fatalf("ping %d: %v", i, err)
+		}
+	}
+	// 100 in the burst, and a connection's token, then 50 per second
+	if took := time.Since(start); took < 300*time.Millisecond {
+		t.Errorf("120 pings at 50/s took only %v", took)
+	}
+}
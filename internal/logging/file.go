@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File is a log file that rotates itself: the current file is renamed to
+// path.1 (path.1 to path.2, and so on) once it would outgrow MaxSize, or
+// when a write falls into a new Every period (counted from the Unix epoch,
+// so 24h rotates at midnight UTC) than the file's last write. Only the Keep
+// newest rotated files are kept.
+type File struct {
+	Path    string
+	MaxSize int64         // bytes; 0 never rotates by size
+	Every   time.Duration // 0 never rotates by time
+	Keep    int           // rotated files to keep; 0 deletes them
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	last time.Time // time of the last write
+}
+
+// OpenFile opens (or creates) path for appending and returns it as a File.
+func OpenFile(path string, maxSize int64, every time.Duration, keep int) (*File, error) {
+	lf := &File{Path: path, MaxSize: maxSize, Every: every, Keep: keep}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+func (lf *File) open() error {
+	if err := os.MkdirAll(filepath.Dir(lf.Path), 0o755); err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+	f, err := os.OpenFile(lf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("log file: %w", err)
+	}
+	lf.f, lf.size, lf.last = f, fi.Size(), fi.ModTime()
+	return nil
+}
+
+// Write appends p, rotating first if it is due. p is never split across
+// files.
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	now := time.Now()
+	if lf.size > 0 && lf.due(now, len(p)) {
+		if err := lf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	lf.last = now
+	return n, err
+}
+
+func (lf *File) due(now time.Time, n int) bool {
+	if lf.MaxSize > 0 && lf.size+int64(n) > lf.MaxSize {
+		return true
+	}
+	return lf.Every > 0 && !now.Truncate(lf.Every).Equal(lf.last.Truncate(lf.Every))
+}
+
+// rotate shifts path.N to path.N+1, dropping what falls past Keep, moves
+// the current file to path.1 and starts a new one. If the directory isn't
+// writable, the renames fail and it carries on appending to the same file.
+func (lf *File) rotate() error {
+	lf.f.Close()
+	if lf.Keep == 0 {
+		os.Remove(lf.Path)
+		return lf.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", lf.Path, lf.Keep))
+	for i := lf.Keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", lf.Path, i), fmt.Sprintf("%s.%d", lf.Path, i+1))
+	}
+	os.Rename(lf.Path, lf.Path+".1")
+	return lf.open()
+}
+
+// Close closes the current file.
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.f.Close()
+}
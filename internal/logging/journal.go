@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: MIT
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// journalSocket is journald's native protocol socket, overridable in tests.
+var journalSocket = "/run/systemd/journal/socket"
+
+// UnderJournal reports whether the log output is connected to the systemd
+// journal, as it is for a service started by systemd with the default
+// StandardOutput.
+func UnderJournal() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	mu.Lock()
+	f, ok := out.(*os.File)
+	mu.Unlock()
+	if !ok {
+		return false
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return false
+	}
+	return stream == fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
+
+// UseJournal sends log messages to the journal through its native protocol,
+// with their level as PRIORITY and their fields as journal fields (pct
+// becomes PCT), so journalctl can filter on them.
+func UseJournal() error {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	logger = slog.New(&journalHandler{conn: conn})
+	return nil
+}
+
+// journalHandler writes each record as one journal entry.
+type journalHandler struct {
+	conn  net.Conn
+	attrs []slog.Attr
+}
+
+func (h *journalHandler) Enabled(_ context.Context, l slog.Level) bool { return l >= level.Level() }
+
+func (h *journalHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", r.Message)
+	journalField(&b, "PRIORITY", priority(r.Level))
+	journalField(&b, "SYSLOG_IDENTIFIER", "conservationd")
+	add := func(a slog.Attr) bool {
+		if key := journalKey(a.Key); key != "" {
+			journalField(&b, key, a.Value.Resolve().String())
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &journalHandler{conn: h.conn, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *journalHandler) WithGroup(string) slog.Handler { return h }
+
+// priority maps a level to a syslog priority.
+func priority(l slog.Level) string {
+	switch {
+	case l >= slog.LevelError:
+		return "3"
+	case l >= slog.LevelWarn:
+		return "4"
+	case l >= slog.LevelInfo:
+		return "6"
+	default:
+		return "7"
+	}
+}
+
+// journalKey turns a field name into a journal field name: upper case
+// letters, digits and underscores, starting with a letter (a leading
+// underscore marks fields only journald may set). It returns "" if nothing
+// is left.
+func journalKey(k string) string {
+	k = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, k)
+	return strings.TrimLeft(k, "_0123456789")
+}
+
+// journalField appends one field in the native protocol: KEY=value, or
+// the length-prefixed form for values spanning lines.
+func journalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", key, value)
+		return
+	}
+	b.WriteString(key)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
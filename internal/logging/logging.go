@@ -0,0 +1,232 @@
+// SPDX-License-Identifier: MIT
+
+// Package logging provides the daemon's leveled, structured log output and
+// its optional machine-readable event stream. Log lines go to stdout as
+// text, or straight to the systemd journal with their fields when the
+// daemon runs under systemd.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	mu     sync.Mutex
+	out    io.Writer = os.Stdout
+	events io.Writer // nil unless EnableEvents was called
+	subs   = map[chan []byte]struct{}{}
+
+	level  = new(slog.LevelVar) // Info unless SetLevel was called
+	logger = slog.New(&lineHandler{})
+
+	revertMu sync.Mutex
+	revert   *time.Timer // pending SetLevelFor revert, to revertTo at revertAt
+	revertTo slog.Level
+	revertAt time.Time
+)
+
+// SetLevel drops log messages below l, cancelling a pending SetLevelFor
+// revert.
+func SetLevel(l slog.Level) {
+	revertMu.Lock()
+	defer revertMu.Unlock()
+	if revert != nil {
+		revert.Stop()
+		revert = nil
+	}
+	level.Set(l)
+}
+
+// SetLevelFor sets the level to l for d, then goes back to the level it
+// replaces; if a revert was already pending, back to the level that one
+// would have restored. It returns the level it will go back to.
+func SetLevelFor(l slog.Level, d time.Duration) slog.Level {
+	revertMu.Lock()
+	defer revertMu.Unlock()
+	prev := level.Level()
+	if revert != nil {
+		revert.Stop()
+		prev = revertTo
+	}
+	level.Set(l)
+	revertTo, revertAt = prev, time.Now().Add(d)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		revertMu.Lock()
+		defer revertMu.Unlock()
+		if revert == t {
+			logger.Info("log level back to " + strings.ToLower(prev.String()))
+			level.Set(prev)
+			revert = nil
+		}
+	})
+	revert = t
+	return prev
+}
+
+// Level returns the current level and, if SetLevelFor will change it back,
+// when and to what.
+func Level() (cur slog.Level, until time.Time, then slog.Level) {
+	revertMu.Lock()
+	defer revertMu.Unlock()
+	if revert != nil {
+		until, then = revertAt, revertTo
+	}
+	return level.Level(), until, then
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return l, fmt.Errorf("log level must be debug, info, warn or error, got %q", s)
+	}
+	return l, nil
+}
+
+// Debug, Info, Warn and Error log msg with key-value fields, e.g.
+// Info("decision", "pct", 80.5, "action", "none").
+func Debug(msg string, args ...any) { logger.Debug(msg, args...) }
+func Info(msg string, args ...any)  { logger.Info(msg, args...) }
+func Warn(msg string, args ...any)  { logger.Warn(msg, args...) }
+func Error(msg string, args ...any) { logger.Error(msg, args...) }
+
+// Logf logs a formatted message at info level.
+func Logf(f string, a ...any) { logger.Info(fmt.Sprintf(f, a...)) }
+
+// Debugf, Warnf and Errorf log a formatted message at their level.
+func Debugf(f string, a ...any) { logger.Debug(fmt.Sprintf(f, a...)) }
+func Warnf(f string, a ...any)  { logger.Warn(fmt.Sprintf(f, a...)) }
+func Errorf(f string, a ...any) { logger.Error(fmt.Sprintf(f, a...)) }
+
+// lineHandler writes one timestamped line per record to out, fields as
+// key=value after the message.
+type lineHandler struct {
+	attrs []slog.Attr
+}
+
+func (h *lineHandler) Enabled(_ context.Context, l slog.Level) bool { return l >= level.Level() }
+
+func (h *lineHandler) Handle(_ context.Context, r slog.Record) error {
+	b := r.Time.AppendFormat(nil, time.RFC3339)
+	b = append(b, " conservationd: "...)
+	switch {
+	case r.Level >= slog.LevelError:
+		b = append(b, "error: "...)
+	case r.Level >= slog.LevelWarn:
+		b = append(b, "warning: "...)
+	case r.Level < slog.LevelInfo:
+		b = append(b, "debug: "...)
+	}
+	b = append(b, r.Message...)
+	appendAttr := func(a slog.Attr) bool {
+		b = fmt.Appendf(b, " %s=%s", a.Key, quote(a.Value.Resolve().String()))
+		return true
+	}
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(appendAttr)
+	b = append(b, '\n')
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := out.Write(b)
+	return err
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &lineHandler{attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *lineHandler) WithGroup(string) slog.Handler { return h }
+
+// quote quotes s if it is empty or holds spaces, quotes or control
+// characters, so fields stay one token each.
+func quote(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '"' || r == '=' || r == 0x7f {
+			return fmt.Sprintf("%q", s)
+		}
+	}
+	return s
+}
+
+// SetOutput sends log lines to w instead of stdout, e.g. a rotating File.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// EnableEvents makes Event write JSON lines to w. If w is stdout, log lines
+// move to stderr so the stream stays parseable.
+func EnableEvents(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	events = w
+	if w == os.Stdout {
+		out = os.Stderr
+	}
+}
+
+// SubscribeEvents returns a channel receiving every event as Event would
+// write it, one JSON object without the newline, whether or not
+// EnableEvents was called. Events are dropped rather than wait for a full
+// channel. cancel stops the subscription and closes the channel.
+func SubscribeEvents(buf int) (ch <-chan []byte, cancel func()) {
+	c := make(chan []byte, buf)
+	mu.Lock()
+	subs[c] = struct{}{}
+	mu.Unlock()
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			mu.Lock()
+			delete(subs, c)
+			mu.Unlock()
+			close(c)
+		})
+	}
+}
+
+// Event emits one JSON object, on a single line, with the event name, a
+// timestamp and fields, to the EnableEvents writer and to subscribers. It is
+// a no-op if there are neither.
+func Event(name string, fields map[string]any) {
+	mu.Lock()
+	defer mu.Unlock()
+	if events == nil && len(subs) == 0 {
+		return
+	}
+	obj := make(map[string]any, len(fields)+2)
+	for k, v := range fields {
+		obj[k] = v
+	}
+	obj["event"] = name
+	obj["time"] = time.Now().Format(time.RFC3339)
+	b, err := json.Marshal(obj)
+	if err != nil {
+		fmt.Fprintf(out, "conservationd: marshal event %s: %v\n", name, err)
+		return
+	}
+	for c := range subs {
+		select {
+		case c <- b:
+		default:
+		}
+	}
+	if events != nil {
+		events.Write(append(b, '\n'))
+	}
+}
@@ -0,0 +1,176 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvent(t *testing.T) {
+	Event("ignored", nil) // disabled by default: must not panic
+
+	var buf bytes.Buffer
+	EnableEvents(&buf)
+	t.Cleanup(func() { events = nil })
+
+	Event("decision", map[string]any{"pct": 80.5, "action": "none"})
+	Event("started", nil)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines: %q", len(lines), buf.String())
+	}
+	var ev map[string]any
+	if err := json.Unmarshal(lines[0], &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev["event"] != "decision" || ev["pct"] != 80.5 || ev["action"] != "none" || ev["time"] == nil {
+		t.Errorf("unexpected event %v", ev)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	mu.Lock()
+	old := out
+	out = &buf
+	mu.Unlock()
+	t.Cleanup(func() {
+		out = old
+		SetLevel(slog.LevelInfo)
+	})
+
+	Debugf("hidden %d", 1)
+	Info("decision", "pct", 80.5, "action", "none", "reason", "pct 80.5 ≥ max 80")
+	SetLevel(slog.LevelWarn)
+	Logf("hidden too")
+	Warnf("rapid charge is %s", "on")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines: %q", len(lines), buf.String())
+	}
+	if !strings.HasSuffix(lines[0], ` conservationd: decision pct=80.5 action=none reason="pct 80.5 ≥ max 80"`) {
+		t.Errorf("info line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], " conservationd: warning: rapid charge is on") {
+		t.Errorf("warn line %q", lines[1])
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("ParseLevel accepted loud")
+	}
+	if l, err := ParseLevel("debug"); err != nil || l != slog.LevelDebug {
+		t.Errorf("ParseLevel(debug) = %v, %v", l, err)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "journal.sock")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	oldSock, oldLogger := journalSocket, logger
+	journalSocket = sock
+	t.Cleanup(func() { journalSocket, logger = oldSock, oldLogger })
+
+	if err := UseJournal(); err != nil {
+		t.Fatal(err)
+	}
+	Error("write failed", "knob", "/sys/x", "error", "line 1\nline 2")
+
+	buf := make([]byte, 4096)
+	n, err := ln.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	for _, want := range []string{"MESSAGE=write failed\n", "PRIORITY=3\n", "SYSLOG_IDENTIFIER=conservationd\n", "KNOB=/sys/x\n", "ERROR\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("entry %q lacks %q", msg, want)
+		}
+	}
+	if !strings.HasSuffix(msg, "line 1\nline 2\n") {
+		t.Errorf("multi-line field not length-prefixed: %q", msg)
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log", "conservationd.log")
+	lf, err := OpenFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n"} {
+		if _, err := lf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// aaaa+bbbb fill the first file; cccc+dddd the second; nothing rotated
+	// past .1 yet.
+	for name, want := range map[string]string{"": "cccc\ndddd\n", ".1": "aaaa\nbbbb\n"} {
+		if b, err := os.ReadFile(path + name); err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v; want %q", path+name, b, err, want)
+		}
+	}
+	lf.Write([]byte("eeee\n"))
+	lf.Write([]byte("ffffffffffff\n")) // larger than MaxSize: its own file
+	if b, _ := os.ReadFile(path); string(b) != "ffffffffffff\n" {
+		t.Errorf("current file %q", b)
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("kept more than 2 rotated files")
+	}
+	if b, _ := os.ReadFile(path + ".2"); string(b) != "cccc\ndddd\n" {
+		t.Errorf(".2 = %q", b)
+	}
+
+	// A write in a new period rotates however small the file is.
+	lf.Every = time.Hour
+	lf.last = lf.last.Add(-time.Hour)
+	lf.Write([]byte("g\n"))
+	if b, _ := os.ReadFile(path); string(b) != "g\n" {
+		t.Errorf("after a period: current file %q", b)
+	}
+}
+
+func TestSetLevelFor(t *testing.T) {
+	t.Cleanup(func() { SetLevel(slog.LevelInfo) })
+	SetLevel(slog.LevelWarn)
+	if prev := SetLevelFor(slog.LevelDebug, time.Hour); prev != slog.LevelWarn {
+		t.Errorf("SetLevelFor returned %v", prev)
+	}
+	// A second temporary level still goes back to warn, not debug.
+	if prev := SetLevelFor(slog.LevelInfo, 20*time.Millisecond); prev != slog.LevelWarn {
+		t.Errorf("second SetLevelFor returned %v", prev)
+	}
+	if cur, until, then := Level(); cur != slog.LevelInfo || until.IsZero() || then != slog.LevelWarn {
+		t.Errorf("Level() = %v, %v, %v", cur, until, then)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cur, until, _ := Level()
+		if cur == slog.LevelWarn && until.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still at %v", cur)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	SetLevelFor(slog.LevelDebug, 20*time.Millisecond)
+	SetLevel(slog.LevelError) // cancels the revert
+	time.Sleep(50 * time.Millisecond)
+	if cur, _, _ := Level(); cur != slog.LevelError {
+		t.Errorf("revert overrode SetLevel: %v", cur)
+	}
+}
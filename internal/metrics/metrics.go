@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: MIT
+
+// Package metrics renders daemon status in the Prometheus text exposition
+// format, for node_exporter's textfile collector.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Sample is the status the metrics are built from.
+type Sample struct {
+	Pct           float64
+	Max           float64
+	State         string // battery state, e.g. "charging"
+	Cons          int    // conservation knob value
+	Auto          bool
+	Health        float64 // full vs. design capacity, 0 if unknown
+	Temp          float64 // °C, 0 if unknown
+	WriteFailures int
+	Updated       time.Time // last measurement, zero if none yet
+}
+
+// States lists the battery states exported as conservationd_battery_state.
+var States = []string{"unknown", "charging", "discharging", "full", "empty", "pending"}
+
+// Write writes s in the text exposition format.
+func Write(w io.Writer, s Sample) error {
+	var b bytes.Buffer
+	gauge := func(name, help string, v float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	gauge("conservationd_battery_percent", "Battery charge in percent.", s.Pct)
+	gauge("conservationd_max_percent", "Configured charge target in percent.", s.Max)
+	gauge("conservationd_conservation_mode", "Conservation knob value written by the daemon.", float64(s.Cons))
+	gauge("conservationd_auto_mode", "Whether auto mode is enabled.", bool2f(s.Auto))
+
+	b.WriteString("# HELP conservationd_battery_state Battery state, 1 for the current one.\n# TYPE conservationd_battery_state gauge\n")
+	for _, st := range States {
+		fmt.Fprintf(&b, "conservationd_battery_state{state=%q} %g\n", st, bool2f(st == s.State))
+	}
+
+	if s.Health > 0 {
+		gauge("conservationd_battery_health_percent", "Full charge capacity vs. design capacity in percent.", s.Health)
+	}
+	if s.Temp > 0 {
+		gauge("conservationd_battery_temperature_celsius", "Battery temperature.", s.Temp)
+	}
+	fmt.Fprintf(&b, "# HELP conservationd_write_failures_total Knob writes that failed after retries.\n# TYPE conservationd_write_failures_total counter\nconservationd_write_failures_total %d\n", s.WriteFailures)
+	if !s.Updated.IsZero() {
+		gauge("conservationd_last_update_timestamp_seconds", "Unix time of the last battery measurement.", float64(s.Updated.Unix()))
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// WriteFile writes s to path through a temporary file and a rename, so the
+// collector never reads a half-written file.
+func WriteFile(path string, s Sample) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".conservationd-*.prom")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := Write(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Run writes the sample returned by sample to path every interval until ctx
+// is cancelled, starting right away.
+func Run(ctx context.Context, path string, interval time.Duration, sample func() Sample, logf func(string, ...any)) {
+	for {
+		if err := WriteFile(path, sample()); err != nil {
+			logf("prom textfile: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func bool2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "conservationd.prom")
+	s := Sample{Pct: 79.5, Max: 80, State: "charging", Cons: 1, Health: 91.2, Updated: time.Unix(1700000000, 0)}
+	if err := WriteFile(path, s); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		"conservationd_battery_percent 79.5\n",
+		"conservationd_conservation_mode 1\n",
+		`conservationd_battery_state{state="charging"} 1` + "\n",
+		`conservationd_battery_state{state="full"} 0` + "\n",
+		"conservationd_battery_health_percent 91.2\n",
+		"conservationd_last_update_timestamp_seconds 1700000000\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "temperature") {
+		t.Error("unknown temperature exported")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: MIT
+
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Health returns the battery's full capacity as a percentage of its design
+// capacity, and its cycle count when the firmware reports one (0 if not).
+func (b SysfsBattery) Health() (pct float64, cycles int, err error) {
+	for _, unit := range []string{"energy", "charge"} {
+		full, err1 := b.intAttr(unit + "_full")
+		design, err2 := b.intAttr(unit + "_full_design")
+		if err1 != nil || err2 != nil || design <= 0 {
+			continue
+		}
+		if c, err := b.intAttr("cycle_count"); err == nil && c > 0 {
+			cycles = c
+		}
+		return float64(full) * 100 / float64(design), cycles, nil
+	}
+	return 0, 0, fmt.Errorf("%s reports no full/design capacity", b.Name)
+}
+
+func (b SysfsBattery) intAttr(name string) (int, error) {
+	v, err := b.attr(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// Temp returns the battery temperature in °C.
+func (b SysfsBattery) Temp() (float64, error) {
+	t, err := b.intAttr("temp")
+	if err != nil {
+		return 0, err
+	}
+	return float64(t) / 10, nil // tenths of a degree
+}
@@ -0,0 +1,360 @@
+// SPDX-License-Identifier: MIT
+
+// Package monitor reads battery state from UPower and docking hints from DRM.
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+type BatteryState uint32
+
+const (
+	BatteryStateUnknown   BatteryState = 0
+	BatteryStateCharging  BatteryState = 1
+	BatteryStateDischarge BatteryState = 2
+	BatteryStateEmpty     BatteryState = 3
+	BatteryStateFull      BatteryState = 4
+	BatteryStatePending   BatteryState = 5
+)
+
+func (s BatteryState) String() string {
+	switch s {
+	case BatteryStateCharging:
+		return "charging"
+	case BatteryStateDischarge:
+		return "discharging"
+	case BatteryStateFull:
+		return "full"
+	case BatteryStateEmpty:
+		return "empty"
+	case BatteryStatePending:
+		return "pending"
+	default:
+		return "unknown"
+	}
+}
+
+const upowerPath = dbus.ObjectPath("/org/freedesktop/UPower")
+
+// drmStatusGlob matches connector status files, overridable in tests.
+var drmStatusGlob = "/sys/class/drm/*/status"
+
+// Battery reads the UPower display device.
+type Battery struct {
+	conn   *dbus.Conn
+	path   dbus.ObjectPath
+	device dbus.BusObject // cached proxies
+	upower dbus.BusObject
+
+	// While Watch runs, property reads are cached until the next
+	// PropertiesChanged signal instead of costing a round-trip each.
+	mu       sync.Mutex
+	watching bool
+	gen      uint64 // bumped on every invalidation
+	cache    batteryCache
+}
+
+// cacheTTL bounds how long cached properties are trusted, in case a signal
+// is lost (e.g. UPower restarting).
+const cacheTTL = time.Minute
+
+type batteryCache struct {
+	at      time.Time // when the cache was first filled
+	valid   bool
+	pct     float64
+	state   BatteryState
+	onValid bool
+	onBat   bool
+}
+
+// fill starts a fresh cache window, dropping values older than cacheTTL.
+func (c *batteryCache) fill() {
+	if time.Since(c.at) >= cacheTTL {
+		*c = batteryCache{at: time.Now()}
+	}
+}
+
+// NewBattery resolves the UPower display device on conn.
+func NewBattery(ctx context.Context, conn *dbus.Conn) (*Battery, error) {
+	obj := conn.Object("org.freedesktop.UPower", upowerPath)
+	var path dbus.ObjectPath
+	if err := obj.CallWithContext(ctx, "org.freedesktop.UPower.GetDisplayDevice", 0).Store(&path); err != nil {
+		return nil, fmt.Errorf("GetDisplayDevice: %w", err)
+	}
+	return &Battery{
+		conn:   conn,
+		path:   path,
+		device: conn.Object("org.freedesktop.UPower", path),
+		upower: obj,
+	}, nil
+}
+
+// Path returns the UPower object path of the battery.
+func (b *Battery) Path() dbus.ObjectPath {
+	return b.path
+}
+
+// Read returns the current charge percentage and state.
+func (b *Battery) Read(ctx context.Context) (percent float64, state BatteryState, err error) {
+	b.mu.Lock()
+	c, gen := b.cache, b.gen
+	b.mu.Unlock()
+	if c.valid && time.Since(c.at) < cacheTTL {
+		return c.pct, c.state, nil
+	}
+
+	// One GetAll instead of a Get per property
+	var props map[string]dbus.Variant
+	if err = b.device.CallWithContext(ctx, "org.freedesktop.DBus.Properties.GetAll", 0, "org.freedesktop.UPower.Device").Store(&props); err != nil {
+		return 0, 0, fmt.Errorf("get device properties: %w", err)
+	}
+	p, ok := props["Percentage"].Value().(float64)
+	if !ok {
+		return 0, 0, errors.New("percentage not float64")
+	}
+	switch v := props["State"].Value().(type) {
+	case uint32:
+		state = BatteryState(v)
+	case uint64:
+		state = BatteryState(uint32(v))
+	default:
+		return p, 0, errors.New("state not uint")
+	}
+
+	b.mu.Lock()
+	if b.watching && b.gen == gen {
+		b.cache.fill()
+		b.cache.valid, b.cache.pct, b.cache.state = true, p, state
+	}
+	b.mu.Unlock()
+	return p, state, nil
+}
+
+// OnBattery reports whether UPower considers the system to run on battery.
+func (b *Battery) OnBattery(ctx context.Context) (bool, error) {
+	b.mu.Lock()
+	c, gen := b.cache, b.gen
+	b.mu.Unlock()
+	if c.onValid && time.Since(c.at) < cacheTTL {
+		return c.onBat, nil
+	}
+
+	var variant dbus.Variant
+	if err := b.upower.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, "org.freedesktop.UPower", "OnBattery").Store(&variant); err != nil {
+		return false, fmt.Errorf("get OnBattery: %w", err)
+	}
+	v, ok := variant.Value().(bool)
+	if !ok {
+		return false, errors.New("OnBattery not bool")
+	}
+
+	b.mu.Lock()
+	if b.watching && b.gen == gen {
+		b.cache.fill()
+		b.cache.onValid, b.cache.onBat = true, v
+	}
+	b.mu.Unlock()
+	return v, nil
+}
+
+// Watch subscribes to PropertiesChanged on the battery device and on UPower
+// itself (AC online/offline). The returned channel receives a value, coalesced,
+// whenever either changes, until ctx is cancelled. While watching, Read and
+// OnBattery serve cached values until the next change.
+func (b *Battery) Watch(ctx context.Context) (<-chan struct{}, error) {
+	for _, path := range []dbus.ObjectPath{b.path, upowerPath} {
+		if err := b.conn.AddMatchSignalContext(ctx,
+			dbus.WithMatchObjectPath(path),
+			dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+			dbus.WithMatchMember("PropertiesChanged"),
+		); err != nil {
+			return nil, fmt.Errorf("watch %s: %w", path, err)
+		}
+	}
+	sigs := make(chan *dbus.Signal, 16)
+	b.conn.Signal(sigs)
+
+	b.mu.Lock()
+	b.watching = true
+	b.mu.Unlock()
+
+	out := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			b.conn.RemoveSignal(sigs)
+			b.mu.Lock()
+			b.watching, b.cache = false, batteryCache{}
+			b.mu.Unlock()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig, ok := <-sigs:
+				if !ok {
+					return
+				}
+				if sig.Name != "org.freedesktop.DBus.Properties.PropertiesChanged" || (sig.Path != b.path && sig.Path != upowerPath) {
+					continue
+				}
+				b.mu.Lock()
+				b.cache = batteryCache{}
+				b.gen++
+				b.mu.Unlock()
+				select {
+				case out <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchResume reports system resumes from suspend, as announced by logind's
+// PrepareForSleep(false) signal.
+func WatchResume(ctx context.Context, conn *dbus.Conn) (<-chan struct{}, error) {
+	if err := conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath("/org/freedesktop/login1"),
+		dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+		dbus.WithMatchMember("PrepareForSleep"),
+	); err != nil {
+		return nil, fmt.Errorf("watch PrepareForSleep: %w", err)
+	}
+	sigs := make(chan *dbus.Signal, 4)
+	conn.Signal(sigs)
+
+	out := make(chan struct{}, 1)
+	go func() {
+		defer conn.RemoveSignal(sigs)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig, ok := <-sigs:
+				if !ok {
+					return
+				}
+				if sig.Name != "org.freedesktop.login1.Manager.PrepareForSleep" || len(sig.Body) != 1 {
+					continue
+				}
+				if sleeping, _ := sig.Body[0].(bool); sleeping {
+					continue
+				}
+				select {
+				case out <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Merge fans several event channels into one, coalescing pending events.
+func Merge(ctx context.Context, chans ...<-chan struct{}) <-chan struct{} {
+	out := make(chan struct{}, 1)
+	for _, ch := range chans {
+		if ch == nil {
+			continue
+		}
+		go func(ch <-chan struct{}) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case _, ok := <-ch:
+					if !ok {
+						return
+					}
+					select {
+					case out <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}(ch)
+	}
+	return out
+}
+
+// ExternalDisplayConnected reports whether any non-internal DRM connector
+// is connected.
+func ExternalDisplayConnected() (bool, error) {
+	dirs, err := filepath.Glob(drmStatusGlob)
+	if err != nil {
+		return false, err
+	}
+	for _, statusFile := range dirs {
+		dir := filepath.Base(filepath.Dir(statusFile))
+		// skip internal displays
+		if strings.Contains(dir, "eDP") || strings.Contains(dir, "LVDS") || strings.Contains(dir, "DSI") {
+			continue
+		}
+
+		b, err := os.ReadFile(statusFile)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(string(b), "connected") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Session is an active, seat-attached logind session.
+type Session struct {
+	UID  uint32
+	Seat string
+}
+
+// ActiveSessions lists the active logind sessions attached to a seat.
+// Remote sessions (no seat) are skipped: they don't own the machine's power.
+func ActiveSessions(ctx context.Context, conn *dbus.Conn) ([]Session, error) {
+	var list []struct {
+		ID   string
+		UID  uint32
+		User string
+		Seat string
+		Path dbus.ObjectPath
+	}
+	mgr := conn.Object("org.freedesktop.login1", "/org/freedesktop/login1")
+	if err := mgr.CallWithContext(ctx, "org.freedesktop.login1.Manager.ListSessions", 0).Store(&list); err != nil {
+		return nil, fmt.Errorf("ListSessions: %w", err)
+	}
+	var out []Session
+	for _, s := range list {
+		if s.Seat == "" {
+			continue
+		}
+		var v dbus.Variant
+		if err := conn.Object("org.freedesktop.login1", s.Path).CallWithContext(ctx,
+			"org.freedesktop.DBus.Properties.Get", 0, "org.freedesktop.login1.Session", "Active").Store(&v); err != nil {
+			continue // session went away meanwhile
+		}
+		if active, _ := v.Value().(bool); active {
+			out = append(out, Session{UID: s.UID, Seat: s.Seat})
+		}
+	}
+	return out, nil
+}
+
+// NameHasOwner reports whether a service currently owns the bus name.
+func NameHasOwner(ctx context.Context, conn *dbus.Conn, name string) (bool, error) {
+	var owned bool
+	if err := conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.NameHasOwner", 0, name).Store(&owned); err != nil {
+		return false, fmt.Errorf("NameHasOwner %s: %w", name, err)
+	}
+	return owned, nil
+}
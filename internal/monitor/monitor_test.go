@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func fakeConnector(t *testing.T, root, name, status string) {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "status"), []byte(status+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalDisplayConnected(t *testing.T) {
+	root := t.TempDir()
+	old := drmStatusGlob
+	drmStatusGlob = filepath.Join(root, "*", "status")
+	t.Cleanup(func() { drmStatusGlob = old })
+
+	fakeConnector(t, root, "card0-eDP-1", "connected")
+	fakeConnector(t, root, "card0-HDMI-A-1", "disconnected")
+	if ok, err := ExternalDisplayConnected(); err != nil || ok {
+		t.Fatalf("internal panel only: got %t, %v", ok, err)
+	}
+
+	fakeConnector(t, root, "card0-DP-1", "connected")
+	if ok, err := ExternalDisplayConnected(); err != nil || !ok {
+		t.Fatalf("external connected: got %t, %v", ok, err)
+	}
+}
+
+func TestBatteryStateString(t *testing.T) {
+	if BatteryStateCharging.String() != "charging" || BatteryState(42).String() != "unknown" {
+		t.Error("unexpected BatteryState strings")
+	}
+}
+
+func TestBatteryCacheFill(t *testing.T) {
+	var c batteryCache
+	c.fill()
+	c.valid, c.pct = true, 50
+	c.fill()
+	if !c.valid || c.pct != 50 {
+		t.Error("fill within the TTL dropped cached values")
+	}
+	c.at = time.Now().Add(-cacheTTL)
+	c.fill()
+	if c.valid {
+		t.Error("fill kept values older than the TTL")
+	}
+}
+
+func TestSysfsBattery(t *testing.T) {
+	root := t.TempDir()
+	old := powerSupplyDir
+	powerSupplyDir = root
+	t.Cleanup(func() { powerSupplyDir = old })
+	write := func(dev, attr, v string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(root, dev), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dev, attr), []byte(v+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("BAT0", "capacity", "76")
+	write("BAT0", "status", "Not charging")
+	write("BAT0", "type", "Battery")
+	b := SysfsBattery{Name: "BAT0"}
+
+	pct, state, err := b.Read(context.Background())
+	if err != nil || pct != 76 || state != BatteryStatePending {
+		t.Errorf("Read = %.0f, %s, %v", pct, state, err)
+	}
+	if _, err := b.OnBattery(context.Background()); err == nil {
+		t.Error("OnBattery without an AC supply should fail")
+	}
+	write("AC", "type", "Mains")
+	write("AC", "online", "0")
+	if on, err := b.OnBattery(context.Background()); err != nil || !on {
+		t.Errorf("OnBattery unplugged = %t, %v", on, err)
+	}
+	write("AC", "online", "1")
+	if on, err := b.OnBattery(context.Background()); err != nil || on {
+		t.Errorf("OnBattery plugged = %t, %v", on, err)
+	}
+}
+
+func TestIsPowerSupplyUevent(t *testing.T) {
+	msg := []byte("change@/devices/LNXSYSTM:00/ACPI0003:00/power_supply/AC\x00ACTION=change\x00SUBSYSTEM=power_supply\x00POWER_SUPPLY_ONLINE=1\x00")
+	if !isPowerSupplyUevent(msg) {
+		t.Error("power_supply uevent not recognised")
+	}
+	if isPowerSupplyUevent([]byte("add@/devices/usb1\x00SUBSYSTEM=usb\x00")) {
+		t.Error("usb uevent accepted")
+	}
+}
+
+func TestSysfsBatteryHealth(t *testing.T) {
+	root := t.TempDir()
+	old := powerSupplyDir
+	powerSupplyDir = root
+	t.Cleanup(func() { powerSupplyDir = old })
+
+	bat := filepath.Join(root, "BAT0")
+	os.MkdirAll(bat, 0o755)
+	b := SysfsBattery{Name: "BAT0"}
+	if _, _, err := b.Health(); err == nil {
+		t.Error("expected error without capacity files")
+	}
+	os.WriteFile(filepath.Join(bat, "charge_full"), []byte("4500000\n"), 0o644)
+	os.WriteFile(filepath.Join(bat, "charge_full_design"), []byte("5000000\n"), 0o644)
+	os.WriteFile(filepath.Join(bat, "cycle_count"), []byte("0\n"), 0o644)
+	h, c, err := b.Health()
+	if err != nil || h != 90 || c != 0 {
+		t.Errorf("Health = %v, %d, %v", h, c, err)
+	}
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: MIT
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// powerSupplyDir is the sysfs power_supply class, overridable in tests.
+var powerSupplyDir = "/sys/class/power_supply"
+
+// SysfsBattery reads a battery straight from sysfs, for systems without
+// UPower.
+type SysfsBattery struct {
+	Name string // e.g. "BAT0"
+}
+
+func (b SysfsBattery) attr(name string) (string, error) {
+	v, err := os.ReadFile(filepath.Join(powerSupplyDir, b.Name, name))
+	return strings.TrimSpace(string(v)), err
+}
+
+// Read returns the charge percentage and state, mapped like UPower does.
+func (b SysfsBattery) Read(context.Context) (float64, BatteryState, error) {
+	c, err := b.attr("capacity")
+	if err != nil {
+		return 0, 0, err
+	}
+	pct, err := strconv.ParseFloat(c, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse capacity: %w", err)
+	}
+	status, _ := b.attr("status")
+	var state BatteryState
+	switch status {
+	case "Charging":
+		state = BatteryStateCharging
+	case "Discharging":
+		state = BatteryStateDischarge
+	case "Full":
+		state = BatteryStateFull
+	case "Not charging":
+		state = BatteryStatePending
+	}
+	return pct, state, nil
+}
+
+// OnBattery reports whether no mains/USB supply is online.
+func (b SysfsBattery) OnBattery(context.Context) (bool, error) {
+	dirs, err := os.ReadDir(powerSupplyDir)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, d := range dirs {
+		t, _ := os.ReadFile(filepath.Join(powerSupplyDir, d.Name(), "type"))
+		switch strings.TrimSpace(string(t)) {
+		case "Mains", "USB", "USB_C", "USB_PD":
+		default:
+			continue
+		}
+		found = true
+		if on, _ := os.ReadFile(filepath.Join(powerSupplyDir, d.Name(), "online")); strings.TrimSpace(string(on)) == "1" {
+			return false, nil
+		}
+	}
+	if !found {
+		return false, errors.New("no AC power supply in sysfs")
+	}
+	return true, nil
+}
+
+// WatchUevents listens for kernel power_supply uevents (AC online/offline,
+// capacity changes) over netlink. The returned channel receives a value,
+// coalesced, for each one until ctx is cancelled.
+func WatchUevents(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("uevent socket: %w", err)
+	}
+	// Group 1 carries the kernel's own events
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("uevent bind: %w", err)
+	}
+	// Non-blocking so the file goes through the runtime poller and Close
+	// interrupts a pending Read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("uevent socket: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), "uevent")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	out := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 16*1024)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			if !isPowerSupplyUevent(buf[:n]) {
+				continue
+			}
+			select {
+			case out <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return out, nil
+}
+
+// isPowerSupplyUevent reports whether msg ("action@devpath\0KEY=VALUE\0...")
+// belongs to the power_supply subsystem.
+func isPowerSupplyUevent(msg []byte) bool {
+	for _, field := range strings.Split(string(msg), "\x00") {
+		if field == "SUBSYSTEM=power_supply" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: MIT
+
+// Package platform drives the ACPI platform_profile (quiet, balanced,
+// performance, ...).
+package platform
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"conservationDaemon/internal/backend"
+)
+
+// acpiDir holds platform_profile, overridable in tests.
+var acpiDir = "/sys/firmware/acpi"
+
+// Profile is the platform_profile sysfs interface.
+type Profile struct {
+	Path string
+}
+
+// Find returns the platform_profile interface, if the platform has one.
+func Find() (Profile, error) {
+	p := filepath.Join(acpiDir, "platform_profile")
+	if _, err := os.Stat(p); err != nil {
+		return Profile{}, fmt.Errorf("platform_profile not supported: %w", err)
+	}
+	return Profile{Path: p}, nil
+}
+
+// Choices lists the profiles the platform accepts.
+func (p Profile) Choices() ([]string, error) {
+	b, err := os.ReadFile(p.Path + "_choices")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
+
+func (p Profile) Read() (string, error) {
+	b, err := os.ReadFile(p.Path)
+	return strings.TrimSpace(string(b)), err
+}
+
+func (p Profile) Write(name string) error {
+	return backend.WriteSysfs(p.Path, name)
+}
+
+// Check verifies that every profile in names is supported.
+func (p Profile) Check(names map[string]string) error {
+	choices, err := p.Choices()
+	if err != nil {
+		return err
+	}
+	for mode, name := range names {
+		if !slices.Contains(choices, name) {
+			return fmt.Errorf("%s profile %q not supported (choices: %s)", mode, name, strings.Join(choices, " "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package platform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	root := t.TempDir()
+	old := acpiDir
+	acpiDir = root
+	t.Cleanup(func() { acpiDir = old })
+
+	if _, err := Find(); err == nil {
+		t.Fatal("found platform_profile on an empty tree")
+	}
+	os.WriteFile(filepath.Join(root, "platform_profile"), []byte("balanced\n"), 0o644)
+	os.WriteFile(filepath.Join(root, "platform_profile_choices"), []byte("low-power balanced performance\n"), 0o644)
+
+	p, err := Find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Check(map[string]string{"conserve": "low-power", "trip": "performance"}); err != nil {
+		t.Error(err)
+	}
+	if err := p.Check(map[string]string{"charge": "turbo"}); err == nil {
+		t.Error("unsupported profile accepted")
+	}
+	if err := p.Write("low-power"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.Read(); err != nil || got != "low-power" {
+		t.Errorf("Read = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: MIT
+
+// Package quirks identifies the laptop model via DMI and describes firmware
+// behaviour the daemon has to work around.
+package quirks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"conservationDaemon/internal/backend"
+	"conservationDaemon/internal/logging"
+)
+
+// dmiDir is the DMI sysfs directory, overridable in tests.
+var dmiDir = "/sys/class/dmi/id"
+
+// DMI holds the identifying strings of the machine.
+type DMI struct {
+	Vendor  string // sys_vendor
+	Product string // product_name (Lenovo: machine type, e.g. 82A1)
+	Version string // product_version (Lenovo: marketing name)
+	Family  string // product_family
+}
+
+func (d DMI) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", d.Vendor, d.Version, d.Product))
+}
+
+// ReadDMI reads the DMI identification; missing fields are left empty.
+func ReadDMI() DMI {
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(dmiDir, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(b))
+	}
+	return DMI{
+		Vendor:  read("sys_vendor"),
+		Product: read("product_name"),
+		Version: read("product_version"),
+		Family:  read("product_family"),
+	}
+}
+
+// Profile is a set of workarounds for a model family.
+type Profile struct {
+	Name string
+
+	// RapidChargeConflict: firmware ignores conservation mode while rapid
+	// charge is enabled, so rapid charge must be turned off first.
+	RapidChargeConflict bool
+
+	// ResetAfterSuspend: the conservation node reads back as off after
+	// resume, so the setting must be re-applied when the system wakes.
+	ResetAfterSuspend bool
+
+	// RewriteAfterResume: the driver keeps reporting the value last written,
+	// but the firmware forgets it across suspend, so it must be written again
+	// on resume even though it reads back unchanged (asus-wmi).
+	RewriteAfterResume bool
+
+	// VendorDaemon is the bus name of the vendor's power daemon, which also
+	// manages the charge thresholds and would fight the daemon over them.
+	VendorDaemon string
+}
+
+// Generic is the profile used when no known quirk matches.
+var Generic = Profile{Name: "generic"}
+
+// known maps DMI substrings to profiles. Lenovo puts the marketing name in
+// product_version, so that is what most entries match on.
+var known = []struct {
+	vendor  string
+	version string
+	profile Profile
+}{
+	{"LENOVO", "Legion", Profile{Name: "lenovo-legion", RapidChargeConflict: true}},
+	{"LENOVO", "IdeaPad Gaming", Profile{Name: "lenovo-ideapad-gaming", RapidChargeConflict: true}},
+	{"LENOVO", "IdeaPad 5 14ARE05", Profile{Name: "lenovo-ideapad5-are05", RapidChargeConflict: true, ResetAfterSuspend: true}},
+	{"LENOVO", "Yoga Slim 7 14ARE05", Profile{Name: "lenovo-yoga-slim7-are05", ResetAfterSuspend: true}},
+	{"ASUSTeK COMPUTER INC.", "", Profile{Name: "asus", RewriteAfterResume: true}},
+	{"System76", "", Profile{Name: "system76", VendorDaemon: "com.system76.PowerDaemon"}},
+}
+
+// Detect returns the quirk profile for d.
+func Detect(d DMI) Profile {
+	for _, k := range known {
+		if strings.EqualFold(d.Vendor, k.vendor) && strings.Contains(strings.ToLower(d.Version), strings.ToLower(k.version)) {
+			return k.profile
+		}
+	}
+	return Generic
+}
+
+// Knob is the conservation setting being guarded.
+type Knob interface {
+	Read() (int, error)
+	Write(v int) error
+	ValueString(v int) string
+}
+
+// rapidChargeGuard turns rapid charge off before enabling conservation.
+type rapidChargeGuard struct {
+	Knob
+	rapidPath string
+}
+
+// GuardRapidCharge wraps k so that enabling conservation first disables the
+// rapid_charge node next to knobPath, if there is one. It returns k unchanged
+// when no such node exists.
+func GuardRapidCharge(k Knob, knobPath string) Knob {
+	p := filepath.Join(filepath.Dir(knobPath), "rapid_charge")
+	if _, err := os.Stat(p); err != nil {
+		return k
+	}
+	return &rapidChargeGuard{Knob: k, rapidPath: p}
+}
+
+func (g *rapidChargeGuard) Write(v int) error {
+	if v == 1 {
+		b, err := os.ReadFile(g.rapidPath)
+		if err == nil && strings.TrimSpace(string(b)) == "1" {
+			if err := backend.WriteSysfs(g.rapidPath, "0"); err != nil {
+				return fmt.Errorf("disable rapid charge: %w", err)
+			}
+			logging.Logf("quirk: disabled rapid charge before enabling conservation")
+		}
+	}
+	return g.Knob.Write(v)
+}
+
+// Rewrite forwards every resume from resumed after writing k's current value
+// again, for firmware that forgets it while the driver does not.
+func Rewrite(ctx context.Context, k Knob, resumed <-chan struct{}) <-chan struct{} {
+	out := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-resumed:
+			}
+			v, err := k.Read()
+			if err == nil {
+				err = k.Write(v)
+			}
+			if err != nil {
+				logging.Warnf("quirk: rewrite after resume: %v", err)
+			} else {
+				logging.Logf("quirk: rewrote %s after resume", k.ValueString(v))
+			}
+			select {
+			case out <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return out
+}
+
+// rapidChargeRefusal keeps conservation off while rapid charge is on.
+type rapidChargeRefusal struct {
+	Knob
+	rapidPath string
+}
+
+// RefuseRapidCharge wraps k so that enabling conservation fails while the
+// rapid_charge node next to knobPath is on, leaving the user's choice of
+// rapid charge alone. It returns k unchanged when no such node exists.
+func RefuseRapidCharge(k Knob, knobPath string) Knob {
+	p := filepath.Join(filepath.Dir(knobPath), "rapid_charge")
+	if _, err := os.Stat(p); err != nil {
+		return k
+	}
+	return &rapidChargeRefusal{Knob: k, rapidPath: p}
+}
+
+func (r *rapidChargeRefusal) Write(v int) error {
+	if v == 1 {
+		if b, err := os.ReadFile(r.rapidPath); err == nil && strings.TrimSpace(string(b)) == "1" {
+			return fmt.Errorf("rapid charge is on: not enabling conservation (-rapid-charge-conflict refuse)")
+		}
+	}
+	return r.Knob.Write(v)
+}
+
+// rapidChargePolicy handles rapid charge as its policy says at each write.
+type rapidChargePolicy struct {
+	Knob
+	guard, refuse Knob
+	policy        func() string
+}
+
+// RapidChargePolicy wraps k like GuardRapidCharge, RefuseRapidCharge or
+// neither, as policy returns "disable", "refuse" or "ignore" when
+// conservation is turned on, so a restored setting applies without a
+// restart. It returns k unchanged when there is no rapid_charge node.
+func RapidChargePolicy(k Knob, knobPath string, policy func() string) Knob {
+	guard := GuardRapidCharge(k, knobPath)
+	if guard == k {
+		return k
+	}
+	return &rapidChargePolicy{Knob: k, guard: guard, refuse: RefuseRapidCharge(k, knobPath), policy: policy}
+}
+
+func (p *rapidChargePolicy) Write(v int) error {
+	switch p.policy() {
+	case "refuse":
+		return p.refuse.Write(v)
+	case "ignore":
+		return p.Knob.Write(v)
+	}
+	return p.guard.Write(v)
+}
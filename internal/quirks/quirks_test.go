@@ -0,0 +1,144 @@
+package quirks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadDMIAndDetect(t *testing.T) {
+	dir := t.TempDir()
+	old := dmiDir
+	dmiDir = dir
+	t.Cleanup(func() { dmiDir = old })
+
+	for name, v := range map[string]string{
+		"sys_vendor":      "LENOVO\n",
+		"product_name":    "82A1\n",
+		"product_version": "Yoga Slim 7 14ARE05\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(v), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := ReadDMI()
+	if d.Vendor != "LENOVO" || d.Product != "82A1" || d.Family != "" {
+		t.Fatalf("ReadDMI = %+v", d)
+	}
+	if p := Detect(d); p.Name != "lenovo-yoga-slim7-are05" || !p.ResetAfterSuspend {
+		t.Errorf("Detect = %+v", p)
+	}
+	if p := Detect(DMI{Vendor: "ASUSTeK COMPUTER INC.", Version: "1.0"}); p.Name != "asus" || !p.RewriteAfterResume {
+		t.Errorf("Detect(ASUS) = %+v", p)
+	}
+	if p := Detect(DMI{Vendor: "System76", Version: "lemp11"}); p.VendorDaemon == "" {
+		t.Errorf("Detect(System76) = %+v", p)
+	}
+	if p := Detect(DMI{Vendor: "Dell Inc.", Version: "Legion"}); p != Generic {
+		t.Errorf("vendor mismatch matched %+v", p)
+	}
+}
+
+type memKnob struct{ val int }
+
+func (k *memKnob) Read() (int, error)       { return k.val, nil }
+func (k *memKnob) Write(v int) error        { k.val = v; return nil }
+func (k *memKnob) ValueString(v int) string { return strconv.Itoa(v) }
+
+func TestGuardRapidCharge(t *testing.T) {
+	dir := t.TempDir()
+	knobPath := filepath.Join(dir, "conservation_mode")
+
+	k := &memKnob{}
+	if got := GuardRapidCharge(k, knobPath); got != Knob(k) {
+		t.Fatal("knob wrapped without a rapid_charge node")
+	}
+
+	rapid := filepath.Join(dir, "rapid_charge")
+	if err := os.WriteFile(rapid, []byte("1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g := GuardRapidCharge(k, knobPath)
+	if err := g.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(rapid)
+	if strings.TrimSpace(string(b)) != "0" || k.val != 1 {
+		t.Errorf("rapid_charge=%q knob=%d", b, k.val)
+	}
+}
+
+func TestRefuseRapidCharge(t *testing.T) {
+	dir := t.TempDir()
+	knobPath := filepath.Join(dir, "conservation_mode")
+	rapid := filepath.Join(dir, "rapid_charge")
+	if err := os.WriteFile(rapid, []byte("1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	k := &memKnob{}
+	r := RefuseRapidCharge(k, knobPath)
+	if err := r.Write(1); err == nil || k.val != 0 {
+		t.Errorf("conservation enabled with rapid charge on: err=%v knob=%d", err, k.val)
+	}
+	if b, _ := os.ReadFile(rapid); strings.TrimSpace(string(b)) != "1" {
+		t.Errorf("rapid_charge touched: %q", b)
+	}
+	if err := os.WriteFile(rapid, []byte("0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Write(1); err != nil || k.val != 1 {
+		t.Errorf("Write = %v, knob=%d", err, k.val)
+	}
+}
+
+func TestRapidChargePolicy(t *testing.T) {
+	dir := t.TempDir()
+	knobPath := filepath.Join(dir, "conservation_mode")
+	k := &memKnob{}
+	policy := "refuse"
+	if got := RapidChargePolicy(k, knobPath, func() string { return policy }); got != Knob(k) {
+		t.Fatal("knob wrapped without a rapid_charge node")
+	}
+
+	rapid := filepath.Join(dir, "rapid_charge")
+	if err := os.WriteFile(rapid, []byte("1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := RapidChargePolicy(k, knobPath, func() string { return policy })
+	if err := p.Write(1); err == nil || k.val != 0 {
+		t.Errorf("refuse: err=%v knob=%d", err, k.val)
+	}
+	// Changed at runtime: the next write follows it
+	policy = "disable"
+	if err := p.Write(1); err != nil || k.val != 1 {
+		t.Errorf("disable: err=%v knob=%d", err, k.val)
+	}
+	if b, _ := os.ReadFile(rapid); strings.TrimSpace(string(b)) != "0" {
+		t.Errorf("rapid_charge = %q after disable", b)
+	}
+}
+
+type countingKnob struct {
+	memKnob
+	writes int
+}
+
+func (k *countingKnob) Write(v int) error { k.writes++; return k.memKnob.Write(v) }
+
+func TestRewrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	k := &countingKnob{memKnob: memKnob{val: 80}}
+	resumed := make(chan struct{})
+	out := Rewrite(ctx, k, resumed)
+
+	resumed <- struct{}{}
+	<-out
+	if k.writes != 1 || k.val != 80 {
+		t.Errorf("writes=%d val=%d, want the unchanged value written once", k.writes, k.val)
+	}
+}
@@ -0,0 +1,67 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "telemetry-id")
+	id, err := LoadID(path)
+	if err != nil || len(id) != 32 {
+		t.Fatalf("LoadID = %q, %v", id, err)
+	}
+	if again, _ := LoadID(path); again != id {
+		t.Errorf("ID changed: %q -> %q", id, again)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var got Report
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+	if err := Send(context.Background(), srv.URL, Report{ID: "x", HealthPct: 91.5}); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "x" || got.HealthPct != 91.5 {
+		t.Errorf("server got %+v", got)
+	}
+}
+
+func TestRunOverdue(t *testing.T) {
+	got := make(chan Report, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rep Report
+		json.NewDecoder(r.Body).Decode(&rep)
+		got <- rep
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Last sent two weeks ago with a weekly interval: due at once
+	build := func() (Report, error) { return Report{ID: "x"}, nil }
+	sent := make(chan time.Time, 1)
+	go Run(ctx, srv.URL, 7*24*time.Hour, time.Now().Add(-14*24*time.Hour), build,
+		func(at time.Time) { sent <- at }, t.Logf)
+	select {
+	case rep := <-got:
+		if rep.ID != "x" {
+			t.Errorf("server got %+v", rep)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("overdue report not sent")
+	}
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sent not called")
+	}
+}
@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: MIT
+
+// Package version holds the build metadata shared by every program, so the
+// daemon, CLI and tray always report the same release.
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Version metadata injected at build time via
+// -ldflags "-X conservationDaemon/internal/version.Version=..."
+var (
+	Version = "dev"
+	Commit  = "none"
+	Date    = "unknown"
+)
+
+// String is the -version line for the named program.
+func String(name string) string {
+	return fmt.Sprintf("%s %s (commit %s, built %s) %s/%s", name, Version, Commit, Date, runtime.GOOS, runtime.GOARCH)
+}